@@ -0,0 +1,142 @@
+#!/bin/bash
+
+# 车位分配并发校验脚本
+# 并发压测车辆入场与预订下单，校验同一车位不会被重复分配：
+#   1. 同一车位不存在两条"在场"停车记录
+#   2. 同一车位不存在时间段重叠的有效预订（已预订/使用中）
+#
+# 用法: ./concurrency_check.sh [停车场ID] [并发数]
+# 依赖: 后端服务已启动、本机可用 mysql 客户端与 curl，数据库中已有测试用户和车辆（见 generate_test_data.sh）
+# 鉴权: 入场以管理员身份办理（ADMIN_PHONE/ADMIN_PASS，需为系统管理员或该停车场管理员），
+#       预订以车主身份下单（测试用户统一密码 USER_PASS），脚本先登录取得 Bearer token
+# 预订并发的自动化校验见 internal/booking/service_test.go（go test，需配置测试数据库）
+
+LOT_ID=${1:-1}
+CONCURRENCY=${2:-20}
+BASE_URL=${BASE_URL:-"http://127.0.0.1:8080"}
+DB_USER=${DB_USER:-"root"}
+DB_PASS=${DB_PASS:-"12345"}
+DB_NAME=${DB_NAME:-"smart_parking"}
+ADMIN_PHONE=${ADMIN_PHONE:-""}
+ADMIN_PASS=${ADMIN_PASS:-""}
+USER_PASS=${USER_PASS:-"12345678"}
+
+echo "=========================================="
+echo "智能停车系统 - 车位分配并发校验"
+echo "=========================================="
+echo "   - 停车场ID: $LOT_ID"
+echo "   - 并发数: $CONCURRENCY"
+echo "   - 服务地址: $BASE_URL"
+echo ""
+
+for cmd in curl mysql; do
+    if ! command -v $cmd &> /dev/null; then
+        echo "❌ 错误: 未找到 $cmd"
+        exit 1
+    fi
+done
+
+if [ -z "$ADMIN_PHONE" ] || [ -z "$ADMIN_PASS" ]; then
+    echo "❌ 错误: 请通过 ADMIN_PHONE / ADMIN_PASS 指定管理员账号（入场接口需要管理员登录）"
+    exit 1
+fi
+
+mysql_query() {
+    mysql -u"$DB_USER" -p"$DB_PASS" -N -B "$DB_NAME" -e "$1" 2>/dev/null
+}
+
+# login 登录并输出访问令牌（$1 登录地址，$2 手机号，$3 密码），失败时输出为空
+login() {
+    curl -s -X POST "$BASE_URL$1" \
+        -H "Content-Type: application/json" \
+        -d "{\"phone\":\"$2\",\"password\":\"$3\"}" |
+        grep -o '"token":"[^"]*"' | head -n 1 | cut -d'"' -f4
+}
+
+ADMIN_TOKEN=$(login "/admin/login" "$ADMIN_PHONE" "$ADMIN_PASS")
+if [ -z "$ADMIN_TOKEN" ]; then
+    echo "❌ 错误: 管理员登录失败，请检查 ADMIN_PHONE / ADMIN_PASS"
+    exit 1
+fi
+
+# 选取当前不在场的车辆，分别用于入场与预订
+VEHICLES=$(mysql_query "SELECT v.vehicle_id, u.phone, v.license_plate FROM vehicle v
+    JOIN users_list u ON u.user_id = v.user_id
+    WHERE NOT EXISTS (SELECT 1 FROM parking_record p WHERE p.vehicle_id = v.vehicle_id AND p.record_status = 1)
+    ORDER BY v.vehicle_id LIMIT $((CONCURRENCY * 2));")
+if [ -z "$VEHICLES" ]; then
+    echo "❌ 错误: 没有可用于测试的车辆，请先运行 generate_test_data.sh"
+    exit 1
+fi
+
+# 预订请求的车主先逐个登录，令牌按车辆保存，避免登录耗时冲淡下单并发
+declare -A USER_TOKENS
+i=0
+while read -r VEHICLE_ID PHONE PLATE; do
+    if [ $i -ge $CONCURRENCY ]; then
+        USER_TOKENS[$VEHICLE_ID]=$(login "/api/v1/login" "$PHONE" "$USER_PASS")
+        if [ -z "${USER_TOKENS[$VEHICLE_ID]}" ]; then
+            echo "⚠️  车主 $PHONE 登录失败，跳过车辆 $VEHICLE_ID 的预订请求"
+        fi
+    fi
+    i=$((i + 1))
+done <<< "$VEHICLES"
+
+# 预订时间段：1 小时后开始，持续 2 小时（所有请求争抢同一时间段）
+START_TIME=$(date -u -d "+1 hour" +"%Y-%m-%dT%H:00:00Z")
+END_TIME=$(date -u -d "+3 hour" +"%Y-%m-%dT%H:00:00Z")
+
+echo "🔄 并发发送 $CONCURRENCY 个入场请求和 $CONCURRENCY 个预订请求..."
+i=0
+while read -r VEHICLE_ID PHONE PLATE; do
+    if [ $i -lt $CONCURRENCY ]; then
+        curl -s -o /dev/null -X POST "$BASE_URL/api/parking/entry" \
+            -H "Content-Type: application/json" \
+            -H "Authorization: Bearer $ADMIN_TOKEN" \
+            -d "{\"license_plate\":\"$PLATE\",\"space_type\":\"普通\",\"lot_id\":$LOT_ID}" &
+    elif [ -n "${USER_TOKENS[$VEHICLE_ID]}" ]; then
+        curl -s -o /dev/null -X POST "$BASE_URL/api/v4/booking/create" \
+            -H "Content-Type: application/json" \
+            -H "Authorization: Bearer ${USER_TOKENS[$VEHICLE_ID]}" \
+            -d "{\"vehicle_id\":$VEHICLE_ID,\"lot_id\":$LOT_ID,\"start_time\":\"$START_TIME\",\"end_time\":\"$END_TIME\",\"space_type\":\"普通\"}" &
+    fi
+    i=$((i + 1))
+done <<< "$VEHICLES"
+wait
+
+echo ""
+echo "🔍 校验分配结果..."
+FAILED=0
+
+DUP_ENTRIES=$(mysql_query "SELECT space_id, COUNT(*) FROM parking_record
+    WHERE record_status = 1 GROUP BY space_id HAVING COUNT(*) > 1;")
+if [ -n "$DUP_ENTRIES" ]; then
+    echo "❌ 同一车位存在多条在场记录 (space_id, 记录数):"
+    echo "$DUP_ENTRIES"
+    FAILED=1
+else
+    echo "✅ 无车位被重复分配给在场车辆"
+fi
+
+DUP_BOOKINGS=$(mysql_query "SELECT a.space_id, a.order_id, b.order_id FROM reservation_order a
+    JOIN reservation_order b ON a.space_id = b.space_id AND a.order_id < b.order_id
+    WHERE a.status IN (1, 2) AND b.status IN (1, 2)
+      AND a.start_time < b.end_time AND a.end_time > b.start_time;")
+if [ -n "$DUP_BOOKINGS" ]; then
+    echo "❌ 同一车位存在时间段重叠的预订 (space_id, order_id, order_id):"
+    echo "$DUP_BOOKINGS"
+    FAILED=1
+else
+    echo "✅ 无车位存在时间段重叠的预订"
+fi
+
+echo ""
+if [ $FAILED -ne 0 ]; then
+    echo "=========================================="
+    echo "❌ 并发校验失败"
+    echo "=========================================="
+    exit 1
+fi
+echo "=========================================="
+echo "✅ 并发校验通过"
+echo "=========================================="
@@ -0,0 +1,140 @@
+package access
+
+import (
+	"errors"
+	"net/http"
+	"smart_parking_backend/internal/middleware"
+	"smart_parking_backend/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler 通行日志 HTTP 处理
+type Handler struct {
+	svc *Service
+}
+
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// accessErrorStatus 通行日志业务错误对应的 HTTP 状态码
+func accessErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrEventNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidFilter), errors.Is(err, ErrInvalidGroupBy), errors.Is(err, ErrInvalidTimeSpan):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func respondError(c *gin.Context, err error) {
+	status := accessErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		message = "查询失败"
+	}
+	c.JSON(status, gin.H{"code": status, "message": message})
+}
+
+// parseFilter 解析查询条件并限定为管理员可管理的停车场（已写入响应时返回 false）
+func parseFilter(c *gin.Context) (Filter, bool) {
+	f := Filter{
+		LotID:        uint(utils.ParseInt(c.Query("lot_id"), 0)),
+		GateID:       uint(utils.ParseInt(c.Query("gate_id"), 0)),
+		Direction:    c.Query("direction"),
+		Source:       c.Query("source"),
+		Decision:     c.Query("decision"),
+		Reason:       c.Query("reason"),
+		LicensePlate: c.Query("license_plate"),
+	}
+	if f.LotID != 0 && !middleware.CanManageLot(c, f.LotID) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "无权限管理该停车场"})
+		return f, false
+	}
+	if c.GetString("role") != middleware.RoleSystem {
+		f.LotIDs = append([]uint{}, middleware.ManagedLots(c)...)
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"start_time", &f.StartTime}, {"end_time", &f.EndTime}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": p.name + " 格式无效，请使用RFC3339格式（如：2025-01-02T08:00:00+08:00）"})
+			return f, false
+		}
+		*p.dst = t
+	}
+	return f, true
+}
+
+// ListEvents 分页查询通行日志
+// GET /admin/access/events?lot_id=1&gate_id=2&direction=in&decision=denied&reason=lot_full&source=gate&license_plate=京A12345&start_time=...&end_time=...&page=1&page_size=20
+func (h *Handler) ListEvents(c *gin.Context) {
+	f, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+	page := utils.ParseInt(c.DefaultQuery("page", "1"), 1)
+	pageSize := utils.ParseInt(c.DefaultQuery("page_size", "20"), 20)
+
+	list, total, err := h.svc.List(f, page, pageSize)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    gin.H{"total": total, "page": page, "page_size": pageSize, "records": list},
+	})
+}
+
+// GetEvent 查询通行日志详情
+// GET /admin/access/events/:access_id
+func (h *Handler) GetEvent(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("access_id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的日志ID"})
+		return
+	}
+	ev, err := h.svc.Get(id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	// 未识别停车场的日志只有系统管理员可查看
+	if c.GetString("role") != middleware.RoleSystem && (ev.LotID == nil || !middleware.CanManageLot(c, *ev.LotID)) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "无权限管理该停车场"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": ev})
+}
+
+// Stats 通行统计：汇总、拒绝原因分布、按日/小时趋势与各停车场对比（含车位已满的拒入次数）
+// GET /admin/access/stats?lot_id=1&start_time=...&end_time=...&group_by=day
+func (h *Handler) Stats(c *gin.Context) {
+	f, ok := parseFilter(c)
+	if !ok {
+		return
+	}
+	// 未指定时间范围时默认最近 7 天
+	if f.StartTime.IsZero() && f.EndTime.IsZero() {
+		f.EndTime = time.Now()
+		f.StartTime = f.EndTime.AddDate(0, 0, -7)
+	}
+	stats, err := h.svc.Stats(f, c.Query("group_by"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": stats})
+}
@@ -0,0 +1,189 @@
+package access
+
+import (
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Repository 通行日志数据访问层（只追加，不提供修改与删除）
+type Repository struct{}
+
+// NewRepository 创建 Repository 实例
+func NewRepository() *Repository {
+	return &Repository{}
+}
+
+// Filter 通行日志查询条件（零值表示不限）
+type Filter struct {
+	LotIDs       []uint // 为 nil 时不限停车场（系统管理员）
+	LotID        uint
+	GateID       uint
+	Direction    string
+	Source       string
+	Decision     string
+	Reason       string
+	LicensePlate string
+	StartTime    time.Time
+	EndTime      time.Time
+}
+
+// apply 按条件拼接查询
+func (f Filter) apply(query *gorm.DB) *gorm.DB {
+	if f.LotIDs != nil {
+		query = query.Where("lot_id IN ?", f.LotIDs)
+	}
+	if f.LotID != 0 {
+		query = query.Where("lot_id = ?", f.LotID)
+	}
+	if f.GateID != 0 {
+		query = query.Where("gate_id = ?", f.GateID)
+	}
+	if f.Direction != "" {
+		query = query.Where("direction = ?", f.Direction)
+	}
+	if f.Source != "" {
+		query = query.Where("source = ?", f.Source)
+	}
+	if f.Decision != "" {
+		query = query.Where("decision = ?", f.Decision)
+	}
+	if f.Reason != "" {
+		query = query.Where("reason = ?", f.Reason)
+	}
+	if f.LicensePlate != "" {
+		query = query.Where("license_plate = ?", f.LicensePlate)
+	}
+	if !f.StartTime.IsZero() {
+		query = query.Where("occurred_at >= ?", f.StartTime)
+	}
+	if !f.EndTime.IsZero() {
+		query = query.Where("occurred_at <= ?", f.EndTime)
+	}
+	return query
+}
+
+// Insert 追加一条通行日志
+func (r *Repository) Insert(ev *model.AccessEvent) error {
+	return inits.DB.Create(ev).Error
+}
+
+// Get 查询通行日志
+func (r *Repository) Get(accessID uint64) (*model.AccessEvent, error) {
+	var ev model.AccessEvent
+	err := inits.DB.First(&ev, accessID).Error
+	return &ev, err
+}
+
+// Find 分页查询通行日志（按通行时间倒序）
+func (r *Repository) Find(f Filter, offset, limit int) ([]model.AccessEvent, int64, error) {
+	var list []model.AccessEvent
+	var total int64
+	query := f.apply(inits.DB.Model(&model.AccessEvent{}))
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("occurred_at DESC, access_id DESC").Offset(offset).Limit(limit).Find(&list).Error
+	return list, total, err
+}
+
+// ==================== 统计 ====================
+
+// decisionCount 按方向与处理结果汇总
+type decisionCount struct {
+	Direction  string
+	Decision   string
+	Count      int64
+	AvgLatency float64
+	MaxLatency int64
+}
+
+// CountByDecision 按方向、处理结果统计次数与处理耗时
+func (r *Repository) CountByDecision(f Filter) ([]decisionCount, error) {
+	var rows []decisionCount
+	err := f.apply(inits.DB.Model(&model.AccessEvent{})).
+		Select("direction, decision, COUNT(*) as count, COALESCE(AVG(latency_ms), 0) as avg_latency, COALESCE(MAX(latency_ms), 0) as max_latency").
+		Group("direction, decision").
+		Scan(&rows).Error
+	return rows, err
+}
+
+// ReasonCount 未正常放行的原因统计
+type ReasonCount struct {
+	Direction string `json:"direction"`
+	Decision  string `json:"decision"`
+	Reason    string `json:"reason"`
+	Count     int64  `json:"count"`
+}
+
+// CountByReason 按原因统计被拒绝、出错及放行但有异常（如支付单创建失败）的次数
+func (r *Repository) CountByReason(f Filter) ([]ReasonCount, error) {
+	var rows []ReasonCount
+	err := f.apply(inits.DB.Model(&model.AccessEvent{})).
+		Select("direction, decision, reason, COUNT(*) as count").
+		Where("reason <> ?", ReasonOK).
+		Group("direction, decision, reason").
+		Order("count DESC").
+		Scan(&rows).Error
+	return rows, err
+}
+
+// TrendRow 按时间段统计
+type TrendRow struct {
+	Period       string `json:"period"`
+	EntryAllowed int64  `json:"entry_allowed"`
+	EntryDenied  int64  `json:"entry_denied"`
+	TurnAways    int64  `json:"turn_aways"`
+	ExitAllowed  int64  `json:"exit_allowed"`
+	ExitDenied   int64  `json:"exit_denied"`
+	Errors       int64  `json:"errors"`
+	AvgLatencyMS int64  `json:"avg_latency_ms"`
+}
+
+// trendSelect 按方向与结果分列计数
+const trendSelect = `
+	SUM(CASE WHEN direction = 'in' AND decision = 'allowed' THEN 1 ELSE 0 END) as entry_allowed,
+	SUM(CASE WHEN direction = 'in' AND decision = 'denied' THEN 1 ELSE 0 END) as entry_denied,
+	SUM(CASE WHEN direction = 'in' AND reason = 'lot_full' THEN 1 ELSE 0 END) as turn_aways,
+	SUM(CASE WHEN direction = 'out' AND decision = 'allowed' THEN 1 ELSE 0 END) as exit_allowed,
+	SUM(CASE WHEN direction = 'out' AND decision = 'denied' THEN 1 ELSE 0 END) as exit_denied,
+	SUM(CASE WHEN decision = 'error' THEN 1 ELSE 0 END) as errors,
+	COALESCE(ROUND(AVG(latency_ms)), 0) as avg_latency_ms`
+
+// Trend 按日或小时统计（layout 为 MySQL DATE_FORMAT 格式）
+func (r *Repository) Trend(f Filter, layout string) ([]TrendRow, error) {
+	var rows []TrendRow
+	period := "DATE_FORMAT(occurred_at, '" + layout + "')"
+	err := f.apply(inits.DB.Model(&model.AccessEvent{})).
+		Select(period + " as period," + trendSelect).
+		Group(period).
+		Order("period").
+		Scan(&rows).Error
+	return rows, err
+}
+
+// LotRow 按停车场统计
+type LotRow struct {
+	LotID        uint  `json:"lot_id"`
+	EntryAllowed int64 `json:"entry_allowed"`
+	EntryDenied  int64 `json:"entry_denied"`
+	TurnAways    int64 `json:"turn_aways"`
+	ExitAllowed  int64 `json:"exit_allowed"`
+	ExitDenied   int64 `json:"exit_denied"`
+	Errors       int64 `json:"errors"`
+	AvgLatencyMS int64 `json:"avg_latency_ms"`
+}
+
+// ByLot 按停车场统计（未识别停车场的尝试不计入）
+func (r *Repository) ByLot(f Filter) ([]LotRow, error) {
+	var rows []LotRow
+	err := f.apply(inits.DB.Model(&model.AccessEvent{})).
+		Select("lot_id," + trendSelect).
+		Where("lot_id IS NOT NULL").
+		Group("lot_id").
+		Order("lot_id").
+		Scan(&rows).Error
+	return rows, err
+}
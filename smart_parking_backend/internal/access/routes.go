@@ -0,0 +1,20 @@
+package access
+
+import (
+	"smart_parking_backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessRoutes 注册通行日志查询与统计路由（日志由停车模块在入场/出场时写入，不提供修改接口）
+func AccessRoutes(r *gin.Engine, svc *Service) {
+	handler := NewHandler(svc)
+
+	events := r.Group("/admin/access")
+	events.Use(middleware.AdminAuthMiddleware(), middleware.RequireRoles(middleware.RoleSystem, middleware.RoleLotAdmin))
+	{
+		events.GET("/events", handler.ListEvents)          // 查询通行日志
+		events.GET("/events/:access_id", handler.GetEvent) // 查询通行日志详情
+		events.GET("/stats", handler.Stats)                // 通行统计
+	}
+}
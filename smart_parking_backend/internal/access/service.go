@@ -0,0 +1,233 @@
+package access
+
+import (
+	"errors"
+	"log"
+	"smart_parking_backend/internal/model"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// ==================== 通行日志 ====================
+// 每次入场/出场尝试（接口、闸机、离线补传重放、管理员处理冲突）都追加一条日志，记录车牌、闸机、
+// 处理结果与原因、匹配的预约、分配/释放的车位与处理耗时；停车记录只保留最终状态，被拒绝或失败的尝试只能从这里追溯
+
+// 通行方向
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// 来源
+const (
+	SourceAPI    = "api"    // /api/parking/entry、/exit（管理员人工办理）
+	SourceGate   = "gate"   // 闸机实时上报 /api/gate/entry、/exit
+	SourceSync   = "sync"   // 闸机离线补传重放
+	SourceManual = "manual" // 管理员处理补传冲突
+)
+
+// 处理结果
+const (
+	DecisionAllowed = "allowed" // 已放行
+	DecisionDenied  = "denied"  // 被业务规则拒绝（4xx）
+	DecisionError   = "error"   // 服务端错误（5xx）
+)
+
+// 原因编码
+const (
+	ReasonOK               = "ok"
+	ReasonPaymentFailed    = "payment_failed"    // 已出场但支付单创建失败，需手动支付
+	ReasonInvalidRequest   = "invalid_request"   // 请求参数无效
+	ReasonLotNotFound      = "lot_not_found"     // 停车场不存在
+	ReasonLotClosed        = "lot_closed"        // 停车场已关闭
+	ReasonLotFull          = "lot_full"          // 车位已满（拒之门外）
+	ReasonSpaceOccupied    = "space_occupied"    // 分配的车位已被占用
+	ReasonReservationUsed  = "reservation_used"  // 预约已被使用
+	ReasonGateLotMismatch  = "gate_lot_mismatch" // 请求的停车场与闸机所属停车场不一致
+	ReasonLotForbidden     = "lot_forbidden"     // 管理员人工办理时停车场不在其管理范围内
+	ReasonNoActiveRecord   = "no_active_record"  // 出场时没有在场记录
+	ReasonWrongLot         = "wrong_lot"         // 在场记录属于其他停车场
+	ReasonDoubleEntry      = "double_entry"      // 补传入场时车辆已在场
+	ReasonExitBeforeEntry  = "exit_before_entry" // 补传出场时间早于入场时间
+	ReasonInternal         = "internal_error"    // 服务端错误
+	ReasonAlreadyInside    = "already_inside"    // 车辆已在本停车场内，返回原停车会话（车牌识别重复触发）
+	ReasonInsideOtherLot   = "inside_other_lot"  // 车辆在其他停车场有在场记录
+	ReasonExitReplayed     = "exit_replayed"     // 车辆刚出场，重复的出场请求返回原出场结果
+	ReasonIdempotentReplay = "idempotent_replay" // 相同幂等键的请求已处理，返回原响应
+	ReasonDuplicateRequest = "duplicate_request" // 相同幂等键的请求处理中或请求体不一致
+)
+
+// 通行日志相关错误
+var (
+	ErrEventNotFound   = errors.New("通行日志不存在")
+	ErrInvalidFilter   = errors.New("筛选条件无效：direction 可选 in / out，source 可选 api / gate / sync / manual，decision 可选 allowed / denied / error")
+	ErrInvalidGroupBy  = errors.New("统计粒度无效，可选 day / hour")
+	ErrInvalidTimeSpan = errors.New("结束时间不能早于开始时间")
+)
+
+// Service 通行日志服务
+type Service struct {
+	repo *Repository
+}
+
+// NewService 创建 Service 实例
+func NewService(repo *Repository) *Service {
+	return &Service{repo: repo}
+}
+
+// Record 追加一条通行日志；写入失败只记录到服务日志，不影响入场/出场结果
+func (s *Service) Record(ev *model.AccessEvent) {
+	if ev.Reason == "" {
+		ev.Reason = ReasonOK
+	}
+	ev.Message = truncate(ev.Message, 255)
+	if err := s.repo.Insert(ev); err != nil {
+		log.Printf("写入通行日志失败（%s %s %s）: %v", ev.Direction, ev.LicensePlate, ev.Reason, err)
+	}
+}
+
+// List 分页查询通行日志
+func (s *Service) List(f Filter, page, pageSize int) ([]model.AccessEvent, int64, error) {
+	if err := validateFilter(f); err != nil {
+		return nil, 0, err
+	}
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	return s.repo.Find(f, (page-1)*pageSize, pageSize)
+}
+
+// Get 查询通行日志
+func (s *Service) Get(accessID uint64) (*model.AccessEvent, error) {
+	ev, err := s.repo.Get(accessID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrEventNotFound
+	}
+	return ev, err
+}
+
+// Totals 汇总指标
+type Totals struct {
+	Total        int64   `json:"total"`
+	EntryAllowed int64   `json:"entry_allowed"`
+	EntryDenied  int64   `json:"entry_denied"`
+	ExitAllowed  int64   `json:"exit_allowed"`
+	ExitDenied   int64   `json:"exit_denied"`
+	Errors       int64   `json:"errors"`
+	TurnAways    int64   `json:"turn_aways"`     // 车位已满被拒绝的入场次数
+	DenyRate     float64 `json:"deny_rate"`      // 被拒绝的入场占入场尝试的百分比
+	AvgLatencyMS float64 `json:"avg_latency_ms"` // 平均处理耗时
+	MaxLatencyMS int64   `json:"max_latency_ms"` // 最长处理耗时
+}
+
+// Stats 通行统计结果
+type Stats struct {
+	Totals   Totals        `json:"totals"`
+	ByReason []ReasonCount `json:"by_reason"` // 未正常放行的原因分布
+	Trend    []TrendRow    `json:"trend"`     // 按日或小时的趋势
+	ByLot    []LotRow      `json:"by_lot"`    // 各停车场对比
+}
+
+// trendLayouts 统计粒度对应的 MySQL DATE_FORMAT 格式
+var trendLayouts = map[string]string{
+	"day":  "%Y-%m-%d",
+	"hour": "%Y-%m-%d %H:00",
+}
+
+// Stats 统计通行日志：汇总、原因分布、趋势与各停车场对比
+func (s *Service) Stats(f Filter, groupBy string) (*Stats, error) {
+	if groupBy == "" {
+		groupBy = "day"
+	}
+	layout, ok := trendLayouts[groupBy]
+	if !ok {
+		return nil, ErrInvalidGroupBy
+	}
+	if err := validateFilter(f); err != nil {
+		return nil, err
+	}
+
+	decisions, err := s.repo.CountByDecision(f)
+	if err != nil {
+		return nil, err
+	}
+	stats := &Stats{}
+	t := &stats.Totals
+	var latencySum float64
+	for _, row := range decisions {
+		t.Total += row.Count
+		latencySum += row.AvgLatency * float64(row.Count)
+		if row.MaxLatency > t.MaxLatencyMS {
+			t.MaxLatencyMS = row.MaxLatency
+		}
+		switch {
+		case row.Decision == DecisionError:
+			t.Errors += row.Count
+		case row.Direction == DirectionIn && row.Decision == DecisionAllowed:
+			t.EntryAllowed += row.Count
+		case row.Direction == DirectionIn:
+			t.EntryDenied += row.Count
+		case row.Decision == DecisionAllowed:
+			t.ExitAllowed += row.Count
+		default:
+			t.ExitDenied += row.Count
+		}
+	}
+	if t.Total > 0 {
+		t.AvgLatencyMS = latencySum / float64(t.Total)
+	}
+
+	if stats.ByReason, err = s.repo.CountByReason(f); err != nil {
+		return nil, err
+	}
+	for _, row := range stats.ByReason {
+		if row.Direction == DirectionIn && row.Reason == ReasonLotFull {
+			t.TurnAways += row.Count
+		}
+	}
+	if attempts := t.EntryAllowed + t.EntryDenied; attempts > 0 {
+		t.DenyRate = float64(t.EntryDenied) / float64(attempts) * 100
+	}
+
+	if stats.Trend, err = s.repo.Trend(f, layout); err != nil {
+		return nil, err
+	}
+	if stats.ByLot, err = s.repo.ByLot(f); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// ==================== 工具函数 ====================
+
+func validateFilter(f Filter) error {
+	if f.Direction != "" && f.Direction != DirectionIn && f.Direction != DirectionOut {
+		return ErrInvalidFilter
+	}
+	switch f.Source {
+	case "", SourceAPI, SourceGate, SourceSync, SourceManual:
+	default:
+		return ErrInvalidFilter
+	}
+	switch f.Decision {
+	case "", DecisionAllowed, DecisionDenied, DecisionError:
+	default:
+		return ErrInvalidFilter
+	}
+	if !f.StartTime.IsZero() && !f.EndTime.IsZero() && f.EndTime.Before(f.StartTime) {
+		return ErrInvalidTimeSpan
+	}
+	return nil
+}
+
+// truncate 按字符截断，避免超出字段长度
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,242 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"smart_parking_backend/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler 管理员账号 HTTP 处理
+type Handler struct {
+	svc *Service
+}
+
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// adminErrorStatus 管理员账号业务错误对应的 HTTP 状态码
+func adminErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrAdminNotFound), errors.Is(err, ErrLotNotFound), errors.Is(err, ErrLotNotAssigned):
+		return http.StatusNotFound
+	case errors.Is(err, ErrPhoneRegistered), errors.Is(err, ErrLastSystemAdmin):
+		return http.StatusConflict
+	case errors.Is(err, ErrBootstrapFinished):
+		return http.StatusForbidden
+	case errors.Is(err, ErrInvalidPhone), errors.Is(err, ErrInvalidRole), errors.Is(err, ErrInvalidStatus),
+		errors.Is(err, ErrNotLotAdmin), errors.Is(err, ErrDisableSelf):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func respondError(c *gin.Context, err error) {
+	status := adminErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		message = "操作失败"
+	}
+	c.JSON(status, gin.H{"code": status, "message": message})
+}
+
+// parseID 解析路径参数中的ID
+func parseID(c *gin.Context, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// BootstrapRequest 初始化系统管理员请求体
+type BootstrapRequest struct {
+	Phone    string `json:"phone" binding:"required"`
+	Password string `json:"password" binding:"required,min=6,max=20"`
+}
+
+// Bootstrap 初始化首个系统管理员（系统中已有管理员后返回 403）
+// POST /admin/register
+func (h *Handler) Bootstrap(c *gin.Context) {
+	var req BootstrapRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+	a, err := h.svc.Bootstrap(req.Phone, req.Password)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "系统管理员初始化成功", "data": a})
+}
+
+// CreateAdminRequest 创建管理员请求体
+type CreateAdminRequest struct {
+	Phone    string `json:"phone" binding:"required"`
+	Password string `json:"password" binding:"required,min=6,max=20"`
+	Role     string `json:"role"`    // system / lot_admin，默认 lot_admin
+	LotIDs   []uint `json:"lot_ids"` // 停车场管理员管理的停车场
+}
+
+// CreateAdmin 创建管理员
+// POST /admin/admins
+func (h *Handler) CreateAdmin(c *gin.Context) {
+	var req CreateAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+	a, err := h.svc.CreateAdmin(CreateRequest{Phone: req.Phone, Password: req.Password, Role: req.Role, LotIDs: req.LotIDs})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "管理员创建成功", "data": a})
+}
+
+// ListAdmins 查询管理员列表
+// GET /admin/admins?role=lot_admin&status=1&lot_id=2&page=1&page_size=20
+func (h *Handler) ListAdmins(c *gin.Context) {
+	var status *int8
+	if v := c.Query("status"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 8)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": ErrInvalidStatus.Error()})
+			return
+		}
+		s := int8(n)
+		status = &s
+	}
+	lotID := uint(utils.ParseInt(c.Query("lot_id"), 0))
+	page := utils.ParseInt(c.DefaultQuery("page", "1"), 1)
+	pageSize := utils.ParseInt(c.DefaultQuery("page_size", "20"), 20)
+
+	list, total, err := h.svc.ListAdmins(c.Query("role"), status, lotID, page, pageSize)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    gin.H{"total": total, "page": page, "page_size": pageSize, "records": list},
+	})
+}
+
+// GetAdmin 查询管理员详情
+// GET /admin/admins/:id
+func (h *Handler) GetAdmin(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的管理员ID"})
+		return
+	}
+	a, err := h.svc.GetAdmin(id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": a})
+}
+
+// AssignLotRequest 分配停车场请求体
+type AssignLotRequest struct {
+	LotID uint `json:"lot_id" binding:"required"`
+}
+
+// AssignLot 为停车场管理员分配停车场
+// POST /admin/admins/:id/lots
+func (h *Handler) AssignLot(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的管理员ID"})
+		return
+	}
+	var req AssignLotRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+	a, err := h.svc.AssignLot(id, req.LotID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "停车场分配成功", "data": a})
+}
+
+// UnassignLot 取消停车场管理员对停车场的管理
+// DELETE /admin/admins/:id/lots/:lot_id
+func (h *Handler) UnassignLot(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的管理员ID"})
+		return
+	}
+	lotID, ok := parseID(c, "lot_id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的停车场ID"})
+		return
+	}
+	a, err := h.svc.UnassignLot(id, lotID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "已取消停车场分配", "data": a})
+}
+
+// SetStatusRequest 启用/禁用请求体
+type SetStatusRequest struct {
+	Status *int8 `json:"status" binding:"required"`
+}
+
+// SetStatus 启用/禁用管理员账号
+// PUT /admin/admins/:id/status
+func (h *Handler) SetStatus(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的管理员ID"})
+		return
+	}
+	var req SetStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+	a, err := h.svc.SetStatus(c.Request.Context(), c.GetUint("admin_id"), id, *req.Status)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "账号状态已更新", "data": a})
+}
+
+// ResetPasswordRequest 重置密码请求体
+type ResetPasswordRequest struct {
+	Password string `json:"password" binding:"required,min=6,max=20"`
+}
+
+// ResetPassword 重置管理员密码
+// POST /admin/admins/:id/password
+func (h *Handler) ResetPassword(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的管理员ID"})
+		return
+	}
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+	if err := h.svc.ResetPassword(c.Request.Context(), id, req.Password); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "密码已重置"})
+}
@@ -0,0 +1,134 @@
+package admin
+
+import (
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Repository 管理员账号数据访问层
+type Repository struct{}
+
+// NewRepository 创建 Repository 实例
+func NewRepository() *Repository {
+	return &Repository{}
+}
+
+// ==================== 管理员（Admins）操作 ====================
+
+// CountAdminsWithTx 统计管理员数量并锁定扫描范围（首个系统管理员初始化时防止并发重复创建）
+func (r *Repository) CountAdminsWithTx(tx *gorm.DB) (int64, error) {
+	var count int64
+	err := tx.Model(&model.Admins{}).Clauses(clause.Locking{Strength: "UPDATE"}).Count(&count).Error
+	return count, err
+}
+
+// PhoneExistsWithTx 判断手机号是否已被管理员使用（同时检查 username 和 phone_number 字段）
+func (r *Repository) PhoneExistsWithTx(tx *gorm.DB, phone string) (bool, error) {
+	var count int64
+	err := tx.Model(&model.Admins{}).Where("username = ? OR phone_number = ?", phone, phone).Count(&count).Error
+	return count > 0, err
+}
+
+// CreateWithTx 创建管理员
+func (r *Repository) CreateWithTx(tx *gorm.DB, a *model.Admins) error {
+	return tx.Create(a).Error
+}
+
+// GetAdmin 查询管理员
+func (r *Repository) GetAdmin(adminID uint) (*model.Admins, error) {
+	var a model.Admins
+	err := inits.DB.First(&a, adminID).Error
+	return &a, err
+}
+
+// LockAdminWithTx 对管理员记录加行锁
+func (r *Repository) LockAdminWithTx(tx *gorm.DB, adminID uint) (*model.Admins, error) {
+	var a model.Admins
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&a, adminID).Error
+	return &a, err
+}
+
+// FindAdmins 分页查询管理员（role 为空、status 为 nil、lotID 为 0 时不过滤）
+func (r *Repository) FindAdmins(role string, status *int8, lotID uint, offset, limit int) ([]model.Admins, int64, error) {
+	var list []model.Admins
+	var total int64
+	query := inits.DB.Model(&model.Admins{})
+	if role != "" {
+		query = query.Where("role = ?", role)
+	}
+	if status != nil {
+		query = query.Where("status = ?", *status)
+	}
+	if lotID > 0 {
+		query = query.Where("EXISTS (?)", inits.DB.Table("admin_lot al").Select("1").
+			Where("al.admin_id = admins_list.admin_id AND al.lot_id = ?", lotID))
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("admin_id ASC").Offset(offset).Limit(limit).Find(&list).Error
+	return list, total, err
+}
+
+// CountEnabledSystemAdminsWithTx 统计除 excludeID 外仍启用的系统管理员数量（加锁，避免并发禁用掉全部系统管理员）
+func (r *Repository) CountEnabledSystemAdminsWithTx(tx *gorm.DB, excludeID uint) (int64, error) {
+	var count int64
+	err := tx.Model(&model.Admins{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("role = ? AND status = 1 AND admin_id <> ?", "system", excludeID).
+		Count(&count).Error
+	return count, err
+}
+
+// UpdateStatusWithTx 更新管理员状态
+func (r *Repository) UpdateStatusWithTx(tx *gorm.DB, adminID uint, status int8) error {
+	return tx.Model(&model.Admins{}).Where("admin_id = ?", adminID).Update("status", status).Error
+}
+
+// UpdatePassword 更新管理员密码，返回是否找到该管理员
+func (r *Repository) UpdatePassword(adminID uint, hash string) (bool, error) {
+	res := inits.DB.Model(&model.Admins{}).Where("admin_id = ?", adminID).Update("password_hash", hash)
+	return res.RowsAffected > 0, res.Error
+}
+
+// ==================== 管理停车场（AdminLot）操作 ====================
+
+// LotIDsByAdmins 批量查询管理员管理的停车场，返回 admin_id → 停车场ID列表（按停车场ID升序）
+func (r *Repository) LotIDsByAdmins(adminIDs []uint) (map[uint][]uint, error) {
+	result := make(map[uint][]uint, len(adminIDs))
+	if len(adminIDs) == 0 {
+		return result, nil
+	}
+	var rows []model.AdminLot
+	if err := inits.DB.Where("admin_id IN ?", adminIDs).Order("admin_id ASC, lot_id ASC").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.AdminID] = append(result[row.AdminID], row.LotID)
+	}
+	return result, nil
+}
+
+// CountLotsWithTx 统计存在的停车场数量（用于校验停车场ID）
+func (r *Repository) CountLotsWithTx(tx *gorm.DB, lotIDs []uint) (int64, error) {
+	var count int64
+	err := tx.Model(&model.ParkingLot{}).Where("lot_id IN ?", lotIDs).Count(&count).Error
+	return count, err
+}
+
+// AddLotsWithTx 为管理员分配停车场，已分配的停车场忽略
+func (r *Repository) AddLotsWithTx(tx *gorm.DB, adminID uint, lotIDs []uint) error {
+	rows := make([]model.AdminLot, 0, len(lotIDs))
+	for _, lotID := range lotIDs {
+		rows = append(rows, model.AdminLot{AdminID: adminID, LotID: lotID})
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
+
+// RemoveLotWithTx 取消管理员对停车场的管理，返回是否存在该分配
+func (r *Repository) RemoveLotWithTx(tx *gorm.DB, adminID, lotID uint) (bool, error) {
+	res := tx.Where("admin_id = ? AND lot_id = ?", adminID, lotID).Delete(&model.AdminLot{})
+	return res.RowsAffected > 0, res.Error
+}
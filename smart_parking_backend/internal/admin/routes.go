@@ -0,0 +1,27 @@
+package admin
+
+import (
+	"smart_parking_backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminRoutes 注册管理员账号管理路由
+func AdminRoutes(r *gin.Engine, svc *Service) {
+	handler := NewHandler(svc)
+
+	// 仅用于初始化首个系统管理员，系统中已有管理员后关闭
+	r.POST("/admin/register", handler.Bootstrap)
+
+	admins := r.Group("/admin/admins")
+	admins.Use(middleware.AdminAuthMiddleware(), middleware.RequireRoles(middleware.RoleSystem))
+	{
+		admins.GET("", handler.ListAdmins)                      // 查询管理员列表
+		admins.POST("", handler.CreateAdmin)                    // 创建管理员
+		admins.GET("/:id", handler.GetAdmin)                    // 查询管理员详情
+		admins.PUT("/:id/status", handler.SetStatus)            // 启用/禁用账号
+		admins.POST("/:id/password", handler.ResetPassword)     // 重置密码
+		admins.POST("/:id/lots", handler.AssignLot)             // 分配停车场
+		admins.DELETE("/:id/lots/:lot_id", handler.UnassignLot) // 取消停车场分配
+	}
+}
@@ -0,0 +1,333 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"smart_parking_backend/internal/auth"
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/middleware"
+	"smart_parking_backend/internal/model"
+	"smart_parking_backend/utils"
+	"sort"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// 管理员账号状态
+const (
+	StatusDisabled int8 = 0
+	StatusEnabled  int8 = 1
+)
+
+// 管理员账号相关错误
+var (
+	ErrAdminNotFound     = errors.New("管理员不存在")
+	ErrInvalidPhone      = errors.New("手机号格式无效")
+	ErrPhoneRegistered   = errors.New("手机号已注册")
+	ErrInvalidRole       = errors.New("角色无效，可选 system / lot_admin")
+	ErrInvalidStatus     = errors.New("状态无效，可选 0（禁用）/ 1（启用）")
+	ErrLotNotFound       = errors.New("停车场不存在")
+	ErrNotLotAdmin       = errors.New("只能为停车场管理员分配停车场")
+	ErrLotNotAssigned    = errors.New("该管理员未管理此停车场")
+	ErrDisableSelf       = errors.New("不能禁用自己的账号")
+	ErrLastSystemAdmin   = errors.New("至少需要保留一个启用的系统管理员")
+	ErrBootstrapFinished = errors.New("系统已初始化，请由系统管理员在管理后台创建管理员账号")
+)
+
+// CreateRequest 创建管理员参数
+type CreateRequest struct {
+	Phone    string
+	Password string
+	Role     string // 为空时默认 lot_admin
+	LotIDs   []uint // 仅停车场管理员可分配
+}
+
+// Service 管理员账号服务：仅系统管理员可创建账号、分配停车场、启用/禁用账号与重置密码
+type Service struct {
+	repo   *Repository
+	tokens *auth.Service
+}
+
+// NewService 创建 Service 实例
+func NewService(repo *Repository, tokens *auth.Service) *Service {
+	return &Service{repo: repo, tokens: tokens}
+}
+
+// ==================== 账号创建 ====================
+
+// Bootstrap 初始化首个系统管理员，仅当系统中还没有任何管理员时可用
+func (s *Service) Bootstrap(phone, password string) (*model.Admins, error) {
+	var created *model.Admins
+	err := inits.DB.Transaction(func(tx *gorm.DB) error {
+		count, err := s.repo.CountAdminsWithTx(tx)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrBootstrapFinished
+		}
+		created, err = s.createWithTx(tx, CreateRequest{Phone: phone, Password: password, Role: middleware.RoleSystem})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// CreateAdmin 创建管理员，停车场管理员可同时分配停车场
+func (s *Service) CreateAdmin(req CreateRequest) (*model.Admins, error) {
+	var created *model.Admins
+	err := inits.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		created, err = s.createWithTx(tx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *Service) createWithTx(tx *gorm.DB, req CreateRequest) (*model.Admins, error) {
+	if !utils.ValidatePhoneFormat(req.Phone) {
+		return nil, ErrInvalidPhone
+	}
+	role := req.Role
+	if role == "" {
+		role = middleware.RoleLotAdmin
+	}
+	if role != middleware.RoleSystem && role != middleware.RoleLotAdmin {
+		return nil, ErrInvalidRole
+	}
+	lotIDs := uniqueIDs(req.LotIDs)
+	if role == middleware.RoleSystem && len(lotIDs) > 0 {
+		return nil, ErrNotLotAdmin
+	}
+
+	exists, err := s.repo.PhoneExistsWithTx(tx, req.Phone)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrPhoneRegistered
+	}
+	if err := s.checkLotsWithTx(tx, lotIDs); err != nil {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	a := &model.Admins{
+		Username:     req.Phone,
+		PhoneNumber:  req.Phone,
+		PasswordHash: string(hash),
+		Role:         role,
+		Status:       StatusEnabled,
+	}
+	if err := s.repo.CreateWithTx(tx, a); err != nil {
+		return nil, err
+	}
+	if len(lotIDs) > 0 {
+		if err := s.repo.AddLotsWithTx(tx, a.AdminID, lotIDs); err != nil {
+			return nil, err
+		}
+	}
+	a.SetLots(lotIDs)
+	return a, nil
+}
+
+// ==================== 停车场分配 ====================
+
+// AssignLot 为停车场管理员分配停车场（重复分配视为成功）
+func (s *Service) AssignLot(adminID, lotID uint) (*model.Admins, error) {
+	err := inits.DB.Transaction(func(tx *gorm.DB) error {
+		a, err := s.lockAdminWithTx(tx, adminID)
+		if err != nil {
+			return err
+		}
+		if a.Role != middleware.RoleLotAdmin {
+			return ErrNotLotAdmin
+		}
+		if err := s.checkLotsWithTx(tx, []uint{lotID}); err != nil {
+			return err
+		}
+		return s.repo.AddLotsWithTx(tx, adminID, []uint{lotID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAdmin(adminID)
+}
+
+// UnassignLot 取消停车场管理员对停车场的管理
+func (s *Service) UnassignLot(adminID, lotID uint) (*model.Admins, error) {
+	err := inits.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := s.lockAdminWithTx(tx, adminID); err != nil {
+			return err
+		}
+		ok, err := s.repo.RemoveLotWithTx(tx, adminID, lotID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrLotNotAssigned
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAdmin(adminID)
+}
+
+// ==================== 账号状态与密码 ====================
+
+// SetStatus 启用/禁用管理员账号，禁用后该账号的 token 立即失效（鉴权中间件按数据库状态校验，并吊销已签发的 token）
+// 不能禁用自己，也不能禁用最后一个启用的系统管理员
+func (s *Service) SetStatus(ctx context.Context, operatorID, adminID uint, status int8) (*model.Admins, error) {
+	if status != StatusDisabled && status != StatusEnabled {
+		return nil, ErrInvalidStatus
+	}
+	err := inits.DB.Transaction(func(tx *gorm.DB) error {
+		a, err := s.lockAdminWithTx(tx, adminID)
+		if err != nil {
+			return err
+		}
+		if status == StatusDisabled {
+			if adminID == operatorID {
+				return ErrDisableSelf
+			}
+			if a.Role == middleware.RoleSystem {
+				others, err := s.repo.CountEnabledSystemAdminsWithTx(tx, adminID)
+				if err != nil {
+					return err
+				}
+				if others == 0 {
+					return ErrLastSystemAdmin
+				}
+			}
+		}
+		return s.repo.UpdateStatusWithTx(tx, adminID, status)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status == StatusDisabled {
+		if err := s.tokens.RevokeSubject(ctx, auth.KindAdmin, adminID); err != nil {
+			return nil, err
+		}
+	}
+	return s.GetAdmin(adminID)
+}
+
+// ResetPassword 重置管理员密码，并吊销该管理员已签发的全部 token
+func (s *Service) ResetPassword(ctx context.Context, adminID uint, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	ok, err := s.repo.UpdatePassword(adminID, string(hash))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// 新旧密码哈希不同，未更新到记录说明管理员不存在
+		return ErrAdminNotFound
+	}
+	return s.tokens.RevokeSubject(ctx, auth.KindAdmin, adminID)
+}
+
+// ==================== 查询 ====================
+
+// ListAdmins 分页查询管理员（含管理的停车场）
+func (s *Service) ListAdmins(role string, status *int8, lotID uint, page, pageSize int) ([]model.Admins, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	list, total, err := s.repo.FindAdmins(role, status, lotID, (page-1)*pageSize, pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	ids := make([]uint, 0, len(list))
+	for _, a := range list {
+		ids = append(ids, a.AdminID)
+	}
+	lots, err := s.repo.LotIDsByAdmins(ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range list {
+		list[i].SetLots(nonNil(lots[list[i].AdminID]))
+	}
+	return list, total, nil
+}
+
+// GetAdmin 查询管理员（含管理的停车场）
+func (s *Service) GetAdmin(adminID uint) (*model.Admins, error) {
+	a, err := s.repo.GetAdmin(adminID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAdminNotFound
+		}
+		return nil, err
+	}
+	lots, err := s.repo.LotIDsByAdmins([]uint{adminID})
+	if err != nil {
+		return nil, err
+	}
+	a.SetLots(nonNil(lots[adminID]))
+	return a, nil
+}
+
+func (s *Service) lockAdminWithTx(tx *gorm.DB, adminID uint) (*model.Admins, error) {
+	a, err := s.repo.LockAdminWithTx(tx, adminID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAdminNotFound
+	}
+	return a, err
+}
+
+// checkLotsWithTx 校验停车场均存在
+func (s *Service) checkLotsWithTx(tx *gorm.DB, lotIDs []uint) error {
+	if len(lotIDs) == 0 {
+		return nil
+	}
+	count, err := s.repo.CountLotsWithTx(tx, lotIDs)
+	if err != nil {
+		return err
+	}
+	if count != int64(len(lotIDs)) {
+		return ErrLotNotFound
+	}
+	return nil
+}
+
+// uniqueIDs 去重、去掉 0 并升序排列
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	result := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if id == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// nonNil 未分配停车场时返回空数组，JSON 输出 [] 而不是 null
+func nonNil(ids []uint) []uint {
+	if ids == nil {
+		return []uint{}
+	}
+	return ids
+}
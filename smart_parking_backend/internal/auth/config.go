@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"smart_parking_backend/internal/inits"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// minSecretLen HS256 密钥最短长度（字节）
+const minSecretLen = 32
+
+// KeyConfig 单个签名密钥，kid 写入 token 头部，校验时按 kid 选择密钥
+type KeyConfig struct {
+	KID       string `yaml:"kid"`
+	SecretEnv string `yaml:"secret_env"` // 从环境变量（支持 .env 文件）读取密钥，优先于 secret
+	Secret    string `yaml:"secret"`     // 直接配置密钥，仅建议本地调试使用
+}
+
+// Config 映射 config.yaml 中的 auth 配置段
+type Config struct {
+	Auth struct {
+		Issuer     string      `yaml:"issuer"`
+		AccessTTL  string      `yaml:"access_ttl"`  // 访问令牌有效期，默认 15m
+		RefreshTTL string      `yaml:"refresh_ttl"` // 刷新令牌有效期（每次刷新重新计算），默认 720h
+		SigningKID string      `yaml:"signing_kid"` // 签发新 token 使用的密钥，可被环境变量 JWT_SIGNING_KID 覆盖
+		Keys       []KeyConfig `yaml:"keys"`        // 校验时接受的全部密钥，轮换期间新旧密钥同时保留
+	} `yaml:"auth"`
+
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	signingKID string
+	keys       map[string][]byte
+}
+
+// LoadConfig 从 YAML 文件加载鉴权配置并读取密钥
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.init(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (c *Config) init() error {
+	a := &c.Auth
+	if a.Issuer == "" {
+		a.Issuer = "parking-system"
+	}
+
+	var err error
+	if c.accessTTL, err = parseTTL(a.AccessTTL, 15*time.Minute); err != nil {
+		return fmt.Errorf("auth.access_ttl 无效: %w", err)
+	}
+	if c.refreshTTL, err = parseTTL(a.RefreshTTL, 720*time.Hour); err != nil {
+		return fmt.Errorf("auth.refresh_ttl 无效: %w", err)
+	}
+	if c.refreshTTL <= c.accessTTL {
+		return fmt.Errorf("auth.refresh_ttl 必须大于 auth.access_ttl")
+	}
+
+	c.keys = make(map[string][]byte, len(a.Keys))
+	for _, k := range a.Keys {
+		if k.KID == "" {
+			return fmt.Errorf("auth.keys 中存在未配置 kid 的密钥")
+		}
+		if _, dup := c.keys[k.KID]; dup {
+			return fmt.Errorf("密钥 %s 重复配置", k.KID)
+		}
+		secret := k.Secret
+		if k.SecretEnv != "" {
+			if v := inits.GetEnv(k.SecretEnv); v != "" {
+				secret = v
+			}
+		}
+		if len(secret) < minSecretLen {
+			return fmt.Errorf("密钥 %s 未配置或长度不足 %d 字节", k.KID, minSecretLen)
+		}
+		c.keys[k.KID] = []byte(secret)
+	}
+
+	// 读取密钥时已加载 .env，此处直接读取环境变量（未设置时使用配置文件中的 signing_kid）
+	c.signingKID = a.SigningKID
+	if v := os.Getenv("JWT_SIGNING_KID"); v != "" {
+		c.signingKID = v
+	}
+	if _, ok := c.keys[c.signingKID]; !ok {
+		return fmt.Errorf("签名密钥 %q 不在 auth.keys 中", c.signingKID)
+	}
+	return nil
+}
+
+func parseTTL(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < time.Minute {
+		return 0, fmt.Errorf("不能小于 1m")
+	}
+	return d, nil
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler 令牌刷新与注销 HTTP 处理
+type Handler struct {
+	svc *Service
+}
+
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// RefreshRequest 刷新令牌请求体
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// Refresh 使用刷新令牌换取新的令牌对
+// POST /api/v1/token/refresh、POST /admin/token/refresh
+func (h *Handler) Refresh(kind string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RefreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+			return
+		}
+		pair, err := h.svc.Refresh(c.Request.Context(), kind, req.RefreshToken)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": pair})
+	}
+}
+
+// LogoutRequest 注销请求体
+type LogoutRequest struct {
+	All bool `json:"all"` // 同时注销该账号在其他设备上的全部会话
+}
+
+// Logout 注销当前会话（Authorization 头中的访问令牌所属会话）
+// POST /api/v1/logout、POST /admin/logout
+func (h *Handler) Logout(kind string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenStr, ok := BearerToken(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "message": "缺少认证token"})
+			return
+		}
+		var req LogoutRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+				return
+			}
+		}
+
+		ctx := c.Request.Context()
+		claims, err := h.svc.Verify(ctx, tokenStr, kind)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if err := h.svc.Logout(ctx, claims); err != nil {
+			respondError(c, err)
+			return
+		}
+		if req.All {
+			if err := h.svc.RevokeSubject(ctx, kind, claims.SubjectID()); err != nil {
+				respondError(c, err)
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"code": 0, "message": "已退出登录"})
+	}
+}
+
+// authErrorStatus 鉴权错误对应的 HTTP 状态码
+func authErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrSubjectDisabled):
+		return http.StatusForbidden
+	case IsAuthError(err):
+		return http.StatusUnauthorized
+	}
+	return http.StatusServiceUnavailable
+}
+
+func respondError(c *gin.Context, err error) {
+	status := authErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusServiceUnavailable {
+		message = "认证服务暂不可用，请稍后重试"
+	}
+	c.JSON(status, gin.H{"code": status, "message": message})
+}
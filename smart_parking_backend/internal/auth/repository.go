@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis 键
+// auth:refresh:{hash}                 刷新令牌 → 会话信息，TTL 为 refresh_ttl
+// auth:refresh:used:{hash}            已轮换的刷新令牌 → 会话ID，用于发现刷新令牌被重放
+// auth:revoked:session:{sid}          已吊销的会话（注销、刷新令牌重放）
+// auth:revoked:subject:{kind}:{id}    该时间点（unix 毫秒）及之前签发的 token 全部失效（禁用账号、重置密码）
+const (
+	refreshKeyPrefix        = "auth:refresh:"
+	refreshUsedKeyPrefix    = "auth:refresh:used:"
+	revokedSessionKeyPrefix = "auth:revoked:session:"
+	revokedSubjectKeyPrefix = "auth:revoked:subject:"
+)
+
+// refreshRecord 刷新令牌对应的会话信息
+type refreshRecord struct {
+	Kind       string `json:"kind"`
+	SubjectID  uint   `json:"subject_id"`
+	SessionID  string `json:"sid"`
+	IssuedAtMs int64  `json:"iat_ms"` // 毫秒级签发时间
+}
+
+// Repository 令牌状态存储（Redis）与账号状态查询（MySQL）
+type Repository struct{}
+
+// NewRepository 创建 Repository 实例
+func NewRepository() *Repository {
+	return &Repository{}
+}
+
+// ==================== 刷新令牌 ====================
+
+// SaveRefresh 保存刷新令牌
+func (r *Repository) SaveRefresh(ctx context.Context, hash string, rec refreshRecord, ttl time.Duration) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return inits.RedisClient.Set(ctx, refreshKeyPrefix+hash, data, ttl).Err()
+}
+
+// TakeRefresh 原子地取出并删除刷新令牌（并发刷新时只有一个请求能成功），不存在时返回 nil
+func (r *Repository) TakeRefresh(ctx context.Context, hash string) (*refreshRecord, error) {
+	data, err := inits.RedisClient.GetDel(ctx, refreshKeyPrefix+hash).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec refreshRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// MarkRefreshUsed 记录已轮换的刷新令牌所属会话
+func (r *Repository) MarkRefreshUsed(ctx context.Context, hash, sessionID string, ttl time.Duration) error {
+	return inits.RedisClient.Set(ctx, refreshUsedKeyPrefix+hash, sessionID, ttl).Err()
+}
+
+// UsedRefreshSession 查询已轮换的刷新令牌所属会话，未使用过时返回空字符串
+func (r *Repository) UsedRefreshSession(ctx context.Context, hash string) (string, error) {
+	sid, err := inits.RedisClient.Get(ctx, refreshUsedKeyPrefix+hash).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return sid, err
+}
+
+// ==================== 吊销列表 ====================
+
+// RevokeSession 吊销会话
+func (r *Repository) RevokeSession(ctx context.Context, sessionID string, ttl time.Duration) error {
+	return inits.RedisClient.Set(ctx, revokedSessionKeyPrefix+sessionID, "1", ttl).Err()
+}
+
+// RevokeSubject 吊销主体在 at 及之前签发的全部 token
+func (r *Repository) RevokeSubject(ctx context.Context, kind string, id uint, at time.Time, ttl time.Duration) error {
+	return inits.RedisClient.Set(ctx, subjectKey(kind, id), at.UnixMilli(), ttl).Err()
+}
+
+// RevocationState 一次查询会话是否被吊销以及主体的吊销时间点（unix 毫秒，未吊销为 0）
+func (r *Repository) RevocationState(ctx context.Context, sessionID, kind string, id uint) (bool, int64, error) {
+	vals, err := inits.RedisClient.MGet(ctx, revokedSessionKeyPrefix+sessionID, subjectKey(kind, id)).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	var revokedBefore int64
+	if s, ok := vals[1].(string); ok {
+		revokedBefore, _ = strconv.ParseInt(s, 10, 64)
+		// 兼容旧版本按秒写入的吊销时间点
+		if revokedBefore > 0 && revokedBefore < 1e12 {
+			revokedBefore = revokedBefore*1000 + 999
+		}
+	}
+	return vals[0] != nil, revokedBefore, nil
+}
+
+func subjectKey(kind string, id uint) string {
+	return fmt.Sprintf("%s%s:%d", revokedSubjectKeyPrefix, kind, id)
+}
+
+// ==================== 账号 ====================
+
+// LoadSubject 查询令牌主体的最新信息与启用状态，账号不存在时返回 nil
+func (r *Repository) LoadSubject(kind string, id uint) (*Subject, bool, error) {
+	if kind == KindAdmin {
+		var a model.Admins
+		res := inits.DB.Select("admin_id", "username", "role", "status").Where("admin_id = ?", id).Limit(1).Find(&a)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return nil, false, res.Error
+		}
+		return &Subject{Kind: KindAdmin, ID: a.AdminID, Name: a.Username, Role: a.Role}, a.Status == 1, nil
+	}
+	var u model.Users_list
+	res := inits.DB.Select("user_id", "username", "status").Where("user_id = ?", id).Limit(1).Find(&u)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return nil, false, res.Error
+	}
+	return &Subject{Kind: KindUser, ID: u.UserID, Name: u.Username}, u.Status != 0, nil
+}
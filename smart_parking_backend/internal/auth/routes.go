@@ -0,0 +1,20 @@
+package auth
+
+import "github.com/gin-gonic/gin"
+
+// AuthRoutes 注册令牌刷新与注销路由（凭刷新令牌或请求头中的访问令牌自行校验，不经过鉴权中间件）
+func AuthRoutes(r *gin.Engine, svc *Service) {
+	handler := NewHandler(svc)
+
+	user := r.Group("/api/v1")
+	{
+		user.POST("/token/refresh", handler.Refresh(KindUser)) // 用户刷新令牌
+		user.POST("/logout", handler.Logout(KindUser))         // 用户注销
+	}
+
+	admin := r.Group("/admin")
+	{
+		admin.POST("/token/refresh", handler.Refresh(KindAdmin)) // 管理员刷新令牌
+		admin.POST("/logout", handler.Logout(KindAdmin))         // 管理员注销
+	}
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 鉴权相关错误
+var (
+	ErrInvalidToken    = errors.New("无效的token")
+	ErrTokenRevoked    = errors.New("token已失效，请重新登录")
+	ErrInvalidRefresh  = errors.New("refresh token 无效或已过期")
+	ErrRefreshReused   = errors.New("refresh token 已被使用，会话已注销，请重新登录")
+	ErrSubjectDisabled = errors.New("账号已禁用")
+)
+
+// TokenPair 登录/刷新返回的令牌
+type TokenPair struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`         // 访问令牌有效期（秒）
+	RefreshExpiresIn int    `json:"refresh_expires_in"` // 刷新令牌有效期（秒）
+}
+
+// Service 令牌服务：短期访问令牌（JWT）+ 轮换刷新令牌（不透明随机串，哈希保存在 Redis）
+// 注销按会话吊销，禁用账号、重置密码按主体吊销此前签发的全部 token
+type Service struct {
+	cfg  *Config
+	repo *Repository
+}
+
+// NewService 创建 Service 实例
+func NewService(cfg *Config, repo *Repository) *Service {
+	return &Service{cfg: cfg, repo: repo}
+}
+
+// ==================== 签发 ====================
+
+// Login 登录成功后开启新会话并签发令牌
+func (s *Service) Login(ctx context.Context, sub Subject) (*TokenPair, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(ctx, sub, sessionID)
+}
+
+func (s *Service) issue(ctx context.Context, sub Subject, sessionID string) (*TokenPair, error) {
+	now := time.Now()
+	access, err := s.signAccessToken(sub, sessionID, now)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	rec := refreshRecord{Kind: sub.Kind, SubjectID: sub.ID, SessionID: sessionID, IssuedAtMs: now.UnixMilli()}
+	if err := s.repo.SaveRefresh(ctx, hashToken(refresh), rec, s.cfg.refreshTTL); err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:      access,
+		RefreshToken:     refresh,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(s.cfg.accessTTL.Seconds()),
+		RefreshExpiresIn: int(s.cfg.refreshTTL.Seconds()),
+	}, nil
+}
+
+// Refresh 使用刷新令牌换取新的令牌对，旧刷新令牌立即作废
+// 已作废的刷新令牌再次使用视为泄露，吊销整个会话
+func (s *Service) Refresh(ctx context.Context, kind, refreshToken string) (*TokenPair, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefresh
+	}
+	hash := hashToken(refreshToken)
+	rec, err := s.repo.TakeRefresh(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		sid, err := s.repo.UsedRefreshSession(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		if sid == "" {
+			return nil, ErrInvalidRefresh
+		}
+		if err := s.repo.RevokeSession(ctx, sid, s.cfg.refreshTTL); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshReused
+	}
+	if err := s.repo.MarkRefreshUsed(ctx, hash, rec.SessionID, s.cfg.refreshTTL); err != nil {
+		return nil, err
+	}
+	if rec.Kind != kind {
+		return nil, ErrInvalidRefresh
+	}
+
+	if err := s.checkRevoked(ctx, rec.SessionID, rec.Kind, rec.SubjectID, rec.IssuedAtMs); err != nil {
+		return nil, err
+	}
+	sub, enabled, err := s.repo.LoadSubject(rec.Kind, rec.SubjectID)
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil {
+		return nil, ErrInvalidRefresh
+	}
+	if !enabled {
+		return nil, ErrSubjectDisabled
+	}
+	return s.issue(ctx, *sub, rec.SessionID)
+}
+
+// ==================== 校验与吊销 ====================
+
+// Verify 校验访问令牌：签名（按 kid 选择密钥）、受众、有效期以及吊销列表
+// 返回 ErrInvalidToken / ErrTokenRevoked 以外的错误表示吊销列表不可用
+func (s *Service) Verify(ctx context.Context, tokenStr, kind string) (*Claims, error) {
+	claims, err := s.parseAccessToken(tokenStr, kind)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkRevoked(ctx, claims.SessionID, kind, claims.SubjectID(), claims.IssuedAtMilli()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkRevoked 会话被吊销，或签发时间（毫秒）不晚于主体吊销时间点时视为失效；
+// 吊销后同一秒内重新登录签发的 token 不受影响
+func (s *Service) checkRevoked(ctx context.Context, sessionID, kind string, id uint, issuedAt int64) error {
+	sessionRevoked, revokedBefore, err := s.repo.RevocationState(ctx, sessionID, kind, id)
+	if err != nil {
+		return fmt.Errorf("查询吊销列表失败: %w", err)
+	}
+	if sessionRevoked || issuedAt <= revokedBefore {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// Logout 注销会话：该会话的访问令牌与刷新令牌立即失效
+func (s *Service) Logout(ctx context.Context, claims *Claims) error {
+	return s.repo.RevokeSession(ctx, claims.SessionID, s.cfg.refreshTTL)
+}
+
+// RevokeSubject 吊销主体此前签发的全部 token（禁用账号、重置密码、退出全部设备）
+func (s *Service) RevokeSubject(ctx context.Context, kind string, id uint) error {
+	return s.repo.RevokeSubject(ctx, kind, id, time.Now(), s.cfg.refreshTTL)
+}
+
+// IsAuthError 是否为令牌本身无效导致的错误（其余错误为存储不可用）
+func IsAuthError(err error) bool {
+	return errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrTokenRevoked) ||
+		errors.Is(err, ErrInvalidRefresh) || errors.Is(err, ErrRefreshReused) || errors.Is(err, ErrSubjectDisabled)
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 令牌主体类型，同时作为 JWT 的 aud，用户 token 不能用于管理端接口，反之亦然
+const (
+	KindUser  = "user"
+	KindAdmin = "admin"
+)
+
+// Subject 令牌主体
+type Subject struct {
+	Kind string
+	ID   uint
+	Name string // 用户名（用户与管理员均为登录用户名）
+	Role string // 仅管理员
+}
+
+// Claims 访问令牌声明；用户 token 携带 user_id/username，管理员 token 携带 admin_id/username/role
+type Claims struct {
+	UserID     uint   `json:"user_id,omitempty"`
+	Username   string `json:"username,omitempty"`
+	AdminID    uint   `json:"admin_id,omitempty"`
+	Role       string `json:"role,omitempty"`
+	SessionID  string `json:"sid"`              // 登录会话ID，刷新时保持不变，注销时按会话吊销
+	IssuedAtMs int64  `json:"iat_ms,omitempty"` // 毫秒级签发时间，iat 只精确到秒，吊销判断以此为准
+	jwt.RegisteredClaims
+}
+
+// Kind 令牌主体类型
+func (c *Claims) Kind() string {
+	if len(c.Audience) > 0 {
+		return c.Audience[0]
+	}
+	return ""
+}
+
+// SubjectID 令牌主体ID
+func (c *Claims) SubjectID() uint {
+	if c.Kind() == KindAdmin {
+		return c.AdminID
+	}
+	return c.UserID
+}
+
+// IssuedAtMilli 毫秒级签发时间，旧 token 没有 iat_ms 时退回 iat
+func (c *Claims) IssuedAtMilli() int64 {
+	if c.IssuedAtMs > 0 {
+		return c.IssuedAtMs
+	}
+	if c.IssuedAt != nil {
+		return c.IssuedAt.UnixMilli()
+	}
+	return 0
+}
+
+// signAccessToken 使用当前签名密钥签发访问令牌，头部携带 kid
+func (s *Service) signAccessToken(sub Subject, sessionID string, now time.Time) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	claims := Claims{
+		SessionID:  sessionID,
+		IssuedAtMs: now.UnixMilli(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   strconv.FormatUint(uint64(sub.ID), 10),
+			Audience:  jwt.ClaimStrings{sub.Kind},
+			Issuer:    s.cfg.Auth.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.cfg.accessTTL)),
+		},
+	}
+	if sub.Kind == KindAdmin {
+		claims.AdminID, claims.Username, claims.Role = sub.ID, sub.Name, sub.Role
+	} else {
+		claims.UserID, claims.Username = sub.ID, sub.Name
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = s.cfg.signingKID
+	return token.SignedString(s.cfg.keys[s.cfg.signingKID])
+}
+
+// parseAccessToken 按 kid 选择密钥校验签名、签发者、受众与有效期
+func (s *Service) parseAccessToken(tokenStr, kind string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := s.cfg.keys[kid]
+		if !ok {
+			return nil, jwt.ErrTokenUnverifiable
+		}
+		return key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(s.cfg.Auth.Issuer),
+		jwt.WithAudience(kind),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || claims.SessionID == "" || claims.SubjectID() == 0 {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// PeekKind 不校验签名读取令牌受众（主体类型），仅用于同时接受用户与管理员 token 的接口选择校验方式，
+// 令牌仍须按该类型通过 Verify 校验
+func PeekKind(tokenStr string) string {
+	claims := &Claims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenStr, claims); err != nil {
+		return ""
+	}
+	return claims.Kind()
+}
+
+// randomToken 生成 n 字节随机数的 base64url 编码
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken 刷新令牌只以哈希形式保存在 Redis 中
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// BearerToken 从 Authorization 请求头提取 Bearer token
+func BearerToken(c *gin.Context) (string, bool) {
+	parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
@@ -1,6 +1,7 @@
 package booking
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -43,6 +44,17 @@ func errorResponse(code int, message string) *Response {
 	}
 }
 
+// bookingErrorStatus 预订归属错误对应的 HTTP 状态码
+func bookingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrBookingNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrNotBookingOwner), errors.Is(err, ErrVehicleNotOwned):
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
 // parseFlexibleTime 尝试多种时间格式进行解析
 // 修复时区问题：解析后的时间统一转换为Asia/Shanghai时区，确保与数据库存储时区一致
 func parseFlexibleTime(t string) (time.Time, error) {
@@ -92,10 +104,9 @@ func parseFlexibleTime(t string) (time.Time, error) {
 	return time.Time{}, fmt.Errorf("无效的时间格式: %s", t)
 }
 
-// CreateBooking 创建预订
+// CreateBooking 创建预订（用户ID取自 token，车辆须属于当前用户）
 func (h *Handler) CreateBooking(c *gin.Context) {
 	var req struct {
-		UserID    uint   `json:"user_id" binding:"required"`
 		VehicleID uint   `json:"vehicle_id" binding:"required"` // 添加车辆ID字段
 		LotID     uint   `json:"lot_id" binding:"required"`
 		Start     string `json:"start_time" binding:"required"`
@@ -129,9 +140,10 @@ func (h *Handler) CreateBooking(c *gin.Context) {
 	}
 
 	// 调用业务层
-	booking, err := h.service.CreateBooking(req.UserID, req.VehicleID, req.LotID, start, end, spaceType)
+	booking, err := h.service.CreateBooking(c.GetUint("user_id"), req.VehicleID, req.LotID, start, end, spaceType)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, errorResponse(400, err.Error()))
+		status := bookingErrorStatus(err)
+		c.JSON(status, errorResponse(status, err.Error()))
 		return
 	}
 
@@ -148,6 +160,7 @@ func (h *Handler) CreateBooking(c *gin.Context) {
 		"duration_minut":    booking.DurationMinutes,
 		"status":            booking.Status,
 		"total_fee":         booking.TotalFee,
+		"fee_breakdown":     booking.FeeBreakdown,
 		"paid_fee":          booking.PaidFee,
 		"payment_status":   booking.PaymentStatus,
 		"reservation_cod":   booking.ReservationCode,
@@ -171,29 +184,18 @@ func (h *Handler) CancelBooking(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.CancelBooking(uint(id)); err != nil {
-		c.JSON(http.StatusBadRequest, errorResponse(400, err.Error()))
+	if err := h.service.CancelBooking(uint(id), c.GetUint("user_id")); err != nil {
+		status := bookingErrorStatus(err)
+		c.JSON(status, errorResponse(status, err.Error()))
 		return
 	}
 
 	c.JSON(http.StatusOK, successResponse(gin.H{"message": "预订取消成功"}))
 }
 
-// GetUserBookings 获取用户预订列表（改进错误处理）
+// GetUserBookings 获取当前登录用户的预订列表
 func (h *Handler) GetUserBookings(c *gin.Context) {
-	userIDStr := c.Query("user_id")
-	if userIDStr == "" {
-		c.JSON(http.StatusBadRequest, errorResponse(400, "缺少user_id参数"))
-		return
-	}
-
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil || userID <= 0 {
-		c.JSON(http.StatusBadRequest, errorResponse(400, "无效的用户ID"))
-		return
-	}
-
-	list, err := h.service.GetUserBookings(uint(userID))
+	list, err := h.service.GetUserBookings(c.GetUint("user_id"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, errorResponse(500, "系统错误"+err.Error()))
 		return
@@ -217,9 +219,13 @@ func (h *Handler) GetBookingDetail(c *gin.Context) {
 		return
 	}
 
-	booking, err := h.service.GetBookingDetail(uint(id))
+	booking, err := h.service.GetUserBooking(uint(id), c.GetUint("user_id"))
 	if err != nil {
-		c.JSON(http.StatusNotFound, errorResponse(404, "预订不存在"))
+		status := bookingErrorStatus(err)
+		if status == http.StatusBadRequest {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, errorResponse(status, err.Error()))
 		return
 	}
 
@@ -256,6 +262,7 @@ func formatReservationOrder(booking *model.ReservationOrder) map[string]interfac
 		"duration_minut":  booking.DurationMinutes,
 		"status":          booking.Status,
 		"total_fee":       booking.TotalFee,
+		"fee_breakdown":   booking.FeeBreakdown,
 		"paid_fee":        booking.PaidFee,
 		"payment_status":  booking.PaymentStatus,
 		"reservation_cod": booking.ReservationCode,
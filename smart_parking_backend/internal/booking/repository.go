@@ -4,8 +4,10 @@ import (
 	"errors"
 	"smart_parking_backend/internal/inits"
 	"smart_parking_backend/internal/model"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Repository 数据访问层结构体，封装所有数据库操作
@@ -33,6 +35,22 @@ func (r *Repository) UpdateBooking(order *model.ReservationOrder) error {
 	return inits.DB.Save(order).Error
 }
 
+// CancelReservedBooking 条件取消：仅"已预订"状态的订单可取消，与入场时的 1→2 状态转换互斥
+// 返回 false 表示订单已不是"已预订"状态（已入场、已完成或已取消）
+func (r *Repository) CancelReservedBooking(orderID uint) (bool, error) {
+	result := inits.DB.Model(&model.ReservationOrder{}).
+		Where("order_id = ? AND status = ?", orderID, 1).
+		Update("status", 0)
+	return result.RowsAffected > 0, result.Error
+}
+
+// IsVehicleOwnedBy 判断车辆是否属于该用户
+func (r *Repository) IsVehicleOwnedBy(vehicleID, userID uint) (bool, error) {
+	var count int64
+	err := inits.DB.Model(&model.Vehicle{}).Where("vehicle_id = ? AND user_id = ?", vehicleID, userID).Count(&count).Error
+	return count > 0, err
+}
+
 func (r *Repository) FindBookingsByUser(userID uint) ([]model.ReservationOrder, error) {
 	var list []model.ReservationOrder
 	err := inits.DB.Where("user_id = ?", userID).
@@ -41,6 +59,15 @@ func (r *Repository) FindBookingsByUser(userID uint) ([]model.ReservationOrder,
 	return list, err
 }
 
+// FindUpcomingBookings 查询在 [from, until] 内开始、仍为已预订状态的订单（用于发送预订提醒）
+func (r *Repository) FindUpcomingBookings(from, until time.Time) ([]model.ReservationOrder, error) {
+	var list []model.ReservationOrder
+	err := inits.DB.Where("status = ? AND start_time > ? AND start_time <= ? AND actual_end_time IS NULL", 1, from, until).
+		Preload("Space").Preload("Lot").
+		Find(&list).Error
+	return list, err
+}
+
 // ==================== 支付记录（PaymentRecord）操作 ====================
 
 func (r *Repository) CreatePayment(p *model.PaymentRecord) error {
@@ -65,7 +92,7 @@ func (r *Repository) FindPaymentsByUser(userID uint) ([]model.PaymentRecord, err
 // 根据 OrderID 查找状态为待支付的支付记录
 func (r *Repository) FindPendingPaymentByOrder(orderID uint) (*model.PaymentRecord, error) {
 	var p model.PaymentRecord
-	err := inits.DB.Where("order_id = ? AND payment_status = 0", orderID).First(&p).Error
+	err := inits.DB.Where("payable_type = ? AND order_id = ? AND payment_status = 0", model.PayableReservation, orderID).First(&p).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, nil // 没有 pending 记录，返回 nil 而不是 error
 	}
@@ -79,40 +106,126 @@ func (r *Repository) UpdatePayment(p *model.PaymentRecord) error {
 
 // ==================== 车位（ParkingSpace）操作 ====================
 
-// FindAvailableSlot 查找可用车位
-// 严格按照用户要求：在车位可用的基础上，按照用户所选车位类型（普通或充电），按照车位序号由小到大安排
-func (r *Repository) FindAvailableSlot(lotID uint, spaceType string) (*model.ParkingSpace, error) {
-	var space model.ParkingSpace
-	
-	// 构建查询条件：车位可用（未被预订、未被占用、状态正常）
-	query := inits.DB.Where("lot_id = ? AND is_reserved = 0 AND is_occupied = 0 AND status = 1", lotID)
-	
-	// 必须按照用户所选车位类型进行过滤（无论是"普通"还是"充电"）
+// ReservationHoldBuffer 预订开始前车位即被视为"已预订"的提前量（与入场允许提前30分钟保持一致）
+const ReservationHoldBuffer = 30 * time.Minute
+
+// overlappingReservations 构造与 [start, end) 时间段重叠的有效预订子查询（关联到外层 parking_space）
+func overlappingReservations(db *gorm.DB, start, end time.Time) *gorm.DB {
+	return db.Model(&model.ReservationOrder{}).
+		Select("1").
+		Where("reservation_order.space_id = parking_space.space_id").
+		Where("reservation_order.status IN ?", []int8{1, 2}). // 1-已预订, 2-使用中
+		Where("reservation_order.start_time < ? AND reservation_order.end_time > ?", end, start)
+}
+
+// ErrNoAvailableSlot 指定时间段内没有可预订的车位
+var ErrNoAvailableSlot = errors.New("当前停车场该时间段无该类型的可用车位")
+
+// claimRetries 锁定车位后复核发现冲突时的最大重试次数
+const claimRetries = 3
+
+// availableSlotQuery 构造指定时间段内可预订车位的查询条件
+// 在车位可用的基础上，按照用户所选车位类型（普通或充电），排除该时间段内已有重叠预订的车位
+func availableSlotQuery(db *gorm.DB, lotID uint, spaceType string, start, end time.Time) *gorm.DB {
 	// 如果未指定类型，默认为"普通"
 	if spaceType == "" {
 		spaceType = "普通"
 	}
-	query = query.Where("space_type = ?", spaceType)
-	
-	// 按照车位序号由小到大排序，确保优先分配序号较小的车位
-	err := query.Order("space_number ASC").First(&space).Error
-	
+
+	// 构建查询条件：车位状态正常、类型匹配、时间段内无重叠预订
+	query := db.Model(&model.ParkingSpace{}).
+		Where("lot_id = ? AND status = 1 AND space_type = ?", lotID, spaceType).
+		Where("NOT EXISTS (?)", overlappingReservations(db, start, end))
+
+	// 预订即将开始时，车位必须当前未被占用（无预订的临停车辆仍在车位上）
+	if start.Before(time.Now().Add(ReservationHoldBuffer)) {
+		query = query.Where("is_occupied = 0")
+	}
+	return query
+}
+
+// FindAvailableSlot 查找指定时间段内可预订的车位（只读，不加锁，仅用于展示/预检）
+// 同一车位可以承载多个时间段互不重叠的预订，按照车位序号由小到大安排
+func (r *Repository) FindAvailableSlot(lotID uint, spaceType string, start, end time.Time) (*model.ParkingSpace, error) {
+	var space model.ParkingSpace
+	err := availableSlotQuery(inits.DB, lotID, spaceType, start, end).
+		Order("space_number ASC").
+		First(&space).Error
+
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		// 如果找不到指定类型的可用车位，返回错误（不再回退到其他类型）
-		return nil, errors.New("当前停车场无该类型的可用车位")
+		return nil, ErrNoAvailableSlot
 	}
-	
+
 	return &space, err
 }
 
-func (r *Repository) MarkSlotAsBooked(spaceID uint, booked bool) error {
-	status := int8(0)
-	if booked {
-		status = 1
+// ClaimSlotWithTx 在事务中原子地锁定一个指定时间段内可预订的车位
+// 使用 SELECT ... FOR UPDATE SKIP LOCKED：并发请求会跳过已被其它事务锁定的车位，而不是拿到同一个车位
+// 车位行锁持有到事务结束，调用方必须在同一事务内写入预订订单
+func ClaimSlotWithTx(tx *gorm.DB, lotID uint, spaceType string, start, end time.Time) (*model.ParkingSpace, error) {
+	var excluded []uint
+	for attempt := 0; attempt < claimRetries; attempt++ {
+		var space model.ParkingSpace
+		query := availableSlotQuery(tx, lotID, spaceType, start, end).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		if len(excluded) > 0 {
+			query = query.Where("space_id NOT IN ?", excluded)
+		}
+		err := query.Order("space_number ASC").First(&space).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoAvailableSlot
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// 子查询中的预订表为快照读，持有车位行锁后再用锁定读复核最新已提交的预订
+		var conflicts []uint
+		err = tx.Model(&model.ReservationOrder{}).
+			Clauses(clause.Locking{Strength: "SHARE"}).
+			Where("space_id = ? AND status IN ?", space.SpaceID, []int8{1, 2}).
+			Where("start_time < ? AND end_time > ?", end, start).
+			Limit(1).
+			Pluck("order_id", &conflicts).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(conflicts) == 0 {
+			return &space, nil
+		}
+		excluded = append(excluded, space.SpaceID)
+	}
+	return nil, ErrNoAvailableSlot
+}
+
+// HeldReservations 构造"当前时刻车位处于保留窗口内"的已预订订单子查询（关联到外层 parking_space）
+func HeldReservations(db *gorm.DB, now time.Time) *gorm.DB {
+	return db.Model(&model.ReservationOrder{}).
+		Select("1").
+		Where("reservation_order.space_id = parking_space.space_id").
+		Where("reservation_order.status = ?", 1). // 1-已预订
+		Where("reservation_order.start_time <= ? AND reservation_order.end_time >= ?", now.Add(ReservationHoldBuffer), now)
+}
+
+// RefreshReservedFlags 根据当前时间重新计算车位的 is_reserved 标记
+// is_reserved 不再在下单时直接置位，而是表示"当前时刻车位处于某个已预订订单的保留窗口内"
+// 未传入 spaceIDs 时刷新全部车位
+func (r *Repository) RefreshReservedFlags(spaceIDs ...uint) error {
+	return RefreshReservedFlagsWithTx(inits.DB, spaceIDs...)
+}
+
+// RefreshReservedFlagsWithTx 同 RefreshReservedFlags，支持在事务中调用
+func RefreshReservedFlagsWithTx(db *gorm.DB, spaceIDs ...uint) error {
+	active := HeldReservations(db, time.Now())
+
+	query := db.Model(&model.ParkingSpace{})
+	if len(spaceIDs) > 0 {
+		query = query.Where("space_id IN ?", spaceIDs)
+	} else {
+		query = query.Where("1 = 1")
 	}
-	return inits.DB.Model(&model.ParkingSpace{}).
-		Where("space_id = ?", spaceID).
-		Update("is_reserved", status).Error
+	return query.Update("is_reserved", gorm.Expr("CASE WHEN EXISTS (?) THEN 1 ELSE 0 END", active)).Error
 }
 
 // ==================== 车辆（Vehicle）操作 ====================
@@ -131,21 +244,34 @@ func (r *Repository) GetVehicleByID(vehicleID uint) (*model.Vehicle, error) {
 
 // ==================== 事务性操作（预订+支付） ====================
 
-func (r *Repository) CreateBookingWithPayment(order *model.ReservationOrder, payment *model.PaymentRecord) error {
+// ClaimAndCreateBooking 在同一事务内锁定可用车位并写入预订订单，保证同一时间段的车位不会被重复分配
+func (r *Repository) ClaimAndCreateBooking(order *model.ReservationOrder, spaceType string) error {
 	return inits.DB.Transaction(func(tx *gorm.DB) error {
+		space, err := ClaimSlotWithTx(tx, order.LotID, spaceType, order.StartTime, order.EndTime)
+		if err != nil {
+			return err
+		}
+		order.SpaceID = space.SpaceID
 		if err := tx.Create(order).Error; err != nil {
 			return err
 		}
-		payment.OrderID = order.OrderID
-		if err := tx.Create(payment).Error; err != nil {
+		order.Space = *space
+		// 预订可能在未来某个时间段，is_reserved 只在保留窗口内才置位
+		return RefreshReservedFlagsWithTx(tx, order.SpaceID)
+	})
+}
+
+func (r *Repository) CreateBookingWithPayment(order *model.ReservationOrder, payment *model.PaymentRecord) error {
+	return inits.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(order).Error; err != nil {
 			return err
 		}
-		if err := tx.Model(&model.ParkingSpace{}).
-			Where("space_id = ?", order.SpaceID).
-			Update("is_reserved", 1).Error; err != nil {
+		payment.PayableType = model.PayableReservation
+		payment.OrderID = &order.OrderID
+		if err := tx.Create(payment).Error; err != nil {
 			return err
 		}
-		return nil
+		return RefreshReservedFlagsWithTx(tx, order.SpaceID)
 	})
 }
 
@@ -1,6 +1,10 @@
 package booking
 
-import "github.com/gin-gonic/gin"
+import (
+	"smart_parking_backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
 
 // RegisterRoutes 注册 booking 模块相关路由
 func BookingRoutes(r *gin.Engine, service *Service) {
@@ -8,10 +12,15 @@ func BookingRoutes(r *gin.Engine, service *Service) {
 
 	api := r.Group("/api/v4/booking")
 	{
-		api.POST("/create", handler.CreateBooking)                        // 创建预订
-		api.DELETE("/cancel/:id", handler.CancelBooking)                  // 取消预订
-		api.GET("/user", handler.GetUserBookings)                         // 获取用户预订列表
-		api.GET("/detail/:id", handler.GetBookingDetail)                  // 获取预订详情
-		api.POST("/check-expired", handler.CheckAndUpdateExpiredBookings) // 检查并更新超时预订
+		// 用户只能创建、查看和取消自己的预订，用户ID取自 token
+		user := api.Group("", middleware.UserAuthMiddleware())
+		user.POST("/create", handler.CreateBooking)       // 创建预订
+		user.DELETE("/cancel/:id", handler.CancelBooking) // 取消预订
+		user.GET("/user", handler.GetUserBookings)        // 获取用户预订列表
+		user.GET("/detail/:id", handler.GetBookingDetail) // 获取预订详情
+
+		// 全局超时扫描仅系统管理员可手动触发
+		api.POST("/check-expired", middleware.AdminAuthMiddleware(), middleware.RequireRoles(middleware.RoleSystem),
+			handler.CheckAndUpdateExpiredBookings) // 检查并更新超时预订
 	}
 }
@@ -3,39 +3,52 @@ package booking
 import (
 	"errors"
 	"fmt"
+	"log"
+	"math"
 	"smart_parking_backend/internal/inits"
 	"smart_parking_backend/internal/model"
-	"strings"
+	"smart_parking_backend/internal/notify"
+	"smart_parking_backend/internal/tariff"
 	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 预订归属相关错误
+var (
+	ErrBookingNotFound = errors.New("订单不存在")
+	ErrNotBookingOwner = errors.New("无权操作该订单")
+	ErrVehicleNotOwned = errors.New("车辆不存在或不属于当前用户")
 )
 
 // Service 层：封装停车位预订与支付的核心业务逻辑
 type Service struct {
-	repo *Repository
+	repo      *Repository
+	tariffSvc *tariff.Service
+	notifier  *notify.Service
 }
 
 // NewService 创建 Service 实例
-func NewService(repo *Repository) *Service {
-	return &Service{repo: repo}
+func NewService(repo *Repository, tariffSvc *tariff.Service, notifier *notify.Service) *Service {
+	return &Service{repo: repo, tariffSvc: tariffSvc, notifier: notifier}
 }
 
 // ==================== 预订流程 ====================
 // CreateBooking 用户预订车位
-// 流程：查找可用车位 → 创建预订订单 → 标记车位为已预订
+// 流程：校验时间段 → 计费报价 → 事务内锁定该时间段内无重叠预订的车位并创建预订订单 → 按当前时间刷新车位预订标记
 func (s *Service) CreateBooking(userID, vehicleID, lotID uint, start, end time.Time, spaceType string) (*model.ReservationOrder, error) {
 	// 兼容前端“充电桩”与数据库“充电”枚举不一致的问题
-	spaceType = strings.TrimSpace(spaceType)
-	if spaceType == "" {
-		spaceType = "普通"
-	}
-	if spaceType == "充电桩" {
-		spaceType = "充电"
-	}
+	spaceType = tariff.NormalizeSpaceType(spaceType)
 
-	space, err := s.repo.FindAvailableSlot(lotID, spaceType)
+	owned, err := s.repo.IsVehicleOwnedBy(vehicleID, userID)
 	if err != nil {
-		return nil, errors.New("当前停车场无可用车位")
+		return nil, err
 	}
+	if !owned {
+		return nil, ErrVehicleNotOwned
+	}
+
 	if !end.After(start) {
 		return nil, errors.New("结束时间必须晚于开始时间")
 	}
@@ -43,6 +56,15 @@ func (s *Service) CreateBooking(userID, vehicleID, lotID uint, start, end time.T
 	if duration <= 0 {
 		return nil, errors.New("预订时间无效")
 	}
+	if !end.After(time.Now()) {
+		return nil, errors.New("预订时间段已结束")
+	}
+
+	// 按停车场计费规则报价，明细随订单保存
+	quote, err := s.tariffSvc.Quote(lotID, spaceType, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("计算预订费用失败: %w", err)
+	}
 	// 确保使用Asia/Shanghai时区
 	loc, err := time.LoadLocation("Asia/Shanghai")
 	if err != nil {
@@ -55,21 +77,23 @@ func (s *Service) CreateBooking(userID, vehicleID, lotID uint, start, end time.T
 		UserID:          userID,
 		VehicleID:       vehicleID,
 		LotID:           lotID,
-		SpaceID:         space.SpaceID,
 		StartTime:       start.In(loc), // 确保使用Asia/Shanghai时区
 		EndTime:         end.In(loc),   // 确保使用Asia/Shanghai时区
 		DurationMinutes: duration,
 		BookingTime:     now, // 使用Asia/Shanghai时区的当前时间
 		Status:          1,
+		TotalFee:        quote.Total,
+		FeeBreakdown:    quote.Encode(),
 		PaymentStatus:   0,
 		ReservationCode: resCode,
 	}
-	if err := s.repo.CreateBooking(order); err != nil {
+	// 车位的查找、锁定与订单写入在同一事务内完成，并发下单不会拿到同一车位
+	if err := s.repo.ClaimAndCreateBooking(order, spaceType); err != nil {
+		if errors.Is(err, ErrNoAvailableSlot) {
+			return nil, errors.New("当前停车场该时间段无可用车位")
+		}
 		return nil, err
 	}
-	if err := s.repo.MarkSlotAsBooked(space.SpaceID, true); err != nil {
-		return order, errors.New("车位状态更新失败")
-	}
 	return order, nil
 }
 
@@ -87,8 +111,9 @@ func (s *Service) CreatePendingPayment(orderID uint, userID uint, amount float64
 	}
 	now := time.Now()
 	p := &model.PaymentRecord{
-		OrderID:       orderID,
-		UserID:        userID,
+		PayableType:   model.PayableReservation,
+		OrderID:       &orderID,
+		UserID:        &userID,
 		Amount:        amount,
 		Method:        method,
 		TransactionNo: transactionNo,
@@ -101,86 +126,113 @@ func (s *Service) CreatePendingPayment(orderID uint, userID uint, amount float64
 	return p, nil
 }
 
-// PayBooking: 当收到支付回调时调用此方法，若存在 pending 记录则更新；否则创建新记录
-func (s *Service) PayBooking(orderID uint, userID uint, amount float64, method, transactionNo string) (*model.PaymentRecord, error) {
-	order, err := s.repo.GetBookingByID(orderID)
-	if err != nil {
-		return nil, errors.New("订单不存在")
+// MarkBookingPaidWithTx: 在支付记录所在的事务中结算订单（锁定订单行），只更新订单的支付状态，不再写支付记录
+// 订单保持"已预订"状态，入场时才转为"使用中"；订单已取消时返回错误，调用方回滚支付记录的更新
+func (s *Service) MarkBookingPaidWithTx(tx *gorm.DB, orderID uint, amount float64) error {
+	var order model.ReservationOrder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+		return errors.New("订单不存在")
 	}
 	if order.PaymentStatus == 1 {
-		return nil, errors.New("订单已支付")
+		return nil
 	}
 	if order.Status == 0 {
-		return nil, errors.New("订单已取消，无法支付")
+		return errors.New("订单已取消，无法支付")
 	}
+	return tx.Model(&model.ReservationOrder{}).
+		Where("order_id = ?", order.OrderID).
+		Updates(map[string]interface{}{"payment_status": 1, "paid_fee": amount}).Error
+}
 
-	// 尝试查找 pending 支付记录
-	pending, err := s.repo.FindPendingPaymentByOrder(orderID)
+// ==================== 取消预订 ====================
+// CancelBooking 用户取消未支付的预订（仅订单所属用户可操作）
+func (s *Service) CancelBooking(orderID, userID uint) error {
+	order, err := s.GetUserBooking(orderID, userID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	now := time.Now()
-	if pending != nil {
-		// 更新 pending 记录为已支付
-		pending.Amount = amount
-		pending.Method = method
-		pending.TransactionNo = transactionNo
-		pending.PaymentStatus = 1
-		pending.PayTime = &now
-		if err := s.repo.UpdatePayment(pending); err != nil {
-			return nil, err
-		}
-		// 更新订单
-		order.PaymentStatus = 1
-		order.Status = 2
-		order.PaidFee = amount
-		if err := s.repo.UpdateBooking(order); err != nil {
-			return pending, errors.New("支付成功但订单更新失败")
-		}
-		return pending, nil
+	if order.PaymentStatus == 1 {
+		return errors.New("已支付订单请申请退款")
 	}
+	order.Status = 0
+	order.PaymentStatus = 0
+	if err := s.repo.UpdateBooking(order); err != nil {
+		return err
+	}
+	// 同一车位可能还有其它时间段的预订，按当前时间重新计算标记而不是直接清零
+	if err := s.repo.RefreshReservedFlags(order.SpaceID); err != nil {
+		return errors.New("订单取消成功，但车位释放失败")
+	}
+	return nil
+}
 
-	// 没有 pending，创建新的支付记录并写入
-	payment := &model.PaymentRecord{
-		OrderID:       order.OrderID,
-		UserID:        userID,
-		Amount:        amount,
-		Method:        method,
-		TransactionNo: transactionNo,
-		PaymentStatus: 1,
-		PayTime:       &now,
+// ErrBookingNotCancellable 订单已入场、已完成或已取消，不能再取消
+var ErrBookingNotCancellable = errors.New("订单当前状态不可取消")
+
+// CancelPaidBooking 取消已支付的订单（退款由支付模块处理），释放车位预订标记
+func (s *Service) CancelPaidBooking(orderID uint) error {
+	ok, err := s.repo.CancelReservedBooking(orderID)
+	if err != nil {
+		return err
 	}
-	if err := s.repo.CreatePayment(payment); err != nil {
-		return nil, err
+	if !ok {
+		return ErrBookingNotCancellable
 	}
-	order.PaymentStatus = 1
-	order.Status = 2
-	order.PaidFee = amount
-	if err := s.repo.UpdateBooking(order); err != nil {
-		return payment, errors.New("支付成功但订单更新失败")
+	order, err := s.repo.GetBookingByID(orderID)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.RefreshReservedFlags(order.SpaceID); err != nil {
+		return errors.New("订单取消成功，但车位释放失败")
 	}
-	return payment, nil
+	return nil
 }
 
-// ==================== 取消预订 ====================
-func (s *Service) CancelBooking(orderID uint) error {
+// ApplyRefund 退款成功后累计订单的已退款金额，并更新支付状态（2-已退款，3-部分退款）
+func (s *Service) ApplyRefund(orderID uint, amount float64) error {
 	order, err := s.repo.GetBookingByID(orderID)
 	if err != nil {
 		return errors.New("订单不存在")
 	}
-	if order.PaymentStatus == 1 {
-		return errors.New("已支付订单请申请退款")
+	refunded := math.Round((order.RefundedFee+amount)*100) / 100
+	paymentStatus := int8(3)
+	if refunded >= order.PaidFee {
+		paymentStatus = 2
 	}
-	order.Status = 0
-	order.PaymentStatus = 0
-	if err := s.repo.UpdateBooking(order); err != nil {
-		return err
+	// 只更新退款相关字段，避免覆盖并发入场对订单状态的修改
+	return inits.DB.Model(&model.ReservationOrder{}).
+		Where("order_id = ?", orderID).
+		Updates(map[string]interface{}{"refunded_fee": refunded, "payment_status": paymentStatus}).Error
+}
+
+// SyncReservedFlags 按当前时间重新计算全部车位的预订标记（预订进入/离开保留窗口时由定时任务调用）
+func (s *Service) SyncReservedFlags() error {
+	return s.repo.RefreshReservedFlags()
+}
+
+// RemindUpcomingBookings 为 lead 时间内即将开始的预订发送提醒，每个订单只提醒一次（发件箱去重键保证）
+// 返回扫描到的订单数
+func (s *Service) RemindUpcomingBookings(lead time.Duration) (int, error) {
+	now := time.Now()
+	orders, err := s.repo.FindUpcomingBookings(now, now.Add(lead))
+	if err != nil {
+		return 0, err
 	}
-	if err := s.repo.MarkSlotAsBooked(order.SpaceID, false); err != nil {
-		return errors.New("订单取消成功，但车位释放失败")
+	for _, o := range orders {
+		err := s.notifier.NotifyWithTx(inits.DB, notify.EventReservationReminder, notify.Recipient{UserID: o.UserID},
+			fmt.Sprintf("reservation_reminder:%d", o.OrderID), map[string]interface{}{
+				"order_id":         o.OrderID,
+				"lot_name":         o.Lot.Name,
+				"space_number":     o.Space.SpaceNumber,
+				"start_time":       o.StartTime.Format("2006-01-02 15:04"),
+				"end_time":         o.EndTime.Format("2006-01-02 15:04"),
+				"reservation_code": o.ReservationCode,
+			})
+		if err != nil {
+			return 0, err
+		}
 	}
-	return nil
+	return len(orders), nil
 }
 
 // ==================== 查询功能 ===================
@@ -192,18 +244,34 @@ func (s *Service) GetBookingDetail(orderID uint) (*model.ReservationOrder, error
 	return s.repo.GetBookingByID(orderID)
 }
 
+// GetUserBooking 查询用户自己的预订，订单不属于该用户时返回 ErrNotBookingOwner
+func (s *Service) GetUserBooking(orderID, userID uint) (*model.ReservationOrder, error) {
+	order, err := s.repo.GetBookingByID(orderID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBookingNotFound
+		}
+		return nil, err
+	}
+	if order.UserID != userID {
+		return nil, ErrNotBookingOwner
+	}
+	return order, nil
+}
+
 // ==================== 检查和更新超时预订 ====================
 // CheckAndUpdateExpiredBookings 检查并更新超时的预订记录
-// 将已超过结束时间且未完成的预订状态更新为已取消
+// 将已超过结束时间仍未入场（已预订）的预订更新为已取消，并按当前时间同步所有车位的预订标记
+// 使用中（车辆已入场）的预订不在此处理：由出场完成，超时未出场由违规规则 reservation_overstay 处理
 func (s *Service) CheckAndUpdateExpiredBookings() (int, error) {
 	now := time.Now()
 	var expiredBookings []model.ReservationOrder
 
-	// 查找所有已超过结束时间且状态为已预订(1)或使用中(2)的预订
+	// 查找所有已超过结束时间且状态为已预订(1)的预订
 	err := inits.DB.
 		Where("end_time < ?", now).
-		Where("status IN (?)", []int8{1, 2}). // 1-已预订, 2-使用中
-		Where("actual_end_time IS NULL").     // 未实际结束
+		Where("status = ?", 1).           // 1-已预订（未入场）
+		Where("actual_end_time IS NULL"). // 未实际结束
 		Find(&expiredBookings).Error
 
 	if err != nil {
@@ -212,23 +280,26 @@ func (s *Service) CheckAndUpdateExpiredBookings() (int, error) {
 
 	count := 0
 	for _, booking := range expiredBookings {
-		// 更新预订状态为已取消
+		// 条件更新为已取消：查询后车辆可能刚好入场（1→2），此时不再取消
 		actualEndTime := now
-		booking.Status = 0 // 0-已取消
-		booking.ActualEndTime = &actualEndTime
-
-		if err := s.repo.UpdateBooking(&booking); err != nil {
-			continue // 如果更新失败，跳过这条记录
-		}
-
-		// 释放车位
-		if err := s.repo.MarkSlotAsBooked(booking.SpaceID, false); err != nil {
-			// 记录错误但不影响主流程
-			fmt.Printf("释放车位失败: space_id=%d, error=%v\n", booking.SpaceID, err)
+		result := inits.DB.Model(&model.ReservationOrder{}).
+			Where("order_id = ? AND status = ?", booking.OrderID, 1).
+			Updates(map[string]interface{}{
+				"status":          0, // 0-已取消
+				"actual_end_time": &actualEndTime,
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue // 更新失败或状态已变化，跳过这条记录
 		}
 
 		count++
 	}
 
+	// 预订标记由时间推导：过期订单释放车位，进入保留窗口的订单占用车位
+	if err := s.repo.RefreshReservedFlags(); err != nil {
+		// 记录错误但不影响主流程
+		log.Printf("同步车位预订标记失败: error=%v", err)
+	}
+
 	return count, nil
 }
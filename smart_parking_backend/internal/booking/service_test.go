@@ -0,0 +1,143 @@
+package booking
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"smart_parking_backend/internal/tariff"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDSNEnv 测试数据库连接串（需已按 buildSQL.md 建表），未设置时跳过依赖数据库的测试
+const testDSNEnv = "SMART_PARKING_TEST_DSN"
+
+// openTestDB 连接测试数据库并替换 inits.DB，测试结束后恢复
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("未设置 %s，跳过依赖数据库的测试", testDSNEnv)
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("连接测试数据库失败: %v", err)
+	}
+	prev := inits.DB
+	inits.DB = db
+	t.Cleanup(func() {
+		inits.DB = prev
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// seedSingleSpaceLot 创建只有一个普通车位的停车场，以及 n 个各自拥有一辆车的用户
+// 返回停车场 ID 与 [用户ID, 车辆ID] 列表，测试结束后删除全部测试数据
+func seedSingleSpaceLot(t *testing.T, db *gorm.DB, n int) (uint, [][2]uint) {
+	t.Helper()
+	tag := time.Now().UnixNano() % 1e8
+
+	lot := model.ParkingLot{Name: fmt.Sprintf("并发测试停车场-%d", tag), Address: "test", TotalSpaces: 1, HourlyRate: 5, Status: 1}
+	if err := db.Create(&lot).Error; err != nil {
+		t.Fatalf("创建停车场失败: %v", err)
+	}
+	space := model.ParkingSpace{LotID: lot.LotID, Level: 1, SpaceNumber: "T-001", SpaceType: "普通", Status: 1}
+	if err := db.Create(&space).Error; err != nil {
+		t.Fatalf("创建车位失败: %v", err)
+	}
+
+	var userIDs []uint
+	owners := make([][2]uint, 0, n)
+	t.Cleanup(func() {
+		db.Where("lot_id = ?", lot.LotID).Delete(&model.ReservationOrder{})
+		if len(userIDs) > 0 {
+			db.Where("user_id IN ?", userIDs).Delete(&model.Vehicle{})
+			db.Where("user_id IN ?", userIDs).Delete(&model.Users_list{})
+		}
+		db.Delete(&space)
+		db.Delete(&lot)
+	})
+
+	for i := 0; i < n; i++ {
+		user := model.Users_list{
+			Username:     fmt.Sprintf("ct%d_%d", tag, i),
+			PasswordHash: "x",
+			Phone:        fmt.Sprintf("199%08d", (tag*100+int64(i))%1e8),
+		}
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatalf("创建用户失败: %v", err)
+		}
+		userIDs = append(userIDs, user.UserID)
+		vehicle := model.Vehicle{UserID: user.UserID, LicensePlate: fmt.Sprintf("测T%d%02d", tag%1e6, i)}
+		if err := db.Create(&vehicle).Error; err != nil {
+			t.Fatalf("创建车辆失败: %v", err)
+		}
+		owners = append(owners, [2]uint{user.UserID, vehicle.VehicleID})
+	}
+	return lot.LotID, owners
+}
+
+// TestCreateBookingConcurrentSingleSpace 多个用户并发预订同一停车场唯一车位的同一时间段，只能有一个成功
+func TestCreateBookingConcurrentSingleSpace(t *testing.T) {
+	db := openTestDB(t)
+
+	const concurrency = 10
+	lotID, owners := seedSingleSpaceLot(t, db, concurrency)
+	svc := NewService(NewRepository(), tariff.NewService(tariff.NewRepository()), nil)
+
+	start := time.Now().Add(2 * time.Hour).Truncate(time.Minute)
+	end := start.Add(2 * time.Hour)
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded []uint
+		failures  []error
+	)
+	ready := make(chan struct{})
+	for _, owner := range owners {
+		wg.Add(1)
+		go func(userID, vehicleID uint) {
+			defer wg.Done()
+			<-ready
+			order, err := svc.CreateBooking(userID, vehicleID, lotID, start, end, "普通")
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures = append(failures, err)
+				return
+			}
+			succeeded = append(succeeded, order.OrderID)
+		}(owner[0], owner[1])
+	}
+	close(ready)
+	wg.Wait()
+
+	if len(succeeded) != 1 {
+		t.Fatalf("并发预订同一车位应恰好成功 1 次，实际成功 %d 次（订单 %v），失败: %v", len(succeeded), succeeded, failures)
+	}
+	for _, err := range failures {
+		if err.Error() != "当前停车场该时间段无可用车位" {
+			t.Errorf("失败的预订应返回无可用车位，实际: %v", err)
+		}
+	}
+
+	var active int64
+	db.Model(&model.ReservationOrder{}).
+		Where("lot_id = ? AND status IN ?", lotID, []int8{1, 2}).
+		Where("start_time < ? AND end_time > ?", end, start).
+		Count(&active)
+	if active != 1 {
+		t.Fatalf("该车位在时间段内应只有 1 条有效预订，实际 %d 条", active)
+	}
+}
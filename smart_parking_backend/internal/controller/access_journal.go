@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"smart_parking_backend/internal/access"
+	"smart_parking_backend/internal/gate"
+	"smart_parking_backend/internal/model"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessJournal 通行日志服务实例（未初始化时不记录）
+var AccessJournal *access.Service
+
+// InitAccessJournal 初始化通行日志服务
+func InitAccessJournal(journal *access.Service) {
+	AccessJournal = journal
+}
+
+// accessAttempt 一次入场/出场尝试的来源，处理完成后连同结果写入通行日志
+type accessAttempt struct {
+	direction string
+	source    string
+	gateID    uint
+	gateCode  string
+	eventID   uint64
+	start     time.Time
+}
+
+// newAccessAttempt 接口请求的通行尝试，经闸机认证的请求记为闸机来源
+func newAccessAttempt(c *gin.Context, direction string) accessAttempt {
+	a := accessAttempt{direction: direction, source: access.SourceAPI, start: time.Now()}
+	if gateID := c.GetUint("gate_id"); gateID != 0 {
+		a.source, a.gateID, a.gateCode = access.SourceGate, gateID, c.GetString("gate_code")
+	}
+	return a
+}
+
+// replayAttempt 闸机补传事件重放的通行尝试
+func replayAttempt(src gate.ReplaySource, direction string) accessAttempt {
+	a := accessAttempt{
+		direction: direction,
+		source:    access.SourceSync,
+		gateID:    src.GateID,
+		gateCode:  src.GateCode,
+		eventID:   src.EventID,
+		start:     time.Now(),
+	}
+	if src.Manual {
+		a.source = access.SourceManual
+	}
+	return a
+}
+
+// journalEntry 记录入场尝试：匹配的预约、分配的车位与停车记录
+func (a accessAttempt) journalEntry(req VehicleEntryRequest, at time.Time, resp *VehicleEntryResponse, err error) {
+	if AccessJournal == nil {
+		return
+	}
+	ev := a.event(req.LicensePlate, "", req.LotID, at, err)
+	if resp != nil {
+		ev.TicketCode = resp.TicketCode
+		ev.ReservationID = resp.ReservationID
+		ev.SpaceID, ev.SpaceNumber = &resp.SpaceID, resp.SpaceNumber
+		ev.RecordID = &resp.RecordID
+		if resp.AlreadyInside {
+			ev.Reason = access.ReasonAlreadyInside
+		}
+	}
+	AccessJournal.Record(ev)
+}
+
+// journalExit 记录出场尝试：释放的车位、停车记录与应付金额，支付单创建失败时记为 payment_failed
+func (a accessAttempt) journalExit(req VehicleExitRequest, lotID uint, at time.Time, resp *VehicleExitResponse, err error) {
+	if AccessJournal == nil {
+		return
+	}
+	ev := a.event(req.LicensePlate, req.TicketCode, lotID, at, err)
+	if resp != nil {
+		ev.LotID = &resp.LotID
+		ev.TicketCode = resp.TicketCode
+		ev.ReservationID = resp.ReservationID
+		ev.SpaceID, ev.SpaceNumber = &resp.SpaceID, resp.SpaceNumber
+		ev.RecordID = &resp.RecordID
+		ev.Fee = resp.TotalFee
+		if resp.Replayed {
+			ev.Reason = access.ReasonExitReplayed
+		}
+		if resp.paymentErr != nil {
+			ev.Reason, ev.Message = access.ReasonPaymentFailed, "生成支付单失败: "+resp.paymentErr.Error()
+		}
+	}
+	AccessJournal.Record(ev)
+}
+
+// journalIdempotent 记录未实际办理的请求（幂等重放、幂等键冲突或无效），车牌等从原始请求体解析
+// recordID 为重放响应中的停车记录ID，err 为 nil 时记为幂等重放
+func (a accessAttempt) journalIdempotent(c *gin.Context, body []byte, recordID uint, err error) {
+	if AccessJournal == nil {
+		return
+	}
+	var req struct {
+		LicensePlate string `json:"license_plate"`
+		TicketCode   string `json:"ticket_code"`
+		LotID        uint   `json:"lot_id"`
+	}
+	_ = json.Unmarshal(body, &req)
+	lotID := req.LotID
+	if gateLotID := c.GetUint("gate_lot_id"); gateLotID != 0 {
+		lotID = gateLotID
+	}
+	ev := a.event(req.LicensePlate, req.TicketCode, lotID, a.start, err)
+	if err == nil {
+		ev.Reason = access.ReasonIdempotentReplay
+		if recordID != 0 {
+			ev.RecordID = &recordID
+		}
+	}
+	AccessJournal.Record(ev)
+}
+
+// event 按处理结果生成通行日志
+func (a accessAttempt) event(licensePlate, ticketCode string, lotID uint, at time.Time, err error) *model.AccessEvent {
+	ev := &model.AccessEvent{
+		Direction:    a.direction,
+		Source:       a.source,
+		GateCode:     a.gateCode,
+		LicensePlate: licensePlate,
+		TicketCode:   ticketCode,
+		Decision:     access.DecisionAllowed,
+		Reason:       access.ReasonOK,
+		HTTPStatus:   http.StatusOK,
+		LatencyMS:    time.Since(a.start).Milliseconds(),
+		OccurredAt:   at,
+	}
+	if lotID != 0 {
+		ev.LotID = &lotID
+	}
+	if a.gateID != 0 {
+		ev.GateID = &a.gateID
+	}
+	if a.eventID != 0 {
+		ev.GateEventID = &a.eventID
+	}
+	if err != nil {
+		ev.Decision, ev.Reason, ev.HTTPStatus, ev.Message = classifyAccessError(err)
+	}
+	return ev
+}
+
+// conflictReasons 补传冲突类型对应的通行日志原因编码
+var conflictReasons = map[string]string{
+	gate.ConflictDoubleEntry:      access.ReasonDoubleEntry,
+	gate.ConflictExitWithoutEntry: access.ReasonNoActiveRecord,
+	gate.ConflictExitBeforeEntry:  access.ReasonExitBeforeEntry,
+	gate.ConflictWrongLot:         access.ReasonWrongLot,
+}
+
+// classifyAccessError 入场/出场错误对应的处理结果、原因编码、HTTP 状态码与说明
+func classifyAccessError(err error) (decision, reason string, status int, message string) {
+	var pe *parkingError
+	if errors.As(err, &pe) {
+		decision = access.DecisionDenied
+		if pe.status >= http.StatusInternalServerError {
+			decision = access.DecisionError
+		}
+		return decision, pe.reason, pe.status, pe.message
+	}
+	var conflict *gate.ConflictError
+	if errors.As(err, &conflict) {
+		reason = conflictReasons[conflict.Type]
+		if reason == "" {
+			reason = access.ReasonInternal
+		}
+		return access.DecisionDenied, reason, http.StatusConflict, conflict.Message
+	}
+	return access.DecisionError, access.ReasonInternal, http.StatusInternalServerError, err.Error()
+}
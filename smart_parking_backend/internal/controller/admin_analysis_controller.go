@@ -3,6 +3,7 @@ package controller
 import (
 	"net/http"
 	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/middleware"
 	"smart_parking_backend/internal/model"
 	"strconv"
 	"time"
@@ -10,26 +11,62 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// ParkingSpaceOccupancyAnalysis 分析特定时间段内停车场车位的占用情况
-func ParkingSpaceOccupancyAnalysis(c *gin.Context) {
-	// 从中间件上下文中获取管理员角色和停车场信息
-	roleVal, _ := c.Get("role")
-	role, _ := roleVal.(string)
-
-	var lotIDUint uint
-	if lotID, exists := c.Get("lot_id"); exists && lotID != nil {
-		if v, ok := lotID.(uint); ok {
-			lotIDUint = v
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "停车场ID格式错误"})
-			return
+// analysisScope 确定统计范围：
+// 系统管理员指定 lot_id 时统计该停车场，未指定时统计全部停车场（全网）；
+// 停车场管理员可通过 lot_id 指定自己管理的停车场，未指定时取管理的第一个
+// 返回统计的停车场ID列表与单个停车场ID（全网统计时为 0），无权限时写入错误响应并返回 false
+func analysisScope(c *gin.Context) ([]uint, uint, bool) {
+	role := c.GetString("role")
+	if role != middleware.RoleSystem && role != middleware.RoleLotAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限查询或未分配停车场"})
+		return nil, 0, false
+	}
+	lotIDStr := c.Query("lot_id")
+	if lotIDStr == "" {
+		if role == middleware.RoleSystem {
+			lotIDs, err := allLotIDs()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "查询停车场失败"})
+				return nil, 0, false
+			}
+			return lotIDs, 0, true
+		}
+		lotID := c.GetUint("lot_id")
+		if lotID == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "无权限查询或未分配停车场"})
+			return nil, 0, false
 		}
+		return []uint{lotID}, lotID, true
+	}
+	lotID, err := strconv.ParseUint(lotIDStr, 10, 64)
+	if err != nil || lotID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的停车场ID"})
+		return nil, 0, false
 	}
+	if !middleware.CanManageLot(c, uint(lotID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限管理该停车场"})
+		return nil, 0, false
+	}
+	return []uint{uint(lotID)}, uint(lotID), true
+}
 
-	// 如果是系统管理员且未绑定具体停车场，则不允许直接访问该接口
-	//（当前实现仅支持针对单个停车场的分析）
-	if lotIDUint == 0 || role != "lot_admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "无权限查询或未分配停车场"})
+// scopeMetadata 在元数据中写入统计范围：单个停车场时 lot_id 为该停车场，全网统计时为 null
+func scopeMetadata(lotIDs []uint, lotID uint, metadata gin.H) gin.H {
+	metadata["scope"] = "lot"
+	metadata["lot_id"] = lotID
+	if lotID == 0 {
+		metadata["scope"] = "network"
+		metadata["lot_id"] = nil
+	}
+	metadata["lot_ids"] = lotIDs
+	return metadata
+}
+
+// ParkingSpaceOccupancyAnalysis 分析特定时间段内停车场车位的占用情况
+func ParkingSpaceOccupancyAnalysis(c *gin.Context) {
+	// 系统管理员可查询全网或任一停车场，停车场管理员只能查询自己管理的停车场
+	lotIDs, lotIDUint, ok := analysisScope(c)
+	if !ok {
 		return
 	}
 
@@ -56,7 +93,7 @@ func ParkingSpaceOccupancyAnalysis(c *gin.Context) {
 	}
 
 	// 查询车位占用情况
-	occupancyStats, err := getParkingOccupancyStats(lotIDUint, startTime, endTime)
+	occupancyStats, err := getParkingOccupancyStats(lotIDs, startTime, endTime)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据分析失败: " + err.Error()})
 		return
@@ -65,38 +102,37 @@ func ParkingSpaceOccupancyAnalysis(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message": "停车场分析成功",
 		"data":    occupancyStats,
-		"metadata": gin.H{
-			"lot_id":          lotIDUint,
+		"metadata": scopeMetadata(lotIDs, lotIDUint, gin.H{
 			"start_time":      startTime.Format(time.RFC3339),
 			"end_time":        endTime.Format(time.RFC3339),
 			"time_range_days": endTime.Sub(startTime).Hours() / 24,
-		},
+		}),
 	})
 }
 
 // getParkingOccupancyStats 封装统计分析逻辑
-func getParkingOccupancyStats(lotID uint, startTime, endTime time.Time) (map[string]interface{}, error) {
+func getParkingOccupancyStats(lotIDs []uint, startTime, endTime time.Time) (map[string]interface{}, error) {
 	var occupancyStats = make(map[string]interface{})
 
 	// 计算车位总数
 	var totalSpaces int64
 	err := inits.DB.Model(&model.ParkingSpace{}).
-		Where("lot_id = ?", lotID).
+		Where("lot_id IN ?", lotIDs).
 		Count(&totalSpaces).Error
 	if err != nil {
 		return nil, err
 	}
 	occupancyStats["total_spaces"] = totalSpaces
 
-	// 计算已占用车位数（在指定时间段内有停车记录的）
+	// 计算区间内使用过的车位数（在指定时间段内有停车记录的）
 	var occupiedSpaces int64
 	occupiedSubQuery := inits.DB.Model(&model.ParkingRecord{}).
 		Select("DISTINCT space_id").
-		Where("lot_id = ? AND entry_time <= ? AND (exit_time >= ? OR exit_time IS NULL)",
-			lotID, endTime, startTime)
+		Where("lot_id IN ? AND entry_time <= ? AND (exit_time >= ? OR exit_time IS NULL)",
+			lotIDs, endTime, startTime)
 
 	err = inits.DB.Model(&model.ParkingSpace{}).
-		Where("lot_id = ? AND space_id IN (?)", lotID, occupiedSubQuery).
+		Where("lot_id IN ? AND space_id IN (?)", lotIDs, occupiedSubQuery).
 		Count(&occupiedSpaces).Error
 	if err != nil {
 		return nil, err
@@ -107,23 +143,24 @@ func getParkingOccupancyStats(lotID uint, startTime, endTime time.Time) (map[str
 	var reservedSpaces int64
 	reserveSubQuery := inits.DB.Model(&model.ReservationOrder{}).
 		Select("DISTINCT space_id").
-		Where("lot_id = ? AND start_time <= ? AND end_time >= ? AND status = ?",
-			lotID, endTime, startTime, 1) // 1-已预订
+		Where("lot_id IN ? AND start_time <= ? AND end_time >= ? AND status = ?",
+			lotIDs, endTime, startTime, 1) // 1-已预订
 
 	err = inits.DB.Model(&model.ParkingSpace{}).
-		Where("lot_id = ? AND space_id IN (?)", lotID, reserveSubQuery).
+		Where("lot_id IN ? AND space_id IN (?)", lotIDs, reserveSubQuery).
 		Count(&reservedSpaces).Error
 	if err != nil {
 		return nil, err
 	}
 	occupancyStats["reserved_spaces"] = reservedSpaces
 
-	// 计算占用率
-	occupancyRate := 0.0
-	if totalSpaces > 0 {
-		occupancyRate = float64(occupiedSpaces) / float64(totalSpaces) * 100
+	// 计算占用率（时间加权：占用的车位分钟数 / 可用的车位分钟数）
+	timeWeighted, err := getTimeWeightedOccupancy(lotIDs, nil, startTime, endTime)
+	if err != nil {
+		return nil, err
 	}
-	occupancyStats["occupancy_rate"] = occupancyRate
+	occupancyStats["occupancy_rate"] = timeWeighted.OccupancyRate
+	occupancyStats["time_weighted"] = timeWeighted
 
 	// 计算总收入
 	var incomeResult struct {
@@ -131,7 +168,7 @@ func getParkingOccupancyStats(lotID uint, startTime, endTime time.Time) (map[str
 	}
 	err = inits.DB.Model(&model.ParkingRecord{}).
 		Select("COALESCE(SUM(fee_paid), 0) as total_income").
-		Where("lot_id = ? AND entry_time BETWEEN ? AND ?", lotID, startTime, endTime).
+		Where("lot_id IN ? AND entry_time BETWEEN ? AND ?", lotIDs, startTime, endTime).
 		Scan(&incomeResult).Error
 	if err != nil {
 		return nil, err
@@ -152,7 +189,7 @@ func getParkingOccupancyStats(lotID uint, startTime, endTime time.Time) (map[str
 	}
 	err = inits.DB.Model(&model.ParkingRecord{}).
 		Select("COALESCE(AVG(duration_minutes), 0) as avg_duration").
-		Where("lot_id = ? AND entry_time BETWEEN ? AND ?", lotID, startTime, endTime).
+		Where("lot_id IN ? AND entry_time BETWEEN ? AND ?", lotIDs, startTime, endTime).
 		Scan(&avgDuration).Error
 	if err != nil {
 		return nil, err
@@ -170,7 +207,7 @@ func getParkingOccupancyStats(lotID uint, startTime, endTime time.Time) (map[str
 	
 	// 普通车位总数
 	err = inits.DB.Model(&model.ParkingSpace{}).
-		Where("lot_id = ? AND space_type = ?", lotID, "普通").
+		Where("lot_id IN ? AND space_type = ?", lotIDs, "普通").
 		Count(&normalStats.Total).Error
 	if err != nil {
 		return nil, err
@@ -179,11 +216,11 @@ func getParkingOccupancyStats(lotID uint, startTime, endTime time.Time) (map[str
 	// 普通车位已占用数
 	normalOccupiedSubQuery := inits.DB.Model(&model.ParkingRecord{}).
 		Select("DISTINCT space_id").
-		Where("lot_id = ? AND entry_time <= ? AND (exit_time >= ? OR exit_time IS NULL)",
-			lotID, endTime, startTime)
+		Where("lot_id IN ? AND entry_time <= ? AND (exit_time >= ? OR exit_time IS NULL)",
+			lotIDs, endTime, startTime)
 	
 	err = inits.DB.Model(&model.ParkingSpace{}).
-		Where("lot_id = ? AND space_type = ? AND space_id IN (?)", lotID, "普通", normalOccupiedSubQuery).
+		Where("lot_id IN ? AND space_type = ? AND space_id IN (?)", lotIDs, "普通", normalOccupiedSubQuery).
 		Count(&normalStats.Occupied).Error
 	if err != nil {
 		return nil, err
@@ -203,7 +240,7 @@ func getParkingOccupancyStats(lotID uint, startTime, endTime time.Time) (map[str
 	
 	// 充电车位总数
 	err = inits.DB.Model(&model.ParkingSpace{}).
-		Where("lot_id = ? AND space_type = ?", lotID, "充电").
+		Where("lot_id IN ? AND space_type = ?", lotIDs, "充电").
 		Count(&chargingStats.Total).Error
 	if err != nil {
 		return nil, err
@@ -212,11 +249,11 @@ func getParkingOccupancyStats(lotID uint, startTime, endTime time.Time) (map[str
 	// 充电车位已占用数
 	chargingOccupiedSubQuery := inits.DB.Model(&model.ParkingRecord{}).
 		Select("DISTINCT space_id").
-		Where("lot_id = ? AND entry_time <= ? AND (exit_time >= ? OR exit_time IS NULL)",
-			lotID, endTime, startTime)
+		Where("lot_id IN ? AND entry_time <= ? AND (exit_time >= ? OR exit_time IS NULL)",
+			lotIDs, endTime, startTime)
 	
 	err = inits.DB.Model(&model.ParkingSpace{}).
-		Where("lot_id = ? AND space_type = ? AND space_id IN (?)", lotID, "充电", chargingOccupiedSubQuery).
+		Where("lot_id IN ? AND space_type = ? AND space_id IN (?)", lotIDs, "充电", chargingOccupiedSubQuery).
 		Count(&chargingStats.Occupied).Error
 	if err != nil {
 		return nil, err
@@ -252,26 +289,9 @@ func getParkingOccupancyStats(lotID uint, startTime, endTime time.Time) (map[str
 
 // ViolationAnalysis 统计和分析违规停车行为的数量及其处理情况
 func ViolationAnalysis(c *gin.Context) {
-	// 直接从中间件设置的上下文中获取管理员信息
-	roleVal, _ := c.Get("role")
-	role, _ := roleVal.(string)
-
-	lotID, exists := c.Get("lot_id")
-	if !exists || lotID == nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": "无权限查询或未分配停车场"})
-		return
-	}
-
-	// 类型断言，确保lotID是uint类型
-	lotIDUint, ok := lotID.(uint)
+	// 系统管理员可查询全网或任一停车场，停车场管理员只能查询自己管理的停车场
+	lotIDs, lotIDUint, ok := analysisScope(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "停车场ID格式错误"})
-		return
-	}
-
-	// 违规分析同样只允许停车场管理员查询自己停车场的数据
-	if role != "lot_admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "无权限查询或未分配停车场"})
 		return
 	}
 
@@ -301,7 +321,7 @@ func ViolationAnalysis(c *gin.Context) {
 	endTime := startTime.AddDate(0, 1, 0).Add(-time.Nanosecond)
 
 	// 获取违规统计数据
-	violationStats, err := getViolationStats(lotIDUint, startTime, endTime)
+	violationStats, err := getViolationStats(lotIDs, startTime, endTime)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "违规数据分析失败: " + err.Error()})
 		return
@@ -310,171 +330,105 @@ func ViolationAnalysis(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message": "违规分析成功",
 		"data":    violationStats,
-		"metadata": gin.H{
-			"lot_id":     lotIDUint,
+		"metadata": scopeMetadata(lotIDs, lotIDUint, gin.H{
 			"year":       year,
 			"month":      month,
 			"start_time": startTime.Format(time.RFC3339),
 			"end_time":   endTime.Format(time.RFC3339),
-		},
+		}),
 	})
 }
 
 // getViolationStats 获取违规统计数据
-func getViolationStats(lotID uint, startTime, endTime time.Time) (map[string]interface{}, error) {
+func getViolationStats(lotIDs []uint, startTime, endTime time.Time) (map[string]interface{}, error) {
 	var stats = make(map[string]interface{})
 
-	// 1. 统计总违规次数（包括所有类型的违规）
-	// 先统计有停车记录关联的违规
-	var violationsWithRecord int64
+	// 1. 统计总违规次数（按违规记录的 lot_id 归属停车场，包括没有停车记录的预订类违规）
+	var totalViolations int64
 	err := inits.DB.Model(&model.ViolationRecord{}).
-		Joins("JOIN parking_record ON violation_record.record_id = parking_record.record_id").
-		Where("parking_record.lot_id = ? AND violation_record.violation_time BETWEEN ? AND ?",
-			lotID, startTime, endTime).
-		Count(&violationsWithRecord).Error
+		Where("violation_record.lot_id IN ? AND violation_record.violation_time BETWEEN ? AND ?",
+			lotIDs, startTime, endTime).
+		Count(&totalViolations).Error
 	if err != nil {
 		return nil, err
 	}
-	
-	// 统计未支付停车费违规（通过车辆关联到该停车场的停车记录）
-	var unpaidParkingViolationsCount int64
-	err = inits.DB.Model(&model.ViolationRecord{}).
-		Joins("JOIN vehicle ON violation_record.vehicle_id = vehicle.vehicle_id").
-		Joins("JOIN parking_record ON vehicle.vehicle_id = parking_record.vehicle_id").
-		Where("parking_record.lot_id = ? AND violation_record.violation_type = ? AND violation_record.violation_time BETWEEN ? AND ?",
-			lotID, "未支付停车费", startTime, endTime).
-		Distinct("violation_record.violation_id").
-		Count(&unpaidParkingViolationsCount).Error
-	if err != nil {
-		// 如果查询失败，设为0（保守策略）
-		unpaidParkingViolationsCount = 0
-	}
-	
-	totalViolations := violationsWithRecord + unpaidParkingViolationsCount
 	stats["total_violations"] = totalViolations
 
 	// 2. 按违规类型统计（确保包含三种类型：超时停车、预订未使用、未支付停车费）
-	// 先统计所有违规类型
 	var violationsByTypeRaw []struct {
 		ViolationType string
 		Count         int64
 	}
-	
-	// 查询有停车记录关联的违规（超时停车、预订未使用等）
 	err = inits.DB.Model(&model.ViolationRecord{}).
 		Select("violation_type, COUNT(*) as count").
-		Joins("JOIN parking_record ON violation_record.record_id = parking_record.record_id").
-		Where("parking_record.lot_id = ? AND violation_record.violation_time BETWEEN ? AND ?",
-			lotID, startTime, endTime).
+		Where("violation_record.lot_id IN ? AND violation_record.violation_time BETWEEN ? AND ?",
+			lotIDs, startTime, endTime).
 		Group("violation_type").
 		Scan(&violationsByTypeRaw).Error
 	if err != nil {
 		return nil, err
 	}
-	
-	// 查询未支付停车费违规（通过车辆关联到该停车场的停车记录）
-	// 注意：未支付停车费的record_id可能为0，需要通过车辆关联停车场
-	var unpaidParkingViolations int64
-	err = inits.DB.Model(&model.ViolationRecord{}).
-		Joins("JOIN vehicle ON violation_record.vehicle_id = vehicle.vehicle_id").
-		Joins("JOIN parking_record ON vehicle.vehicle_id = parking_record.vehicle_id").
-		Where("parking_record.lot_id = ? AND violation_record.violation_type = ? AND violation_record.violation_time BETWEEN ? AND ?",
-			lotID, "未支付停车费", startTime, endTime).
-		Distinct("violation_record.violation_id").
-		Count(&unpaidParkingViolations).Error
-	if err != nil {
-		// 如果查询失败，设为0（保守策略）
-		unpaidParkingViolations = 0
-	}
-	
+
 	// 构建标准化的违规类型统计（确保三种类型都存在）
 	violationsByType := make([]map[string]interface{}, 0)
 	typeCountMap := make(map[string]int64)
-	
+
 	// 将查询结果放入map
 	for _, item := range violationsByTypeRaw {
 		typeCountMap[item.ViolationType] = item.Count
 	}
-	
-	// 添加未支付停车费统计
-	if unpaidParkingViolations > 0 {
-		typeCountMap["未支付停车费"] = unpaidParkingViolations
-	}
-	
-	// 确保三种类型都存在（即使为0）
+
+	// 确保三种类型都存在（即使为0），其它类型（如未支付罚款）按实际数据追加
 	requiredTypes := []string{"超时停车", "预订未使用", "未支付停车费"}
 	for _, vType := range requiredTypes {
-		count := typeCountMap[vType]
-		if count == 0 {
-			// 如果该类型没有数据，也要显示为0
-			count = 0
-		}
 		violationsByType = append(violationsByType, map[string]interface{}{
 			"violation_type": vType,
-			"count":          count,
+			"count":          typeCountMap[vType],
 		})
+		delete(typeCountMap, vType)
 	}
-	
+	for _, item := range violationsByTypeRaw {
+		if count, ok := typeCountMap[item.ViolationType]; ok {
+			violationsByType = append(violationsByType, map[string]interface{}{
+				"violation_type": item.ViolationType,
+				"count":          count,
+			})
+		}
+	}
+
 	stats["violations_by_type"] = violationsByType
 
-	// 3. 按处理状态统计（包括所有类型的违规）
-	// 先统计有停车记录关联的违规状态
+	// 3. 按处理状态统计
 	var violationsByStatusRaw []struct {
 		Status int8
 		Count  int64
 	}
 	err = inits.DB.Model(&model.ViolationRecord{}).
 		Select("violation_record.status, COUNT(*) as count").
-		Joins("JOIN parking_record ON violation_record.record_id = parking_record.record_id").
-		Where("parking_record.lot_id = ? AND violation_record.violation_time BETWEEN ? AND ?",
-			lotID, startTime, endTime).
+		Where("violation_record.lot_id IN ? AND violation_record.violation_time BETWEEN ? AND ?",
+			lotIDs, startTime, endTime).
 		Group("violation_record.status").
 		Scan(&violationsByStatusRaw).Error
 	if err != nil {
 		return nil, err
 	}
-	
-	// 统计未支付停车费违规的状态（通过车辆关联到该停车场的停车记录）
-	var unpaidStatusStats []struct {
-		Status int8
-		Count  int64
-	}
-	err = inits.DB.Model(&model.ViolationRecord{}).
-		Select("violation_record.status, COUNT(DISTINCT violation_record.violation_id) as count").
-		Joins("JOIN vehicle ON violation_record.vehicle_id = vehicle.vehicle_id").
-		Joins("JOIN parking_record ON vehicle.vehicle_id = parking_record.vehicle_id").
-		Where("parking_record.lot_id = ? AND violation_record.violation_type = ? AND violation_record.violation_time BETWEEN ? AND ?",
-			lotID, "未支付停车费", startTime, endTime).
-		Group("violation_record.status").
-		Scan(&unpaidStatusStats).Error
-	if err != nil {
-		// 如果查询失败，忽略未支付停车费的状态统计
-		unpaidStatusStats = []struct {
-			Status int8
-			Count  int64
-		}{}
-	}
-	
-	// 合并状态统计
+
 	statusMap := make(map[int8]int64)
 	for _, item := range violationsByStatusRaw {
 		statusMap[item.Status] += item.Count
 	}
-	for _, item := range unpaidStatusStats {
-		statusMap[item.Status] += item.Count
-	}
-	
-	// 构建标准化的状态统计（确保两种状态都存在）
+
+	// 构建标准化的状态统计（确保各状态都存在）
 	violationsByStatus := make([]map[string]interface{}, 0)
-	// 确保显示两种状态：0-未处理，1-已处理
-	for _, status := range []int8{0, 1} {
+	// 确保显示全部状态：0-未处理，1-已处理，2-申诉中，3-已免除
+	for _, status := range []int8{0, 1, 2, 3} {
 		count := statusMap[status]
 		violationsByStatus = append(violationsByStatus, map[string]interface{}{
 			"status": status,
 			"count":  count,
 		})
 	}
-	
+
 	stats["violations_by_status"] = violationsByStatus
 
 	// 4. 统计罚款总额
@@ -483,9 +437,8 @@ func getViolationStats(lotID uint, startTime, endTime time.Time) (map[string]int
 	}
 	err = inits.DB.Model(&model.ViolationRecord{}).
 		Select("COALESCE(SUM(fine_amount), 0) as total_fines").
-		Joins("JOIN parking_record ON violation_record.record_id = parking_record.record_id").
-		Where("parking_record.lot_id = ? AND violation_record.violation_time BETWEEN ? AND ?",
-			lotID, startTime, endTime).
+		Where("violation_record.lot_id IN ? AND violation_record.violation_time BETWEEN ? AND ?",
+			lotIDs, startTime, endTime).
 		Scan(&totalFines).Error
 	if err != nil {
 		return nil, err
@@ -498,9 +451,8 @@ func getViolationStats(lotID uint, startTime, endTime time.Time) (map[string]int
 	}
 	err = inits.DB.Model(&model.ViolationRecord{}).
 		Select("COALESCE(SUM(fine_amount), 0) as collected_fines").
-		Joins("JOIN parking_record ON violation_record.record_id = parking_record.record_id").
-		Where("parking_record.lot_id = ? AND violation_record.violation_time BETWEEN ? AND ? AND violation_record.status = ?",
-			lotID, startTime, endTime, 1). // 状态1表示已处理
+		Where("violation_record.lot_id IN ? AND violation_record.violation_time BETWEEN ? AND ? AND violation_record.status = ?",
+			lotIDs, startTime, endTime, 1). // 状态1表示已处理
 		Scan(&collectedFines).Error
 	if err != nil {
 		return nil, err
@@ -508,7 +460,7 @@ func getViolationStats(lotID uint, startTime, endTime time.Time) (map[string]int
 	stats["collected_fines"] = collectedFines.CollectedFines
 
 	// 6. 月度趋势分析（最近6个月）
-	monthlyTrend, err := getViolationTrend(lotID, 6)
+	monthlyTrend, err := getViolationTrend(lotIDs, 6)
 	if err != nil {
 		return nil, err
 	}
@@ -518,7 +470,7 @@ func getViolationStats(lotID uint, startTime, endTime time.Time) (map[string]int
 }
 
 // getViolationTrend 获取违规趋势数据
-func getViolationTrend(lotID uint, months int) ([]map[string]interface{}, error) {
+func getViolationTrend(lotIDs []uint, months int) ([]map[string]interface{}, error) {
 	var trend []map[string]interface{}
 
 	now := time.Now()
@@ -534,9 +486,8 @@ func getViolationTrend(lotID uint, months int) ([]map[string]interface{}, error)
 
 		// 总违规数
 		err := inits.DB.Model(&model.ViolationRecord{}).
-			Joins("JOIN parking_record ON violation_record.record_id = parking_record.record_id").
-			Where("parking_record.lot_id = ? AND violation_record.violation_time BETWEEN ? AND ?",
-				lotID, startTime, endTime).
+			Where("violation_record.lot_id IN ? AND violation_record.violation_time BETWEEN ? AND ?",
+				lotIDs, startTime, endTime).
 			Count(&monthlyStats.TotalViolations).Error
 		if err != nil {
 			return nil, err
@@ -544,9 +495,8 @@ func getViolationTrend(lotID uint, months int) ([]map[string]interface{}, error)
 
 		// 已处理数
 		err = inits.DB.Model(&model.ViolationRecord{}).
-			Joins("JOIN parking_record ON violation_record.record_id = parking_record.record_id").
-			Where("parking_record.lot_id = ? AND violation_record.violation_time BETWEEN ? AND ? AND violation_record.status = ?",
-				lotID, startTime, endTime, 1).
+			Where("violation_record.lot_id IN ? AND violation_record.violation_time BETWEEN ? AND ? AND violation_record.status = ?",
+				lotIDs, startTime, endTime, 1).
 			Count(&monthlyStats.ProcessedCount).Error
 		if err != nil {
 			return nil, err
@@ -555,9 +505,8 @@ func getViolationTrend(lotID uint, months int) ([]map[string]interface{}, error)
 		// 罚款总额
 		err = inits.DB.Model(&model.ViolationRecord{}).
 			Select("COALESCE(SUM(fine_amount), 0) as total_fines").
-			Joins("JOIN parking_record ON violation_record.record_id = parking_record.record_id").
-			Where("parking_record.lot_id = ? AND violation_record.violation_time BETWEEN ? AND ?",
-				lotID, startTime, endTime).
+			Where("violation_record.lot_id IN ? AND violation_record.violation_time BETWEEN ? AND ?",
+				lotIDs, startTime, endTime).
 			Scan(&monthlyStats.TotalFines).Error
 		if err != nil {
 			return nil, err
@@ -577,26 +526,9 @@ func getViolationTrend(lotID uint, months int) ([]map[string]interface{}, error)
 
 // GenerateReport 生成月度报告和年度报告
 func GenerateReport(c *gin.Context) {
-	// 直接从中间件设置的上下文中获取管理员信息
-	roleVal, _ := c.Get("role")
-	role, _ := roleVal.(string)
-
-	lotID, exists := c.Get("lot_id")
-	if !exists || lotID == nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": "无权限查询或未分配停车场"})
-		return
-	}
-
-	// 类型断言
-	lotIDUint, ok := lotID.(uint)
+	// 系统管理员可查询全网或任一停车场，停车场管理员只能查询自己管理的停车场
+	lotIDs, lotIDUint, ok := analysisScope(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "停车场ID格式错误"})
-		return
-	}
-
-	// 报表生成同样只允许停车场管理员查看自己停车场的数据
-	if role != "lot_admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "无权限查询或未分配停车场"})
 		return
 	}
 
@@ -636,7 +568,7 @@ func GenerateReport(c *gin.Context) {
 	}
 
 	// 生成综合报告
-	report, err := generateComprehensiveReport(lotIDUint, startTime, endTime, reportType)
+	report, err := generateComprehensiveReport(lotIDs, startTime, endTime, reportType)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "报告生成失败: " + err.Error()})
 		return
@@ -645,19 +577,18 @@ func GenerateReport(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message": "报告生成成功",
 		"report":  report,
-		"metadata": gin.H{
-			"lot_id":      lotIDUint,
+		"metadata": scopeMetadata(lotIDs, lotIDUint, gin.H{
 			"report_type": reportType,
 			"year":        year,
 			"month":       monthStr,
 			"start_time":  startTime.Format(time.RFC3339),
 			"end_time":    endTime.Format(time.RFC3339),
-		},
+		}),
 	})
 }
 
 // generateComprehensiveReport 生成综合报告
-func generateComprehensiveReport(lotID uint, startTime, endTime time.Time, reportType string) (map[string]interface{}, error) {
+func generateComprehensiveReport(lotIDs []uint, startTime, endTime time.Time, reportType string) (map[string]interface{}, error) {
 	var report = make(map[string]interface{})
 
 	// 基础信息
@@ -666,35 +597,35 @@ func generateComprehensiveReport(lotID uint, startTime, endTime time.Time, repor
 	report["generated_at"] = time.Now().Format(time.RFC3339)
 
 	// 1. 停车数据统计
-	parkingStats, err := getParkingStatsForReport(lotID, startTime, endTime)
+	parkingStats, err := getParkingStatsForReport(lotIDs, startTime, endTime)
 	if err != nil {
 		return nil, err
 	}
 	report["parking_statistics"] = parkingStats
 
 	// 2. 违规数据统计
-	violationStats, err := getViolationStatsForReport(lotID, startTime, endTime)
+	violationStats, err := getViolationStatsForReport(lotIDs, startTime, endTime)
 	if err != nil {
 		return nil, err
 	}
 	report["violation_statistics"] = violationStats
 
 	// 3. 收入统计
-	revenueStats, err := getRevenueStats(lotID, startTime, endTime)
+	revenueStats, err := getRevenueStats(lotIDs, startTime, endTime)
 	if err != nil {
 		return nil, err
 	}
 	report["revenue_statistics"] = revenueStats
 
 	// 4. 车位使用率分析
-	occupancyStats, err := getOccupancyStatsForReport(lotID, startTime, endTime)
+	occupancyStats, err := getOccupancyStatsForReport(lotIDs, startTime, endTime)
 	if err != nil {
 		return nil, err
 	}
 	report["occupancy_statistics"] = occupancyStats
 
 	// 5. 高峰时段分析
-	peakHours, err := getPeakHoursAnalysis(lotID, startTime, endTime)
+	peakHours, err := getPeakHoursAnalysis(lotIDs, startTime, endTime)
 	if err != nil {
 		return nil, err
 	}
@@ -704,13 +635,13 @@ func generateComprehensiveReport(lotID uint, startTime, endTime time.Time, repor
 }
 
 // getParkingStatsForReport 获取停车统计数据用于报告
-func getParkingStatsForReport(lotID uint, startTime, endTime time.Time) (map[string]interface{}, error) {
+func getParkingStatsForReport(lotIDs []uint, startTime, endTime time.Time) (map[string]interface{}, error) {
 	var stats = make(map[string]interface{})
 
 	// 总停车次数
 	var totalParkings int64
 	err := inits.DB.Model(&model.ParkingRecord{}).
-		Where("lot_id = ? AND entry_time BETWEEN ? AND ?", lotID, startTime, endTime).
+		Where("lot_id IN ? AND entry_time BETWEEN ? AND ?", lotIDs, startTime, endTime).
 		Count(&totalParkings).Error
 	if err != nil {
 		return nil, err
@@ -723,8 +654,8 @@ func getParkingStatsForReport(lotID uint, startTime, endTime time.Time) (map[str
 	}
 	err = inits.DB.Model(&model.ParkingRecord{}).
 		Select("COALESCE(AVG(duration_minutes), 0) as avg_duration").
-		Where("lot_id = ? AND entry_time BETWEEN ? AND ? AND exit_time IS NOT NULL",
-			lotID, startTime, endTime).
+		Where("lot_id IN ? AND entry_time BETWEEN ? AND ? AND exit_time IS NOT NULL",
+			lotIDs, startTime, endTime).
 		Scan(&avgDuration).Error
 	if err != nil {
 		return nil, err
@@ -739,8 +670,8 @@ func getParkingStatsForReport(lotID uint, startTime, endTime time.Time) (map[str
 	err = inits.DB.Model(&model.ParkingRecord{}).
 		Select("vehicle.brand, COUNT(*) as count").
 		Joins("JOIN vehicle ON parking_record.vehicle_id = vehicle.vehicle_id").
-		Where("parking_record.lot_id = ? AND parking_record.entry_time BETWEEN ? AND ?",
-			lotID, startTime, endTime).
+		Where("parking_record.lot_id IN ? AND parking_record.entry_time BETWEEN ? AND ?",
+			lotIDs, startTime, endTime).
 		Group("vehicle.brand").
 		Scan(&vehicleBrandStats).Error
 	if err != nil {
@@ -752,15 +683,14 @@ func getParkingStatsForReport(lotID uint, startTime, endTime time.Time) (map[str
 }
 
 // getViolationStatsForReport 获取违规统计数据用于报告
-func getViolationStatsForReport(lotID uint, startTime, endTime time.Time) (map[string]interface{}, error) {
+func getViolationStatsForReport(lotIDs []uint, startTime, endTime time.Time) (map[string]interface{}, error) {
 	var stats = make(map[string]interface{})
 
 	// 总违规次数
 	var totalViolations int64
 	err := inits.DB.Model(&model.ViolationRecord{}).
-		Joins("JOIN parking_record ON violation_record.record_id = parking_record.record_id").
-		Where("parking_record.lot_id = ? AND violation_record.violation_time BETWEEN ? AND ?",
-			lotID, startTime, endTime).
+		Where("violation_record.lot_id IN ? AND violation_record.violation_time BETWEEN ? AND ?",
+			lotIDs, startTime, endTime).
 		Count(&totalViolations).Error
 	if err != nil {
 		return nil, err
@@ -770,9 +700,8 @@ func getViolationStatsForReport(lotID uint, startTime, endTime time.Time) (map[s
 	// 违规处理率
 	var processedViolations int64
 	err = inits.DB.Model(&model.ViolationRecord{}).
-		Joins("JOIN parking_record ON violation_record.record_id = parking_record.record_id").
-		Where("parking_record.lot_id = ? AND violation_record.violation_time BETWEEN ? AND ? AND violation_record.status = ?",
-			lotID, startTime, endTime, 1).
+		Where("violation_record.lot_id IN ? AND violation_record.violation_time BETWEEN ? AND ? AND violation_record.status = ?",
+			lotIDs, startTime, endTime, 1).
 		Count(&processedViolations).Error
 	if err != nil {
 		return nil, err
@@ -790,9 +719,8 @@ func getViolationStatsForReport(lotID uint, startTime, endTime time.Time) (map[s
 			COALESCE(SUM(fine_amount), 0) as total_fines,
 			COALESCE(SUM(CASE WHEN status = 1 THEN fine_amount ELSE 0 END), 0) as collected_fines
 		`).
-		Joins("JOIN parking_record ON violation_record.record_id = parking_record.record_id").
-		Where("parking_record.lot_id = ? AND violation_record.violation_time BETWEEN ? AND ?",
-			lotID, startTime, endTime).
+		Where("violation_record.lot_id IN ? AND violation_record.violation_time BETWEEN ? AND ?",
+			lotIDs, startTime, endTime).
 		Scan(&fineStats).Error
 	if err != nil {
 		return nil, err
@@ -805,7 +733,7 @@ func getViolationStatsForReport(lotID uint, startTime, endTime time.Time) (map[s
 }
 
 // getRevenueStats 获取收入统计数据
-func getRevenueStats(lotID uint, startTime, endTime time.Time) (map[string]interface{}, error) {
+func getRevenueStats(lotIDs []uint, startTime, endTime time.Time) (map[string]interface{}, error) {
 	var stats = make(map[string]interface{})
 
 	// 停车费总收入
@@ -814,7 +742,7 @@ func getRevenueStats(lotID uint, startTime, endTime time.Time) (map[string]inter
 	}
 	err := inits.DB.Model(&model.ParkingRecord{}).
 		Select("COALESCE(SUM(fee_paid), 0) as total_income").
-		Where("lot_id = ? AND entry_time BETWEEN ? AND ?", lotID, startTime, endTime).
+		Where("lot_id IN ? AND entry_time BETWEEN ? AND ?", lotIDs, startTime, endTime).
 		Scan(&parkingIncome).Error
 	if err != nil {
 		return nil, err
@@ -827,9 +755,8 @@ func getRevenueStats(lotID uint, startTime, endTime time.Time) (map[string]inter
 	}
 	err = inits.DB.Model(&model.ViolationRecord{}).
 		Select("COALESCE(SUM(fine_amount), 0) as fine_income").
-		Joins("JOIN parking_record ON violation_record.record_id = parking_record.record_id").
-		Where("parking_record.lot_id = ? AND violation_record.violation_time BETWEEN ? AND ? AND violation_record.status = ?",
-			lotID, startTime, endTime, 1).
+		Where("violation_record.lot_id IN ? AND violation_record.violation_time BETWEEN ? AND ? AND violation_record.status = ?",
+			lotIDs, startTime, endTime, 1).
 		Scan(&fineIncome).Error
 	if err != nil {
 		return nil, err
@@ -839,7 +766,7 @@ func getRevenueStats(lotID uint, startTime, endTime time.Time) (map[string]inter
 
 	// 月度收入趋势（如果是年度报告）
 	if endTime.Sub(startTime).Hours()/24 > 90 { // 超过3个月，显示月度趋势
-		monthlyRevenue, err := getMonthlyRevenueTrend(lotID, startTime, endTime)
+		monthlyRevenue, err := getMonthlyRevenueTrend(lotIDs, startTime, endTime)
 		if err != nil {
 			return nil, err
 		}
@@ -850,46 +777,39 @@ func getRevenueStats(lotID uint, startTime, endTime time.Time) (map[string]inter
 }
 
 // getOccupancyStatsForReport 获取车位使用率统计用于报告
-func getOccupancyStatsForReport(lotID uint, startTime, endTime time.Time) (map[string]interface{}, error) {
+func getOccupancyStatsForReport(lotIDs []uint, startTime, endTime time.Time) (map[string]interface{}, error) {
 	var stats = make(map[string]interface{})
 
-	// 获取总车位数
-	var totalSpaces int64
-	err := inits.DB.Model(&model.ParkingSpace{}).
-		Where("lot_id = ? AND status = 1", lotID). // 只统计可用的车位
-		Count(&totalSpaces).Error
+	// 时间加权使用率：按停车记录的入场、出场区间计算占用的车位分钟数
+	occupancy, err := getTimeWeightedOccupancy(lotIDs, nil, startTime, endTime)
 	if err != nil {
 		return nil, err
 	}
 
-	// 计算平均日使用率
-	days := endTime.Sub(startTime).Hours() / 24
-	if days < 1 {
-		days = 1
-	}
-
-	// 估算总可能停车车次
-	totalPossibleParkings := totalSpaces * int64(days)
-
 	// 实际停车次数
 	var actualParkings int64
 	err = inits.DB.Model(&model.ParkingRecord{}).
-		Where("lot_id = ? AND entry_time BETWEEN ? AND ?", lotID, startTime, endTime).
+		Where("lot_id IN ? AND entry_time BETWEEN ? AND ?", lotIDs, startTime, endTime).
 		Count(&actualParkings).Error
 	if err != nil {
 		return nil, err
 	}
 
-	stats["total_spaces"] = totalSpaces
-	stats["total_possible_parkings"] = totalPossibleParkings
+	stats["total_spaces"] = occupancy.TotalSpaces
 	stats["actual_parkings"] = actualParkings
-	stats["estimated_occupancy_rate"] = calculateRate(actualParkings, totalPossibleParkings)
+	stats["occupied_space_minutes"] = occupancy.OccupiedMinutes
+	stats["available_space_minutes"] = occupancy.AvailableMinutes
+	stats["occupancy_rate"] = occupancy.OccupancyRate
+	stats["peak_hour"] = occupancy.peakHour()
+	stats["by_hour"] = occupancy.ByHour
+	stats["by_level"] = occupancy.ByLevel
+	stats["by_type"] = occupancy.ByType
 
 	return stats, nil
 }
 
 // getPeakHoursAnalysis 获取高峰时段分析
-func getPeakHoursAnalysis(lotID uint, startTime, endTime time.Time) ([]map[string]interface{}, error) {
+func getPeakHoursAnalysis(lotIDs []uint, startTime, endTime time.Time) ([]map[string]interface{}, error) {
 	var peakHours []map[string]interface{}
 
 	// 按小时统计停车次数
@@ -900,7 +820,7 @@ func getPeakHoursAnalysis(lotID uint, startTime, endTime time.Time) ([]map[strin
 
 	err := inits.DB.Model(&model.ParkingRecord{}).
 		Select("HOUR(entry_time) as hour, COUNT(*) as count").
-		Where("lot_id = ? AND entry_time BETWEEN ? AND ?", lotID, startTime, endTime).
+		Where("lot_id IN ? AND entry_time BETWEEN ? AND ?", lotIDs, startTime, endTime).
 		Group("HOUR(entry_time)").
 		Order("hour").
 		Scan(&hourlyStats).Error
@@ -920,7 +840,7 @@ func getPeakHoursAnalysis(lotID uint, startTime, endTime time.Time) ([]map[strin
 }
 
 // getMonthlyRevenueTrend 获取月度收入趋势
-func getMonthlyRevenueTrend(lotID uint, startTime, endTime time.Time) ([]map[string]interface{}, error) {
+func getMonthlyRevenueTrend(lotIDs []uint, startTime, endTime time.Time) ([]map[string]interface{}, error) {
 	var monthlyTrend []map[string]interface{}
 
 	// 按月统计收入
@@ -934,7 +854,7 @@ func getMonthlyRevenueTrend(lotID uint, startTime, endTime time.Time) ([]map[str
 			DATE_FORMAT(entry_time, '%Y-%m') as year_month,
 			COALESCE(SUM(fee_paid), 0) as income
 		`).
-		Where("lot_id = ? AND entry_time BETWEEN ? AND ?", lotID, startTime, endTime).
+		Where("lot_id IN ? AND entry_time BETWEEN ? AND ?", lotIDs, startTime, endTime).
 		Group("DATE_FORMAT(entry_time, '%Y-%m')").
 		Order("year_month").
 		Scan(&monthlyStats).Error
@@ -0,0 +1,465 @@
+package controller
+
+import (
+	"net/http"
+	"smart_parking_backend/internal/access"
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/middleware"
+	"smart_parking_backend/internal/model"
+	"smart_parking_backend/utils"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 多停车场（全网）分析 ====================
+// 系统管理员的统计范围为全部停车场，停车场管理员为自己管理的全部停车场
+// 全网汇总复用单停车场的统计函数（按停车场ID列表统计），停车场对比指标按 lot_id 分组一次查询
+
+// lotComparison 单个停车场在统计区间内的对比指标
+type lotComparison struct {
+	LotID          uint    `json:"lot_id"`
+	Name           string  `json:"name"`
+	Status         int8    `json:"status"`
+	TotalSpaces    int64   `json:"total_spaces"`
+	OccupiedSpaces int64   `json:"occupied_spaces"` // 区间内有车停放过的车位数
+	OccupancyRate  float64 `json:"occupancy_rate"`  // 时间加权使用率
+	Parkings       int64   `json:"parkings"`        // 区间内入场次数
+	ParkingIncome  float64 `json:"parking_income"`  // 停车费（按入场时间归属）
+	FineIncome     float64 `json:"fine_income"`     // 已收罚款（按违规时间归属）
+	Revenue        float64 `json:"revenue"`         // 停车费 + 已收罚款
+	Violations     int64   `json:"violations"`
+	ViolationRate  float64 `json:"violations_per_100_parkings"`
+	TurnAways      int64   `json:"turn_aways"` // 区间内因车位已满被拒绝的入场次数（来自通行日志）
+}
+
+// rankingItem 排名条目
+type rankingItem struct {
+	Rank  int     `json:"rank"`
+	LotID uint    `json:"lot_id"`
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+// allLotIDs 全部停车场ID（按ID升序）
+func allLotIDs() ([]uint, error) {
+	lotIDs := []uint{}
+	err := inits.DB.Model(&model.ParkingLot{}).Order("lot_id ASC").Pluck("lot_id", &lotIDs).Error
+	return lotIDs, err
+}
+
+// networkScope 全网分析的统计范围
+func networkScope(c *gin.Context) ([]uint, bool) {
+	if c.GetString("role") == middleware.RoleSystem {
+		lotIDs, err := allLotIDs()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询停车场失败"})
+			return nil, false
+		}
+		return lotIDs, true
+	}
+	lotIDs := middleware.ManagedLots(c)
+	if len(lotIDs) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限查询或未分配停车场"})
+		return nil, false
+	}
+	return lotIDs, true
+}
+
+// analysisTimeRange 解析 start_time / end_time（RFC3339），未传时默认最近 30 天
+func analysisTimeRange(c *gin.Context) (time.Time, time.Time, bool) {
+	endTime := time.Now()
+	startTime := endTime.AddDate(0, 0, -30)
+	if v := c.Query("start_time"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "开始时间格式无效，请使用RFC3339格式（如：2023-10-18T10:00:00Z）"})
+			return startTime, endTime, false
+		}
+		startTime = t
+	}
+	if v := c.Query("end_time"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "结束时间格式无效，请使用RFC3339格式（如：2023-10-18T20:00:00Z）"})
+			return startTime, endTime, false
+		}
+		endTime = t
+	}
+	if endTime.Before(startTime) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "结束时间不能早于开始时间"})
+		return startTime, endTime, false
+	}
+	return startTime, endTime, true
+}
+
+// NetworkOverview 全网概览：汇总指标、各停车场对比与收入 / 使用率 / 违规排名
+// GET /admin/network/overview?start_time=...&end_time=...&top=10
+func NetworkOverview(c *gin.Context) {
+	lotIDs, ok := networkScope(c)
+	if !ok {
+		return
+	}
+	startTime, endTime, ok := analysisTimeRange(c)
+	if !ok {
+		return
+	}
+	top := utils.ParseInt(c.Query("top"), 0) // 排名条数，0 表示全部
+
+	occupancy, err := getParkingOccupancyStats(lotIDs, startTime, endTime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据分析失败: " + err.Error()})
+		return
+	}
+	violations, err := getViolationStatsForReport(lotIDs, startTime, endTime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据分析失败: " + err.Error()})
+		return
+	}
+	revenue, err := getRevenueStats(lotIDs, startTime, endTime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据分析失败: " + err.Error()})
+		return
+	}
+	lots, err := getLotComparisons(lotIDs, startTime, endTime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据分析失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "全网分析成功",
+		"data": gin.H{
+			"totals": gin.H{
+				"lot_count":  len(lotIDs),
+				"occupancy":  occupancy,
+				"violations": violations,
+				"revenue":    revenue,
+			},
+			"lots": lots,
+			"rankings": gin.H{
+				"revenue":    rankLots(lots, top, func(l lotComparison) float64 { return l.Revenue }),
+				"occupancy":  rankLots(lots, top, func(l lotComparison) float64 { return l.OccupancyRate }),
+				"violations": rankLots(lots, top, func(l lotComparison) float64 { return float64(l.Violations) }),
+				"turn_aways": rankLots(lots, top, func(l lotComparison) float64 { return float64(l.TurnAways) }),
+			},
+		},
+		"metadata": scopeMetadata(lotIDs, 0, gin.H{
+			"start_time":      startTime.Format(time.RFC3339),
+			"end_time":        endTime.Format(time.RFC3339),
+			"time_range_days": endTime.Sub(startTime).Hours() / 24,
+		}),
+	})
+}
+
+// NetworkLotDetail 全网 → 停车场下钻：停车场汇总指标与各楼层对比
+// GET /admin/network/lots/:lot_id?start_time=...&end_time=...
+func NetworkLotDetail(c *gin.Context) {
+	lotID, _ := strconv.ParseUint(c.Param("lot_id"), 10, 64) // LotScope 已校验
+	startTime, endTime, ok := analysisTimeRange(c)
+	if !ok {
+		return
+	}
+
+	var lot model.ParkingLot
+	if err := inits.DB.First(&lot, lotID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "停车场不存在"})
+		return
+	}
+	lotIDs := []uint{lot.LotID}
+	summary, err := getParkingOccupancyStats(lotIDs, startTime, endTime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据分析失败: " + err.Error()})
+		return
+	}
+	violations, err := getViolationStatsForReport(lotIDs, startTime, endTime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据分析失败: " + err.Error()})
+		return
+	}
+	levels, err := getLevelComparisons(lot.LotID, startTime, endTime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据分析失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "停车场分析成功",
+		"data": gin.H{
+			"lot":        gin.H{"lot_id": lot.LotID, "name": lot.Name, "address": lot.Address, "total_levels": lot.TotalLevels, "status": lot.Status},
+			"summary":    summary,
+			"violations": violations,
+			"levels":     levels,
+		},
+		"metadata": scopeMetadata(lotIDs, lot.LotID, gin.H{
+			"start_time": startTime.Format(time.RFC3339),
+			"end_time":   endTime.Format(time.RFC3339),
+		}),
+	})
+}
+
+// NetworkLevelDetail 停车场 → 楼层下钻：楼层内各车位的使用情况
+// GET /admin/network/lots/:lot_id/levels/:level?start_time=...&end_time=...
+func NetworkLevelDetail(c *gin.Context) {
+	lotID, _ := strconv.ParseUint(c.Param("lot_id"), 10, 64) // LotScope 已校验
+	level, err := strconv.Atoi(c.Param("level"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的楼层"})
+		return
+	}
+	startTime, endTime, ok := analysisTimeRange(c)
+	if !ok {
+		return
+	}
+
+	spaces, err := getLevelSpaceStats(uint(lotID), level, startTime, endTime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据分析失败: " + err.Error()})
+		return
+	}
+	if len(spaces) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "该楼层没有车位"})
+		return
+	}
+	occupancy, err := getTimeWeightedOccupancy([]uint{uint(lotID)}, &level, startTime, endTime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "数据分析失败: " + err.Error()})
+		return
+	}
+
+	var used int64
+	var parkings int64
+	var income float64
+	for i, sp := range spaces {
+		spaces[i].OccupancyRate = occupancy.spaceRate(sp.SpaceID)
+		if sp.Parkings > 0 {
+			used++
+		}
+		parkings += sp.Parkings
+		income += sp.Income
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "楼层分析成功",
+		"data": gin.H{
+			"summary": gin.H{
+				"total_spaces":   len(spaces),
+				"used_spaces":    used,
+				"occupancy_rate": occupancy.OccupancyRate,
+				"parkings":       parkings,
+				"parking_income": income,
+			},
+			"by_hour": occupancy.ByHour,
+			"by_type": occupancy.ByType,
+			"spaces":  spaces,
+		},
+		"metadata": scopeMetadata([]uint{uint(lotID)}, uint(lotID), gin.H{
+			"level":      level,
+			"start_time": startTime.Format(time.RFC3339),
+			"end_time":   endTime.Format(time.RFC3339),
+		}),
+	})
+}
+
+// getLotComparisons 按 lot_id 分组统计各停车场的对比指标
+func getLotComparisons(lotIDs []uint, startTime, endTime time.Time) ([]lotComparison, error) {
+	var lots []model.ParkingLot
+	if err := inits.DB.Select("lot_id", "name", "status").Where("lot_id IN ?", lotIDs).
+		Order("lot_id ASC").Find(&lots).Error; err != nil {
+		return nil, err
+	}
+
+	type lotCount struct {
+		LotID uint
+		Count int64
+		Sum   float64
+	}
+
+	// 车位总数
+	var spaces []lotCount
+	if err := inits.DB.Model(&model.ParkingSpace{}).
+		Select("lot_id, COUNT(*) as count").
+		Where("lot_id IN ?", lotIDs).
+		Group("lot_id").Scan(&spaces).Error; err != nil {
+		return nil, err
+	}
+	// 时间加权使用率
+	occupancy, err := getTimeWeightedOccupancy(lotIDs, nil, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	// 区间内有车停放过的车位数
+	var occupied []lotCount
+	if err := inits.DB.Model(&model.ParkingRecord{}).
+		Select("lot_id, COUNT(DISTINCT space_id) as count").
+		Where("lot_id IN ? AND entry_time <= ? AND (exit_time >= ? OR exit_time IS NULL)", lotIDs, endTime, startTime).
+		Group("lot_id").Scan(&occupied).Error; err != nil {
+		return nil, err
+	}
+	// 入场次数与停车费
+	var parkings []lotCount
+	if err := inits.DB.Model(&model.ParkingRecord{}).
+		Select("lot_id, COUNT(*) as count, COALESCE(SUM(fee_paid), 0) as sum").
+		Where("lot_id IN ? AND entry_time BETWEEN ? AND ?", lotIDs, startTime, endTime).
+		Group("lot_id").Scan(&parkings).Error; err != nil {
+		return nil, err
+	}
+	// 违规次数与已收罚款
+	var violations []lotCount
+	if err := inits.DB.Model(&model.ViolationRecord{}).
+		Select("lot_id, COUNT(*) as count, COALESCE(SUM(CASE WHEN status = 1 THEN fine_amount ELSE 0 END), 0) as sum").
+		Where("lot_id IN ? AND violation_time BETWEEN ? AND ?", lotIDs, startTime, endTime).
+		Group("lot_id").Scan(&violations).Error; err != nil {
+		return nil, err
+	}
+
+	// 因车位已满被拒绝的入场
+	var turnAways []lotCount
+	if err := inits.DB.Model(&model.AccessEvent{}).
+		Select("lot_id, COUNT(*) as count").
+		Where("lot_id IN ? AND direction = ? AND reason = ? AND occurred_at BETWEEN ? AND ?",
+			lotIDs, access.DirectionIn, access.ReasonLotFull, startTime, endTime).
+		Group("lot_id").Scan(&turnAways).Error; err != nil {
+		return nil, err
+	}
+
+	index := func(rows []lotCount) map[uint]lotCount {
+		m := make(map[uint]lotCount, len(rows))
+		for _, r := range rows {
+			m[r.LotID] = r
+		}
+		return m
+	}
+	spaceMap, occupiedMap, parkingMap, violationMap := index(spaces), index(occupied), index(parkings), index(violations)
+	turnAwayMap := index(turnAways)
+
+	result := make([]lotComparison, 0, len(lots))
+	for _, lot := range lots {
+		item := lotComparison{
+			LotID:          lot.LotID,
+			Name:           lot.Name,
+			Status:         lot.Status,
+			TotalSpaces:    spaceMap[lot.LotID].Count,
+			OccupiedSpaces: occupiedMap[lot.LotID].Count,
+			Parkings:       parkingMap[lot.LotID].Count,
+			ParkingIncome:  parkingMap[lot.LotID].Sum,
+			FineIncome:     violationMap[lot.LotID].Sum,
+			Violations:     violationMap[lot.LotID].Count,
+			TurnAways:      turnAwayMap[lot.LotID].Count,
+		}
+		item.OccupancyRate = occupancy.lotRate(lot.LotID)
+		item.Revenue = item.ParkingIncome + item.FineIncome
+		item.ViolationRate = calculateRate(item.Violations, item.Parkings)
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+// rankLots 按指标降序排名（指标相同时按停车场ID升序），top 为 0 时返回全部
+func rankLots(lots []lotComparison, top int, value func(lotComparison) float64) []rankingItem {
+	sorted := make([]lotComparison, len(lots))
+	copy(sorted, lots)
+	sort.SliceStable(sorted, func(i, j int) bool { return value(sorted[i]) > value(sorted[j]) })
+	if top > 0 && len(sorted) > top {
+		sorted = sorted[:top]
+	}
+	result := make([]rankingItem, 0, len(sorted))
+	for i, lot := range sorted {
+		result = append(result, rankingItem{Rank: i + 1, LotID: lot.LotID, Name: lot.Name, Value: value(lot)})
+	}
+	return result
+}
+
+// getLevelComparisons 按楼层统计停车场内各楼层的车位数、使用率、入场次数与停车费
+func getLevelComparisons(lotID uint, startTime, endTime time.Time) ([]map[string]interface{}, error) {
+	occupancy, err := getTimeWeightedOccupancy([]uint{lotID}, nil, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	levelRates := make(map[int]float64, len(occupancy.ByLevel))
+	for _, l := range occupancy.ByLevel {
+		levelRates[l.Level] = l.OccupancyRate
+	}
+
+	type levelCount struct {
+		Level int
+		Count int64
+		Sum   float64
+	}
+	var spaces []levelCount
+	if err := inits.DB.Model(&model.ParkingSpace{}).
+		Select("level, COUNT(*) as count, COALESCE(SUM(is_occupied), 0) as sum").
+		Where("lot_id = ?", lotID).
+		Group("level").Order("level").Scan(&spaces).Error; err != nil {
+		return nil, err
+	}
+	var occupied []levelCount
+	if err := inits.DB.Table("parking_record r").
+		Select("s.level, COUNT(DISTINCT r.space_id) as count").
+		Joins("JOIN parking_space s ON s.space_id = r.space_id").
+		Where("r.lot_id = ? AND r.entry_time <= ? AND (r.exit_time >= ? OR r.exit_time IS NULL)", lotID, endTime, startTime).
+		Group("s.level").Scan(&occupied).Error; err != nil {
+		return nil, err
+	}
+	var parkings []levelCount
+	if err := inits.DB.Table("parking_record r").
+		Select("s.level, COUNT(*) as count, COALESCE(SUM(r.fee_paid), 0) as sum").
+		Joins("JOIN parking_space s ON s.space_id = r.space_id").
+		Where("r.lot_id = ? AND r.entry_time BETWEEN ? AND ?", lotID, startTime, endTime).
+		Group("s.level").Scan(&parkings).Error; err != nil {
+		return nil, err
+	}
+
+	occupiedMap := make(map[int]int64, len(occupied))
+	for _, o := range occupied {
+		occupiedMap[o.Level] = o.Count
+	}
+	parkingMap := make(map[int]levelCount, len(parkings))
+	for _, p := range parkings {
+		parkingMap[p.Level] = p
+	}
+
+	levels := make([]map[string]interface{}, 0, len(spaces))
+	for _, sp := range spaces {
+		levels = append(levels, map[string]interface{}{
+			"level":            sp.Level,
+			"total_spaces":     sp.Count,
+			"current_occupied": int64(sp.Sum),
+			"occupied_spaces":  occupiedMap[sp.Level],
+			"occupancy_rate":   levelRates[sp.Level],
+			"parkings":         parkingMap[sp.Level].Count,
+			"parking_income":   parkingMap[sp.Level].Sum,
+		})
+	}
+	return levels, nil
+}
+
+// levelSpaceStat 楼层内单个车位的使用情况
+type levelSpaceStat struct {
+	SpaceID     uint    `json:"space_id"`
+	SpaceNumber string  `json:"space_number"`
+	SpaceType   string  `json:"space_type"`
+	Status      int8    `json:"status"`
+	IsOccupied  int8    `json:"is_occupied"`
+	IsReserved  int8    `json:"is_reserved"`
+	Parkings    int64   `json:"parkings"`
+	Income      float64 `json:"parking_income"`
+
+	OccupancyRate float64 `json:"occupancy_rate" gorm:"-"` // 时间加权使用率
+}
+
+// getLevelSpaceStats 统计楼层内各车位在区间内的入场次数与停车费
+func getLevelSpaceStats(lotID uint, level int, startTime, endTime time.Time) ([]levelSpaceStat, error) {
+	var spaces []levelSpaceStat
+	err := inits.DB.Table("parking_space s").
+		Select(`s.space_id, s.space_number, s.space_type, s.status, s.is_occupied, s.is_reserved,
+			COUNT(r.record_id) as parkings, COALESCE(SUM(r.fee_paid), 0) as income`).
+		Joins("LEFT JOIN parking_record r ON r.space_id = s.space_id AND r.entry_time BETWEEN ? AND ?", startTime, endTime).
+		Where("s.lot_id = ? AND s.level = ?", lotID, level).
+		Group("s.space_id, s.space_number, s.space_type, s.status, s.is_occupied, s.is_reserved").
+		Order("s.space_number").
+		Scan(&spaces).Error
+	return spaces, err
+}
@@ -0,0 +1,266 @@
+package controller
+
+import (
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"sort"
+	"time"
+)
+
+// ==================== 时间加权使用率 ====================
+// 使用率 = 车位被占用的分钟数之和 / 车位可用的分钟数之和
+// 占用时长由停车记录的入场、出场时间计算（裁剪到统计区间内，未出场的记录计到当前时间），
+// 同一车位时间重叠的记录合并后只计一次；可用时长 = 车位数 × 区间分钟数，区间结束时间晚于当前时间时截至当前时间
+// 车位状态没有历史记录，可用时长按统计范围内的全部车位计算
+
+// occupancyBucket 一个统计维度上的占用时长与使用率
+type occupancyBucket struct {
+	OccupiedMinutes  float64 `json:"occupied_space_minutes"`
+	AvailableMinutes float64 `json:"available_space_minutes"`
+	OccupancyRate    float64 `json:"occupancy_rate"`
+}
+
+func (b *occupancyBucket) finish() {
+	b.OccupancyRate = calculateRateFloat(b.OccupiedMinutes, b.AvailableMinutes)
+}
+
+// hourOccupancy 按小时（0-23，服务器本地时区）统计的使用率
+type hourOccupancy struct {
+	Hour int `json:"hour"`
+	occupancyBucket
+}
+
+// levelOccupancy 按楼层统计的使用率
+type levelOccupancy struct {
+	Level       int   `json:"level"`
+	TotalSpaces int64 `json:"total_spaces"`
+	occupancyBucket
+}
+
+// typeOccupancy 按车位类型统计的使用率
+type typeOccupancy struct {
+	SpaceType   string `json:"space_type"`
+	TotalSpaces int64  `json:"total_spaces"`
+	occupancyBucket
+}
+
+// timeWeightedOccupancy 时间加权使用率统计结果
+type timeWeightedOccupancy struct {
+	TotalSpaces int64 `json:"total_spaces"`
+	occupancyBucket
+	ByHour  []hourOccupancy  `json:"by_hour"`
+	ByLevel []levelOccupancy `json:"by_level"`
+	ByType  []typeOccupancy  `json:"by_type"`
+
+	// 以下维度供全网分析对比与下钻使用，不直接输出
+	byLot   map[uint]*occupancyBucket
+	bySpace map[uint]*occupancyBucket
+}
+
+// peakHour 使用率最高的小时，没有数据时返回 nil
+func (o *timeWeightedOccupancy) peakHour() *hourOccupancy {
+	var peak *hourOccupancy
+	for i := range o.ByHour {
+		if o.ByHour[i].OccupiedMinutes > 0 && (peak == nil || o.ByHour[i].OccupancyRate > peak.OccupancyRate) {
+			peak = &o.ByHour[i]
+		}
+	}
+	return peak
+}
+
+// lotRate 单个停车场的使用率
+func (o *timeWeightedOccupancy) lotRate(lotID uint) float64 {
+	if b, ok := o.byLot[lotID]; ok {
+		return b.OccupancyRate
+	}
+	return 0
+}
+
+// spaceRate 单个车位的使用率
+func (o *timeWeightedOccupancy) spaceRate(spaceID uint) float64 {
+	if b, ok := o.bySpace[spaceID]; ok {
+		return b.OccupancyRate
+	}
+	return 0
+}
+
+// occupancySpace 参与统计的车位
+type occupancySpace struct {
+	SpaceID   uint
+	LotID     uint
+	Level     int
+	SpaceType string
+}
+
+// occupancyInterval 停车记录的占用区间
+type occupancyInterval struct {
+	SpaceID   uint
+	EntryTime time.Time
+	ExitTime  *time.Time
+}
+
+// getTimeWeightedOccupancy 计算停车场列表在统计区间内的时间加权使用率（总体、按小时、按楼层、按车位类型）
+// level 不为 nil 时只统计该楼层的车位
+func getTimeWeightedOccupancy(lotIDs []uint, level *int, startTime, endTime time.Time) (*timeWeightedOccupancy, error) {
+	result := &timeWeightedOccupancy{
+		byLot:   make(map[uint]*occupancyBucket),
+		bySpace: make(map[uint]*occupancyBucket),
+	}
+
+	// 统计区间：使用本地时区划分小时，结束时间不晚于当前时间
+	now := time.Now()
+	startTime, endTime = startTime.In(time.Local), endTime.In(time.Local)
+	if endTime.After(now) {
+		endTime = now
+	}
+
+	var spaces []occupancySpace
+	query := inits.DB.Model(&model.ParkingSpace{}).
+		Select("space_id, lot_id, level, space_type").
+		Where("lot_id IN ?", lotIDs)
+	if level != nil {
+		query = query.Where("level = ?", *level)
+	}
+	if err := query.Order("space_id").Scan(&spaces).Error; err != nil {
+		return nil, err
+	}
+	result.TotalSpaces = int64(len(spaces))
+
+	var intervals []occupancyInterval
+	if len(spaces) > 0 && endTime.After(startTime) {
+		spaceIDs := make([]uint, 0, len(spaces))
+		for _, sp := range spaces {
+			spaceIDs = append(spaceIDs, sp.SpaceID)
+		}
+		err := inits.DB.Model(&model.ParkingRecord{}).
+			Select("space_id, entry_time, exit_time").
+			Where("lot_id IN ? AND space_id IN ? AND entry_time < ? AND (exit_time > ? OR exit_time IS NULL)",
+				lotIDs, spaceIDs, endTime, startTime).
+			Order("space_id, entry_time").
+			Scan(&intervals).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 可用时长：每个车位的可用分钟数相同，按小时拆分后乘以车位数
+	windowMinutes := 0.0
+	var hourMinutes [24]float64
+	if endTime.After(startTime) {
+		windowMinutes = endTime.Sub(startTime).Minutes()
+		splitByHour(startTime, endTime, func(hour int, minutes float64) {
+			hourMinutes[hour] += minutes
+		})
+	}
+
+	byHour := make([]hourOccupancy, 24)
+	for h := range byHour {
+		byHour[h].Hour = h
+		byHour[h].AvailableMinutes = hourMinutes[h] * float64(len(spaces))
+	}
+	byLevel := make(map[int]*levelOccupancy)
+	byType := make(map[string]*typeOccupancy)
+	spaceIndex := make(map[uint]occupancySpace, len(spaces))
+	for _, sp := range spaces {
+		spaceIndex[sp.SpaceID] = sp
+		result.AvailableMinutes += windowMinutes
+		result.bySpace[sp.SpaceID] = &occupancyBucket{AvailableMinutes: windowMinutes}
+		if result.byLot[sp.LotID] == nil {
+			result.byLot[sp.LotID] = &occupancyBucket{}
+		}
+		result.byLot[sp.LotID].AvailableMinutes += windowMinutes
+		if byLevel[sp.Level] == nil {
+			byLevel[sp.Level] = &levelOccupancy{Level: sp.Level}
+		}
+		byLevel[sp.Level].TotalSpaces++
+		byLevel[sp.Level].AvailableMinutes += windowMinutes
+		if byType[sp.SpaceType] == nil {
+			byType[sp.SpaceType] = &typeOccupancy{SpaceType: sp.SpaceType}
+		}
+		byType[sp.SpaceType].TotalSpaces++
+		byType[sp.SpaceType].AvailableMinutes += windowMinutes
+	}
+
+	// 占用时长：记录已按车位、入场时间排序，同一车位重叠的区间合并后累加
+	addOccupied := func(spaceID uint, from, to time.Time) {
+		sp, ok := spaceIndex[spaceID]
+		if !ok || !to.After(from) {
+			return
+		}
+		minutes := to.Sub(from).Minutes()
+		result.OccupiedMinutes += minutes
+		result.bySpace[spaceID].OccupiedMinutes += minutes
+		result.byLot[sp.LotID].OccupiedMinutes += minutes
+		byLevel[sp.Level].OccupiedMinutes += minutes
+		byType[sp.SpaceType].OccupiedMinutes += minutes
+		splitByHour(from, to, func(hour int, minutes float64) {
+			byHour[hour].OccupiedMinutes += minutes
+		})
+	}
+
+	var curSpace uint
+	var curFrom, curTo time.Time
+	for _, iv := range intervals {
+		from := iv.EntryTime.In(time.Local)
+		to := endTime
+		if iv.ExitTime != nil && iv.ExitTime.Before(endTime) {
+			to = iv.ExitTime.In(time.Local)
+		}
+		if from.Before(startTime) {
+			from = startTime
+		}
+		if !to.After(from) {
+			continue
+		}
+		if iv.SpaceID == curSpace && !from.After(curTo) {
+			if to.After(curTo) {
+				curTo = to
+			}
+			continue
+		}
+		addOccupied(curSpace, curFrom, curTo)
+		curSpace, curFrom, curTo = iv.SpaceID, from, to
+	}
+	addOccupied(curSpace, curFrom, curTo)
+
+	// 汇总使用率并按楼层、车位类型排序输出
+	result.finish()
+	for _, b := range result.byLot {
+		b.finish()
+	}
+	for _, b := range result.bySpace {
+		b.finish()
+	}
+	for h := range byHour {
+		byHour[h].finish()
+	}
+	result.ByHour = byHour
+
+	result.ByLevel = make([]levelOccupancy, 0, len(byLevel))
+	for _, l := range byLevel {
+		l.finish()
+		result.ByLevel = append(result.ByLevel, *l)
+	}
+	sort.Slice(result.ByLevel, func(i, j int) bool { return result.ByLevel[i].Level < result.ByLevel[j].Level })
+
+	result.ByType = make([]typeOccupancy, 0, len(byType))
+	for _, t := range byType {
+		t.finish()
+		result.ByType = append(result.ByType, *t)
+	}
+	sort.Slice(result.ByType, func(i, j int) bool { return result.ByType[i].SpaceType < result.ByType[j].SpaceType })
+
+	return result, nil
+}
+
+// splitByHour 将时间区间按整点拆分，依次回调每段所在的小时与分钟数
+func splitByHour(from, to time.Time, fn func(hour int, minutes float64)) {
+	for from.Before(to) {
+		next := time.Date(from.Year(), from.Month(), from.Day(), from.Hour(), 0, 0, 0, from.Location()).Add(time.Hour)
+		if next.After(to) {
+			next = to
+		}
+		fn(from.Hour(), next.Sub(from).Minutes())
+		from = next
+	}
+}
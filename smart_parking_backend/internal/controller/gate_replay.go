@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"smart_parking_backend/internal/access"
+	"smart_parking_backend/internal/gate"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GateReplayer 按闸机补传事件的发生时间办理入场/出场（实现 gate.Replayer）
+// 与当前停车数据冲突的事件返回 *gate.ConflictError，由闸机模块转入人工处理队列；每次重放都写入通行日志
+type GateReplayer struct{}
+
+// ReplayEntry 按事件时间办理入场；车辆已有在场记录时视为重复入场
+func (GateReplayer) ReplayEntry(src gate.ReplaySource, lotID uint, licensePlate, spaceType string, at time.Time) (uint, error) {
+	attempt := replayAttempt(src, access.DirectionIn)
+	req := VehicleEntryRequest{LicensePlate: licensePlate, SpaceType: spaceType, LotID: lotID}
+	resp, err := replayEntry(req, at)
+	attempt.journalEntry(req, at, resp, err)
+	if err != nil {
+		return 0, replayError(err)
+	}
+	return resp.RecordID, nil
+}
+
+func replayEntry(req VehicleEntryRequest, at time.Time) (*VehicleEntryResponse, error) {
+	record, _, _, err := findActiveParkingRecordByLicensePlate(req.LicensePlate)
+	if err == nil {
+		return nil, &gate.ConflictError{
+			Type:    gate.ConflictDoubleEntry,
+			Message: fmt.Sprintf("车辆已在场（停车记录 %d，入场时间 %s）", record.RecordID, record.EntryTime.Format(time.DateTime)),
+		}
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return enterVehicle(req, at)
+}
+
+// ReplayExit 按事件时间办理出场，停车费按原始入场/出场时间计算
+// lotID 为 0 时不限停车场（人工处理冲突时关闭其他停车场的在场记录）
+func (GateReplayer) ReplayExit(src gate.ReplaySource, lotID uint, licensePlate, ticketCode string, at time.Time) (uint, error) {
+	attempt := replayAttempt(src, access.DirectionOut)
+	req := VehicleExitRequest{LicensePlate: licensePlate, TicketCode: ticketCode}
+	resp, err := replayExit(req, lotID, at)
+	attempt.journalExit(req, lotID, at, resp, err)
+	if err != nil {
+		return 0, replayError(err)
+	}
+	return resp.RecordID, nil
+}
+
+// replayExit 查找在场记录并校验后办理出场
+func replayExit(req VehicleExitRequest, lotID uint, at time.Time) (*VehicleExitResponse, error) {
+	var err error
+	var recordLot uint
+	var entryTime time.Time
+	if req.TicketCode != "" {
+		record, _, _, e := findActiveParkingRecordByTicket(req.TicketCode)
+		if e == nil {
+			recordLot, entryTime = record.LotID, record.EntryTime
+		}
+		err = e
+	}
+	// 凭证号查不到时再按车牌查找（闸机可能只识别到车牌）
+	if (req.TicketCode == "" || errors.Is(err, gorm.ErrRecordNotFound)) && req.LicensePlate != "" {
+		req.TicketCode = ""
+		record, _, _, e := findActiveParkingRecordByLicensePlate(req.LicensePlate)
+		if e == nil {
+			recordLot, entryTime = record.LotID, record.EntryTime
+		}
+		err = e
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &gate.ConflictError{Type: gate.ConflictExitWithoutEntry, Message: "未找到在场停车记录"}
+		}
+		return nil, err
+	}
+	if lotID != 0 && recordLot != lotID {
+		return nil, &gate.ConflictError{Type: gate.ConflictWrongLot, Message: "车辆在场记录属于其他停车场"}
+	}
+	if at.Before(entryTime) {
+		return nil, &gate.ConflictError{
+			Type:    gate.ConflictExitBeforeEntry,
+			Message: fmt.Sprintf("出场时间早于入场时间 %s", entryTime.Format(time.DateTime)),
+		}
+	}
+	return exitVehicle(req, lotID, at)
+}
+
+// replayError 入场/出场规则拒绝（4xx）的事件转为冲突，其它错误原样返回
+func replayError(err error) error {
+	var pe *parkingError
+	if errors.As(err, &pe) && pe.status < http.StatusInternalServerError {
+		return &gate.ConflictError{Type: gate.ConflictRejected, Message: pe.message}
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"smart_parking_backend/internal/booking"
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"smart_parking_backend/internal/tariff"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDSNEnv 测试数据库连接串（需已按 buildSQL.md 建表），未设置时跳过依赖数据库的测试
+const testDSNEnv = "SMART_PARKING_TEST_DSN"
+
+// openTestDB 连接测试数据库并替换 inits.DB，测试结束后恢复
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("未设置 %s，跳过依赖数据库的测试", testDSNEnv)
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("连接测试数据库失败: %v", err)
+	}
+	prev := inits.DB
+	inits.DB = db
+	t.Cleanup(func() {
+		inits.DB = prev
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// TestVehicleEntryAndBookingConcurrentSingleSpace 访客入场与会员预订并发争抢停车场唯一车位，车位只能分配一次
+func TestVehicleEntryAndBookingConcurrentSingleSpace(t *testing.T) {
+	db := openTestDB(t)
+	gin.SetMode(gin.TestMode)
+	prevRedis := inits.RedisClient
+	inits.RedisClient = nil // 不使用幂等键，每个入场请求都实际办理
+	t.Cleanup(func() { inits.RedisClient = prevRedis })
+
+	const concurrency = 5
+	tag := time.Now().UnixNano() % 1e8
+	lot := model.ParkingLot{Name: fmt.Sprintf("入场预订并发测试-%d", tag), Address: "test", TotalSpaces: 1, HourlyRate: 5, Status: 1}
+	if err := db.Create(&lot).Error; err != nil {
+		t.Fatalf("创建停车场失败: %v", err)
+	}
+	space := model.ParkingSpace{LotID: lot.LotID, Level: 1, SpaceNumber: "T-001", SpaceType: "普通", Status: 1}
+	if err := db.Create(&space).Error; err != nil {
+		t.Fatalf("创建车位失败: %v", err)
+	}
+	var userIDs []uint
+	t.Cleanup(func() {
+		db.Where("lot_id = ?", lot.LotID).Delete(&model.ParkingRecord{})
+		db.Where("lot_id = ?", lot.LotID).Delete(&model.ReservationOrder{})
+		if len(userIDs) > 0 {
+			db.Where("user_id IN ?", userIDs).Delete(&model.Vehicle{})
+			db.Where("user_id IN ?", userIDs).Delete(&model.Users_list{})
+		}
+		db.Delete(&space)
+		db.Delete(&lot)
+	})
+
+	owners := make([][2]uint, 0, concurrency)
+	for i := 0; i < concurrency; i++ {
+		user := model.Users_list{Username: fmt.Sprintf("ce%d_%d", tag, i), PasswordHash: "x", Phone: fmt.Sprintf("198%08d", (tag*100+int64(i))%1e8)}
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatalf("创建用户失败: %v", err)
+		}
+		userIDs = append(userIDs, user.UserID)
+		vehicle := model.Vehicle{UserID: user.UserID, LicensePlate: fmt.Sprintf("测E%d%02d", tag%1e6, i)}
+		if err := db.Create(&vehicle).Error; err != nil {
+			t.Fatalf("创建车辆失败: %v", err)
+		}
+		owners = append(owners, [2]uint{user.UserID, vehicle.VehicleID})
+	}
+
+	bookingSvc := booking.NewService(booking.NewRepository(), tariff.NewService(tariff.NewRepository()), nil)
+	r := gin.New()
+	r.POST("/entry", VehicleEntry)
+
+	// 预订时间段覆盖当前时间，与入场争抢同一车位
+	start := time.Now().Truncate(time.Minute)
+	end := start.Add(2 * time.Hour)
+
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		entries    int
+		bookings   int
+		unexpected []string
+	)
+	ready := make(chan struct{})
+	for i, owner := range owners {
+		wg.Add(2)
+		go func(userID, vehicleID uint) {
+			defer wg.Done()
+			<-ready
+			_, err := bookingSvc.CreateBooking(userID, vehicleID, lot.LotID, start, end, "普通")
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				bookings++
+			case err.Error() != "当前停车场该时间段无可用车位":
+				unexpected = append(unexpected, "预订: "+err.Error())
+			}
+		}(owner[0], owner[1])
+		go func(plate string) {
+			defer wg.Done()
+			<-ready
+			body := fmt.Sprintf(`{"license_plate":"%s","lot_id":%d}`, plate, lot.LotID)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/entry", strings.NewReader(body)))
+			mu.Lock()
+			defer mu.Unlock()
+			switch w.Code {
+			case http.StatusOK:
+				entries++
+			case http.StatusConflict:
+			default:
+				unexpected = append(unexpected, fmt.Sprintf("入场: %d %s", w.Code, w.Body.String()))
+			}
+		}(fmt.Sprintf("测G%d%02d", tag%1e6, i)) // 未登记车牌，按访客入场
+	}
+	close(ready)
+	wg.Wait()
+
+	if len(unexpected) > 0 {
+		t.Fatalf("出现非预期的失败: %v", unexpected)
+	}
+	if entries+bookings != 1 {
+		t.Fatalf("唯一车位应只分配 1 次，实际入场成功 %d 次、预订成功 %d 次", entries, bookings)
+	}
+
+	var active, held int64
+	db.Model(&model.ParkingRecord{}).Where("space_id = ? AND record_status = ?", space.SpaceID, 1).Count(&active)
+	db.Model(&model.ReservationOrder{}).
+		Where("space_id = ? AND status IN ?", space.SpaceID, []int8{1, 2}).
+		Where("start_time < ? AND end_time > ?", end, start).
+		Count(&held)
+	if active+held != 1 {
+		t.Fatalf("车位 %d 同时有 %d 条在场记录与 %d 条有效预订", space.SpaceID, active, held)
+	}
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"smart_parking_backend/internal/access"
+	"smart_parking_backend/internal/inits"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// ==================== 入场/出场幂等 ====================
+// 客户端（闸机、车牌识别相机）在请求头 Idempotency-Key 中携带本次通行的唯一标识，网络超时后用同一个键重试：
+// 已成功处理的请求直接返回原响应（响应头 Idempotent-Replayed: true），不会重复入场或重复出场
+
+const (
+	idempotencyHeader         = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	idempotencyKeyPrefix      = "parking:idem:"
+	idempotencyKeyMaxLen      = 64
+	idempotencyLease          = 30 * time.Second // 处理中占位的有效期（处理异常中断时自动释放）
+	idempotencyTTL            = 24 * time.Hour   // 成功结果的保留时间
+)
+
+// idempotentResult 幂等键对应的处理状态，Status 为 0 表示处理中
+type idempotentResult struct {
+	Fingerprint string          `json:"fingerprint"` // 请求体 SHA-256
+	Status      int             `json:"status"`
+	Body        json.RawMessage `json:"body,omitempty"`
+}
+
+// parkingHandler 办理入场/出场（含写入通行日志），返回 HTTP 状态码与响应体
+type parkingHandler func(c *gin.Context, attempt accessAttempt) (int, interface{})
+
+// serveIdempotent 按 Idempotency-Key 请求头办理入场/出场
+// 未携带时直接办理；同键同请求体且已成功时返回原响应，处理中返回 409，请求体不同返回 422
+// 只保存成功结果，失败时释放幂等键以便重试；Redis 不可用时不做幂等控制（同一车牌的重复入场仍由在场记录唯一约束拦截）
+func serveIdempotent(c *gin.Context, direction string, handle parkingHandler) {
+	attempt := newAccessAttempt(c, direction)
+	key := strings.TrimSpace(c.GetHeader(idempotencyHeader))
+	if key == "" {
+		c.JSON(handle(c, attempt))
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		respondSkipped(c, attempt, body, newParkingError(http.StatusBadRequest, "读取请求体失败"))
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if len(key) > idempotencyKeyMaxLen {
+		respondSkipped(c, attempt, body, newParkingError(http.StatusBadRequest, fmt.Sprintf("Idempotency-Key 长度不能超过%d个字符", idempotencyKeyMaxLen)))
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	fingerprint := hex.EncodeToString(sum[:])
+	redisKey := idempotencyRedisKey(c, direction, key)
+	ctx := context.Background()
+
+	acquired, err := acquireIdempotencyKey(ctx, redisKey, fingerprint)
+	if err != nil {
+		log.Printf("幂等键 %s 不可用，按普通请求处理: %v", redisKey, err)
+		c.JSON(handle(c, attempt))
+		return
+	}
+	if !acquired {
+		replayIdempotent(c, attempt, body, redisKey, fingerprint)
+		return
+	}
+
+	status, resp := handle(c, attempt)
+	data, err := json.Marshal(resp)
+	if err != nil {
+		inits.RedisClient.Del(ctx, redisKey)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+		return
+	}
+	if status >= http.StatusOK && status < http.StatusMultipleChoices {
+		stored, _ := json.Marshal(idempotentResult{Fingerprint: fingerprint, Status: status, Body: data})
+		if err := inits.RedisClient.Set(ctx, redisKey, stored, idempotencyTTL).Err(); err != nil {
+			log.Printf("保存幂等结果 %s 失败: %v", redisKey, err)
+		}
+	} else {
+		inits.RedisClient.Del(ctx, redisKey)
+	}
+	c.Data(status, "application/json; charset=utf-8", data)
+}
+
+// idempotencyRedisKey 幂等键按方向与调用方隔离：闸机请求按闸机，其他请求共用
+func idempotencyRedisKey(c *gin.Context, direction, key string) string {
+	scope := "api"
+	if gateID := c.GetUint("gate_id"); gateID != 0 {
+		scope = fmt.Sprintf("gate%d", gateID)
+	}
+	return fmt.Sprintf("%s%s:%s:%s", idempotencyKeyPrefix, direction, scope, key)
+}
+
+// acquireIdempotencyKey 占用幂等键（写入处理中状态），键已存在时返回 false
+func acquireIdempotencyKey(ctx context.Context, redisKey, fingerprint string) (bool, error) {
+	if inits.RedisClient == nil {
+		return false, errors.New("Redis 未初始化")
+	}
+	pending, _ := json.Marshal(idempotentResult{Fingerprint: fingerprint})
+	return inits.RedisClient.SetNX(ctx, redisKey, pending, idempotencyLease).Result()
+}
+
+// replayIdempotent 幂等键已被占用：返回已保存的结果，或拒绝处理中/请求体不一致的请求
+func replayIdempotent(c *gin.Context, attempt accessAttempt, body []byte, redisKey, fingerprint string) {
+	data, err := inits.RedisClient.Get(context.Background(), redisKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// 占位刚好过期或已被释放，按处理中返回，由客户端稍后重试
+		respondSkipped(c, attempt, body, newParkingDenial(http.StatusConflict, access.ReasonDuplicateRequest, "相同 Idempotency-Key 的请求正在处理中，请稍后重试"))
+		return
+	}
+	var stored idempotentResult
+	if err == nil {
+		err = json.Unmarshal(data, &stored)
+	}
+	if err != nil {
+		log.Printf("读取幂等结果 %s 失败: %v", redisKey, err)
+		respondSkipped(c, attempt, body, newParkingError(http.StatusInternalServerError, "读取幂等结果失败"))
+		return
+	}
+
+	switch {
+	case stored.Fingerprint != fingerprint:
+		respondSkipped(c, attempt, body, newParkingDenial(http.StatusUnprocessableEntity, access.ReasonDuplicateRequest, "Idempotency-Key 已用于其他请求"))
+	case stored.Status == 0:
+		respondSkipped(c, attempt, body, newParkingDenial(http.StatusConflict, access.ReasonDuplicateRequest, "相同 Idempotency-Key 的请求正在处理中，请稍后重试"))
+	default:
+		var resp struct {
+			RecordID uint `json:"record_id"`
+		}
+		_ = json.Unmarshal(stored.Body, &resp)
+		attempt.journalIdempotent(c, body, resp.RecordID, nil)
+		c.Header(idempotencyReplayedHeader, "true")
+		c.Data(stored.Status, "application/json; charset=utf-8", stored.Body)
+	}
+}
+
+// respondSkipped 未办理的请求：写入通行日志并返回错误
+func respondSkipped(c *gin.Context, attempt accessAttempt, body []byte, err error) {
+	attempt.journalIdempotent(c, body, 0, err)
+	c.JSON(parkingErrorResponse(err))
+}
@@ -0,0 +1,300 @@
+package controller
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"smart_parking_backend/internal/inits"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis 只实现幂等键用到的 SET（NX/EX/PX）、GET、DEL 的最小 RESP 服务，不处理过期
+type fakeRedis struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+// startFakeRedis 启动 fakeRedis 并替换 inits.RedisClient，测试结束后恢复
+func startFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("启动测试 Redis 失败: %v", err)
+	}
+	f := &fakeRedis{data: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+
+	prev := inits.RedisClient
+	client := redis.NewClient(&redis.Options{Addr: ln.Addr().String(), Protocol: 2, DisableIdentity: true})
+	inits.RedisClient = client
+	t.Cleanup(func() {
+		inits.RedisClient = prev
+		client.Close()
+		ln.Close()
+	})
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, f.exec(args)); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand 读取一条 RESP 数组格式的命令
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil { // $<len>
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "SET":
+		for _, opt := range args[3:] {
+			if strings.EqualFold(opt, "NX") {
+				if _, ok := f.data[args[1]]; ok {
+					return "$-1\r\n"
+				}
+			}
+		}
+		f.data[args[1]] = args[2]
+		return "+OK\r\n"
+	case "GET":
+		v, ok := f.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "DEL":
+		deleted := 0
+		for _, k := range args[1:] {
+			if _, ok := f.data[k]; ok {
+				delete(f.data, k)
+				deleted++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+func (f *fakeRedis) set(key string, v idempotentResult) {
+	data, _ := json.Marshal(v)
+	f.mu.Lock()
+	f.data[key] = string(data)
+	f.mu.Unlock()
+}
+
+// idemRequest 按顺序发送的一次请求及期望结果
+type idemRequest struct {
+	key          string
+	body         string
+	wantStatus   int
+	wantReplayed bool
+	wantRecord   int // 期望响应中的 record_id（0 表示不校验）
+}
+
+func TestServeIdempotent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const body = `{"license_plate":"京A12345","lot_id":1}`
+
+	tests := []struct {
+		name      string
+		noRedis   bool
+		seed      map[string]idempotentResult
+		statuses  []int // 第 n 次办理返回的状态码，超出时返回 200
+		requests  []idemRequest
+		wantCalls int
+	}{
+		{
+			name: "未携带幂等键时每次都办理",
+			requests: []idemRequest{
+				{body: body, wantStatus: 200, wantRecord: 1},
+				{body: body, wantStatus: 200, wantRecord: 2},
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "同键同请求体返回原响应",
+			requests: []idemRequest{
+				{key: "k1", body: body, wantStatus: 200, wantRecord: 1},
+				{key: "k1", body: body, wantStatus: 200, wantReplayed: true, wantRecord: 1},
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "同键不同请求体返回 422",
+			requests: []idemRequest{
+				{key: "k1", body: body, wantStatus: 200, wantRecord: 1},
+				{key: "k1", body: `{"license_plate":"京B00000","lot_id":1}`, wantStatus: 422},
+			},
+			wantCalls: 1,
+		},
+		{
+			name:     "失败结果不保存，同键重试时重新办理",
+			statuses: []int{http.StatusConflict},
+			requests: []idemRequest{
+				{key: "k1", body: body, wantStatus: 409},
+				{key: "k1", body: body, wantStatus: 200, wantRecord: 2},
+				{key: "k1", body: body, wantStatus: 200, wantReplayed: true, wantRecord: 2},
+			},
+			wantCalls: 2,
+		},
+		{
+			name:      "处理中的同键请求返回 409",
+			seed:      map[string]idempotentResult{"parking:idem:in:api:k1": {Fingerprint: fingerprintOf(body)}},
+			requests:  []idemRequest{{key: "k1", body: body, wantStatus: 409}},
+			wantCalls: 0,
+		},
+		{
+			name:      "幂等键过长返回 400",
+			requests:  []idemRequest{{key: strings.Repeat("k", idempotencyKeyMaxLen+1), body: body, wantStatus: 400}},
+			wantCalls: 0,
+		},
+		{
+			name:    "Redis 不可用时按普通请求办理",
+			noRedis: true,
+			requests: []idemRequest{
+				{key: "k1", body: body, wantStatus: 200, wantRecord: 1},
+				{key: "k1", body: body, wantStatus: 200, wantRecord: 2},
+			},
+			wantCalls: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.noRedis {
+				prev := inits.RedisClient
+				inits.RedisClient = nil
+				t.Cleanup(func() { inits.RedisClient = prev })
+			} else {
+				f := startFakeRedis(t)
+				for k, v := range tt.seed {
+					f.set(k, v)
+				}
+			}
+
+			calls := 0
+			r := gin.New()
+			r.POST("/entry", func(c *gin.Context) {
+				serveIdempotent(c, "in", func(c *gin.Context, _ accessAttempt) (int, interface{}) {
+					calls++
+					if calls <= len(tt.statuses) {
+						return tt.statuses[calls-1], gin.H{"error": "车辆已在其他停车场内"}
+					}
+					return http.StatusOK, gin.H{"record_id": calls}
+				})
+			})
+
+			for i, req := range tt.requests {
+				httpReq := httptest.NewRequest(http.MethodPost, "/entry", strings.NewReader(req.body))
+				if req.key != "" {
+					httpReq.Header.Set(idempotencyHeader, req.key)
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httpReq)
+
+				if w.Code != req.wantStatus {
+					t.Fatalf("第 %d 次请求状态码 = %d, want %d（%s）", i+1, w.Code, req.wantStatus, w.Body.String())
+				}
+				if replayed := w.Header().Get(idempotencyReplayedHeader) == "true"; replayed != req.wantReplayed {
+					t.Errorf("第 %d 次请求 Idempotent-Replayed = %v, want %v", i+1, replayed, req.wantReplayed)
+				}
+				if req.wantRecord != 0 {
+					var resp struct {
+						RecordID int `json:"record_id"`
+					}
+					_ = json.Unmarshal(w.Body.Bytes(), &resp)
+					if resp.RecordID != req.wantRecord {
+						t.Errorf("第 %d 次请求 record_id = %d, want %d", i+1, resp.RecordID, req.wantRecord)
+					}
+				}
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("实际办理 %d 次, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyRedisKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name      string
+		gateID    uint
+		direction string
+		want      string
+	}{
+		{"非闸机请求共用 api 作用域", 0, "in", "parking:idem:in:api:k1"},
+		{"闸机请求按闸机隔离", 3, "in", "parking:idem:in:gate3:k1"},
+		{"入场与出场隔离", 3, "out", "parking:idem:out:gate3:k1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			if tt.gateID != 0 {
+				c.Set("gate_id", tt.gateID)
+			}
+			if got := idempotencyRedisKey(c, tt.direction, "k1"); got != tt.want {
+				t.Errorf("idempotencyRedisKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fingerprintOf 与 serveIdempotent 相同的请求体指纹
+func fingerprintOf(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
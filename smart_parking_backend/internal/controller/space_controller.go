@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/middleware"
 	"smart_parking_backend/internal/model"
 	"strconv"
 	"time"
@@ -15,6 +16,7 @@ import (
 // AddParkingSpace 添加新停车位
 // 功能：接收JSON格式的车位数据，验证停车场有效性后存入数据库
 // 参数：通过请求体JSON绑定到model.ParkingSpace结构体
+// 权限：停车场管理员只能为自己的停车场添加车位
 // 返回：成功添加的车位信息或错误提示
 func AddParkingSpace(c *gin.Context) {
 	var space model.ParkingSpace
@@ -31,6 +33,10 @@ func AddParkingSpace(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的停车场ID"})
 		return
 	}
+	if !middleware.CanManageLot(c, lot.LotID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限管理该停车场"})
+		return
+	}
 
 	// 3. 设置最后更新时间
 	space.LastUpdate = time.Now()
@@ -56,6 +62,7 @@ func AddParkingSpace(c *gin.Context) {
 // UpdateSpaceStatus 更新车位状态（数据库+Redis缓存）
 // 功能：根据ID查找车位，更新状态字段，同步更新Redis缓存
 // 参数：车位ID（URL路径参数），状态更新字段（JSON请求体）
+// 权限：停车场管理员只能更新自己停车场的车位
 // 返回：更新后的车位信息或错误提示
 func UpdateSpaceStatus(c *gin.Context) {
 	// 1. 从URL路径获取车位ID
@@ -67,6 +74,10 @@ func UpdateSpaceStatus(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "未找到该车位"})
 		return
 	}
+	if !middleware.CanManageLot(c, space.LotID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限管理该停车场"})
+		return
+	}
 
 	// 3. 定义请求体结构（仅接收需要更新的字段）
 	var req struct {
@@ -2,15 +2,21 @@
 package controller
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"net/http"
 	"regexp"
+	"smart_parking_backend/internal/auth"
 	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/middleware"
 	"smart_parking_backend/internal/model" // 引入用户模型定义
+	"smart_parking_backend/internal/notify"
+	"smart_parking_backend/internal/tariff"
 	"smart_parking_backend/utils"
+	"strconv"
 	"time"
 
-	"github.com/gin-gonic/gin" // Gin Web框架
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/gin-gonic/gin"     // Gin Web框架
 	"github.com/redis/go-redis/v9" // Redis客户端
 	"golang.org/x/crypto/bcrypt"   // 密码加密库
 	"gorm.io/gorm"                 // ORM数据库操作库
@@ -99,7 +105,37 @@ func Register(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-// SendLoginCode 发送登录验证码（模拟发送，实际项目中应集成短信服务）
+// NotifyService 通知服务实例
+var NotifyService *notify.Service
+
+// InitNotifyService 初始化通知服务
+func InitNotifyService(notifySvc *notify.Service) {
+	NotifyService = notifySvc
+}
+
+// AuthService 令牌服务实例
+var AuthService *auth.Service
+
+// InitAuthService 初始化令牌服务
+func InitAuthService(authSvc *auth.Service) {
+	AuthService = authSvc
+}
+
+// 验证码登录的有效期与尝试次数限制
+const (
+	loginCodeTTL        = 5 * time.Minute
+	maxCodeAttempts     = 5         // 同一验证码最多输错次数，达到后验证码作废，需重新获取
+	maxIPCodeFailures   = 20        // 同一 IP 在窗口期内最多输错验证码次数（跨手机号累计）
+	ipCodeFailureWindow = time.Hour // IP 失败计数窗口
+)
+
+// 验证码相关的 Redis 键
+func loginCodeKey(phone string) string     { return "login_code:" + phone }
+func loginCodeFailKey(phone string) string { return "login_code_fail:" + phone }
+func loginCodeIPFailKey(ip string) string  { return "login_code_fail_ip:" + ip }
+
+// SendLoginCode 发送登录验证码（通过通知服务的 sms 渠道发送，渠道驱动见 config/notify.yaml）
+// 验证码只通过短信下发，响应中不返回验证码
 func SendLoginCode(rdb *redis.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
@@ -126,27 +162,37 @@ func SendLoginCode(rdb *redis.Client) gin.HandlerFunc {
 			return
 		}
 
+		if !NotifyService.ChannelEnabled(notify.ChannelSMS) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "短信服务未启用"})
+			return
+		}
+
 		// 生成6位随机验证码
 		code := utils.Generate6DigitCode()
-		key := "login_code:" + req.Phone
+		key := loginCodeKey(req.Phone)
 
-		// 将验证码存入Redis，设置5分钟有效期
-		if err := rdb.Set(c.Request.Context(), key, code, 5*time.Minute).Err(); err != nil {
+		// 将验证码存入Redis，设置5分钟有效期；新验证码重新计算输错次数
+		if err := rdb.Set(c.Request.Context(), key, code, loginCodeTTL).Err(); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "系统错误，请重试"})
 			return
 		}
+		rdb.Del(c.Request.Context(), loginCodeFailKey(req.Phone))
 
 		// 设置频率限制（60秒内只能发送一次）
 		rdb.Set(c.Request.Context(), rateLimitKey, "1", 60*time.Second)
 
-		// 在实际项目中应调用短信服务（如阿里云/腾讯云）发送验证码
-		// utils.SendSMS(req.Phone, code)
+		// 通过通知服务发送验证码短信，写入发件箱后立即投递，失败时由后台任务重试
+		if err := NotifyService.Notify(notify.EventLoginCode, notify.Recipient{Phone: req.Phone}, "",
+			map[string]interface{}{"code": code, "expires_minutes": int(loginCodeTTL.Minutes())}); err != nil {
+			rdb.Del(c.Request.Context(), key, rateLimitKey) // 发送失败时允许立即重新获取
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "验证码发送失败，请重试"})
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"message":      "验证码已发送至您的手机", // 明确的成功提示 [6](@ref)
-			"expires_in":   300,           // 告知验证码有效期（秒）
-			"code":         code,
-			"resend_after": 60, // 可重发时间提示
+			"message":      "验证码已发送至您的手机",               // 明确的成功提示 [6](@ref)
+			"expires_in":   int(loginCodeTTL.Seconds()), // 告知验证码有效期（秒）
+			"resend_after": 60,                          // 可重发时间提示
 		})
 	}
 }
@@ -193,8 +239,16 @@ func Login(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 			}
 		} else if req.Code != "" {
 			// ✅ 模式二：验证码登录
-			codeKey := "login_code:" + req.Phone
-			storedCode, err := rdb.Get(c.Request.Context(), codeKey).Result()
+			ctx := c.Request.Context()
+			ipFailKey := loginCodeIPFailKey(c.ClientIP())
+			if n, err := rdb.Get(ctx, ipFailKey).Int(); err == nil && n >= maxIPCodeFailures {
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, please try again later"})
+				return
+			}
+
+			codeKey := loginCodeKey(req.Phone)
+			failKey := loginCodeFailKey(req.Phone)
+			storedCode, err := rdb.Get(ctx, codeKey).Result()
 			if err == redis.Nil {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Verification code expired"})
 				return
@@ -202,12 +256,28 @@ func Login(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Redis error"})
 				return
 			}
-			if storedCode != req.Code {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid verification code"})
+			if subtle.ConstantTimeCompare([]byte(storedCode), []byte(req.Code)) != 1 {
+				// 按 IP 与手机号分别累计输错次数；同一验证码输错达到上限后作废
+				if n, err := rdb.Incr(ctx, ipFailKey).Result(); err == nil && n == 1 {
+					rdb.Expire(ctx, ipFailKey, ipCodeFailureWindow)
+				}
+				fails, err := rdb.Incr(ctx, failKey).Result()
+				if err == nil && fails == 1 {
+					rdb.Expire(ctx, failKey, loginCodeTTL)
+				}
+				if err == nil && fails >= maxCodeAttempts {
+					rdb.Del(ctx, codeKey, failKey)
+					c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid codes, please request a new code"})
+					return
+				}
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error":              "Invalid verification code",
+					"remaining_attempts": maxCodeAttempts - int(fails),
+				})
 				return
 			}
-			// 登录成功后删除验证码，防止重复使用
-			rdb.Del(c.Request.Context(), codeKey)
+			// 登录成功后删除验证码与输错计数，防止重复使用
+			rdb.Del(ctx, codeKey, failKey)
 		} else {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Password or code required"})
 			return
@@ -221,8 +291,12 @@ func Login(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 		var vehicles []model.Vehicle
 		db.Where("user_id = ?", user.UserID).Find(&vehicles)
 
-		// ✅ 生成 JWT token
-		token, _ := utils.GenerateToken(user.UserID, user.Username)
+		// ✅ 签发访问令牌与刷新令牌（开启新会话）
+		tokens, err := AuthService.Login(c.Request.Context(), auth.Subject{Kind: auth.KindUser, ID: user.UserID, Name: user.Username})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Token generation failed"})
+			return
+		}
 
 		// 构建车辆列表（转换为前端需要的格式）
 		var vehicleList []gin.H
@@ -245,7 +319,10 @@ func Login(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 				"email":    user.Email,
 				"vehicles": vehicleList,
 			},
-			"token": token,
+			"token":              tokens.AccessToken, // 访问令牌，过期后用 refresh_token 换取新令牌
+			"refresh_token":      tokens.RefreshToken,
+			"expires_in":         tokens.ExpiresIn,
+			"refresh_expires_in": tokens.RefreshExpiresIn,
 		})
 	}
 }
@@ -253,7 +330,7 @@ func Login(db *gorm.DB, rdb *redis.Client) gin.HandlerFunc {
 // PaymentRecordWithDetails 带详细信息的支付记录响应结构
 type PaymentRecordWithDetails struct {
 	model.PaymentRecord
-	OrderType    string                 `json:"order_type"`    // "reservation", "parking", "violation"
+	OrderType    string                 `json:"order_type"`    // "reservation", "parking", "violation", "wallet_topup"
 	OrderDetails map[string]interface{} `json:"order_details"` // 订单详细信息
 }
 
@@ -277,8 +354,10 @@ func GetUserPaymentRecords(db *gorm.DB) gin.HandlerFunc {
 		// 查询总数
 		db.Model(&model.PaymentRecord{}).Where("user_id = ?", userID).Count(&total)
 
-		// 查询支付记录，关联订单
-		err := db.Preload("Order").
+		// 查询支付记录，按支付对象类型关联对应的业务单据
+		err := db.Preload("Order.ParkingRecord").
+			Preload("ParkingRecord.Vehicle").Preload("ParkingRecord.Lot").
+			Preload("Violation.Vehicle").
 			Where("user_id = ?", userID).
 			Order("create_time DESC").
 			Offset(offset).
@@ -295,17 +374,65 @@ func GetUserPaymentRecords(db *gorm.DB) gin.HandlerFunc {
 		for _, payment := range payments {
 			record := PaymentRecordWithDetails{
 				PaymentRecord: payment,
-				OrderType:     "reservation", // 默认类型
+				OrderType:     payment.PayableType,
 				OrderDetails:  make(map[string]interface{}),
 			}
 
-			// 根据TransactionNo判断订单类型
-			transactionNo := payment.TransactionNo
-			if len(transactionNo) >= 12 && transactionNo[:12] == "PENDING_VIO_" {
-				// 违规订单
-				record.OrderType = "violation"
-				var violation model.ViolationRecord
-				if err := db.Preload("Vehicle").Preload("Record").First(&violation, payment.OrderID).Error; err == nil {
+			switch payment.PayableType {
+			case model.PayableReservation:
+				if order := payment.Order; order != nil {
+					record.OrderDetails["order_id"] = order.OrderID
+					record.OrderDetails["reservation_cod"] = order.ReservationCode
+					record.OrderDetails["start_time"] = order.StartTime
+					record.OrderDetails["end_time"] = order.EndTime
+					record.OrderDetails["status"] = order.Status
+					// 凭该预订入场的停车记录
+					if parkingRecord := order.ParkingRecord; parkingRecord != nil {
+						record.OrderDetails["parking_record"] = map[string]interface{}{
+							"record_id":   parkingRecord.RecordID,
+							"ticket_code": parkingRecord.TicketCode,
+							"entry_time":  parkingRecord.EntryTime,
+							"exit_time":   parkingRecord.ExitTime,
+						}
+					}
+				}
+			case model.PayableParking:
+				if parkingRecord := payment.ParkingRecord; parkingRecord != nil {
+					record.OrderDetails["record_id"] = parkingRecord.RecordID
+					record.OrderDetails["ticket_code"] = parkingRecord.TicketCode
+					record.OrderDetails["entry_time"] = parkingRecord.EntryTime
+					if parkingRecord.ExitTime != nil {
+						record.OrderDetails["exit_time"] = parkingRecord.ExitTime
+					}
+					record.OrderDetails["duration_minute"] = parkingRecord.DurationMinutes
+					record.OrderDetails["fee_calculated"] = parkingRecord.FeeCalculated
+					// 凭预订入场的停车费已抵扣预订预付金额
+					if parkingRecord.ReservationID != nil {
+						record.OrderDetails["reservation_id"] = *parkingRecord.ReservationID
+						var breakdown tariff.Breakdown
+						if json.Unmarshal([]byte(parkingRecord.FeeBreakdown), &breakdown) == nil {
+							record.OrderDetails["prepaid_fee"] = breakdown.Prepaid
+						}
+					}
+					if parkingRecord.Lot.LotID > 0 {
+						record.OrderDetails["lot"] = map[string]interface{}{
+							"lot_id":  parkingRecord.Lot.LotID,
+							"name":    parkingRecord.Lot.Name,
+							"address": parkingRecord.Lot.Address,
+						}
+					}
+					if parkingRecord.Vehicle.VehicleID > 0 {
+						record.OrderDetails["vehicle"] = map[string]interface{}{
+							"vehicle_id":    parkingRecord.Vehicle.VehicleID,
+							"license_plate": parkingRecord.Vehicle.LicensePlate,
+							"brand":         parkingRecord.Vehicle.Brand,
+							"model":         parkingRecord.Vehicle.Model,
+							"color":         parkingRecord.Vehicle.Color,
+						}
+					}
+				}
+			case model.PayableViolation:
+				if violation := payment.Violation; violation != nil {
 					record.OrderDetails["violation_id"] = violation.ViolationID
 					record.OrderDetails["violation_type"] = violation.ViolationType
 					record.OrderDetails["violation_time"] = violation.ViolationTime
@@ -322,136 +449,9 @@ func GetUserPaymentRecords(db *gorm.DB) gin.HandlerFunc {
 						}
 					}
 				}
-			} else if len(transactionNo) >= 8 && transactionNo[:8] == "PENDING_" {
-				// 可能是停车订单或预订订单，优先检查预订订单
-				if payment.Order.OrderID > 0 {
-					// 优先识别为预订订单（即使已进场，预订订单仍然是预订订单）
-					record.OrderType = "reservation"
-					record.OrderDetails["order_id"] = payment.Order.OrderID
-					record.OrderDetails["reservation_cod"] = payment.Order.ReservationCode
-					record.OrderDetails["start_time"] = payment.Order.StartTime
-					record.OrderDetails["end_time"] = payment.Order.EndTime
-					record.OrderDetails["status"] = payment.Order.Status
-					// 如果预订订单已进场，也包含停车记录信息
-					var parkingRecord model.ParkingRecord
-					if err := db.Preload("Vehicle").Preload("Lot").Preload("Space").
-						Where("vehicle_id = ?", payment.Order.VehicleID).
-						Where("record_status = ?", 1). // 在场记录
-						First(&parkingRecord).Error; err == nil {
-						record.OrderDetails["parking_record_id"] = parkingRecord.RecordID
-						record.OrderDetails["entry_time"] = parkingRecord.EntryTime
-						if parkingRecord.ExitTime != nil {
-							record.OrderDetails["exit_time"] = parkingRecord.ExitTime
-						}
-						record.OrderDetails["duration_minute"] = parkingRecord.DurationMinutes
-					}
-				} else {
-					// 没有预订订单，尝试查找停车记录
-					var parkingRecord model.ParkingRecord
-					if err := db.Preload("Vehicle").Preload("Lot").Preload("Space").First(&parkingRecord, payment.OrderID).Error; err == nil {
-						// 找到停车记录
-						record.OrderType = "parking"
-						record.OrderDetails["record_id"] = parkingRecord.RecordID
-						record.OrderDetails["entry_time"] = parkingRecord.EntryTime
-						if parkingRecord.ExitTime != nil {
-							record.OrderDetails["exit_time"] = parkingRecord.ExitTime
-						}
-						record.OrderDetails["duration_minute"] = parkingRecord.DurationMinutes
-						record.OrderDetails["fee_calculated"] = parkingRecord.FeeCalculated
-						if parkingRecord.Lot.LotID > 0 {
-							record.OrderDetails["lot"] = map[string]interface{}{
-								"lot_id":  parkingRecord.Lot.LotID,
-								"name":    parkingRecord.Lot.Name,
-								"address": parkingRecord.Lot.Address,
-							}
-						}
-						if parkingRecord.Vehicle.VehicleID > 0 {
-							record.OrderDetails["vehicle"] = map[string]interface{}{
-								"vehicle_id":    parkingRecord.Vehicle.VehicleID,
-								"license_plate": parkingRecord.Vehicle.LicensePlate,
-								"brand":         parkingRecord.Vehicle.Brand,
-								"model":         parkingRecord.Vehicle.Model,
-								"color":         parkingRecord.Vehicle.Color,
-							}
-						}
-					}
-				}
-			} else {
-				// 没有PENDING前缀，可能是已支付的订单，通过OrderID查找
-				// 优先检查预订订单
-				if payment.Order.OrderID > 0 {
-					record.OrderType = "reservation"
-					record.OrderDetails["order_id"] = payment.Order.OrderID
-					record.OrderDetails["reservation_cod"] = payment.Order.ReservationCode
-					record.OrderDetails["start_time"] = payment.Order.StartTime
-					record.OrderDetails["end_time"] = payment.Order.EndTime
-					record.OrderDetails["status"] = payment.Order.Status
-					// 如果预订订单已完成，可能有关联的停车记录
-					if payment.Order.Status == 3 && payment.Order.ActualEndTime != nil {
-						var parkingRecord model.ParkingRecord
-						if err := db.Preload("Vehicle").Preload("Lot").Preload("Space").
-							Where("vehicle_id = ?", payment.Order.VehicleID).
-							Where("exit_time IS NOT NULL").
-							Order("exit_time DESC").
-							First(&parkingRecord).Error; err == nil {
-							record.OrderDetails["parking_record_id"] = parkingRecord.RecordID
-							record.OrderDetails["entry_time"] = parkingRecord.EntryTime
-							if parkingRecord.ExitTime != nil {
-								record.OrderDetails["exit_time"] = parkingRecord.ExitTime
-							}
-							record.OrderDetails["duration_minute"] = parkingRecord.DurationMinutes
-						}
-					}
-				} else {
-					// 尝试查找停车记录
-					var parkingRecord model.ParkingRecord
-					if err := db.Preload("Vehicle").Preload("Lot").Preload("Space").First(&parkingRecord, payment.OrderID).Error; err == nil {
-						record.OrderType = "parking"
-						record.OrderDetails["record_id"] = parkingRecord.RecordID
-						record.OrderDetails["entry_time"] = parkingRecord.EntryTime
-						if parkingRecord.ExitTime != nil {
-							record.OrderDetails["exit_time"] = parkingRecord.ExitTime
-						}
-						record.OrderDetails["duration_minute"] = parkingRecord.DurationMinutes
-						record.OrderDetails["fee_calculated"] = parkingRecord.FeeCalculated
-						if parkingRecord.Lot.LotID > 0 {
-							record.OrderDetails["lot"] = map[string]interface{}{
-								"lot_id":  parkingRecord.Lot.LotID,
-								"name":    parkingRecord.Lot.Name,
-								"address": parkingRecord.Lot.Address,
-							}
-						}
-						if parkingRecord.Vehicle.VehicleID > 0 {
-							record.OrderDetails["vehicle"] = map[string]interface{}{
-								"vehicle_id":    parkingRecord.Vehicle.VehicleID,
-								"license_plate": parkingRecord.Vehicle.LicensePlate,
-								"brand":         parkingRecord.Vehicle.Brand,
-								"model":         parkingRecord.Vehicle.Model,
-								"color":         parkingRecord.Vehicle.Color,
-							}
-						}
-					} else {
-						// 尝试查找违规记录
-						var violation model.ViolationRecord
-						if err := db.Preload("Vehicle").Preload("Record").First(&violation, payment.OrderID).Error; err == nil {
-							record.OrderType = "violation"
-							record.OrderDetails["violation_id"] = violation.ViolationID
-							record.OrderDetails["violation_type"] = violation.ViolationType
-							record.OrderDetails["violation_time"] = violation.ViolationTime
-							record.OrderDetails["description"] = violation.Description
-							record.OrderDetails["fine_amount"] = violation.FineAmount
-							record.OrderDetails["status"] = violation.Status
-							if violation.Vehicle.VehicleID > 0 {
-								record.OrderDetails["vehicle"] = map[string]interface{}{
-									"vehicle_id":    violation.Vehicle.VehicleID,
-									"license_plate": violation.Vehicle.LicensePlate,
-									"brand":         violation.Vehicle.Brand,
-									"model":         violation.Vehicle.Model,
-									"color":         violation.Vehicle.Color,
-								}
-							}
-						}
-					}
+			case model.PayableWalletTopup:
+				if payment.WalletID != nil {
+					record.OrderDetails["wallet_id"] = *payment.WalletID
 				}
 			}
 
@@ -648,83 +648,12 @@ func DeleteUserVehicle(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-// AdminRegisterRequest 管理员注册请求结构体
-type AdminRegisterRequest struct {
-	Phone    string `json:"phone" binding:"required"`
-	Password string `json:"password" binding:"required,min=6,max=20"`
-	LotID    *uint  `json:"lot_id,omitempty"`
-	Role     string `json:"role,omitempty"`
-}
-
 // AdminLoginRequest 管理员登录请求结构体
 type AdminLoginRequest struct {
 	Phone    string `json:"phone" binding:"required"`
 	Password string `json:"password" binding:"required"`
 }
 
-// AdminRegisterController 管理员注册控制器
-func AdminRegisterController(c *gin.Context) {
-	var req AdminRegisterRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// 验证手机号格式
-	if !isValidPhone(req.Phone) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "手机号格式无效"})
-		return
-	}
-
-	// 检查手机号是否已注册（同时检查username和phone_number字段）
-	var existingAdmin model.Admins
-	result := inits.DB.Where("username = ? OR phone_number = ?", req.Phone, req.Phone).First(&existingAdmin)
-	if result.Error == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "手机号已注册"})
-		return
-	}
-
-	// 加密密码
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "密码加密失败"})
-		return
-	}
-
-	// 默认角色处理
-	role := "lot_admin"
-	if req.Role != "" {
-		role = req.Role
-	}
-
-	// 创建管理员
-	admin := model.Admins{
-		Username:     req.Phone,
-		PhoneNumber:  req.Phone, // 同时设置手机号字段
-		PasswordHash: string(hashedPassword),
-		Role:         role,
-		LotID:        req.LotID,
-		Status:       1, // 默认启用
-		CreateTime:   time.Now(),
-	}
-
-	// 系统管理员不需要lot_id
-	if role == "system" {
-		admin.LotID = nil
-	}
-
-	// 保存管理员
-	if err := inits.DB.Create(&admin).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "注册失败"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "注册成功",
-		"admin":   admin,
-	})
-}
-
 // AdminLoginController 管理员登录控制器
 func AdminLoginController(c *gin.Context) {
 	var req AdminLoginRequest
@@ -759,8 +688,16 @@ func AdminLoginController(c *gin.Context) {
 		return
 	}
 
-	// 生成JWT Token
-	token, err := generateAdminToken(admin)
+	// 停车场管理员管理的停车场（admin_lot），token 中不再携带，由鉴权中间件按数据库实时加载
+	lotIDs, err := middleware.AdminLotIDs(admin.AdminID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询管理员停车场失败"})
+		return
+	}
+	admin.SetLots(lotIDs)
+
+	// 签发访问令牌与刷新令牌（开启新会话）
+	tokens, err := AuthService.Login(c.Request.Context(), auth.Subject{Kind: auth.KindAdmin, ID: admin.AdminID, Name: admin.Username, Role: admin.Role})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token生成失败"})
 		return
@@ -768,8 +705,11 @@ func AdminLoginController(c *gin.Context) {
 
 	// 根据角色类型返回不同的成功响应[1,5](@ref)
 	responseData := gin.H{
-		"token":      token,
-		"admin_info": admin,
+		"token":              tokens.AccessToken,
+		"refresh_token":      tokens.RefreshToken,
+		"expires_in":         tokens.ExpiresIn,
+		"refresh_expires_in": tokens.RefreshExpiresIn,
+		"admin_info":         admin,
 	}
 
 	switch admin.Role {
@@ -780,7 +720,8 @@ func AdminLoginController(c *gin.Context) {
 	case "lot_admin":
 		responseData["message"] = "停车场管理员登录成功"
 		responseData["role"] = "lot_admin"
-		// 如果是停车场管理员，可以返回关联的停车场信息
+		// 如果是停车场管理员，返回管理的停车场（lot_id 为第一个，兼容旧客户端）
+		responseData["lot_ids"] = admin.LotIDs
 		if admin.LotID != nil {
 			responseData["lot_id"] = *admin.LotID
 		}
@@ -791,18 +732,52 @@ func AdminLoginController(c *gin.Context) {
 	}
 }
 
-// 生成JWT Token
-func generateAdminToken(admin model.Admins) (string, error) {
-	claims := jwt.MapClaims{
-		"admin_id": admin.AdminID,
-		"phone":    admin.Username,
-		"role":     admin.Role,
-		"lot_id":   admin.LotID,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(), // 24小时过期
+// UserStatusRequest 启用/禁用用户请求体
+type UserStatusRequest struct {
+	Status *int8 `json:"status" binding:"required"` // 0-禁用，1-正常
+}
+
+// SetUserStatus 启用/禁用用户账号（系统管理员），禁用后该用户已签发的 token 立即失效
+// PUT /admin/users/:id/status
+func SetUserStatus(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || userID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+	var req UserStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if *req.Status != 0 && *req.Status != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "状态无效，可选 0（禁用）/ 1（正常）"})
+		return
+	}
+
+	res := inits.DB.Model(&model.Users_list{}).Where("user_id = ?", userID).Update("status", *req.Status)
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新用户状态失败"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		var count int64
+		inits.DB.Model(&model.Users_list{}).Where("user_id = ?", userID).Count(&count)
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+			return
+		}
+	}
+
+	// 禁用时吊销该用户此前签发的全部 token（刷新时同样会校验账号状态）
+	if *req.Status == 0 {
+		if err := AuthService.RevokeSubject(c.Request.Context(), auth.KindUser, uint(userID)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "用户已禁用，但吊销登录状态失败，请重试"})
+			return
+		}
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(inits.GetEnv("JWT_SECRET_ADMIN")))
+	c.JSON(http.StatusOK, gin.H{"message": "用户状态已更新", "user_id": userID, "status": *req.Status})
 }
 
 // 手机号验证函数
@@ -1,35 +1,42 @@
 package controller
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"smart_parking_backend/internal/access"
+	"smart_parking_backend/internal/booking"
 	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/middleware"
 	"smart_parking_backend/internal/model"
-	"strconv"
+	"smart_parking_backend/internal/tariff"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // ==================== 通用函数 ====================
 
-// findActiveParkingRecordByLicensePlate 根据车牌号查找在场停车记录
+// findActiveParkingRecordByLicensePlate 根据车牌号查找在场停车记录（含访客停车）
 func findActiveParkingRecordByLicensePlate(licensePlate string) (*model.ParkingRecord, *model.ParkingSpace, *model.ParkingLot, error) {
-	var record model.ParkingRecord
-	var vehicle model.Vehicle
+	return findActiveParkingRecord("license_plate = ?", licensePlate)
+}
 
-	// 先查找车辆
-	err := inits.DB.Where("license_plate = ?", licensePlate).First(&vehicle).Error
-	if err != nil {
-		return nil, nil, nil, err
-	}
+// findActiveParkingRecordByTicket 根据停车凭证号查找在场停车记录
+func findActiveParkingRecordByTicket(ticketCode string) (*model.ParkingRecord, *model.ParkingSpace, *model.ParkingLot, error) {
+	return findActiveParkingRecord("ticket_code = ?", ticketCode)
+}
 
-	// 查找在场停车记录
-	err = inits.DB.
-		Where("vehicle_id = ?", vehicle.VehicleID).
+// findActiveParkingRecord 按条件查找在场停车记录
+func findActiveParkingRecord(cond string, arg interface{}) (*model.ParkingRecord, *model.ParkingSpace, *model.ParkingLot, error) {
+	var record model.ParkingRecord
+	err := inits.DB.
+		Where(cond, arg).
 		Where("record_status = ?", 1). // 1-在场
 		Preload("Space").
 		Preload("Lot").
@@ -81,21 +88,16 @@ func updateReservationStatus(tx *gorm.DB, reservationID uint, status int8) error
 		}).Error
 }
 
-// calculateParkingFee 计算停车费用
-func calculateParkingFee(duration time.Duration, hourlyRate float64) float64 {
-	// 计算小时数（向上取整）
-	hours := duration.Hours()
-	if hours < 1 {
-		hours = 1 // 不足1小时按1小时计费
-	} else if hours > float64(int(hours)) {
-		hours = float64(int(hours) + 1) // 超过整小时部分按1小时计费
-	}
+// TariffService 计费服务实例
+var TariffService *tariff.Service
 
-	return hours * hourlyRate
+// InitTariffService 初始化计费服务
+func InitTariffService(tariffSvc *tariff.Service) {
+	TariffService = tariffSvc
 }
 
-// checkViolations 检查违规记录
-func checkViolations(recordID uint) (float64, bool) {
+// checkViolations 检查停车记录未处理的违规记录，返回违规记录与罚款总额
+func checkViolations(recordID uint) ([]model.ViolationRecord, float64) {
 	var violations []model.ViolationRecord
 	err := inits.DB.
 		Where("record_id = ?", recordID).
@@ -103,7 +105,7 @@ func checkViolations(recordID uint) (float64, bool) {
 		Find(&violations).Error
 
 	if err != nil || len(violations) == 0 {
-		return 0, false
+		return nil, 0
 	}
 
 	// 计算总罚款金额
@@ -112,7 +114,45 @@ func checkViolations(recordID uint) (float64, bool) {
 		totalFine += v.FineAmount
 	}
 
-	return totalFine, true
+	return violations, totalFine
+}
+
+// parkingError 入场/出场业务错误，status 为返回给客户端的 HTTP 状态码，reason 为写入通行日志的原因编码
+type parkingError struct {
+	status  int
+	reason  string
+	message string
+}
+
+func (e *parkingError) Error() string { return e.message }
+
+// newParkingError 参数错误或服务端错误，原因编码按状态码确定
+func newParkingError(status int, message string) error {
+	reason := access.ReasonInternal
+	if status == http.StatusBadRequest {
+		reason = access.ReasonInvalidRequest
+	}
+	return &parkingError{status: status, reason: reason, message: message}
+}
+
+// newParkingDenial 被业务规则拒绝的入场/出场（停车场已满、已关闭等）
+func newParkingDenial(status int, reason, message string) error {
+	return &parkingError{status: status, reason: reason, message: message}
+}
+
+// parkingErrorResponse 入场/出场业务错误对应的 HTTP 状态码与响应体（保持 {"error": "..."} 格式）
+func parkingErrorResponse(err error) (int, interface{}) {
+	var pe *parkingError
+	if errors.As(err, &pe) {
+		return pe.status, gin.H{"error": pe.message}
+	}
+	return http.StatusInternalServerError, gin.H{"error": "服务器内部错误"}
+}
+
+// isDuplicateKey 是否违反唯一约束（MySQL 1062）
+func isDuplicateKey(err error) bool {
+	var me *mysql.MySQLError
+	return errors.As(err, &me) && me.Number == 1062
 }
 
 // ==================== 车辆入场功能 ====================
@@ -120,8 +160,8 @@ func checkViolations(recordID uint) (float64, bool) {
 // VehicleEntryRequest 车辆入场请求
 type VehicleEntryRequest struct {
 	LicensePlate string `json:"license_plate" binding:"required"` // 车牌号
-	SpaceType    string `json:"space_type"`                        // 车位类型（普通、充电桩等）
-	LotID        uint   `json:"lot_id"`                            // 停车场ID（可选，如果提供则用于精确匹配预订）
+	SpaceType    string `json:"space_type"`                       // 车位类型（普通、充电桩等）
+	LotID        uint   `json:"lot_id"`                           // 停车场ID（车辆实际所在的停车场，预订匹配与车位分配均限定在该停车场内；闸机上报时取闸机所属停车场）
 }
 
 // VehicleEntryResponse 车辆入场响应
@@ -133,14 +173,70 @@ type VehicleEntryResponse struct {
 	LotName       string    `json:"lot_name"`       // 停车场名称
 	EntryTime     time.Time `json:"entry_time"`     // 入场时间
 	ReservationID *uint     `json:"reservation_id"` // 关联的预约ID（如果有）
+	TicketCode    string    `json:"ticket_code"`    // 停车凭证号（出场、支付、认领时使用）
+	IsGuest       bool      `json:"is_guest"`       // 是否访客停车（车牌未登记）
+	AlreadyInside bool      `json:"already_inside"` // 车辆已在场，返回的是原停车会话（未重复分配车位）
 }
 
-// VehicleEntry 处理车辆入场
+// VehicleEntry 处理车辆入场（每次尝试都写入通行日志，支持 Idempotency-Key 请求头）
 func VehicleEntry(c *gin.Context) {
+	serveIdempotent(c, access.DirectionIn, func(c *gin.Context, attempt accessAttempt) (int, interface{}) {
+		resp, req, err := handleVehicleEntry(c, attempt.start)
+		attempt.journalEntry(req, attempt.start, resp, err)
+		if err != nil {
+			return parkingErrorResponse(err)
+		}
+		return http.StatusOK, resp
+	})
+}
+
+// handleVehicleEntry 解析入场请求并办理入场
+func handleVehicleEntry(c *gin.Context, at time.Time) (*VehicleEntryResponse, VehicleEntryRequest, error) {
 	var req VehicleEntryRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
-		return
+		return nil, req, newParkingError(http.StatusBadRequest, "无效的请求参数")
+	}
+
+	// 经闸机认证的请求以闸机所属停车场为准，请求体中的 lot_id 可省略，填写时必须一致
+	if gateLotID := c.GetUint("gate_lot_id"); gateLotID != 0 {
+		if req.LotID != 0 && req.LotID != gateLotID {
+			req.LotID = gateLotID // 通行日志按闸机所属停车场记录
+			return nil, req, newParkingDenial(http.StatusBadRequest, access.ReasonGateLotMismatch, "停车场ID与闸机所属停车场不一致")
+		}
+		req.LotID = gateLotID
+	}
+
+	// 管理员人工办理入场只能办理管理范围内的停车场
+	if c.GetUint("admin_id") != 0 && !middleware.CanManageLot(c, req.LotID) {
+		return nil, req, newParkingDenial(http.StatusForbidden, access.ReasonLotForbidden, "无权限管理该停车场")
+	}
+
+	resp, err := enterVehicle(req, at)
+	return resp, req, err
+}
+
+// enterVehicle 办理车辆入场，入场时间为 at（实时入场为当前时间，闸机补传的离线事件为事件发生时间）
+// 预约匹配与车位分配均按入场时间判断
+func enterVehicle(req VehicleEntryRequest, at time.Time) (resp *VehicleEntryResponse, err error) {
+	if req.LotID == 0 {
+		return nil, newParkingError(http.StatusBadRequest, "停车场ID不能为空")
+	}
+
+	// 入场停车场必须存在且处于开放状态
+	var entryLot model.ParkingLot
+	if err := inits.DB.First(&entryLot, req.LotID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, newParkingDenial(http.StatusNotFound, access.ReasonLotNotFound, "停车场不存在")
+		}
+		return nil, newParkingError(http.StatusInternalServerError, "查询停车场信息失败")
+	}
+	if entryLot.Status != 1 {
+		return nil, newParkingDenial(http.StatusForbidden, access.ReasonLotClosed, "停车场已关闭")
+	}
+
+	// 车辆已在场（车牌识别重复触发、重复提交）：本停车场返回原停车会话，不再分配车位
+	if resp, err := activeSession(req.LicensePlate, req.LotID); resp != nil || err != nil {
+		return resp, err
 	}
 
 	// 开启事务
@@ -148,88 +244,96 @@ func VehicleEntry(c *gin.Context) {
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			resp, err = nil, newParkingError(http.StatusInternalServerError, "服务器内部错误")
 		}
 	}()
 
-	// 1. 根据车牌号查找车辆信息
+	// 1. 根据车牌号查找车辆信息，未登记的车牌按访客停车处理（不关联用户，凭停车凭证出场）
 	vehicle, user, err := findVehicleAndUser(req.LicensePlate)
-	if err != nil {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		tx.Rollback()
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "未找到车辆信息"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询车辆信息失败"})
-		}
-		return
+		return nil, newParkingError(http.StatusInternalServerError, "查询车辆信息失败")
 	}
+	isGuest := vehicle == nil
 
-	// 2. 检查是否有有效的预约（使用事务查询）
-	// 严格按照用户要求：按车牌号、停车场、当前时间筛选
-	// 如果提供了停车场ID，则必须匹配该停车场；否则不限制停车场（兼容旧逻辑）
+	// 2. 检查是否有有效的预约（使用事务查询，访客无预约）
+	// 按车牌号、停车场、入场时间筛选，只匹配车辆所在停车场的预约
 	var reservation *model.ReservationOrder
 	var space *model.ParkingSpace
 	var lot *model.ParkingLot
-	if req.LotID > 0 {
-		// 如果提供了停车场ID，必须按停车场匹配
-		reservation, space, lot, err = findValidReservationWithTx(tx, vehicle.VehicleID, req.LotID)
+	if !isGuest {
+		reservation, space, lot, err = findValidReservationWithTx(tx, vehicle.VehicleID, req.LotID, at)
 		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询预约信息失败"})
-			return
-		}
-	} else {
-		// 如果没有提供停车场ID，则不限制停车场（兼容旧逻辑）
-		reservation, space, lot, err = findValidReservationWithTx(tx, vehicle.VehicleID, 0)
-		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询预约信息失败"})
-			return
+			return nil, newParkingError(http.StatusInternalServerError, "查询预约信息失败")
 		}
 	}
 
-	// 3. 如果没有有效预约，分配新车位（使用事务查询）
+	// 3. 如果没有有效预约，在当前停车场内分配新车位（使用事务查询）
 	if reservation == nil {
-		space, lot, err = assignNewSpaceWithTx(tx, req.SpaceType)
+		space, lot, err = assignNewSpaceWithTx(tx, req.LotID, req.SpaceType, at)
 		if err != nil {
 			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "分配车位失败: " + err.Error()})
-			return
+			if errors.Is(err, errLotFull) {
+				return nil, newParkingDenial(http.StatusConflict, access.ReasonLotFull, "停车场已满，暂无可用车位")
+			}
+			return nil, newParkingError(http.StatusInternalServerError, "分配车位失败: "+err.Error())
 		}
 	}
 
-	// 4. 创建停车记录
-	record, err := createParkingRecord(tx, user.UserID, vehicle.VehicleID, space.SpaceID, lot.LotID)
+	// 4. 创建停车记录（凭预订入场时记录关联的预订订单，出场时据此完成预订并抵扣预付金额）
+	var userID, vehicleID, reservationID *uint
+	if !isGuest {
+		userID = &user.UserID
+		vehicleID = &vehicle.VehicleID
+	}
+	if reservation != nil {
+		reservationID = &reservation.OrderID
+	}
+	record, err := createParkingRecord(tx, userID, vehicleID, reservationID, req.LicensePlate, space.SpaceID, lot.LotID, at)
 	if err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建停车记录失败"})
-		return
+		// 同一车牌的并发入场只有一个能创建在场记录，其余按车辆已在场处理
+		if isDuplicateKey(err) {
+			if resp, err := activeSession(req.LicensePlate, req.LotID); resp != nil || err != nil {
+				return resp, err
+			}
+		}
+		return nil, newParkingError(http.StatusInternalServerError, "创建停车记录失败")
 	}
 
-	// 5. 更新车位状态
-	if err := updateSpaceStatus(tx, space.SpaceID, true); err != nil {
+	// 5. 更新车位状态（条件更新，车位已被占用时拒绝重复分配）
+	if err := occupySpaceWithTx(tx, space.SpaceID); err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新车位状态失败"})
-		return
+		if errors.Is(err, errSpaceOccupied) {
+			return nil, newParkingDenial(http.StatusConflict, access.ReasonSpaceOccupied, "车位"+space.SpaceNumber+"当前已被占用，请联系管理员")
+		}
+		return nil, newParkingError(http.StatusInternalServerError, "更新车位状态失败")
 	}
 
 	// 6. 如果有预约，更新预约状态
 	if reservation != nil {
-		if err := updateReservationStatus(tx, reservation.OrderID, 2); err != nil { // 2-使用中
+		// 条件更新：仅"已预订"状态可转为"使用中"，同一预约被并发入场时只有一个请求成功
+		result := tx.Model(&model.ReservationOrder{}).
+			Where("order_id = ? AND status = ?", reservation.OrderID, 1).
+			Update("status", 2) // 2-使用中
+		if result.Error != nil {
 			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新预约状态失败"})
-			return
+			return nil, newParkingError(http.StatusInternalServerError, "更新预约状态失败")
+		}
+		if result.RowsAffected == 0 {
+			tx.Rollback()
+			return nil, newParkingDenial(http.StatusConflict, access.ReasonReservationUsed, "该预约已被使用")
 		}
 	}
 
 	// 提交事务
 	if err := tx.Commit().Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "事务提交失败"})
-		return
+		return nil, newParkingError(http.StatusInternalServerError, "事务提交失败")
 	}
 
 	// 构建响应
-	resp := VehicleEntryResponse{
+	resp = &VehicleEntryResponse{
 		RecordID:      record.RecordID,
 		SpaceID:       space.SpaceID,
 		SpaceNumber:   space.SpaceNumber,
@@ -237,13 +341,43 @@ func VehicleEntry(c *gin.Context) {
 		LotName:       lot.Name,
 		EntryTime:     record.EntryTime,
 		ReservationID: nil,
+		TicketCode:    record.TicketCode,
+		IsGuest:       isGuest,
 	}
 
 	if reservation != nil {
 		resp.ReservationID = &reservation.OrderID
 	}
 
-	c.JSON(http.StatusOK, resp)
+	return resp, nil
+}
+
+// activeSession 车牌在场时返回原停车会话：在本停车场返回入场响应（AlreadyInside 为 true），在其他停车场拒绝入场
+// 车辆不在场时返回 nil, nil
+func activeSession(licensePlate string, lotID uint) (*VehicleEntryResponse, error) {
+	record, space, lot, err := findActiveParkingRecordByLicensePlate(licensePlate)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, newParkingError(http.StatusInternalServerError, "查询在场记录失败")
+	}
+	if record.LotID != lotID {
+		return nil, newParkingDenial(http.StatusConflict, access.ReasonInsideOtherLot,
+			fmt.Sprintf("车辆已在%s场内（入场时间 %s），请先办理出场", lot.Name, record.EntryTime.Format(time.DateTime)))
+	}
+	return &VehicleEntryResponse{
+		RecordID:      record.RecordID,
+		SpaceID:       space.SpaceID,
+		SpaceNumber:   space.SpaceNumber,
+		Level:         space.Level,
+		LotName:       lot.Name,
+		EntryTime:     record.EntryTime,
+		ReservationID: record.ReservationID,
+		TicketCode:    record.TicketCode,
+		IsGuest:       record.VehicleID == nil,
+		AlreadyInside: true,
+	}, nil
 }
 
 // findVehicleAndUser 根据车牌号查找车辆和用户信息
@@ -259,19 +393,18 @@ func findVehicleAndUser(licensePlate string) (*model.Vehicle, *model.Users_list,
 
 // findValidReservation 查找有效的预约（使用非事务DB，用于兼容旧代码）
 func findValidReservation(vehicleID uint) (*model.ReservationOrder, *model.ParkingSpace, *model.ParkingLot, error) {
-	return findValidReservationWithTx(inits.DB, vehicleID, 0)
+	return findValidReservationWithTx(inits.DB, vehicleID, 0, time.Now())
 }
 
 // findValidReservationByVehicleAndLot 根据车辆ID和停车场ID查找有效预订（用于检查预订接口）
 func findValidReservationByVehicleAndLot(vehicleID uint, lotID uint) (*model.ReservationOrder, *model.ParkingSpace, *model.ParkingLot, error) {
-	return findValidReservationWithTx(inits.DB, vehicleID, lotID)
+	return findValidReservationWithTx(inits.DB, vehicleID, lotID, time.Now())
 }
 
 // findValidReservationWithTx 查找有效的预约（支持事务）
-// 严格按照用户要求：按车牌号（vehicleID）、停车场（lotID）、当前时间筛选
-// 查找当前时间在预约时间段内且状态为已预订的预约
-func findValidReservationWithTx(db *gorm.DB, vehicleID uint, lotID uint) (*model.ReservationOrder, *model.ParkingSpace, *model.ParkingLot, error) {
-	now := time.Now()
+// 严格按照用户要求：按车牌号（vehicleID）、停车场（lotID）、入场时间（now）筛选
+// 查找入场时间在预约时间段内且状态为已预订的预约
+func findValidReservationWithTx(db *gorm.DB, vehicleID uint, lotID uint, now time.Time) (*model.ReservationOrder, *model.ParkingSpace, *model.ParkingLot, error) {
 	var reservation model.ReservationOrder
 
 	// 查找当前时间在预约时间段内且状态为已预订的预约
@@ -312,45 +445,106 @@ func findValidReservationWithTx(db *gorm.DB, vehicleID uint, lotID uint) (*model
 }
 
 // assignNewSpace 分配新车位（使用非事务DB，用于兼容旧代码）
-func assignNewSpace(spaceType string) (*model.ParkingSpace, *model.ParkingLot, error) {
-	return assignNewSpaceWithTx(inits.DB, spaceType)
-}
-
-// assignNewSpaceWithTx 分配新车位（支持事务）
-func assignNewSpaceWithTx(db *gorm.DB, spaceType string) (*model.ParkingSpace, *model.ParkingLot, error) {
-	// 查找指定类型的可用车位
-	var space model.ParkingSpace
-	err := db.
-		Where("space_type = ?", spaceType).
-		Where("is_occupied = ?", 0). // 未被占用
-		Where("is_reserved = ?", 0). // 未被预订
-		Where("status = ?", 1).      // 状态可用
-		Preload("Lot").              // 关联停车场信息
-		Order("space_id").           // 按顺序分配
-		First(&space).Error
+func assignNewSpace(lotID uint, spaceType string) (*model.ParkingSpace, *model.ParkingLot, error) {
+	return assignNewSpaceWithTx(inits.DB, lotID, spaceType, time.Now())
+}
 
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			// 如果没有指定类型的车位，尝试分配普通车位
-			if spaceType != "普通" {
-				return assignNewSpaceWithTx(db, "普通")
+// errSpaceOccupied 车位已被其它车辆占用
+var errSpaceOccupied = errors.New("车位已被占用")
+
+// errLotFull 停车场内没有可分配的车位（含普通车位降级后仍无可用）
+var errLotFull = errors.New("停车场已满")
+
+// assignNewSpaceWithTx 在指定停车场内分配新车位（支持事务），不会跨停车场分配，预订保留窗口按入场时间（now）判断
+// 使用 SELECT ... FOR UPDATE SKIP LOCKED 锁定车位行，并发入场的车辆不会被分配到同一车位
+func assignNewSpaceWithTx(db *gorm.DB, lotID uint, spaceType string, now time.Time) (*model.ParkingSpace, *model.ParkingLot, error) {
+	spaceType = tariff.NormalizeSpaceType(spaceType)
+	var excluded []uint
+	for attempt := 0; attempt < 3; attempt++ {
+		// 查找指定类型的可用车位：未被占用、不在任何预订的保留窗口内
+		var space model.ParkingSpace
+		query := db.
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("lot_id = ?", lotID).
+			Where("space_type = ?", spaceType).
+			Where("is_occupied = ?", 0). // 未被占用
+			Where("status = ?", 1).      // 状态可用
+			Where("NOT EXISTS (?)", booking.HeldReservations(db, now))
+		if len(excluded) > 0 {
+			query = query.Where("space_id NOT IN ?", excluded)
+		}
+		err := query.Order("space_id").First(&space).Error // 按顺序分配
+
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// 如果没有指定类型的车位，尝试分配同一停车场内的普通车位
+				if spaceType != "普通" {
+					return assignNewSpaceWithTx(db, lotID, "普通", now)
+				}
+				return nil, nil, errLotFull
 			}
-			return nil, nil, errors.New("没有可用车位")
+			return nil, nil, err
 		}
-		return nil, nil, err
+
+		// 持有车位行锁后用锁定读复核，避免漏掉刚提交的预订
+		var held []uint
+		err = db.Model(&model.ReservationOrder{}).
+			Clauses(clause.Locking{Strength: "SHARE"}).
+			Where("space_id = ? AND status = ?", space.SpaceID, 1).
+			Where("start_time <= ? AND end_time >= ?", now.Add(booking.ReservationHoldBuffer), now).
+			Limit(1).
+			Pluck("order_id", &held).Error
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(held) > 0 {
+			excluded = append(excluded, space.SpaceID)
+			continue
+		}
+
+		// 关联停车场信息
+		if err := db.First(&space.Lot, space.LotID).Error; err != nil {
+			return nil, nil, err
+		}
+		return &space, &space.Lot, nil
 	}
+	return nil, nil, errLotFull
+}
 
-	return &space, &space.Lot, nil
+// occupySpaceWithTx 将车位标记为占用（条件更新，仅当车位当前未被占用时生效）
+func occupySpaceWithTx(tx *gorm.DB, spaceID uint) error {
+	result := tx.Model(&model.ParkingSpace{}).
+		Where("space_id = ? AND is_occupied = ?", spaceID, 0).
+		Updates(map[string]interface{}{
+			"is_occupied": 1,
+			"last_update": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errSpaceOccupied
+	}
+	return nil
 }
 
-// createParkingRecord 创建停车记录
-func createParkingRecord(tx *gorm.DB, userID, vehicleID, spaceID, lotID uint) (*model.ParkingRecord, error) {
+// generateTicketCode 生成停车凭证号
+func generateTicketCode(lotID uint, now time.Time) string {
+	return fmt.Sprintf("TK-%d-%d", lotID, now.UnixNano())
+}
+
+// createParkingRecord 创建停车记录（访客停车时 userID、vehicleID 为空，未凭预订入场时 reservationID 为空）
+func createParkingRecord(tx *gorm.DB, userID, vehicleID, reservationID *uint, licensePlate string, spaceID, lotID uint, entryTime time.Time) (*model.ParkingRecord, error) {
 	record := model.ParkingRecord{
 		UserID:        userID,
 		VehicleID:     vehicleID,
+		ReservationID: reservationID,
+		LicensePlate:  licensePlate,
+		TicketCode:    generateTicketCode(lotID, time.Now()), // 按签发时间生成（补传的离线事件入场时间可能相同）
+		ActivePlate:   &licensePlate,                         // 唯一约束，同一车牌只能有一条在场记录
 		SpaceID:       spaceID,
 		LotID:         lotID,
-		EntryTime:     time.Now(),
+		EntryTime:     entryTime,
 		RecordStatus:  1, // 1-在场
 		IsViolation:   0, // 初始无违规
 		PaymentStatus: 0, // 0-未支付
@@ -363,6 +557,79 @@ func createParkingRecord(tx *gorm.DB, userID, vehicleID, spaceID, lotID uint) (*
 	return &record, nil
 }
 
+// ==================== 访客停车认领 ====================
+
+// ClaimGuestParkingRequest 认领访客停车记录请求
+type ClaimGuestParkingRequest struct {
+	TicketCode string `json:"ticket_code" binding:"required"` // 停车凭证号
+}
+
+// ClaimGuestParkingRecord 将访客停车记录认领到当前登录用户名下
+// 要求当前用户已登记与停车记录相同车牌的车辆，认领后该记录及其待支付的停车费一并归属到用户
+func ClaimGuestParkingRecord(c *gin.Context) {
+	userIDVal, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权，请先登录"})
+		return
+	}
+	userID, ok := userIDVal.(uint)
+	if !ok || userID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+
+	var req ClaimGuestParkingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	var record model.ParkingRecord
+	err := inits.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("ticket_code = ? AND user_id IS NULL", req.TicketCode).
+			First(&record).Error; err != nil {
+			return err
+		}
+
+		var vehicle model.Vehicle
+		if err := tx.Where("user_id = ? AND license_plate = ?", userID, record.LicensePlate).
+			First(&vehicle).Error; err != nil {
+			return errVehicleNotRegistered
+		}
+
+		if err := tx.Model(&record).Updates(map[string]interface{}{
+			"user_id":    userID,
+			"vehicle_id": vehicle.VehicleID,
+		}).Error; err != nil {
+			return err
+		}
+		record.UserID = &userID
+		record.VehicleID = &vehicle.VehicleID
+
+		// 访客支付记录只会是停车费，一并归属到用户
+		return tx.Model(&model.PaymentRecord{}).
+			Where("payable_type = ? AND parking_record_id = ? AND user_id IS NULL", model.PayableParking, record.RecordID).
+			Update("user_id", userID).Error
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "未找到可认领的访客停车记录"})
+		case errors.Is(err, errVehicleNotRegistered):
+			c.JSON(http.StatusForbidden, gin.H{"error": "请先将车牌 " + record.LicensePlate + " 添加到您的车辆中"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "认领停车记录失败"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "认领成功", "data": record})
+}
+
+// errVehicleNotRegistered 当前用户未登记该车牌
+var errVehicleNotRegistered = errors.New("车辆未登记")
+
 // ==================== 辅助功能 ====================
 
 // GetParkingSpaceTypes 获取可用的车位类型
@@ -384,22 +651,9 @@ func GetParkingSpaceTypes(c *gin.Context) {
 	c.JSON(http.StatusOK, spaceTypes)
 }
 
-// GetUserActiveParkingRecords 获取用户当前在场停车记录
+// GetUserActiveParkingRecords 获取当前登录用户的在场停车记录（用户ID取自 token）
 func GetUserActiveParkingRecords(c *gin.Context) {
-	userIDStr := c.Param("user_id")
-	if userIDStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "用户ID不能为空"})
-		return
-	}
-
-	// 将字符串类型的用户ID转换为uint
-	userID, err := strconv.ParseUint(userIDStr, 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
-		return
-	}
-
-	records, err := findActiveParkingRecordsByUserID(uint(userID))
+	records, err := findActiveParkingRecordsByUserID(c.GetUint("user_id"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询停车记录失败"})
 		return
@@ -465,9 +719,37 @@ func GetVehicleByLicensePlate(c *gin.Context) {
 		return
 	}
 
+	// 停车场管理员只能查询在管理范围内的停车场停过车或预订过的车辆
+	if c.GetString("role") != middleware.RoleSystem {
+		visible, err := vehicleSeenInLots(vehicle.VehicleID, middleware.ManagedLots(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询车辆信息失败"})
+			return
+		}
+		if !visible {
+			c.JSON(http.StatusNotFound, gin.H{"error": "未找到车辆信息"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, vehicle)
 }
 
+// vehicleSeenInLots 车辆是否在指定停车场有停车记录或预订
+func vehicleSeenInLots(vehicleID uint, lotIDs []uint) (bool, error) {
+	if len(lotIDs) == 0 {
+		return false, nil
+	}
+	var n int64
+	if err := inits.DB.Model(&model.ParkingRecord{}).
+		Where("vehicle_id = ? AND lot_id IN ?", vehicleID, lotIDs).Count(&n).Error; err != nil || n > 0 {
+		return n > 0, err
+	}
+	err := inits.DB.Model(&model.ReservationOrder{}).
+		Where("vehicle_id = ? AND lot_id IN ?", vehicleID, lotIDs).Count(&n).Error
+	return n > 0, err
+}
+
 type OccupancyInfo struct {
 	SpaceType string `json:"space_type"`
 	Total     int64  `json:"total"`
@@ -528,6 +810,11 @@ func CheckValidReservation(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
 		return
 	}
+	// 停车场管理员只能查询管理范围内停车场的预订
+	if !middleware.CanManageLot(c, req.LotID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限管理该停车场"})
+		return
+	}
 
 	// 1. 根据车牌号查找车辆信息
 	vehicle, _, err := findVehicleAndUser(req.LicensePlate)
@@ -578,42 +865,122 @@ func CheckValidReservation(c *gin.Context) {
 
 // VehicleExitRequest 车辆出场请求
 type VehicleExitRequest struct {
-	LicensePlate string `json:"license_plate" binding:"required"` // 车牌号
+	LicensePlate string `json:"license_plate"` // 车牌号（与停车凭证号二选一）
+	TicketCode   string `json:"ticket_code"`   // 停车凭证号（访客停车可凭票出场）
 }
 
 // VehicleExitResponse 车辆出场响应
 type VehicleExitResponse struct {
-	RecordID      uint      `json:"record_id"`      // 停车记录ID
-	SpaceID       uint      `json:"space_id"`       // 车位ID
-	SpaceNumber   string    `json:"space_number"`   // 车位编号
-	LotName       string    `json:"lot_name"`       // 停车场名称
-	EntryTime     time.Time `json:"entry_time"`     // 入场时间
-	ExitTime      time.Time `json:"exit_time"`      // 出场时间
-	DurationHours float64   `json:"duration_hours"` // 停车时长（小时）
-	TotalFee      float64   `json:"total_fee"`      // 总费用
-	IsViolation   bool      `json:"is_violation"`   // 是否有违规
-	ViolationFee  float64   `json:"violation_fee"`  // 违规罚款金额
-	PaymentURL    string    `json:"payment_url"`    // 支付链接
+	RecordID          uint                   `json:"record_id"`          // 停车记录ID
+	TicketCode        string                 `json:"ticket_code"`        // 停车凭证号
+	LotID             uint                   `json:"lot_id"`             // 停车场ID
+	SpaceID           uint                   `json:"space_id"`           // 车位ID
+	SpaceNumber       string                 `json:"space_number"`       // 车位编号
+	LotName           string                 `json:"lot_name"`           // 停车场名称
+	EntryTime         time.Time              `json:"entry_time"`         // 入场时间
+	ExitTime          time.Time              `json:"exit_time"`          // 出场时间
+	DurationHours     float64                `json:"duration_hours"`     // 停车时长（小时）
+	ParkingFee        float64                `json:"parking_fee"`        // 应付停车费（已抵扣预付金额）
+	TotalFee          float64                `json:"total_fee"`          // 总费用（停车费 + 违规罚款）
+	ReservationID     *uint                  `json:"reservation_id"`     // 入场时关联的预约ID（如果有）
+	PrepaidFee        float64                `json:"prepaid_fee"`        // 已从停车费中抵扣的预约预付金额
+	FeeBreakdown      *tariff.Breakdown      `json:"fee_breakdown"`      // 停车费计费明细
+	IsViolation       bool                   `json:"is_violation"`       // 是否有违规
+	ViolationFee      float64                `json:"violation_fee"`      // 违规罚款金额
+	PaymentURL        string                 `json:"payment_url"`        // 停车费支付链接（不含罚款，停车费为0时为空）
+	ViolationPayments []ExitViolationPayment `json:"violation_payments"` // 未处理违规的罚款支付单，每条违规单独支付
+	Replayed          bool                   `json:"replayed"`           // 车辆刚出场，返回的是原出场结果（含同一待支付支付单）
+
+	paymentErr error // 支付单创建失败原因（不返回给客户端，写入通行日志）
 }
 
-// VehicleExit 处理车辆出场
+// ExitViolationPayment 出场时未处理违规的罚款支付单（payable_type=violation，结算时只处理该违规记录）
+type ExitViolationPayment struct {
+	ViolationID uint    `json:"violation_id"` // 违规记录ID
+	FineAmount  float64 `json:"fine_amount"`  // 罚款金额
+	PaymentURL  string  `json:"payment_url"`  // 支付链接（创建失败时为空，可调用违规缴费接口重新支付）
+}
+
+// VehicleExit 处理车辆出场（每次尝试都写入通行日志，支持 Idempotency-Key 请求头）
 func VehicleExit(c *gin.Context) {
-	var req VehicleExitRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
-		return
+	serveIdempotent(c, access.DirectionOut, func(c *gin.Context, attempt accessAttempt) (int, interface{}) {
+		// 经闸机认证的请求只能办理本停车场的在场车辆出场
+		lotID := c.GetUint("gate_lot_id")
+
+		var req VehicleExitRequest
+		var resp *VehicleExitResponse
+		err := c.ShouldBindJSON(&req)
+		if err != nil {
+			err = newParkingError(http.StatusBadRequest, "无效的请求参数")
+		} else if lotID, err = adminExitLot(c, req, lotID, attempt.start); err == nil {
+			resp, err = exitVehicle(req, lotID, attempt.start)
+		}
+		attempt.journalExit(req, lotID, attempt.start, resp, err)
+		if err != nil {
+			return parkingErrorResponse(err)
+		}
+		return http.StatusOK, resp
+	})
+}
+
+// adminExitLot 停车场管理员人工办理出场时限定为管理范围内的停车场：按凭证号或车牌定位在场（或刚出场）记录所在的停车场
+// 闸机请求与系统管理员原样返回 lotID
+func adminExitLot(c *gin.Context, req VehicleExitRequest, lotID uint, at time.Time) (uint, error) {
+	if lotID != 0 || c.GetString("role") != middleware.RoleLotAdmin {
+		return lotID, nil
 	}
+	query := inits.DB.Model(&model.ParkingRecord{}).
+		Where("record_status = ? OR (record_status = ? AND exit_time >= ?)", 1, 2, at.Add(-exitReplayWindow))
+	switch {
+	case req.TicketCode != "":
+		query = query.Where("ticket_code = ?", req.TicketCode)
+	case req.LicensePlate != "":
+		query = query.Where("license_plate = ?", req.LicensePlate)
+	default:
+		return lotID, nil // 由 exitVehicle 返回参数错误
+	}
+	var lots []uint
+	if err := query.Order("record_status ASC, exit_time DESC").Limit(1).Pluck("lot_id", &lots).Error; err != nil {
+		return 0, newParkingError(http.StatusInternalServerError, "查询停车记录失败")
+	}
+	if len(lots) == 0 {
+		return lotID, nil // 由 exitVehicle 返回未找到在场记录
+	}
+	if !middleware.CanManageLot(c, lots[0]) {
+		return lots[0], newParkingDenial(http.StatusForbidden, access.ReasonLotForbidden, "无权限管理该停车场")
+	}
+	return lots[0], nil
+}
 
-	// 1. 先根据车牌号查找在场停车记录（在事务外查询，避免事务隔离问题）
-	record, space, lot, err := findActiveParkingRecordByLicensePlate(req.LicensePlate)
+// exitVehicle 办理车辆出场，出场时间为 at（实时出场为当前时间，闸机补传的离线事件为事件发生时间），停车费按入场至出场时间计算
+// lotID 不为 0 时只办理该停车场的在场记录
+func exitVehicle(req VehicleExitRequest, lotID uint, at time.Time) (resp *VehicleExitResponse, err error) {
+	// 1. 先根据停车凭证号或车牌号查找在场停车记录（在事务外查询，避免事务隔离问题）
+	var record *model.ParkingRecord
+	var space *model.ParkingSpace
+	var lot *model.ParkingLot
+	switch {
+	case req.TicketCode != "":
+		record, space, lot, err = findActiveParkingRecordByTicket(req.TicketCode)
+	case req.LicensePlate != "":
+		record, space, lot, err = findActiveParkingRecordByLicensePlate(req.LicensePlate)
+	default:
+		return nil, newParkingError(http.StatusBadRequest, "请提供车牌号或停车凭证号")
+	}
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "未找到在场停车记录"})
-		} else {
-			log.Printf("查询停车记录失败: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("查询停车记录失败: %v", err)})
+			// 重复的出场请求（闸机重试、车牌识别重复触发）返回刚办理的出场结果
+			if resp, err := recentExit(req, lotID, at); resp != nil || err != nil {
+				return resp, err
+			}
+			return nil, newParkingDenial(http.StatusNotFound, access.ReasonNoActiveRecord, "未找到在场停车记录")
 		}
-		return
+		log.Printf("查询停车记录失败: %v", err)
+		return nil, newParkingError(http.StatusInternalServerError, fmt.Sprintf("查询停车记录失败: %v", err))
+	}
+
+	if lotID != 0 && record.LotID != lotID {
+		return nil, newParkingDenial(http.StatusConflict, access.ReasonWrongLot, "车辆不在本停车场，请在入场停车场出场")
 	}
 
 	// 开启事务
@@ -621,16 +988,23 @@ func VehicleExit(c *gin.Context) {
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "服务器内部错误"})
+			resp, err = nil, newParkingError(http.StatusInternalServerError, "服务器内部错误")
 		}
 	}()
 
-	// 在事务内重新加载记录，确保使用事务的DB实例
+	// 在事务内锁定并重新加载记录：同一车辆的重复出场请求（车牌识别重复触发、不同幂等键）串行执行，
+	// 后到的请求看到记录已出场时回滚，返回刚办理的出场结果，不会重复计费、释放车位或创建支付单
 	var txRecord model.ParkingRecord
-	if err := tx.First(&txRecord, record.RecordID).Error; err != nil {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&txRecord, record.RecordID).Error; err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "在事务内查询停车记录失败"})
-		return
+		return nil, newParkingError(http.StatusInternalServerError, "在事务内查询停车记录失败")
+	}
+	if txRecord.RecordStatus != 1 { // 1-在场
+		tx.Rollback()
+		if resp, err := recentExit(req, lotID, at); resp != nil || err != nil {
+			return resp, err
+		}
+		return nil, newParkingDenial(http.StatusNotFound, access.ReasonNoActiveRecord, "未找到在场停车记录")
 	}
 	record = &txRecord
 
@@ -639,34 +1013,57 @@ func VehicleExit(c *gin.Context) {
 	var txLot model.ParkingLot
 	if err := tx.First(&txSpace, record.SpaceID).Error; err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询车位信息失败"})
-		return
+		return nil, newParkingError(http.StatusInternalServerError, "查询车位信息失败")
 	}
 	if err := tx.First(&txLot, record.LotID).Error; err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询停车场信息失败"})
-		return
+		return nil, newParkingError(http.StatusInternalServerError, "查询停车场信息失败")
 	}
 	space = &txSpace
 	lot = &txLot
 
 	// 2. 更新停车记录
-	exitTime := time.Now()
+	exitTime := at
 	duration := exitTime.Sub(record.EntryTime)
 	durationMinutes := int(duration.Minutes())
 
-	// 计算停车费用
-	hourlyRate := lot.HourlyRate
-	totalFee := calculateParkingFee(duration, hourlyRate)
+	// 按停车场计费规则计算停车费用
+	if TariffService == nil {
+		tx.Rollback()
+		return nil, newParkingError(http.StatusInternalServerError, "计费服务未初始化")
+	}
+	quote, err := TariffService.Quote(lot.LotID, space.SpaceType, record.EntryTime, exitTime)
+	if err != nil {
+		tx.Rollback()
+		return nil, newParkingError(http.StatusInternalServerError, "计算停车费用失败: "+err.Error())
+	}
 
-	// 检查是否有违规记录
-	violationFee, hasViolation := checkViolations(record.RecordID)
+	// 凭预订入场的停车记录：锁定关联的预约，停车费抵扣预约已支付的金额
+	var reservation *model.ReservationOrder
+	if record.ReservationID != nil {
+		var order model.ReservationOrder
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, *record.ReservationID).Error; err != nil {
+			tx.Rollback()
+			return nil, newParkingError(http.StatusInternalServerError, "查询预约信息失败")
+		}
+		reservation = &order
+	}
+	totalFee := quote.ApplyPrepaid(reservationPrepaid(reservation))
+
+	// 检查是否有违规记录（罚款单独支付，不计入停车费）
+	violations, violationFee := checkViolations(record.RecordID)
+	hasViolation := len(violations) > 0
 
 	// 更新停车记录
 	record.ExitTime = &exitTime
 	record.DurationMinutes = durationMinutes
 	record.FeeCalculated = totalFee
+	record.FeeBreakdown = quote.Encode()
 	record.RecordStatus = 2 // 2-已出场
+	record.ActivePlate = nil
+	if totalFee <= 0 {
+		record.PaymentStatus = 1 // 停车费在免费时长内或已被预付金额全额抵扣，无需支付（罚款单独支付）
+	}
 	record.IsViolation = 0
 	if hasViolation {
 		record.IsViolation = 1
@@ -674,28 +1071,16 @@ func VehicleExit(c *gin.Context) {
 
 	if err := tx.Save(record).Error; err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新停车记录失败"})
-		return
+		return nil, newParkingError(http.StatusInternalServerError, "更新停车记录失败")
 	}
 
 	// 3. 释放车位
 	if err := updateSpaceStatus(tx, space.SpaceID, false); err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "释放车位失败"})
-		return
+		return nil, newParkingError(http.StatusInternalServerError, "释放车位失败")
 	}
 
-	// 4. 查找关联的预约记录（使用事务查询）
-	// 严格按照用户要求：按车牌号、停车场、停车位类型、停车位序号，按离场时间最近的一次预订时间筛选
-	reservation, err := findActiveReservationForExit(tx, record.VehicleID, record.LotID, space.SpaceType, space.SpaceNumber, exitTime)
-	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询预约信息失败"})
-		return
-	}
-
-	// 5. 如果有预约且状态为"使用中"，更新预约状态为已完成
-	// 严格按照用户要求：如果第一条预订订单状态为"使用中"，则改为"已完成"；如果第一条是其它状态，则不更改
+	// 4. 如果入场时关联的预约仍为"使用中"，更新为已完成（其它状态不更改）
 	if reservation != nil && reservation.Status == 2 { // 2-使用中
 		actualEndTime := exitTime
 		if err := tx.Model(&model.ReservationOrder{}).
@@ -705,199 +1090,141 @@ func VehicleExit(c *gin.Context) {
 				"actual_end_time": &actualEndTime,
 			}).Error; err != nil {
 			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新预约状态失败"})
-			return
+			return nil, newParkingError(http.StatusInternalServerError, "更新预约状态失败")
 		}
 	}
 
 	// 提交事务
 	if err := tx.Commit().Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "事务提交失败"})
-		return
+		return nil, newParkingError(http.StatusInternalServerError, "事务提交失败")
 	}
 
-	// 6. 检查支付服务是否已初始化
+	// 5. 检查支付服务是否已初始化
 	if PaymentService == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "支付服务未初始化"})
-		return
-	}
-
-	// 7. 生成统一支付链接
-	amount := totalFee + violationFee
-	redirectURL, paymentID, err := PaymentService.CreatePayment(
-		record.RecordID,
-		"parking", // 类型：停车付费
-		"alipay",  // 可改成前端传的
-		&amount,
-	)
-	if err != nil {
-		log.Printf("生成支付链接失败: %v", err)
-		// 即使支付创建失败，也返回离场成功，但提示用户需要手动支付
-		// 前端可以根据PaymentURL是否为空来判断是否需要手动创建支付
-		redirectURL = ""
-		paymentID = 0
-	} else {
-		// 记录 paymentID 用于调试
-		log.Printf("生成的支付ID: %d", paymentID)
+		return nil, newParkingError(http.StatusInternalServerError, "支付服务未初始化")
 	}
 
 	// 构建响应
-	resp := VehicleExitResponse{
+	resp = &VehicleExitResponse{
 		RecordID:      record.RecordID,
+		TicketCode:    record.TicketCode,
+		LotID:         record.LotID,
 		SpaceID:       space.SpaceID,
 		SpaceNumber:   space.SpaceNumber,
 		LotName:       lot.Name,
 		EntryTime:     record.EntryTime,
 		ExitTime:      exitTime,
 		DurationHours: duration.Hours(),
-		TotalFee:      amount,
+		ParkingFee:    totalFee,
+		TotalFee:      totalFee + violationFee,
+		ReservationID: record.ReservationID,
+		PrepaidFee:    quote.Prepaid,
+		FeeBreakdown:  quote,
 		IsViolation:   hasViolation,
 		ViolationFee:  violationFee,
-		PaymentURL:    redirectURL, // 统一 paymentService 返回的 URL
 	}
 
-	c.JSON(http.StatusOK, resp)
-
+	// 6. 生成支付链接：停车费与每条违规罚款分别创建支付单（无需支付时不生成）
+	// 即使支付创建失败，也返回离场成功，前端可以根据 payment_url 是否为空来判断是否需要手动创建支付
+	resp.createPayments(record, violations)
+	return resp, nil
 }
 
-// findActiveReservation 查找有效的预约（使用非事务DB，用于兼容旧代码）
-func findActiveReservation(vehicleID uint, entryTime time.Time) (*model.ReservationOrder, error) {
-	return findActiveReservationWithTx(inits.DB, vehicleID, entryTime)
+// createPayments 为未支付的停车费创建停车支付单，为每条未处理违规创建罚款支付单；支付服务复用同一渠道的待支付支付单，重放时返回原支付单
+func (resp *VehicleExitResponse) createPayments(record *model.ParkingRecord, violations []model.ViolationRecord) {
+	if record.PaymentStatus == 0 && record.FeeCalculated > 0 {
+		resp.PaymentURL, _, resp.paymentErr = PaymentService.CreatePayment(record.RecordID, model.PayableParking, "alipay")
+		if resp.paymentErr != nil {
+			log.Printf("生成停车记录 %d 支付链接失败: %v", record.RecordID, resp.paymentErr)
+		}
+	}
+	for _, v := range violations {
+		url, _, err := PaymentService.CreatePayment(v.ViolationID, model.PayableViolation, "alipay")
+		if err != nil {
+			log.Printf("生成违规记录 %d 支付链接失败: %v", v.ViolationID, err)
+			if resp.paymentErr == nil {
+				resp.paymentErr = err
+			}
+		}
+		resp.ViolationPayments = append(resp.ViolationPayments, ExitViolationPayment{ViolationID: v.ViolationID, FineAmount: v.FineAmount, PaymentURL: url})
+	}
 }
 
-// findActiveReservationForExit 查找离场时关联的预约（支持事务）
-// 严格按照用户要求：按车牌号、停车场、停车位类型、停车位序号，按离场时间最近的一次预订时间筛选
-func findActiveReservationForExit(db *gorm.DB, vehicleID uint, lotID uint, spaceType string, spaceNumber string, exitTime time.Time) (*model.ReservationOrder, error) {
-	var reservation model.ReservationOrder
-
-	// 检查是否需要 JOIN parking_space 表
-	needsJoin := spaceType != "" || spaceNumber != ""
-
-	// 构建查询条件：必须匹配车牌号、停车场
-	// 按离场时间最近的一次预订时间筛选（按预订开始时间降序排列，取第一条）
-	// 使用表前缀避免列名歧义（当有 JOIN 时）
-	query := db.Model(&model.ReservationOrder{})
-
-	// 如果需要进行 JOIN，先执行 JOIN
-	if needsJoin {
-		query = query.Joins("JOIN parking_space ON reservation_order.space_id = parking_space.space_id")
-		// 使用表前缀避免列名歧义
-		query = query.Where("reservation_order.vehicle_id = ?", vehicleID).
-			Where("reservation_order.lot_id = ?", lotID).
-			Where("reservation_order.status = ?", 2) // 2-使用中，只查找状态为"使用中"的预订
+// exitReplayWindow 出场后该时间内重复提交的出场请求返回原出场结果
+const exitReplayWindow = 15 * time.Minute
+
+// recentExit 按停车凭证号或车牌号查找出场时间不早于 at - exitReplayWindow 的停车记录（并发的重复请求中先提交者的出场时间可能晚于 at），重建出场响应
+// 停车费未支付时返回同一待支付支付单的链接；没有刚出场的记录时返回 nil, nil
+func recentExit(req VehicleExitRequest, lotID uint, at time.Time) (*VehicleExitResponse, error) {
+	query := inits.DB.
+		Where("record_status = ?", 2). // 2-已出场
+		Where("exit_time >= ?", at.Add(-exitReplayWindow))
+	if req.TicketCode != "" {
+		query = query.Where("ticket_code = ?", req.TicketCode)
 	} else {
-		// 没有 JOIN 时，不需要表前缀
-		query = query.Where("vehicle_id = ?", vehicleID).
-			Where("lot_id = ?", lotID).
-			Where("status = ?", 2) // 2-使用中，只查找状态为"使用中"的预订
-	}
-
-	// 如果提供了停车位类型，则同时匹配
-	if spaceType != "" {
-		query = query.Where("parking_space.space_type = ?", spaceType)
+		query = query.Where("license_plate = ?", req.LicensePlate)
 	}
-
-	// 如果提供了停车位序号，则同时匹配
-	if spaceNumber != "" {
-		query = query.Where("parking_space.space_number = ?", spaceNumber)
+	if lotID != 0 {
+		query = query.Where("lot_id = ?", lotID)
 	}
-
-	// 预加载关联数据
-	query = query.Preload("Space").
-		Preload("Lot").
-		Preload("Vehicle")
-
-	// 按预订开始时间降序排列，选择离场时间最近的一次预订（即最晚开始的预订）
-	err := query.
-		Order("reservation_order.start_time DESC").
-		First(&reservation).Error
-
+	var record model.ParkingRecord
+	err := query.Preload("Space").Preload("Lot").Order("exit_time DESC").First(&record).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, gorm.ErrRecordNotFound
+			return nil, nil
 		}
-		return nil, err
-	}
-
-	return &reservation, nil
-}
-
-// findActiveReservationWithTx 查找有效的预约（支持事务）
-// 查找与停车记录关联的预约，优先查找状态为使用中的，如果没有则查找已预订的
-// 改进：放宽时间匹配条件，允许在预订时间段前后一定范围内匹配，提高容错性
-// 注意：此函数保留用于兼容旧代码，新的离场逻辑应使用 findActiveReservationForExit
-func findActiveReservationWithTx(db *gorm.DB, vehicleID uint, entryTime time.Time) (*model.ReservationOrder, error) {
-	var reservation model.ReservationOrder
-
-	// 允许的时间偏差：前后1小时（容错处理，避免时间精度问题）
-	timeWindow := 1 * time.Hour
-	earliestTime := entryTime.Add(-timeWindow)
-	latestTime := entryTime.Add(timeWindow)
-
-	// 先尝试查找状态为使用中的预约
-	err := db.
-		Where("vehicle_id = ?", vehicleID).
-		Where("start_time <= ? AND end_time >= ?", latestTime, earliestTime).
-		Where("status = ?", 2).   // 2-使用中
-		Order("start_time DESC"). // 如果有多个，选择最晚开始的
-		First(&reservation).Error
-
-	if err == nil {
-		return &reservation, nil
+		return nil, newParkingError(http.StatusInternalServerError, "查询停车记录失败")
 	}
 
-	// 如果没找到使用中的，尝试查找已预订的预约（可能入场时状态更新失败）
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		err = db.
-			Where("vehicle_id = ?", vehicleID).
-			Where("start_time <= ? AND end_time >= ?", latestTime, earliestTime).
-			Where("status = ?", 1). // 1-已预订
-			Order("start_time DESC").
-			First(&reservation).Error
-
-		if err == nil {
-			return &reservation, nil
+	var quote *tariff.Breakdown
+	if record.FeeBreakdown != "" {
+		quote = &tariff.Breakdown{}
+		if err := json.Unmarshal([]byte(record.FeeBreakdown), quote); err != nil {
+			log.Printf("解析停车记录 %d 计费明细失败: %v", record.RecordID, err)
+			quote = nil
 		}
 	}
+	violations, violationFee := checkViolations(record.RecordID)
 
-	// 如果还是没找到，尝试更宽泛的匹配：扩大时间窗口到前后2小时
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		wideTimeWindow := 2 * time.Hour
-		wideEarliestTime := entryTime.Add(-wideTimeWindow)
-		wideLatestTime := entryTime.Add(wideTimeWindow)
-		
-		// 先查找使用中的
-		err = db.
-			Where("vehicle_id = ?", vehicleID).
-			Where("(start_time <= ? AND end_time >= ?) OR (start_time <= ? AND start_time >= ?)", 
-				wideLatestTime, wideEarliestTime, entryTime, wideEarliestTime).
-			Where("status = ?", 2). // 2-使用中
-			Order("start_time DESC").
-			First(&reservation).Error
-
-		if err == nil {
-			return &reservation, nil
-		}
-
-		// 再查找已预订的
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			err = db.
-				Where("vehicle_id = ?", vehicleID).
-				Where("(start_time <= ? AND end_time >= ?) OR (start_time <= ? AND start_time >= ?)", 
-					wideLatestTime, wideEarliestTime, entryTime, wideEarliestTime).
-				Where("status = ?", 1). // 1-已预订
-				Order("start_time DESC").
-				First(&reservation).Error
-
-			if err == nil {
-				return &reservation, nil
-			}
-		}
+	resp := &VehicleExitResponse{
+		RecordID:      record.RecordID,
+		TicketCode:    record.TicketCode,
+		LotID:         record.LotID,
+		SpaceID:       record.SpaceID,
+		SpaceNumber:   record.Space.SpaceNumber,
+		LotName:       record.Lot.Name,
+		EntryTime:     record.EntryTime,
+		ExitTime:      *record.ExitTime,
+		DurationHours: record.ExitTime.Sub(record.EntryTime).Hours(),
+		ParkingFee:    record.FeeCalculated,
+		TotalFee:      record.FeeCalculated + violationFee,
+		ReservationID: record.ReservationID,
+		FeeBreakdown:  quote,
+		IsViolation:   len(violations) > 0,
+		ViolationFee:  violationFee,
+		Replayed:      true,
+	}
+	if quote != nil {
+		resp.PrepaidFee = quote.Prepaid
 	}
 
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return nil, gorm.ErrRecordNotFound
+	// 停车费或罚款未支付时返回原待支付支付单
+	if PaymentService != nil {
+		resp.createPayments(&record, violations)
 	}
+	return resp, nil
+}
 
-	return nil, err
+// reservationPrepaid 预约已支付且未退款的金额（可从停车费中抵扣），未支付或已全额退款时为 0
+func reservationPrepaid(order *model.ReservationOrder) float64 {
+	if order == nil {
+		return 0
+	}
+	switch order.PaymentStatus {
+	case 1, 3: // 1-已支付，3-部分退款
+		if prepaid := order.PaidFee - order.RefundedFee; prepaid > 0 {
+			return prepaid
+		}
+	}
+	return 0
 }
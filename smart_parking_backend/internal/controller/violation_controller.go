@@ -1,14 +1,14 @@
 package controller
 
 import (
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
 	"smart_parking_backend/internal/inits"
 	"smart_parking_backend/internal/model"
 	"smart_parking_backend/internal/payment"
+	"smart_parking_backend/internal/violation"
 	"strconv"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,12 +17,16 @@ import (
 
 // ViolationCheckRequest 违规检查请求
 type ViolationCheckRequest struct {
-	CheckType int `json:"check_type" binding:"required"` // 检查类型 (1-预订未使用, 2-超时停车, 3-未支付停车费, 4-未支付罚款)
+	CheckType int    `json:"check_type"` // 检查类型 (1-预订未使用, 2-超时停车, 3-未支付停车费, 4-未支付罚款)
+	Rule      string `json:"rule"`       // 可选：只执行指定编码的规则（见 config/violation_rules.yaml）
+	DryRun    bool   `json:"dry_run"`    // 试运行：只返回将要开具的违规，不写库
 }
 
 // ViolationCheckResponse 违规检查响应
 type ViolationCheckResponse struct {
-	ViolationCount int `json:"violation_count"` // 发现的违规数量
+	ViolationCount int              `json:"violation_count"` // 发现的违规数量（试运行时为将要开具的数量）
+	DryRun         bool             `json:"dry_run"`
+	Items          []violation.Item `json:"items"`
 }
 
 // PaymentService 支付服务实例
@@ -33,6 +37,22 @@ func InitPaymentService(paymentSvc *payment.Service) {
 	PaymentService = paymentSvc
 }
 
+// ViolationEngine 违规规则引擎实例
+var ViolationEngine *violation.Engine
+
+// InitViolationEngine 初始化违规规则引擎
+func InitViolationEngine(engine *violation.Engine) {
+	ViolationEngine = engine
+}
+
+// checkTypeSources 检查类型对应的规则源记录
+var checkTypeSources = map[int]string{
+	1: violation.SourceReservationNotEntered,
+	2: violation.SourceReservationOverstay,
+	3: violation.SourceParkingUnpaid,
+	4: violation.SourceViolationUnpaid,
+}
+
 // CheckViolations 检查违规行为
 func CheckViolations(c *gin.Context) {
 	var req ViolationCheckRequest
@@ -41,270 +61,39 @@ func CheckViolations(c *gin.Context) {
 		return
 	}
 
-	var count int
-	var err error
-
-	switch req.CheckType {
-	case 1:
-		count, err = checkUnusedReservations()
-	case 2:
-		count, err = checkOvertimeParking()
-	case 3:
-		count, err = checkUnpaidParkingFees()
-	case 4:
-		count, err = checkUnpaidFines()
-	default:
+	source, ok := checkTypeSources[req.CheckType]
+	if !ok && (req.CheckType != 0 || req.Rule == "") {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的检查类型"})
 		return
 	}
+	if ViolationEngine == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "违规规则引擎未初始化"})
+		return
+	}
 
+	result, err := ViolationEngine.Run(source, req.Rule, req.DryRun)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "检查违规失败: " + err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, ViolationCheckResponse{ViolationCount: count})
+	c.JSON(http.StatusOK, ViolationCheckResponse{ViolationCount: result.Created, DryRun: result.DryRun, Items: result.Items})
 }
 
-// checkUnusedReservations 检查未使用的预订
-func checkUnusedReservations() (int, error) {
-	now := time.Now()
-	halfHourAgo := now.Add(-30 * time.Minute)
-
-	var reservations []model.ReservationOrder
-	err := inits.DB.
-		Where("status = ?", 1). // 1-已预订
-		Where("start_time <= ?", halfHourAgo).
-		Where("actual_end_time IS NULL"). // 未实际结束
-		Preload("Space").
-		Preload("Lot").
-		Find(&reservations).Error
-
-	if err != nil {
-		return 0, err
+// RunViolationCheck 按检查类型执行一次违规扫描，返回新开具的违规记录数（供定时任务调用）
+func RunViolationCheck(checkType int) (int, error) {
+	source, ok := checkTypeSources[checkType]
+	if !ok {
+		return 0, errors.New("无效的检查类型")
 	}
-
-	count := 0
-	for _, reservation := range reservations {
-		// 创建违规记录
-		// RecordID设为0（不关联停车记录），将相关信息记录到description中
-		description := fmt.Sprintf("预订车位后未在预订开始时间后30分钟内使用。预订订单ID: %d", reservation.OrderID)
-		violation := model.ViolationRecord{
-			RecordID:      0, // 不关联停车记录
-			UserID:        reservation.UserID,
-			VehicleID:     reservation.VehicleID,
-			ViolationType: "预订未使用",
-			ViolationTime: now,
-			Description:   description,
-			FineAmount:    reservation.Lot.HourlyRate, // 罚款为1小时停车费
-			Status:        0,                          // 0-未处理
-		}
-
-		if err := inits.DB.Create(&violation).Error; err != nil {
-			log.Printf("创建违规记录失败: %v", err)
-			continue
-		}
-
-		// 取消预约
-		actualEndTime := now
-		if err := inits.DB.Model(&reservation).
-			Updates(map[string]interface{}{
-				"status":          0, // 0-已取消
-				"actual_end_time": &actualEndTime,
-			}).Error; err != nil {
-			log.Printf("取消预约失败: %v", err)
-		}
-
-		// 释放车位
-		if err := inits.DB.Model(&model.ParkingSpace{}).
-			Where("space_id = ?", reservation.SpaceID).
-			Updates(map[string]interface{}{
-				"is_reserved": 0,
-				"last_update": now,
-			}).Error; err != nil {
-			log.Printf("释放车位失败: %v", err)
-		}
-
-		// 发送罚单 (简化实现)
-		sendViolationNotice(reservation.UserID, violation.ViolationID)
-
-		count++
+	if ViolationEngine == nil {
+		return 0, errors.New("违规规则引擎未初始化")
 	}
-
-	return count, nil
-}
-
-// checkOvertimeParking 检查超时停车
-func checkOvertimeParking() (int, error) {
-	now := time.Now()
-	halfHourAgo := now.Add(-30 * time.Minute)
-
-	var reservations []model.ReservationOrder
-	err := inits.DB.
-		Where("status = ?", 2). // 2-使用中
-		Where("end_time <= ?", halfHourAgo).
-		Where("actual_end_time IS NULL"). // 未实际结束
-		Preload("Space").
-		Preload("Lot").
-		Find(&reservations).Error
-
+	result, err := ViolationEngine.Run(source, "", false)
 	if err != nil {
 		return 0, err
 	}
-
-	count := 0
-	for _, reservation := range reservations {
-		// 创建违规记录
-		// RecordID设为0（不关联停车记录），将相关信息记录到description中
-		description := fmt.Sprintf("使用车位超出预订结束时间30分钟。预订订单ID: %d", reservation.OrderID)
-		violation := model.ViolationRecord{
-			RecordID:      0, // 不关联停车记录
-			UserID:        reservation.UserID,
-			VehicleID:     reservation.VehicleID,
-			ViolationType: "超时停车",
-			ViolationTime: now,
-			Description:   description,
-			FineAmount:    reservation.Lot.HourlyRate, // 罚款为1小时停车费
-			Status:        0,                          // 0-未处理
-		}
-
-		if err := inits.DB.Create(&violation).Error; err != nil {
-			log.Printf("创建违规记录失败: %v", err)
-			continue
-		}
-
-		// 取消预约
-		actualEndTime := now
-		if err := inits.DB.Model(&reservation).
-			Updates(map[string]interface{}{
-				"status":          0, // 0-已取消
-				"actual_end_time": &actualEndTime,
-			}).Error; err != nil {
-			log.Printf("取消预约失败: %v", err)
-		}
-
-		// 释放车位
-		if err := inits.DB.Model(&model.ParkingSpace{}).
-			Where("space_id = ?", reservation.SpaceID).
-			Updates(map[string]interface{}{
-				"is_reserved": 0,
-				"last_update": now,
-			}).Error; err != nil {
-			log.Printf("释放车位失败: %v", err)
-		}
-
-		// 发送罚单 (简化实现)
-		sendViolationNotice(reservation.UserID, violation.ViolationID)
-
-		count++
-	}
-
-	return count, nil
-}
-
-// checkUnpaidParkingFees 检查未支付的停车费
-func checkUnpaidParkingFees() (int, error) {
-	now := time.Now()
-	oneMonthAgo := now.AddDate(0, -1, 0)
-
-	var records []model.ParkingRecord
-	err := inits.DB.
-		Where("payment_status = ?", 0). // 0-未支付
-		Where("entry_time <= ?", oneMonthAgo).
-		Preload("Lot").
-		Find(&records).Error
-
-	if err != nil {
-		return 0, err
-	}
-
-	count := 0
-	for _, record := range records {
-		// 计算罚款金额 (剩余费用的两倍)
-		remainingFee := record.FeeCalculated - record.FeePaid
-		fineAmount := remainingFee * 2
-
-		// 创建违规记录
-		// RecordID设为0（不关联停车记录），将停车记录ID记录到description中
-		description := fmt.Sprintf("停车费产生一个月后仍未支付。停车记录ID: %d", record.RecordID)
-		violation := model.ViolationRecord{
-			RecordID:      0, // 不关联停车记录
-			UserID:        record.UserID,
-			VehicleID:     record.VehicleID,
-			ViolationType: "未支付停车费",
-			ViolationTime: now,
-			Description:   description,
-			FineAmount:    fineAmount,
-			Status:        0, // 0-未处理
-		}
-
-		if err := inits.DB.Create(&violation).Error; err != nil {
-			log.Printf("创建违规记录失败: %v", err)
-			continue
-		}
-
-		// 发送罚单 (简化实现)
-		sendViolationNotice(record.UserID, violation.ViolationID)
-
-		count++
-	}
-
-	return count, nil
-}
-
-// checkUnpaidFines 检查未支付的罚款
-func checkUnpaidFines() (int, error) {
-	now := time.Now()
-	twoWeeksAgo := now.AddDate(0, 0, -14)
-
-	var violations []model.ViolationRecord
-	err := inits.DB.
-		Where("status = ?", 0). // 0-未处理
-		Where("violation_time <= ?", twoWeeksAgo).
-		Find(&violations).Error
-
-	if err != nil {
-		return 0, err
-	}
-
-	count := 0
-	for _, violation := range violations {
-		// 创建新的违规记录
-		// RecordID设为0（不关联停车记录），将原违规记录ID记录到description中
-		description := fmt.Sprintf("罚款产生两周后仍未支付。原违规记录ID: %d", violation.ViolationID)
-		if violation.RecordID > 0 {
-			description += fmt.Sprintf("，关联停车记录ID: %d", violation.RecordID)
-		}
-		newViolation := model.ViolationRecord{
-			RecordID:      0, // 不关联停车记录
-			UserID:        violation.UserID,
-			VehicleID:     violation.VehicleID,
-			ViolationType: "未支付罚款",
-			ViolationTime: now,
-			Description:   description,
-			FineAmount:    violation.FineAmount * 2, // 剩余罚款的两倍
-			Status:        0,                        // 0-未处理
-		}
-
-		if err := inits.DB.Create(&newViolation).Error; err != nil {
-			log.Printf("创建违规记录失败: %v", err)
-			continue
-		}
-
-		// 发送罚单 (简化实现)
-		sendViolationNotice(violation.UserID, newViolation.ViolationID)
-
-		count++
-	}
-
-	return count, nil
-}
-
-// sendViolationNotice 发送违规通知 (简化实现)
-func sendViolationNotice(userID uint, violationID uint) {
-	// 在实际应用中，这里会调用通知服务发送短信、邮件或APP推送
-	// 这里简化为记录日志
-	log.Printf("发送罚单给用户 %d, 违规记录ID: %d", userID, violationID)
+	return result.Created, nil
 }
 
 // GetUserViolations 获取用户违规记录
@@ -337,13 +126,9 @@ func GetUserViolations(c *gin.Context) {
 	c.JSON(http.StatusOK, violations)
 }
 
-// 用户获取自己的违规记录历史
+// 用户获取自己的违规记录历史（用户ID取自 token）
 func GetUserViolationHistory(c *gin.Context) {
-	userID := c.Param("user_id")
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "用户ID不能为空"})
-		return
-	}
+	userID := c.GetUint("user_id")
 	// 默认显示所有违规记录，不再支持status参数过滤
 
 	var violations []model.ViolationRecord
@@ -365,7 +150,7 @@ func GetUserViolationHistory(c *gin.Context) {
 	})
 }
 
-// PayViolationFine 支付罚款
+// PayViolationFine 支付罚款（仅违规记录所属用户可支付）
 func PayViolationFine(c *gin.Context) {
 	vioIDStr := c.Param("violation_id")
 	if vioIDStr == "" {
@@ -391,30 +176,39 @@ func PayViolationFine(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "违规记录不存在"})
 		return
 	}
+	if violation.UserID != c.GetUint("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权支付其他用户的罚款"})
+		return
+	}
 
 	// 2. 检查是否已经处理/支付
 	if violation.Status == 1 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "该违规记录已处理，无需重复支付"})
 		return
 	}
+	if violation.Status == 2 {
+		c.JSON(http.StatusConflict, gin.H{"error": "罚款申诉中，审核完成前暂不能支付"})
+		return
+	}
+	if violation.Status == 3 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "该罚款已免除，无需支付"})
+		return
+	}
 
 	// 3. 准备支付金额 (确保金额有效)
-	amount := violation.FineAmount
-	if amount <= 0 {
+	if violation.FineAmount <= 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "罚款金额无效"})
 		return
 	}
 
 	// 4. 调用统一 payment service
-	// 注意：这里显式传递了 amount，防止底层服务因为 nil 而报错或取不到值
-	// 另外，paymentMethod 从前端传参获取可能更好，这里暂时保留 alipay 默认值
+	// 金额由支付服务按罚款金额确定；paymentMethod 从前端传参获取可能更好，这里暂时保留 alipay 默认值
 	paymentMethod := c.DefaultQuery("method", "alipay")
 
 	redirectURL, paymentID, err := PaymentService.CreatePayment(
 		uint(vioID),
 		"violation", // 确保类型字符串正确
 		paymentMethod,
-		&amount, // 传递明确的金额指针
 	)
 
 	if err != nil {
@@ -0,0 +1,75 @@
+package gate
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config 映射 config.yaml 中的 gate 配置段
+type Config struct {
+	Gate struct {
+		OfflineAfter     string `yaml:"offline_after"`     // 超过该时长未收到心跳视为离线，默认 90s
+		MaxClockSkew     string `yaml:"max_clock_skew"`    // HMAC 签名时间戳允许的最大偏差，默认 5m
+		SyncMaxBatch     int    `yaml:"sync_max_batch"`    // 单次补传的最大事件数，默认 500
+		WhitelistHorizon string `yaml:"whitelist_horizon"` // 白名单快照包含的预约时间范围（自生成时起），默认 24h
+	} `yaml:"gate"`
+
+	offlineAfter     time.Duration
+	maxClockSkew     time.Duration
+	syncMaxBatch     int
+	whitelistHorizon time.Duration
+}
+
+// LoadConfig 从 YAML 文件加载闸机配置（未配置 gate 段时使用默认值）
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.init(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (c *Config) init() error {
+	var err error
+	if c.offlineAfter, err = parseDuration(c.Gate.OfflineAfter, 90*time.Second); err != nil {
+		return fmt.Errorf("gate.offline_after 无效: %w", err)
+	}
+	if c.maxClockSkew, err = parseDuration(c.Gate.MaxClockSkew, 5*time.Minute); err != nil {
+		return fmt.Errorf("gate.max_clock_skew 无效: %w", err)
+	}
+	if c.whitelistHorizon, err = parseDuration(c.Gate.WhitelistHorizon, 24*time.Hour); err != nil {
+		return fmt.Errorf("gate.whitelist_horizon 无效: %w", err)
+	}
+	c.syncMaxBatch = c.Gate.SyncMaxBatch
+	if c.syncMaxBatch <= 0 {
+		c.syncMaxBatch = 500
+	}
+	return nil
+}
+
+// OfflineAfter 心跳超时时长
+func (c *Config) OfflineAfter() time.Duration { return c.offlineAfter }
+
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < time.Second {
+		return 0, fmt.Errorf("不能小于 1s")
+	}
+	return d, nil
+}
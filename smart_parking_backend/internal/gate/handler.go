@@ -0,0 +1,393 @@
+package gate
+
+import (
+	"errors"
+	"net/http"
+	"smart_parking_backend/internal/middleware"
+	"smart_parking_backend/internal/model"
+	"smart_parking_backend/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler 闸机设备 HTTP 处理
+type Handler struct {
+	svc *Service
+}
+
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// gateErrorStatus 闸机业务错误对应的 HTTP 状态码
+func gateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrGateNotFound), errors.Is(err, ErrLotNotFound), errors.Is(err, ErrEventNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrCodeExists), errors.Is(err, ErrEventNotPending):
+		return http.StatusConflict
+	case errors.Is(err, ErrBatchTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrInvalidCode), errors.Is(err, ErrInvalidDirection), errors.Is(err, ErrInvalidAuthType),
+		errors.Is(err, ErrInvalidStatus), errors.Is(err, ErrBatchEmpty), errors.Is(err, ErrInvalidResolve),
+		errors.Is(err, ErrResolveNotApplicable), errors.Is(err, ErrInvalidEntryTime), errors.Is(err, ErrInvalidEventStatus):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrUnauthorized), errors.Is(err, ErrSignatureExpired), errors.Is(err, ErrNonceReused):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrGateDisabled), errors.Is(err, ErrDirectionDenied):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func respondError(c *gin.Context, err error) {
+	status := gateErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		message = "操作失败"
+	}
+	c.JSON(status, gin.H{"code": status, "message": message})
+}
+
+// parseID 解析路径参数中的ID
+func parseID(c *gin.Context, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// managedGate 查询路径参数中的闸机，并校验是否在管理员的管理范围内（已写入响应时返回 false）
+func (h *Handler) managedGate(c *gin.Context) (*model.GateDevice, bool) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的闸机ID"})
+		return nil, false
+	}
+	g, err := h.svc.GetGate(id)
+	if err != nil {
+		respondError(c, err)
+		return nil, false
+	}
+	if !middleware.CanManageLot(c, g.LotID) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "无权限管理该停车场"})
+		return nil, false
+	}
+	return g, true
+}
+
+// ==================== 管理端 ====================
+
+// CreateGateRequest 登记闸机请求体
+type CreateGateRequest struct {
+	LotID     uint   `json:"lot_id" binding:"required"`
+	GateCode  string `json:"gate_code" binding:"required"`
+	Name      string `json:"name"`
+	Direction string `json:"direction"` // in / out / both，默认 both
+	AuthType  string `json:"auth_type"` // api_key / hmac，默认 hmac
+}
+
+// CreateGate 登记闸机，响应中的 secret 只返回一次
+// POST /admin/gates
+func (h *Handler) CreateGate(c *gin.Context) {
+	var req CreateGateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+	if !middleware.CanManageLot(c, req.LotID) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "无权限管理该停车场"})
+		return
+	}
+	cred, err := h.svc.CreateGate(CreateRequest{
+		LotID:     req.LotID,
+		GateCode:  req.GateCode,
+		Name:      req.Name,
+		Direction: req.Direction,
+		AuthType:  req.AuthType,
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "闸机登记成功，请妥善保存设备密钥", "data": cred})
+}
+
+// ListGates 查询闸机列表（停车场管理员只能看到自己管理的停车场）
+// GET /admin/gates?lot_id=1&online=1&page=1&page_size=20
+func (h *Handler) ListGates(c *gin.Context) {
+	var online *int8
+	if v := c.Query("online"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 8)
+		if err != nil || (n != 0 && n != 1) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "online 参数无效，可选 0 / 1"})
+			return
+		}
+		o := int8(n)
+		online = &o
+	}
+	lotID := uint(utils.ParseInt(c.Query("lot_id"), 0))
+	if lotID != 0 && !middleware.CanManageLot(c, lotID) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "无权限管理该停车场"})
+		return
+	}
+	var lotIDs []uint
+	if c.GetString("role") != middleware.RoleSystem {
+		lotIDs = append([]uint{}, middleware.ManagedLots(c)...)
+	}
+	page := utils.ParseInt(c.DefaultQuery("page", "1"), 1)
+	pageSize := utils.ParseInt(c.DefaultQuery("page_size", "20"), 20)
+
+	list, total, err := h.svc.ListGates(lotIDs, lotID, online, page, pageSize)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    gin.H{"total": total, "page": page, "page_size": pageSize, "records": list},
+	})
+}
+
+// GetGate 查询闸机详情
+// GET /admin/gates/:id
+func (h *Handler) GetGate(c *gin.Context) {
+	g, ok := h.managedGate(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": g})
+}
+
+// UpdateGateRequest 修改闸机请求体
+type UpdateGateRequest struct {
+	Name      *string `json:"name"`
+	Direction *string `json:"direction"`
+	Status    *int8   `json:"status"`
+}
+
+// UpdateGate 修改闸机名称、通行方向或启用状态
+// PUT /admin/gates/:id
+func (h *Handler) UpdateGate(c *gin.Context) {
+	g, ok := h.managedGate(c)
+	if !ok {
+		return
+	}
+	var req UpdateGateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+	updated, err := h.svc.UpdateGate(g.GateID, UpdateRequest{Name: req.Name, Direction: req.Direction, Status: req.Status})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "闸机已更新", "data": updated})
+}
+
+// RotateSecretRequest 轮换密钥请求体
+type RotateSecretRequest struct {
+	AuthType string `json:"auth_type"` // 为空时保持当前认证方式
+}
+
+// RotateSecret 重新生成设备密钥，旧密钥立即失效
+// POST /admin/gates/:id/secret
+func (h *Handler) RotateSecret(c *gin.Context) {
+	g, ok := h.managedGate(c)
+	if !ok {
+		return
+	}
+	var req RotateSecretRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+			return
+		}
+	}
+	cred, err := h.svc.RotateSecret(g.GateID, req.AuthType)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "设备密钥已重新生成，请更新闸机配置", "data": cred})
+}
+
+// ListEvents 查询闸机补传事件（待处理队列：status=conflict）
+// GET /admin/gates/events?status=conflict&lot_id=1&gate_id=2&page=1&page_size=20
+func (h *Handler) ListEvents(c *gin.Context) {
+	lotID := uint(utils.ParseInt(c.Query("lot_id"), 0))
+	if lotID != 0 && !middleware.CanManageLot(c, lotID) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "无权限管理该停车场"})
+		return
+	}
+	var lotIDs []uint
+	if c.GetString("role") != middleware.RoleSystem {
+		lotIDs = append([]uint{}, middleware.ManagedLots(c)...)
+	}
+	gateID := uint(utils.ParseInt(c.Query("gate_id"), 0))
+	page := utils.ParseInt(c.DefaultQuery("page", "1"), 1)
+	pageSize := utils.ParseInt(c.DefaultQuery("page_size", "20"), 20)
+
+	list, total, err := h.svc.ListEvents(lotIDs, lotID, gateID, c.Query("status"), page, pageSize)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    gin.H{"total": total, "page": page, "page_size": pageSize, "records": list},
+	})
+}
+
+// managedEvent 查询路径参数中的事件，并校验是否在管理员的管理范围内（已写入响应时返回 false）
+func (h *Handler) managedEvent(c *gin.Context) (*model.GateEvent, bool) {
+	id, err := strconv.ParseUint(c.Param("event_id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的事件ID"})
+		return nil, false
+	}
+	ev, err := h.svc.GetEvent(id)
+	if err != nil {
+		respondError(c, err)
+		return nil, false
+	}
+	if !middleware.CanManageLot(c, ev.LotID) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "无权限管理该停车场"})
+		return nil, false
+	}
+	return ev, true
+}
+
+// GetEvent 查询闸机事件详情
+// GET /admin/gates/events/:event_id
+func (h *Handler) GetEvent(c *gin.Context) {
+	ev, ok := h.managedEvent(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": ev})
+}
+
+// ResolveEventRequest 处理冲突事件请求体
+type ResolveEventRequest struct {
+	Action    string     `json:"action" binding:"required"` // retry / dismiss / close_previous / manual_entry
+	EntryTime *time.Time `json:"entry_time"`                // manual_entry 时必填，补录的入场时间
+	Remark    string     `json:"remark"`
+}
+
+// ResolveEvent 处理冲突或失败的补传事件
+// POST /admin/gates/events/:event_id/resolve
+func (h *Handler) ResolveEvent(c *gin.Context) {
+	ev, ok := h.managedEvent(c)
+	if !ok {
+		return
+	}
+	var req ResolveEventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+	ev, err := h.svc.ResolveEvent(ev.EventID, c.GetUint("admin_id"), ResolveRequest{
+		Action:    req.Action,
+		EntryTime: req.EntryTime,
+		Remark:    req.Remark,
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	message := "事件已处理"
+	switch ev.Status {
+	case EventConflict:
+		message = "处理后仍存在冲突：" + ev.Message
+	case EventFailed:
+		message = "处理失败，请稍后重试"
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": message, "data": ev})
+}
+
+// ==================== 设备端 ====================
+
+// HeartbeatRequest 心跳请求体
+type HeartbeatRequest struct {
+	Firmware string `json:"firmware"` // 固件版本（可选）
+}
+
+// Heartbeat 闸机心跳，需经过 DeviceAuth
+// POST /api/gate/heartbeat
+func (h *Handler) Heartbeat(c *gin.Context) {
+	var req HeartbeatRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+			return
+		}
+	}
+	g := CurrentGate(c)
+	at, err := h.svc.Heartbeat(g, c.ClientIP(), req.Firmware)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data": gin.H{
+			"gate_id":       g.GateID,
+			"lot_id":        g.LotID,
+			"direction":     g.Direction,
+			"server_time":   at,
+			"offline_after": h.svc.cfg.OfflineAfter().String(),
+		},
+	})
+}
+
+// SyncRequest 补传事件请求体
+type SyncRequest struct {
+	Events []SyncEvent `json:"events" binding:"required"`
+}
+
+// Sync 闸机恢复连接后补传离线期间的入场/出场事件，需经过 DeviceAuth
+// POST /api/gate/sync
+func (h *Handler) Sync(c *gin.Context) {
+	var req SyncRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+	results, err := h.svc.Sync(CurrentGate(c), req.Events)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	summary := map[string]int{}
+	for _, r := range results {
+		summary[r.Status]++
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    gin.H{"results": results, "summary": summary},
+	})
+}
+
+// Whitelist 下载闸机所属停车场的离线白名单，携带的 version 与当前版本一致时返回 304
+// GET /api/gate/whitelist?version=xxx
+func (h *Handler) Whitelist(c *gin.Context) {
+	wl, err := h.svc.Whitelist(CurrentGate(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if v := c.Query("version"); v != "" && v == wl.Version {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": wl})
+}
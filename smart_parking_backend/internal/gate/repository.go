@@ -0,0 +1,206 @@
+package gate
+
+import (
+	"context"
+	"fmt"
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// Redis 键
+// gate:nonce:{gate_id}:{nonce}    已使用的 HMAC 签名随机串，TTL 为签名时间戳允许的偏差窗口，防止请求重放
+const nonceKeyPrefix = "gate:nonce:"
+
+// Repository 闸机设备数据访问层
+type Repository struct{}
+
+// NewRepository 创建 Repository 实例
+func NewRepository() *Repository {
+	return &Repository{}
+}
+
+// ==================== 闸机（GateDevice）操作 ====================
+
+// CreateGate 登记闸机
+func (r *Repository) CreateGate(g *model.GateDevice) error {
+	return inits.DB.Create(g).Error
+}
+
+// GetGate 查询闸机
+func (r *Repository) GetGate(gateID uint) (*model.GateDevice, error) {
+	var g model.GateDevice
+	err := inits.DB.First(&g, gateID).Error
+	return &g, err
+}
+
+// GetGateByCode 按闸机编码查询闸机
+func (r *Repository) GetGateByCode(code string) (*model.GateDevice, error) {
+	var g model.GateDevice
+	err := inits.DB.Where("gate_code = ?", code).First(&g).Error
+	return &g, err
+}
+
+// CodeExists 判断闸机编码是否已被使用
+func (r *Repository) CodeExists(code string) (bool, error) {
+	var count int64
+	err := inits.DB.Model(&model.GateDevice{}).Where("gate_code = ?", code).Count(&count).Error
+	return count > 0, err
+}
+
+// FindGates 分页查询闸机（lotIDs 为 nil 时不限停车场，lotID 为 0、online 为 nil 时不过滤）
+func (r *Repository) FindGates(lotIDs []uint, lotID uint, online *int8, offset, limit int) ([]model.GateDevice, int64, error) {
+	var list []model.GateDevice
+	var total int64
+	query := inits.DB.Model(&model.GateDevice{})
+	if lotIDs != nil {
+		query = query.Where("lot_id IN ?", lotIDs)
+	}
+	if lotID != 0 {
+		query = query.Where("lot_id = ?", lotID)
+	}
+	if online != nil {
+		query = query.Where("online = ?", *online)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("lot_id, gate_id").Offset(offset).Limit(limit).Find(&list).Error
+	return list, total, err
+}
+
+// UpdateGate 更新闸机字段
+func (r *Repository) UpdateGate(gateID uint, updates map[string]interface{}) error {
+	return inits.DB.Model(&model.GateDevice{}).Where("gate_id = ?", gateID).Updates(updates).Error
+}
+
+// Heartbeat 记录心跳并标记在线
+func (r *Repository) Heartbeat(gateID uint, ip, firmware string, at time.Time) error {
+	updates := map[string]interface{}{"online": 1, "last_heartbeat": at, "last_ip": ip}
+	if firmware != "" {
+		updates["firmware"] = firmware
+	}
+	return r.UpdateGate(gateID, updates)
+}
+
+// MarkOffline 将最近心跳早于 before 的在线闸机标记为离线，返回处理数量
+func (r *Repository) MarkOffline(before time.Time) (int64, error) {
+	res := inits.DB.Model(&model.GateDevice{}).
+		Where("online = 1 AND (last_heartbeat IS NULL OR last_heartbeat < ?)", before).
+		Update("online", 0)
+	return res.RowsAffected, res.Error
+}
+
+// LotExists 判断停车场是否存在
+func (r *Repository) LotExists(lotID uint) (bool, error) {
+	var count int64
+	err := inits.DB.Model(&model.ParkingLot{}).Where("lot_id = ?", lotID).Count(&count).Error
+	return count > 0, err
+}
+
+// ==================== 闸机事件（GateEvent）操作 ====================
+
+// InsertEvent 登记补传事件，同一闸机的事件ID已存在时不插入并返回 false
+func (r *Repository) InsertEvent(ev *model.GateEvent) (bool, error) {
+	res := inits.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(ev)
+	return res.RowsAffected > 0, res.Error
+}
+
+// GetEvent 查询闸机事件
+func (r *Repository) GetEvent(eventID uint64) (*model.GateEvent, error) {
+	var ev model.GateEvent
+	err := inits.DB.First(&ev, eventID).Error
+	return &ev, err
+}
+
+// GetEventByUID 按闸机与事件ID查询事件
+func (r *Repository) GetEventByUID(gateID uint, eventUID string) (*model.GateEvent, error) {
+	var ev model.GateEvent
+	err := inits.DB.Where("gate_id = ? AND event_uid = ?", gateID, eventUID).First(&ev).Error
+	return &ev, err
+}
+
+// TransitEvent 条件更新事件状态（当前状态为 from 时改为 to），用于取得事件处理权，已被其他请求处理时返回 false
+func (r *Repository) TransitEvent(eventID uint64, from, to string) (bool, error) {
+	res := inits.DB.Model(&model.GateEvent{}).
+		Where("event_id = ? AND status = ?", eventID, from).
+		Update("status", to)
+	return res.RowsAffected > 0, res.Error
+}
+
+// UpdateEvent 更新事件字段
+func (r *Repository) UpdateEvent(eventID uint64, updates map[string]interface{}) error {
+	return inits.DB.Model(&model.GateEvent{}).Where("event_id = ?", eventID).Updates(updates).Error
+}
+
+// FindEvents 分页查询闸机事件（lotIDs 为 nil 时不限停车场，lotID、gateID 为 0、status 为空时不过滤），按事件发生时间倒序
+func (r *Repository) FindEvents(lotIDs []uint, lotID, gateID uint, status string, offset, limit int) ([]model.GateEvent, int64, error) {
+	var list []model.GateEvent
+	var total int64
+	query := inits.DB.Model(&model.GateEvent{})
+	if lotIDs != nil {
+		query = query.Where("lot_id IN ?", lotIDs)
+	}
+	if lotID != 0 {
+		query = query.Where("lot_id = ?", lotID)
+	}
+	if gateID != 0 {
+		query = query.Where("gate_id = ?", gateID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("occurred_at DESC, event_id DESC").Offset(offset).Limit(limit).Find(&list).Error
+	return list, total, err
+}
+
+// ==================== 离线白名单查询 ====================
+
+// ActiveVehicles 已登记且用户账号正常的车辆
+func (r *Repository) ActiveVehicles() ([]WhitelistVehicle, error) {
+	list := []WhitelistVehicle{}
+	err := inits.DB.Table("vehicle AS v").
+		Select("v.license_plate").
+		Joins("JOIN users_list u ON u.user_id = v.user_id").
+		Where("u.status = ?", 1).
+		Order("v.license_plate").
+		Scan(&list).Error
+	return list, err
+}
+
+// UpcomingReservations 停车场内 [from, until] 期间有效的已预订订单
+func (r *Repository) UpcomingReservations(lotID uint, from, until time.Time) ([]WhitelistReservation, error) {
+	list := []WhitelistReservation{}
+	err := inits.DB.Table("reservation_order AS r").
+		Select("r.order_id, v.license_plate, s.space_number, r.start_time, r.end_time").
+		Joins("JOIN vehicle v ON v.vehicle_id = r.vehicle_id").
+		Joins("JOIN parking_space s ON s.space_id = r.space_id").
+		Where("r.lot_id = ? AND r.status = ?", lotID, 1). // 1-已预订
+		Where("r.end_time >= ? AND r.start_time <= ?", from, until).
+		Order("r.start_time, r.order_id").
+		Scan(&list).Error
+	return list, err
+}
+
+// InsideVehicles 停车场内的在场车辆
+func (r *Repository) InsideVehicles(lotID uint) ([]WhitelistInside, error) {
+	list := []WhitelistInside{}
+	err := inits.DB.Model(&model.ParkingRecord{}).
+		Select("record_id, license_plate, ticket_code, entry_time").
+		Where("lot_id = ? AND record_status = ?", lotID, 1). // 1-在场
+		Order("record_id").
+		Scan(&list).Error
+	return list, err
+}
+
+// ==================== 签名随机串（Redis） ====================
+
+// UseNonce 登记签名随机串，已使用过时返回 false
+func (r *Repository) UseNonce(ctx context.Context, gateID uint, nonce string, ttl time.Duration) (bool, error) {
+	return inits.RedisClient.SetNX(ctx, fmt.Sprintf("%s%d:%s", nonceKeyPrefix, gateID, nonce), 1, ttl).Result()
+}
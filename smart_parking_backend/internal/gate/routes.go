@@ -0,0 +1,29 @@
+package gate
+
+import (
+	"smart_parking_backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GateRoutes 注册闸机管理、补传事件处理与设备端路由（设备入场/出场路由复用停车模块处理函数，在 router 中注册）
+func GateRoutes(r *gin.Engine, svc *Service) {
+	handler := NewHandler(svc)
+
+	gates := r.Group("/admin/gates")
+	gates.Use(middleware.AdminAuthMiddleware(), middleware.RequireRoles(middleware.RoleSystem, middleware.RoleLotAdmin))
+	{
+		gates.GET("/events", handler.ListEvents)                      // 查询补传事件（冲突待处理队列）
+		gates.GET("/events/:event_id", handler.GetEvent)              // 查询补传事件详情
+		gates.POST("/events/:event_id/resolve", handler.ResolveEvent) // 处理冲突事件
+		gates.GET("", handler.ListGates)                              // 查询闸机列表
+		gates.POST("", handler.CreateGate)                            // 登记闸机
+		gates.GET("/:id", handler.GetGate)                            // 查询闸机详情
+		gates.PUT("/:id", handler.UpdateGate)                         // 修改名称、通行方向、启用状态
+		gates.POST("/:id/secret", handler.RotateSecret)               // 重新生成设备密钥
+	}
+
+	r.POST("/api/gate/heartbeat", svc.DeviceAuth(""), handler.Heartbeat) // 闸机心跳
+	r.POST("/api/gate/sync", svc.DeviceAuth(""), handler.Sync)           // 补传离线事件
+	r.GET("/api/gate/whitelist", svc.DeviceAuth(""), handler.Whitelist)  // 下载离线白名单
+}
@@ -0,0 +1,368 @@
+package gate
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"smart_parking_backend/internal/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 闸机通行方向
+const (
+	DirectionIn   = "in"
+	DirectionOut  = "out"
+	DirectionBoth = "both"
+)
+
+// 闸机认证方式
+// api_key：请求头 X-Gate-Api-Key 携带密钥，服务端只保存 SHA-256 摘要
+// hmac：请求头携带时间戳、随机串与 HMAC-SHA256 签名，密钥不随请求传输
+const (
+	AuthAPIKey = "api_key"
+	AuthHMAC   = "hmac"
+)
+
+// 闸机状态
+const (
+	StatusDisabled int8 = 0
+	StatusEnabled  int8 = 1
+)
+
+// secretBytes 生成的 API Key / HMAC 密钥随机字节数
+const secretBytes = 32
+
+// 闸机相关错误
+var (
+	ErrGateNotFound     = errors.New("闸机不存在")
+	ErrLotNotFound      = errors.New("停车场不存在")
+	ErrInvalidCode      = errors.New("闸机编码无效，仅支持字母、数字、- 和 _，长度 1-50")
+	ErrCodeExists       = errors.New("闸机编码已存在")
+	ErrInvalidDirection = errors.New("通行方向无效，可选 in / out / both")
+	ErrInvalidAuthType  = errors.New("认证方式无效，可选 api_key / hmac")
+	ErrInvalidStatus    = errors.New("状态无效，可选 0（停用）/ 1（启用）")
+
+	ErrUnauthorized     = errors.New("闸机认证失败")
+	ErrSignatureExpired = errors.New("签名时间戳超出允许范围")
+	ErrNonceReused      = errors.New("签名随机串已使用")
+	ErrGateDisabled     = errors.New("闸机已停用")
+	ErrDirectionDenied  = errors.New("该闸机不允许此通行方向")
+)
+
+var gateCodePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,50}$`)
+
+// CreateRequest 登记闸机参数
+type CreateRequest struct {
+	LotID     uint
+	GateCode  string
+	Name      string
+	Direction string // 为空时默认 both
+	AuthType  string // 为空时默认 hmac
+}
+
+// UpdateRequest 修改闸机参数（nil 表示不修改）
+type UpdateRequest struct {
+	Name      *string
+	Direction *string
+	Status    *int8
+}
+
+// Credentials 登记或轮换密钥后返回给管理员的设备凭证，密钥只在此时返回一次
+type Credentials struct {
+	Gate     *model.GateDevice `json:"gate"`
+	AuthType string            `json:"auth_type"`
+	Secret   string            `json:"secret"`
+}
+
+// Service 闸机设备服务：登记闸机、设备认证、心跳与在线状态、离线事件补传与白名单
+type Service struct {
+	cfg      *Config
+	repo     *Repository
+	events   eventStore // 补传事件的登记与状态流转，默认由 repo 实现
+	replayer Replayer
+}
+
+// NewService 创建 Service 实例，replayer 负责按事件时间重放补传的入场/出场
+func NewService(cfg *Config, repo *Repository, replayer Replayer) *Service {
+	return &Service{cfg: cfg, repo: repo, events: repo, replayer: replayer}
+}
+
+// ==================== 闸机登记与管理 ====================
+
+// CreateGate 登记闸机并生成设备密钥
+func (s *Service) CreateGate(req CreateRequest) (*Credentials, error) {
+	if !gateCodePattern.MatchString(req.GateCode) {
+		return nil, ErrInvalidCode
+	}
+	if req.Direction == "" {
+		req.Direction = DirectionBoth
+	}
+	if !validDirection(req.Direction) {
+		return nil, ErrInvalidDirection
+	}
+	if req.AuthType == "" {
+		req.AuthType = AuthHMAC
+	}
+	if req.AuthType != AuthAPIKey && req.AuthType != AuthHMAC {
+		return nil, ErrInvalidAuthType
+	}
+	if req.Name == "" {
+		req.Name = req.GateCode
+	}
+
+	ok, err := s.repo.LotExists(req.LotID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrLotNotFound
+	}
+	exists, err := s.repo.CodeExists(req.GateCode)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrCodeExists
+	}
+
+	g := &model.GateDevice{
+		GateCode:  req.GateCode,
+		Name:      req.Name,
+		LotID:     req.LotID,
+		Direction: req.Direction,
+		AuthType:  req.AuthType,
+		Status:    StatusEnabled,
+	}
+	secret, err := setSecret(g)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateGate(g); err != nil {
+		return nil, err
+	}
+	return &Credentials{Gate: g, AuthType: g.AuthType, Secret: secret}, nil
+}
+
+// ListGates 分页查询闸机（lotIDs 为 nil 时不限停车场）
+func (s *Service) ListGates(lotIDs []uint, lotID uint, online *int8, page, pageSize int) ([]model.GateDevice, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	return s.repo.FindGates(lotIDs, lotID, online, (page-1)*pageSize, pageSize)
+}
+
+// GetGate 查询闸机
+func (s *Service) GetGate(gateID uint) (*model.GateDevice, error) {
+	g, err := s.repo.GetGate(gateID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrGateNotFound
+	}
+	return g, err
+}
+
+// UpdateGate 修改闸机名称、通行方向或启用状态
+func (s *Service) UpdateGate(gateID uint, req UpdateRequest) (*model.GateDevice, error) {
+	if _, err := s.GetGate(gateID); err != nil {
+		return nil, err
+	}
+	updates := map[string]interface{}{}
+	if req.Name != nil && *req.Name != "" {
+		updates["name"] = *req.Name
+	}
+	if req.Direction != nil {
+		if !validDirection(*req.Direction) {
+			return nil, ErrInvalidDirection
+		}
+		updates["direction"] = *req.Direction
+	}
+	if req.Status != nil {
+		if *req.Status != StatusDisabled && *req.Status != StatusEnabled {
+			return nil, ErrInvalidStatus
+		}
+		updates["status"] = *req.Status
+		if *req.Status == StatusDisabled {
+			updates["online"] = 0
+		}
+	}
+	if len(updates) > 0 {
+		if err := s.repo.UpdateGate(gateID, updates); err != nil {
+			return nil, err
+		}
+	}
+	return s.GetGate(gateID)
+}
+
+// RotateSecret 重新生成设备密钥（旧密钥立即失效），可同时切换认证方式
+func (s *Service) RotateSecret(gateID uint, authType string) (*Credentials, error) {
+	g, err := s.GetGate(gateID)
+	if err != nil {
+		return nil, err
+	}
+	if authType != "" {
+		if authType != AuthAPIKey && authType != AuthHMAC {
+			return nil, ErrInvalidAuthType
+		}
+		g.AuthType = authType
+	}
+	secret, err := setSecret(g)
+	if err != nil {
+		return nil, err
+	}
+	err = s.repo.UpdateGate(gateID, map[string]interface{}{
+		"auth_type":   g.AuthType,
+		"secret_hash": g.SecretHash,
+		"hmac_secret": g.HMACSecret,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{Gate: g, AuthType: g.AuthType, Secret: secret}, nil
+}
+
+// ==================== 设备认证 ====================
+
+// SignedRequest 参与设备认证的请求信息
+type SignedRequest struct {
+	GateCode  string
+	APIKey    string
+	Timestamp string
+	Nonce     string
+	Signature string
+	Method    string
+	Path      string
+	Body      []byte
+}
+
+// Authenticate 校验设备凭证，返回请求方闸机
+func (s *Service) Authenticate(ctx context.Context, req SignedRequest) (*model.GateDevice, error) {
+	if req.GateCode == "" {
+		return nil, ErrUnauthorized
+	}
+	g, err := s.repo.GetGateByCode(req.GateCode)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnauthorized
+		}
+		return nil, err
+	}
+
+	switch g.AuthType {
+	case AuthAPIKey:
+		if req.APIKey == "" || !equalHex(hashSecret(req.APIKey), g.SecretHash) {
+			return nil, ErrUnauthorized
+		}
+	case AuthHMAC:
+		if err := s.verifySignature(ctx, g, req); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnauthorized
+	}
+
+	if g.Status != StatusEnabled {
+		return nil, ErrGateDisabled
+	}
+	return g, nil
+}
+
+// verifySignature 校验 HMAC 签名：
+// signature = hex(HMAC-SHA256(secret, timestamp + "\n" + nonce + "\n" + METHOD + "\n" + path + "\n" + hex(SHA256(body))))
+// 时间戳为 unix 秒，与服务器时间的偏差不得超过 max_clock_skew；同一随机串在偏差窗口内只能使用一次
+func (s *Service) verifySignature(ctx context.Context, g *model.GateDevice, req SignedRequest) error {
+	if req.Timestamp == "" || req.Nonce == "" || req.Signature == "" || g.HMACSecret == "" {
+		return ErrUnauthorized
+	}
+	ts, err := strconv.ParseInt(req.Timestamp, 10, 64)
+	if err != nil {
+		return ErrUnauthorized
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > s.cfg.maxClockSkew {
+		return ErrSignatureExpired
+	}
+
+	bodyHash := sha256.Sum256(req.Body)
+	payload := strings.Join([]string{req.Timestamp, req.Nonce, strings.ToUpper(req.Method), req.Path, hex.EncodeToString(bodyHash[:])}, "\n")
+	mac := hmac.New(sha256.New, []byte(g.HMACSecret))
+	mac.Write([]byte(payload))
+	if !equalHex(hex.EncodeToString(mac.Sum(nil)), strings.ToLower(req.Signature)) {
+		return ErrUnauthorized
+	}
+
+	// 签名校验通过后再登记随机串，避免伪造请求占用合法设备的随机串
+	fresh, err := s.repo.UseNonce(ctx, g.GateID, req.Nonce, 2*s.cfg.maxClockSkew)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrNonceReused
+	}
+	return nil
+}
+
+// AllowDirection 闸机是否允许该通行方向
+func AllowDirection(g *model.GateDevice, direction string) bool {
+	return g.Direction == DirectionBoth || g.Direction == direction
+}
+
+// ==================== 心跳与在线状态 ====================
+
+// Heartbeat 记录设备心跳
+func (s *Service) Heartbeat(g *model.GateDevice, ip, firmware string) (time.Time, error) {
+	now := time.Now()
+	return now, s.repo.Heartbeat(g.GateID, ip, firmware, now)
+}
+
+// ScanOffline 将超过 offline_after 未收到心跳的闸机标记为离线（定时任务调用）
+func (s *Service) ScanOffline(ctx context.Context) (int, error) {
+	n, err := s.repo.MarkOffline(time.Now().Add(-s.cfg.offlineAfter))
+	return int(n), err
+}
+
+// ==================== 工具函数 ====================
+
+func validDirection(d string) bool {
+	return d == DirectionIn || d == DirectionOut || d == DirectionBoth
+}
+
+// setSecret 按认证方式生成新密钥并写入闸机：api_key 只保存摘要，hmac 保存密钥原文（签名校验需要）
+func setSecret(g *model.GateDevice) (string, error) {
+	b := make([]byte, secretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	g.SecretHash, g.HMACSecret = "", ""
+	if g.AuthType == AuthAPIKey {
+		g.SecretHash = hashSecret(secret)
+	} else {
+		g.HMACSecret = secret
+	}
+	return secret, nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// equalHex 常量时间比较，避免通过响应时间猜测密钥
+func equalHex(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
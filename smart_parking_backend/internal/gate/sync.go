@@ -0,0 +1,464 @@
+package gate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"smart_parking_backend/internal/model"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ==================== 离线事件补传 ====================
+// 闸机离线期间自行放行并缓存入场/出场事件（带闸机生成的事件ID与本地时间），恢复后批量补传：
+// 1. 按事件发生时间顺序重放，入场时间、出场时间与停车费均以事件发生时间为准
+// 2. 按闸机 + 事件ID去重，重复补传的事件直接返回首次处理结果；服务端错误导致失败的事件重传时重新处理
+// 3. 与当前停车数据冲突的事件（重复入场、无入场记录的出场等）进入待处理队列，由管理员处理
+
+// 事件处理状态
+const (
+	EventPending   = "pending"   // 处理中
+	EventApplied   = "applied"   // 已生效
+	EventConflict  = "conflict"  // 冲突，待人工处理
+	EventFailed    = "failed"    // 服务端错误，闸机重传同一事件ID时重新处理
+	EventResolved  = "resolved"  // 冲突经人工处理后生效
+	EventDismissed = "dismissed" // 人工确认忽略
+)
+
+// 补传结果中的附加状态（不落库）
+const (
+	SyncDuplicate = "duplicate" // 事件ID已处理过
+	SyncInvalid   = "invalid"   // 事件参数无效，未处理
+)
+
+// 冲突类型
+const (
+	ConflictDoubleEntry      = "double_entry"       // 车辆已在场又收到入场事件
+	ConflictExitWithoutEntry = "exit_without_entry" // 没有在场记录的出场事件
+	ConflictExitBeforeEntry  = "exit_before_entry"  // 出场时间早于在场记录的入场时间
+	ConflictWrongLot         = "wrong_lot"          // 在场记录属于其他停车场
+	ConflictRejected         = "rejected"           // 被入场/出场规则拒绝（停车场已满、已关闭等）
+)
+
+// 人工处理方式
+const (
+	ResolveRetry         = "retry"          // 重新处理（如缺失的入场事件已补传）
+	ResolveDismiss       = "dismiss"        // 忽略该事件
+	ResolveClosePrevious = "close_previous" // 重复入场：以本事件时间结束原在场记录，再办理本次入场
+	ResolveManualEntry   = "manual_entry"   // 无入场记录的出场：按管理员确认的入场时间补录入场，再办理出场
+)
+
+// 离线事件相关错误
+var (
+	ErrBatchEmpty           = errors.New("没有需要补传的事件")
+	ErrBatchTooLarge        = errors.New("单次补传的事件数量超过上限")
+	ErrEventNotFound        = errors.New("闸机事件不存在")
+	ErrEventNotPending      = errors.New("只有冲突或处理失败的事件可以处理")
+	ErrInvalidResolve       = errors.New("处理方式无效，可选 retry / dismiss / close_previous / manual_entry")
+	ErrResolveNotApplicable = errors.New("该处理方式不适用于此冲突类型")
+	ErrInvalidEntryTime     = errors.New("补录的入场时间必须早于出场事件时间")
+	ErrInvalidEventStatus   = errors.New("事件状态无效，可选 pending / applied / conflict / failed / resolved / dismissed")
+)
+
+// ConflictError 事件与当前停车数据冲突，需人工处理
+type ConflictError struct {
+	Type    string
+	Message string
+}
+
+func (e *ConflictError) Error() string { return e.Message }
+
+// ReplaySource 重放来源，供停车模块写入通行日志
+type ReplaySource struct {
+	GateID   uint
+	GateCode string
+	EventID  uint64
+	Manual   bool // 管理员处理冲突时的重放
+}
+
+// Replayer 按事件发生时间办理入场/出场，由停车模块实现（controller.GateReplayer）
+// 与停车数据冲突时返回 *ConflictError，其它错误视为服务端错误
+type Replayer interface {
+	ReplayEntry(src ReplaySource, lotID uint, licensePlate, spaceType string, at time.Time) (recordID uint, err error)
+	// ReplayExit lotID 为 0 时不限停车场，优先按停车凭证号查找在场记录
+	ReplayExit(src ReplaySource, lotID uint, licensePlate, ticketCode string, at time.Time) (recordID uint, err error)
+}
+
+// eventStore 补传事件的登记与状态流转（Repository 实现），事件去重与处理权的争用都依赖这几个操作
+type eventStore interface {
+	InsertEvent(ev *model.GateEvent) (bool, error)
+	GetEventByUID(gateID uint, eventUID string) (*model.GateEvent, error)
+	TransitEvent(eventID uint64, from, to string) (bool, error)
+	UpdateEvent(eventID uint64, updates map[string]interface{}) error
+}
+
+// SyncEvent 闸机补传的事件
+type SyncEvent struct {
+	EventID      string    `json:"event_id"`      // 闸机生成的事件ID，同一闸机内唯一
+	Direction    string    `json:"direction"`     // in / out
+	LicensePlate string    `json:"license_plate"` // 车牌号（入场必填）
+	TicketCode   string    `json:"ticket_code"`   // 停车凭证号（出场可选，优先于车牌号）
+	SpaceType    string    `json:"space_type"`    // 车位类型（入场可选）
+	OccurredAt   time.Time `json:"occurred_at"`   // 事件发生时间（RFC3339，带时区）
+}
+
+// SyncResult 单个事件的处理结果
+type SyncResult struct {
+	EventID        string `json:"event_id"`
+	Status         string `json:"status"`                    // applied / conflict / failed / duplicate / invalid
+	PreviousStatus string `json:"previous_status,omitempty"` // duplicate 时为首次处理的当前状态
+	ConflictType   string `json:"conflict_type,omitempty"`
+	Message        string `json:"message,omitempty"`
+	RecordID       *uint  `json:"record_id,omitempty"`
+}
+
+// Sync 处理闸机补传的一批事件，按事件发生时间顺序重放，结果与请求中的事件一一对应
+func (s *Service) Sync(g *model.GateDevice, events []SyncEvent) ([]SyncResult, error) {
+	if len(events) == 0 {
+		return nil, ErrBatchEmpty
+	}
+	if len(events) > s.cfg.syncMaxBatch {
+		return nil, ErrBatchTooLarge
+	}
+
+	order := make([]int, len(events))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return events[order[a]].OccurredAt.Before(events[order[b]].OccurredAt)
+	})
+
+	now := time.Now()
+	results := make([]SyncResult, len(events))
+	seen := make(map[string]bool, len(events))
+	for _, i := range order {
+		ev := events[i]
+		results[i].EventID = ev.EventID
+		if msg := s.validateEvent(g, ev, now); msg != "" {
+			results[i].Status, results[i].Message = SyncInvalid, msg
+			continue
+		}
+		if seen[ev.EventID] {
+			results[i].Status, results[i].Message = SyncDuplicate, "同一批次中的重复事件"
+			continue
+		}
+		seen[ev.EventID] = true
+
+		row, fresh, err := s.claimEvent(g, ev, now)
+		if err != nil {
+			return nil, err
+		}
+		if !fresh {
+			results[i] = SyncResult{
+				EventID:        ev.EventID,
+				Status:         SyncDuplicate,
+				PreviousStatus: row.Status,
+				ConflictType:   row.ConflictType,
+				RecordID:       row.RecordID,
+			}
+			continue
+		}
+
+		recordID, replayErr := s.replay(ReplaySource{GateID: g.GateID, GateCode: g.GateCode, EventID: row.EventID}, row)
+		if err := s.finish(row, recordID, replayErr, EventApplied, nil); err != nil {
+			return nil, err
+		}
+		results[i] = SyncResult{
+			EventID:      ev.EventID,
+			Status:       row.Status,
+			ConflictType: row.ConflictType,
+			Message:      row.Message,
+			RecordID:     row.RecordID,
+		}
+	}
+	return results, nil
+}
+
+// validateEvent 校验事件参数，返回不为空时事件无效
+func (s *Service) validateEvent(g *model.GateDevice, ev SyncEvent, now time.Time) string {
+	switch {
+	case ev.EventID == "" || len(ev.EventID) > 64:
+		return "event_id 不能为空且不超过 64 个字符"
+	case ev.Direction != DirectionIn && ev.Direction != DirectionOut:
+		return "direction 无效，可选 in / out"
+	case !AllowDirection(g, ev.Direction):
+		return ErrDirectionDenied.Error()
+	case ev.Direction == DirectionIn && ev.LicensePlate == "":
+		return "入场事件缺少车牌号"
+	case ev.Direction == DirectionOut && ev.LicensePlate == "" && ev.TicketCode == "":
+		return "出场事件缺少车牌号或停车凭证号"
+	case len(ev.LicensePlate) > 20 || len(ev.TicketCode) > 40:
+		return "车牌号或停车凭证号过长"
+	case ev.OccurredAt.IsZero():
+		return "occurred_at 不能为空"
+	case ev.OccurredAt.After(now.Add(s.cfg.maxClockSkew)):
+		return "occurred_at 晚于服务器当前时间"
+	}
+	return ""
+}
+
+// claimEvent 登记事件并取得处理权：新事件或此前处理失败的事件返回 fresh=true，其它已登记的事件返回已有记录
+func (s *Service) claimEvent(g *model.GateDevice, ev SyncEvent, now time.Time) (*model.GateEvent, bool, error) {
+	row := &model.GateEvent{
+		GateID:       g.GateID,
+		EventUID:     ev.EventID,
+		LotID:        g.LotID,
+		Direction:    ev.Direction,
+		LicensePlate: ev.LicensePlate,
+		TicketCode:   ev.TicketCode,
+		SpaceType:    ev.SpaceType,
+		OccurredAt:   ev.OccurredAt,
+		ReceivedAt:   now,
+		Status:       EventPending,
+	}
+	created, err := s.events.InsertEvent(row)
+	if err != nil || created {
+		return row, created, err
+	}
+
+	existing, err := s.events.GetEventByUID(g.GateID, ev.EventID)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing.Status != EventFailed {
+		return existing, false, nil
+	}
+	claimed, err := s.events.TransitEvent(existing.EventID, EventFailed, EventPending)
+	if err != nil {
+		return nil, false, err
+	}
+	if claimed {
+		existing.Status = EventPending
+	}
+	return existing, claimed, nil
+}
+
+// replay 按事件方向重放入场或出场
+func (s *Service) replay(src ReplaySource, ev *model.GateEvent) (uint, error) {
+	if ev.Direction == DirectionIn {
+		return s.replayer.ReplayEntry(src, ev.LotID, ev.LicensePlate, ev.SpaceType, ev.OccurredAt)
+	}
+	return s.replayer.ReplayExit(src, ev.LotID, ev.LicensePlate, ev.TicketCode, ev.OccurredAt)
+}
+
+// finish 记录事件处理结果：成功时置为 okStatus，冲突进入待处理队列，其它错误记为失败
+func (s *Service) finish(ev *model.GateEvent, recordID uint, err error, okStatus string, extra map[string]interface{}) error {
+	now := time.Now()
+	ev.ProcessedAt = &now
+	ev.ConflictType, ev.Message = "", ""
+	var conflict *ConflictError
+	switch {
+	case err == nil:
+		ev.Status = okStatus
+		if recordID != 0 {
+			ev.RecordID = &recordID
+		}
+	case errors.As(err, &conflict):
+		ev.Status, ev.ConflictType, ev.Message = EventConflict, conflict.Type, truncate(conflict.Message, 255)
+	default:
+		ev.Status, ev.Message = EventFailed, truncate(err.Error(), 255)
+	}
+
+	updates := map[string]interface{}{
+		"status":        ev.Status,
+		"conflict_type": ev.ConflictType,
+		"message":       ev.Message,
+		"record_id":     ev.RecordID,
+		"processed_at":  now,
+	}
+	for k, v := range extra {
+		updates[k] = v
+	}
+	return s.events.UpdateEvent(ev.EventID, updates)
+}
+
+// ==================== 冲突处理（管理端） ====================
+
+// ListEvents 分页查询闸机事件（lotIDs 为 nil 时不限停车场）
+func (s *Service) ListEvents(lotIDs []uint, lotID, gateID uint, status string, page, pageSize int) ([]model.GateEvent, int64, error) {
+	if status != "" && !validEventStatus(status) {
+		return nil, 0, ErrInvalidEventStatus
+	}
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	return s.repo.FindEvents(lotIDs, lotID, gateID, status, (page-1)*pageSize, pageSize)
+}
+
+// GetEvent 查询闸机事件
+func (s *Service) GetEvent(eventID uint64) (*model.GateEvent, error) {
+	ev, err := s.repo.GetEvent(eventID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrEventNotFound
+	}
+	return ev, err
+}
+
+// ResolveRequest 人工处理事件参数
+type ResolveRequest struct {
+	Action    string
+	EntryTime *time.Time // manual_entry 时必填
+	Remark    string
+}
+
+// ResolveEvent 处理冲突或失败的事件，处理后仍冲突时事件保持在待处理队列（冲突原因更新为最新结果）
+func (s *Service) ResolveEvent(eventID uint64, adminID uint, req ResolveRequest) (*model.GateEvent, error) {
+	ev, err := s.GetEvent(eventID)
+	if err != nil {
+		return nil, err
+	}
+	if ev.Status != EventConflict && ev.Status != EventFailed {
+		return nil, ErrEventNotPending
+	}
+	switch req.Action {
+	case ResolveRetry, ResolveDismiss:
+	case ResolveClosePrevious:
+		if ev.ConflictType != ConflictDoubleEntry {
+			return nil, ErrResolveNotApplicable
+		}
+	case ResolveManualEntry:
+		if ev.ConflictType != ConflictExitWithoutEntry {
+			return nil, ErrResolveNotApplicable
+		}
+		if req.EntryTime == nil || !req.EntryTime.Before(ev.OccurredAt) {
+			return nil, ErrInvalidEntryTime
+		}
+	default:
+		return nil, ErrInvalidResolve
+	}
+
+	claimed, err := s.events.TransitEvent(ev.EventID, ev.Status, EventPending)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrEventNotPending
+	}
+
+	now := time.Now()
+	ev.ResolveAction, ev.ResolverID, ev.ResolveRemark, ev.ResolveTime = req.Action, &adminID, truncate(req.Remark, 255), &now
+	extra := map[string]interface{}{
+		"resolve_action": ev.ResolveAction,
+		"resolver_id":    adminID,
+		"resolve_remark": ev.ResolveRemark,
+		"resolve_time":   now,
+	}
+
+	// 闸机编码仅用于写入通行日志，查询失败不影响处理
+	src := ReplaySource{GateID: ev.GateID, EventID: ev.EventID, Manual: true}
+	if g, err := s.repo.GetGate(ev.GateID); err == nil {
+		src.GateCode = g.GateCode
+	}
+
+	var recordID uint
+	var replayErr error
+	okStatus := EventResolved
+	switch req.Action {
+	case ResolveDismiss:
+		okStatus = EventDismissed
+	case ResolveRetry:
+		recordID, replayErr = s.replay(src, ev)
+	case ResolveClosePrevious:
+		if _, replayErr = s.replayer.ReplayExit(src, 0, ev.LicensePlate, "", ev.OccurredAt); replayErr == nil {
+			recordID, replayErr = s.replayer.ReplayEntry(src, ev.LotID, ev.LicensePlate, ev.SpaceType, ev.OccurredAt)
+		}
+	case ResolveManualEntry:
+		if _, replayErr = s.replayer.ReplayEntry(src, ev.LotID, ev.LicensePlate, "", *req.EntryTime); replayErr == nil {
+			recordID, replayErr = s.replayer.ReplayExit(src, ev.LotID, ev.LicensePlate, "", ev.OccurredAt)
+		}
+	}
+	if err := s.finish(ev, recordID, replayErr, okStatus, extra); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// ==================== 离线白名单 ====================
+
+// WhitelistVehicle 已登记且账号正常的车辆
+type WhitelistVehicle struct {
+	LicensePlate string `json:"license_plate"`
+}
+
+// WhitelistReservation 快照有效期内的预约（闸机离线时可按预约放行）
+type WhitelistReservation struct {
+	OrderID      uint      `json:"order_id"`
+	LicensePlate string    `json:"license_plate"`
+	SpaceNumber  string    `json:"space_number"`
+	StartTime    time.Time `json:"start_time"`
+	EndTime      time.Time `json:"end_time"`
+}
+
+// WhitelistInside 当前在场车辆（出口闸机离线时核对凭证）
+type WhitelistInside struct {
+	RecordID     uint      `json:"record_id"`
+	LicensePlate string    `json:"license_plate"`
+	TicketCode   string    `json:"ticket_code"`
+	EntryTime    time.Time `json:"entry_time"`
+}
+
+// Whitelist 闸机所属停车场的离线白名单快照
+type Whitelist struct {
+	LotID        uint                   `json:"lot_id"`
+	Version      string                 `json:"version"` // 内容摘要，内容不变时版本不变
+	GeneratedAt  time.Time              `json:"generated_at"`
+	ValidUntil   time.Time              `json:"valid_until"` // 快照只包含该时间之前开始的预约，闸机应在此之前重新下载
+	Vehicles     []WhitelistVehicle     `json:"vehicles"`
+	Reservations []WhitelistReservation `json:"reservations"`
+	Inside       []WhitelistInside      `json:"inside"`
+}
+
+// Whitelist 生成闸机所属停车场的白名单快照
+func (s *Service) Whitelist(g *model.GateDevice) (*Whitelist, error) {
+	now := time.Now()
+	wl := &Whitelist{
+		LotID:       g.LotID,
+		GeneratedAt: now,
+		ValidUntil:  now.Add(s.cfg.whitelistHorizon),
+	}
+	var err error
+	if wl.Vehicles, err = s.repo.ActiveVehicles(); err != nil {
+		return nil, err
+	}
+	if wl.Reservations, err = s.repo.UpcomingReservations(g.LotID, now, wl.ValidUntil); err != nil {
+		return nil, err
+	}
+	if wl.Inside, err = s.repo.InsideVehicles(g.LotID); err != nil {
+		return nil, err
+	}
+
+	// 版本只由名单内容决定，闸机可携带版本号跳过未变化的下载
+	content, err := json.Marshal([]interface{}{wl.LotID, wl.Vehicles, wl.Reservations, wl.Inside})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	wl.Version = hex.EncodeToString(sum[:8])
+	return wl, nil
+}
+
+// ==================== 工具函数 ====================
+
+func validEventStatus(status string) bool {
+	switch status {
+	case EventPending, EventApplied, EventConflict, EventFailed, EventResolved, EventDismissed:
+		return true
+	}
+	return false
+}
+
+// truncate 按字符截断，避免超出字段长度
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
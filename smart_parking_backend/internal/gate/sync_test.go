@@ -0,0 +1,235 @@
+package gate
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"smart_parking_backend/internal/model"
+)
+
+// memoryEvents 内存中的事件存储，按闸机 + 事件ID唯一
+type memoryEvents struct {
+	nextID uint64
+	rows   map[string]*model.GateEvent
+}
+
+func newMemoryEvents() *memoryEvents {
+	return &memoryEvents{rows: make(map[string]*model.GateEvent)}
+}
+
+func (m *memoryEvents) key(gateID uint, uid string) string {
+	return fmt.Sprintf("%d:%s", gateID, uid)
+}
+
+func (m *memoryEvents) byID(eventID uint64) *model.GateEvent {
+	for _, row := range m.rows {
+		if row.EventID == eventID {
+			return row
+		}
+	}
+	return nil
+}
+
+func (m *memoryEvents) InsertEvent(ev *model.GateEvent) (bool, error) {
+	k := m.key(ev.GateID, ev.EventUID)
+	if _, ok := m.rows[k]; ok {
+		return false, nil
+	}
+	m.nextID++
+	ev.EventID = m.nextID
+	row := *ev
+	m.rows[k] = &row
+	return true, nil
+}
+
+func (m *memoryEvents) GetEventByUID(gateID uint, uid string) (*model.GateEvent, error) {
+	row, ok := m.rows[m.key(gateID, uid)]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	copied := *row
+	return &copied, nil
+}
+
+func (m *memoryEvents) TransitEvent(eventID uint64, from, to string) (bool, error) {
+	row := m.byID(eventID)
+	if row == nil || row.Status != from {
+		return false, nil
+	}
+	row.Status = to
+	return true, nil
+}
+
+func (m *memoryEvents) UpdateEvent(eventID uint64, updates map[string]interface{}) error {
+	row := m.byID(eventID)
+	if row == nil {
+		return errors.New("record not found")
+	}
+	row.Status = updates["status"].(string)
+	row.ConflictType = updates["conflict_type"].(string)
+	row.Message = updates["message"].(string)
+	row.RecordID = updates["record_id"].(*uint)
+	return nil
+}
+
+// recordingReplayer 记录重放顺序，按车牌返回预设的错误，成功时依次分配停车记录ID
+type recordingReplayer struct {
+	calls  []string
+	errs   map[string]error
+	nextID uint
+}
+
+func (r *recordingReplayer) replay(direction, plate string) (uint, error) {
+	r.calls = append(r.calls, direction+":"+plate)
+	if err := r.errs[plate]; err != nil {
+		return 0, err
+	}
+	r.nextID++
+	return r.nextID, nil
+}
+
+func (r *recordingReplayer) ReplayEntry(_ ReplaySource, _ uint, plate, _ string, _ time.Time) (uint, error) {
+	return r.replay(DirectionIn, plate)
+}
+
+func (r *recordingReplayer) ReplayExit(_ ReplaySource, _ uint, plate, _ string, _ time.Time) (uint, error) {
+	return r.replay(DirectionOut, plate)
+}
+
+func uintPtr(v uint) *uint { return &v }
+
+func TestSync(t *testing.T) {
+	base := time.Now().Add(-time.Hour)
+	event := func(uid, direction, plate string, offset time.Duration) SyncEvent {
+		return SyncEvent{EventID: uid, Direction: direction, LicensePlate: plate, OccurredAt: base.Add(offset)}
+	}
+	conflict := &ConflictError{Type: ConflictDoubleEntry, Message: "车辆已在场"}
+
+	tests := []struct {
+		name      string
+		gateDir   string
+		existing  []model.GateEvent
+		errs      map[string]error
+		events    []SyncEvent
+		wantCalls []string
+		want      []SyncResult
+	}{
+		{
+			name: "按事件发生时间顺序重放，结果与请求顺序一一对应",
+			events: []SyncEvent{
+				event("e3", DirectionOut, "A1", 30*time.Minute),
+				event("e1", DirectionIn, "A1", 0),
+				event("e2", DirectionIn, "B2", 10*time.Minute),
+			},
+			wantCalls: []string{"in:A1", "in:B2", "out:A1"},
+			want: []SyncResult{
+				{EventID: "e3", Status: EventApplied, RecordID: uintPtr(3)},
+				{EventID: "e1", Status: EventApplied, RecordID: uintPtr(1)},
+				{EventID: "e2", Status: EventApplied, RecordID: uintPtr(2)},
+			},
+		},
+		{
+			name: "同一批次中的重复事件只处理最早的一条",
+			events: []SyncEvent{
+				event("e1", DirectionIn, "A1", 5*time.Minute),
+				event("e1", DirectionIn, "A1", 0),
+			},
+			wantCalls: []string{"in:A1"},
+			want: []SyncResult{
+				{EventID: "e1", Status: SyncDuplicate, Message: "同一批次中的重复事件"},
+				{EventID: "e1", Status: EventApplied, RecordID: uintPtr(1)},
+			},
+		},
+		{
+			name:     "已处理的事件重复补传时返回首次处理结果",
+			existing: []model.GateEvent{{EventUID: "e1", Status: EventApplied, RecordID: uintPtr(7)}, {EventUID: "e2", Status: EventConflict, ConflictType: ConflictExitWithoutEntry}},
+			events: []SyncEvent{
+				event("e1", DirectionIn, "A1", 0),
+				event("e2", DirectionOut, "B2", time.Minute),
+			},
+			want: []SyncResult{
+				{EventID: "e1", Status: SyncDuplicate, PreviousStatus: EventApplied, RecordID: uintPtr(7)},
+				{EventID: "e2", Status: SyncDuplicate, PreviousStatus: EventConflict, ConflictType: ConflictExitWithoutEntry},
+			},
+		},
+		{
+			name:      "处理失败的事件重传时重新处理",
+			existing:  []model.GateEvent{{EventUID: "e1", Direction: DirectionIn, LicensePlate: "A1", Status: EventFailed, Message: "数据库错误"}},
+			events:    []SyncEvent{event("e1", DirectionIn, "A1", 0)},
+			wantCalls: []string{"in:A1"},
+			want:      []SyncResult{{EventID: "e1", Status: EventApplied, RecordID: uintPtr(1)}},
+		},
+		{
+			name:      "冲突进入待处理队列，其它错误记为失败",
+			errs:      map[string]error{"A1": conflict, "B2": errors.New("数据库错误")},
+			events:    []SyncEvent{event("e1", DirectionIn, "A1", 0), event("e2", DirectionIn, "B2", time.Minute)},
+			wantCalls: []string{"in:A1", "in:B2"},
+			want: []SyncResult{
+				{EventID: "e1", Status: EventConflict, ConflictType: ConflictDoubleEntry, Message: "车辆已在场"},
+				{EventID: "e2", Status: EventFailed, Message: "数据库错误"},
+			},
+		},
+		{
+			name:    "无效事件不处理",
+			gateDir: DirectionIn,
+			events: []SyncEvent{
+				event("e1", DirectionOut, "A1", 0),
+				event("e2", DirectionIn, "", 0),
+				event("", DirectionIn, "A1", 0),
+				event("e3", DirectionIn, "A1", 2*time.Hour),
+				{EventID: "e4", Direction: DirectionIn, LicensePlate: "A1"},
+			},
+			want: []SyncResult{
+				{EventID: "e1", Status: SyncInvalid, Message: ErrDirectionDenied.Error()},
+				{EventID: "e2", Status: SyncInvalid, Message: "入场事件缺少车牌号"},
+				{EventID: "", Status: SyncInvalid, Message: "event_id 不能为空且不超过 64 个字符"},
+				{EventID: "e3", Status: SyncInvalid, Message: "occurred_at 晚于服务器当前时间"},
+				{EventID: "e4", Status: SyncInvalid, Message: "occurred_at 不能为空"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateDir := tt.gateDir
+			if gateDir == "" {
+				gateDir = DirectionBoth
+			}
+			g := &model.GateDevice{GateID: 1, GateCode: "G1", LotID: 1, Direction: gateDir}
+			store := newMemoryEvents()
+			for _, ev := range tt.existing {
+				ev.GateID = g.GateID
+				store.InsertEvent(&ev)
+			}
+			replayer := &recordingReplayer{errs: tt.errs}
+			svc := &Service{cfg: &Config{syncMaxBatch: 10, maxClockSkew: 5 * time.Minute}, events: store, replayer: replayer}
+
+			got, err := svc.Sync(g, tt.events)
+			if err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+			if !reflect.DeepEqual(replayer.calls, tt.wantCalls) {
+				t.Errorf("重放顺序 = %v, want %v", replayer.calls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Sync() = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncBatchLimits(t *testing.T) {
+	g := &model.GateDevice{GateID: 1, LotID: 1, Direction: DirectionBoth}
+	svc := &Service{cfg: &Config{syncMaxBatch: 2, maxClockSkew: 5 * time.Minute}, events: newMemoryEvents(), replayer: &recordingReplayer{}}
+
+	if _, err := svc.Sync(g, nil); !errors.Is(err, ErrBatchEmpty) {
+		t.Errorf("空批次 error = %v, want %v", err, ErrBatchEmpty)
+	}
+	events := make([]SyncEvent, 3)
+	if _, err := svc.Sync(g, events); !errors.Is(err, ErrBatchTooLarge) {
+		t.Errorf("超过上限 error = %v, want %v", err, ErrBatchTooLarge)
+	}
+}
@@ -2,60 +2,64 @@ package middleware
 
 import (
 	"net/http"
+	"smart_parking_backend/internal/auth"
 	"smart_parking_backend/internal/inits"
-	"strings"
+	"smart_parking_backend/internal/model"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
 func AdminAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 获取Authorization头部
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "缺少认证token"})
-			c.Abort()
+		// 校验访问令牌（含吊销列表：注销、重置密码后立即失效）
+		claims, ok := verifyToken(c, auth.KindAdmin)
+		if !ok || !loadAdminContext(c, claims) {
 			return
 		}
+		c.Next()
+	}
+}
 
-		// 验证Authorization头部格式
-		parts := strings.SplitN(authHeader, " ", 2)
-		if !(len(parts) == 2 && parts[0] == "Bearer") {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的认证token格式"})
-			c.Abort()
-			return
-		}
-
-		// 解析JWT Token
-		tokenStr := parts[1]
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			return []byte(inits.GetEnv("JWT_SECRET_ADMIN")), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的token"})
-			c.Abort()
-			return
-		}
+// loadAdminContext 加载管理员角色与管理的停车场并存入上下文，失败时写入响应并中止请求
+func loadAdminContext(c *gin.Context, claims *auth.Claims) bool {
+	adminID := claims.AdminID
 
-		// 提取Claims
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的token"})
-			c.Abort()
-			return
-		}
+	// 角色、状态与管理的停车场以数据库为准，禁用账号、调整停车场后立即生效
+	var admin model.Admins
+	if err := inits.DB.Select("admin_id", "role", "status").First(&admin, adminID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "管理员账号不存在"})
+		c.Abort()
+		return false
+	}
+	if admin.Status != 1 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "账号已禁用"})
+		c.Abort()
+		return false
+	}
+	lotIDs, err := AdminLotIDs(adminID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询管理员停车场失败"})
+		c.Abort()
+		return false
+	}
 
-		// 存储管理员信息到上下文
-		c.Set("admin_id", uint(claims["admin_id"].(float64)))
-		c.Set("phone", claims["phone"])
-		c.Set("role", claims["role"])
+	// 存储管理员信息到上下文
+	c.Set("admin_id", adminID)
+	c.Set("username", claims.Username)
+	c.Set("role", admin.Role)
+	c.Set("lot_ids", lotIDs)
 
-		if claims["lot_id"] != nil {
-			c.Set("lot_id", uint(claims["lot_id"].(float64)))
-		}
-
-		c.Next()
+	// 兼容只支持单个停车场的接口：取管理的第一个停车场
+	if len(lotIDs) > 0 {
+		c.Set("lot_id", lotIDs[0])
 	}
+	return true
+}
+
+// AdminLotIDs 查询管理员管理的停车场ID（按停车场ID升序）
+func AdminLotIDs(adminID uint) ([]uint, error) {
+	lotIDs := []uint{}
+	err := inits.DB.Model(&model.AdminLot{}).Where("admin_id = ?", adminID).
+		Order("lot_id ASC").Pluck("lot_id", &lotIDs).Error
+	return lotIDs, err
 }
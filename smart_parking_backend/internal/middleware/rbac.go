@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"net/http"
+	"smart_parking_backend/internal/auth"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 角色
+// user：普通用户，只能访问自己的资源（身份来自用户 token）
+// lot_admin：停车场管理员，只能管理分配给自己的停车场（admin_lot 表）
+// system：系统管理员，可管理全部停车场
+const (
+	RoleUser     = "user"
+	RoleLotAdmin = "lot_admin"
+	RoleSystem   = "system"
+)
+
+// RequireRoles 管理员角色校验，需在 AdminAuthMiddleware 之后使用
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, r := range roles {
+			if r == role {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限访问"})
+		c.Abort()
+	}
+}
+
+// CanManageLot 系统管理员可管理全部停车场，停车场管理员只能管理分配给自己的停车场
+func CanManageLot(c *gin.Context, lotID uint) bool {
+	role := c.GetString("role")
+	if role == RoleSystem {
+		return true
+	}
+	if role != RoleLotAdmin {
+		return false
+	}
+	for _, id := range ManagedLots(c) {
+		if id == lotID {
+			return true
+		}
+	}
+	return false
+}
+
+// ManagedLots 停车场管理员管理的停车场ID列表（系统管理员为空，表示不限）
+func ManagedLots(c *gin.Context) []uint {
+	v, _ := c.Get("lot_ids")
+	ids, _ := v.([]uint)
+	return ids
+}
+
+// LotScope 路径参数中的停车场必须在管理员的管理范围内，需在 AdminAuthMiddleware 之后使用
+func LotScope(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lotID, err := strconv.ParseUint(c.Param(param), 10, 64)
+		if err != nil || lotID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的停车场ID"})
+			c.Abort()
+			return
+		}
+		if !CanManageLot(c, uint(lotID)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "无权限管理该停车场"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// LotViewer 用户与管理员均可访问的停车场接口（如车位占用情况）：用户 token 直接放行，
+// 管理员 token 要求路径参数中的停车场在管理范围内；未登录时返回 401
+func LotViewer(param string) gin.HandlerFunc {
+	scope := LotScope(param)
+	return func(c *gin.Context) {
+		kind := auth.KindUser
+		if tokenStr, ok := auth.BearerToken(c); ok && auth.PeekKind(tokenStr) == auth.KindAdmin {
+			kind = auth.KindAdmin
+		}
+		claims, ok := verifyToken(c, kind)
+		if !ok {
+			return
+		}
+		if kind == auth.KindUser {
+			setUserContext(c, claims)
+			c.Next()
+			return
+		}
+		if loadAdminContext(c, claims) {
+			scope(c)
+		}
+	}
+}
+
+// SelfOnly 路径参数中的用户ID必须与 token 中的用户一致，需在 UserAuthMiddleware 之后使用
+// 兼容旧版路径中携带 user_id 的接口，处理函数应直接使用上下文中的 user_id
+func SelfOnly(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.Param(param), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+			c.Abort()
+			return
+		}
+		if uint(userID) != c.GetUint("user_id") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "无权访问其他用户的数据"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
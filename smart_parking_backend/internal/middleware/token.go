@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"smart_parking_backend/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+var tokenService *auth.Service
+
+// InitAuthService 设置鉴权中间件使用的令牌服务
+func InitAuthService(svc *auth.Service) {
+	tokenService = svc
+}
+
+// verifyToken 校验 Authorization 头中的访问令牌（签名、有效期、受众与吊销列表），失败时写入响应并中止请求
+func verifyToken(c *gin.Context, kind string) (*auth.Claims, bool) {
+	if c.GetHeader("Authorization") == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "缺少认证token"})
+		c.Abort()
+		return nil, false
+	}
+	tokenStr, ok := auth.BearerToken(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的认证token格式"})
+		c.Abort()
+		return nil, false
+	}
+
+	claims, err := tokenService.Verify(c.Request.Context(), tokenStr, kind)
+	switch {
+	case err == nil:
+		return claims, true
+	case errors.Is(err, auth.ErrTokenRevoked):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case auth.IsAuthError(err):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的token"})
+	default:
+		// 吊销列表不可用时拒绝请求，避免已注销的 token 继续生效
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "认证服务暂不可用，请稍后重试"})
+	}
+	c.Abort()
+	return nil, false
+}
@@ -1,9 +1,7 @@
 package middleware
 
 import (
-	"net/http"
-	"smart_parking_backend/utils"
-	"strings"
+	"smart_parking_backend/internal/auth"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,36 +9,20 @@ import (
 // UserAuthMiddleware 用户认证中间件
 func UserAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 获取Authorization头部
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "缺少认证token"})
-			c.Abort()
+		// 校验访问令牌（含吊销列表：注销、禁用账号后立即失效）
+		claims, ok := verifyToken(c, auth.KindUser)
+		if !ok {
 			return
 		}
 
-		// 验证Authorization头部格式
-		parts := strings.SplitN(authHeader, " ", 2)
-		if !(len(parts) == 2 && parts[0] == "Bearer") {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的认证token格式"})
-			c.Abort()
-			return
-		}
-
-		// 解析JWT Token
-		tokenStr := parts[1]
-		claims, err := utils.ParseToken(tokenStr)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的token"})
-			c.Abort()
-			return
-		}
-
-		// 存储用户信息到上下文
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
-
+		setUserContext(c, claims)
 		c.Next()
 	}
 }
 
+// setUserContext 存储用户信息到上下文
+func setUserContext(c *gin.Context, claims *auth.Claims) {
+	c.Set("user_id", claims.UserID)
+	c.Set("username", claims.Username)
+	c.Set("role", RoleUser)
+}
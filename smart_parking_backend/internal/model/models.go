@@ -90,7 +90,7 @@ func (t LocalTime) String() string {
 type Users_list struct {
 	UserID       uint       `gorm:"primaryKey;autoIncrement;comment:用户唯一标识" json:"user_id"`
 	Username     string     `gorm:"size:50;unique;not null;comment:用户名（登录名）" json:"username"`
-	PasswordHash string     `gorm:"size:255;not null;comment:密码哈希值" json:"password_hash"`
+	PasswordHash string     `gorm:"size:255;not null;comment:密码哈希值" json:"-"`
 	Phone        string     `gorm:"size:20;not null;index:idx_phone;comment:手机号" json:"phone"`
 	Email        string     `gorm:"size:100;index:idx_email;comment:邮箱" json:"email"`
 	RealName     string     `gorm:"size:50;comment:真实姓名" json:"real_name"`
@@ -112,19 +112,44 @@ func (Users_list) TableName() string { return "users_list" }
 // 管理员信息表
 // ////////////////////
 type Admins struct {
-	AdminID      uint        `gorm:"primaryKey;autoIncrement;comment:管理员ID" json:"admin_id"`
-	Username     string      `gorm:"size:50;unique;not null;comment:登录名" json:"username"`
-	PasswordHash string      `gorm:"size:255;not null;comment:加密密码" json:"password_hash"`
-	PhoneNumber  string      `gorm:"size:20;uniqueIndex;comment:电话号码" json:"phone_number"`
-	Role         string      `gorm:"type:enum('system','lot_admin');default:'lot_admin';comment:角色类型" json:"role"`
-	LotID        *uint       `gorm:"comment:若是停车场管理员，对应停车场ID" json:"lot_id"`
-	Lot          *ParkingLot `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL" json:"lot,omitempty"`
-	Status       int8        `gorm:"default:1;comment:状态（0-禁用，1-启用）" json:"status"`
-	CreateTime   time.Time   `gorm:"autoCreateTime;comment:创建时间" json:"create_time"`
+	AdminID      uint      `gorm:"primaryKey;autoIncrement;comment:管理员ID" json:"admin_id"`
+	Username     string    `gorm:"size:50;unique;not null;comment:登录名" json:"username"`
+	PasswordHash string    `gorm:"size:255;not null;comment:加密密码" json:"-"`
+	PhoneNumber  string    `gorm:"size:20;uniqueIndex;comment:电话号码" json:"phone_number"`
+	Role         string    `gorm:"type:enum('system','lot_admin');default:'lot_admin';comment:角色类型" json:"role"`
+	Status       int8      `gorm:"default:1;comment:状态（0-禁用，1-启用）" json:"status"`
+	CreateTime   time.Time `gorm:"autoCreateTime;comment:创建时间" json:"create_time"`
+
+	// 停车场管理员管理的停车场（admin_lot 表），查询时填充
+	LotIDs []uint `gorm:"-" json:"lot_ids"`
+	// 兼容旧客户端：管理的第一个停车场，未分配时为 null
+	LotID *uint `gorm:"-" json:"lot_id"`
 }
 
 func (Admins) TableName() string { return "admins_list" }
 
+// SetLots 填充管理员管理的停车场
+func (a *Admins) SetLots(lotIDs []uint) {
+	a.LotIDs = lotIDs
+	a.LotID = nil
+	if len(lotIDs) > 0 {
+		a.LotID = &lotIDs[0]
+	}
+}
+
+// ////////////////////
+// 管理员-停车场关联表（一个停车场管理员可管理多个停车场）
+// ////////////////////
+type AdminLot struct {
+	AdminID    uint        `gorm:"primaryKey;comment:管理员ID" json:"admin_id"`
+	Admin      *Admins     `gorm:"foreignKey:AdminID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	LotID      uint        `gorm:"primaryKey;index:idx_admin_lot_lot;comment:停车场ID" json:"lot_id"`
+	Lot        *ParkingLot `gorm:"foreignKey:LotID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"lot,omitempty"`
+	CreateTime time.Time   `gorm:"autoCreateTime;comment:分配时间" json:"create_time"`
+}
+
+func (AdminLot) TableName() string { return "admin_lot" }
+
 // ////////////////////
 // 用户车辆表
 // ////////////////////
@@ -208,10 +233,13 @@ type ReservationOrder struct {
 	Status          int8         `gorm:"default:1;comment:订单状态（0-已取消，1-已预订，2-使用中，3-已完成）" json:"status"`
 	TotalFee        float64      `gorm:"type:decimal(10,2);default:0.00;comment:应付总费用" json:"total_fee"`
 	PaidFee         float64      `gorm:"type:decimal(10,2);default:0.00;comment:实付金额" json:"paid_fee"`
-	PaymentStatus   int8         `gorm:"default:0;comment:支付状态（0-未支付，1-已支付）" json:"payment_status"`
+	PaymentStatus   int8         `gorm:"default:0;comment:支付状态（0-未支付，1-已支付，2-已退款，3-部分退款）" json:"payment_status"`
+	RefundedFee     float64      `gorm:"type:decimal(10,2);default:0.00;comment:已退款金额" json:"refunded_fee"`
 	ReservationCode string       `gorm:"size:50;unique;not null;index:idx_reservation_code;comment:预订编号" json:"reservation_cod"`
+	FeeBreakdown    string       `gorm:"type:text;comment:计费明细（JSON）" json:"fee_breakdown"`
 
-	Payments []PaymentRecord `gorm:"foreignKey:OrderID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Payments      []PaymentRecord `gorm:"foreignKey:OrderID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	ParkingRecord *ParkingRecord  `gorm:"foreignKey:ReservationID;references:OrderID" json:"parking_record,omitempty"` // 凭该预订入场的停车记录
 }
 
 func (ReservationOrder) TableName() string { return "reservation_order" }
@@ -219,46 +247,158 @@ func (ReservationOrder) TableName() string { return "reservation_order" }
 // ////////////////////
 // 支付记录表
 // ////////////////////
+
+// 支付对象类型（payment_record.payable_type），每种类型对应一个独立的外键列
+const (
+	PayableReservation = "reservation"  // 预订订单 -> order_id
+	PayableParking     = "parking"      // 停车记录 -> parking_record_id
+	PayableViolation   = "violation"    // 违规罚款 -> violation_id
+	PayableWalletTopup = "wallet_topup" // 钱包充值 -> wallet_id
+)
+
 type PaymentRecord struct {
-	PaymentID     uint64           `gorm:"primaryKey;autoIncrement;comment:支付流水号" json:"payment_id"`
-	OrderID       uint             `gorm:"index:idx_order_id;not null;comment:关联的订单ID" json:"order_id"`
-	Order         ReservationOrder `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:OrderID;references:OrderID" json:"order"`
-	UserID        uint             `gorm:"index:idx_user_id;not null;comment:用户ID" json:"user_id"`
-	User          Users_list       `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:UserID;references:UserID" json:"user"`
-	Amount        float64          `gorm:"type:decimal(10,2);not null;comment:支付金额" json:"amount"`
-	Method        string           `gorm:"type:enum('wechat','alipay','credit_card','wallet');not null;comment:支付方式" json:"method"`
-	TransactionNo string           `gorm:"size:100;unique;comment:第三方支付平台交易号" json:"transaction_no"`
-	PaymentStatus int8             `gorm:"default:0;comment:支付状态（0-待支付，1-支付成功，2-失败，3-退款）" json:"payment_status"`
-	PayTime       *time.Time       `gorm:"comment:支付时间" json:"pay_time"`
-	RefundTime    *time.Time       `gorm:"comment:退款时间" json:"refund_time"`
-	CreateTime    time.Time        `gorm:"autoCreateTime;comment:创建时间" json:"create_time"`
+	PaymentID       uint64            `gorm:"primaryKey;autoIncrement;comment:支付流水号" json:"payment_id"`
+	PayableType     string            `gorm:"size:20;not null;index:idx_payable_type;comment:支付对象类型（reservation/parking/violation）" json:"payable_type"`
+	OrderID         *uint             `gorm:"index:idx_order_id;comment:关联的预订订单ID（payable_type=reservation）" json:"order_id"`
+	Order           *ReservationOrder `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:OrderID;references:OrderID" json:"order,omitempty"`
+	ParkingRecordID *uint             `gorm:"index:idx_parking_record_id;comment:关联的停车记录ID（payable_type=parking）" json:"parking_record_id"`
+	ParkingRecord   *ParkingRecord    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:ParkingRecordID;references:RecordID" json:"parking_record,omitempty"`
+	ViolationID     *uint             `gorm:"index:idx_violation_id;comment:关联的违规记录ID（payable_type=violation）" json:"violation_id"`
+	Violation       *ViolationRecord  `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:ViolationID;references:ViolationID" json:"violation,omitempty"`
+	WalletID        *uint             `gorm:"index:idx_wallet_id;comment:充值的钱包ID（payable_type=wallet_topup）" json:"wallet_id"`
+	Wallet          *Wallet           `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:WalletID;references:WalletID" json:"wallet,omitempty"`
+	UserID          *uint             `gorm:"index:idx_user_id;comment:用户ID（访客停车支付为空）" json:"user_id"`
+	User            Users_list        `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:UserID;references:UserID" json:"user"`
+	Amount          float64           `gorm:"type:decimal(10,2);not null;comment:支付金额" json:"amount"`
+	Method          string            `gorm:"type:enum('wechat','alipay','credit_card','wallet');not null;comment:支付方式" json:"method"`
+	TransactionNo   string            `gorm:"size:100;unique;comment:第三方支付平台交易号" json:"transaction_no"`
+	PaymentStatus   int8              `gorm:"default:0;comment:支付状态（0-待支付，1-支付成功，2-失败，3-已全额退款，4-部分退款）" json:"payment_status"`
+	RefundedAmount  float64           `gorm:"type:decimal(10,2);default:0.00;comment:已退款金额" json:"refunded_amount"`
+	PayTime         *time.Time        `gorm:"comment:支付时间" json:"pay_time"`
+	RefundTime      *time.Time        `gorm:"comment:退款时间" json:"refund_time"`
+	CreateTime      time.Time         `gorm:"autoCreateTime;comment:创建时间" json:"create_time"`
 }
 
 func (PaymentRecord) TableName() string { return "payment_record" }
 
+// ////////////////////
+// 支付回调隔离表（金额不符、重复支付等异常回调，待人工审核）
+// ////////////////////
+type PaymentQuarantine struct {
+	QuarantineID   uint64         `gorm:"primaryKey;autoIncrement;comment:隔离记录ID" json:"quarantine_id"`
+	PaymentID      *uint64        `gorm:"index:idx_quarantine_payment;comment:关联的支付流水号（未找到支付记录时为空）" json:"payment_id"`
+	Payment        *PaymentRecord `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;foreignKey:PaymentID;references:PaymentID" json:"-"`
+	Provider       string         `gorm:"size:20;comment:支付渠道" json:"provider"`
+	TransactionNo  string         `gorm:"size:100;comment:第三方支付平台交易号" json:"transaction_no"`
+	ExpectedAmount float64        `gorm:"type:decimal(10,2);default:0.00;comment:应付金额" json:"expected_amount"`
+	ReceivedAmount float64        `gorm:"type:decimal(10,2);default:0.00;comment:回调金额" json:"received_amount"`
+	Reason         string         `gorm:"size:50;not null;comment:隔离原因" json:"reason"`
+	RawPayload     string         `gorm:"type:text;comment:回调原始参数" json:"raw_payload"`
+	Status         int8           `gorm:"default:0;comment:处理状态（0-待审核，1-已处理）" json:"status"`
+	CreateTime     time.Time      `gorm:"autoCreateTime;comment:创建时间" json:"create_time"`
+}
+
+func (PaymentQuarantine) TableName() string { return "payment_quarantine" }
+
+// ////////////////////
+// 钱包表（每个用户一个钱包）
+// ////////////////////
+type Wallet struct {
+	WalletID   uint       `gorm:"primaryKey;autoIncrement;comment:钱包ID" json:"wallet_id"`
+	UserID     uint       `gorm:"not null;uniqueIndex:uk_wallet_user;comment:用户ID" json:"user_id"`
+	User       Users_list `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:UserID;references:UserID" json:"-"`
+	Balance    float64    `gorm:"type:decimal(10,2);not null;default:0.00;comment:余额" json:"balance"`
+	Status     int8       `gorm:"default:1;comment:状态（0-冻结，1-正常）" json:"status"`
+	CreateTime time.Time  `gorm:"autoCreateTime;comment:创建时间" json:"create_time"`
+	UpdateTime time.Time  `gorm:"autoUpdateTime;comment:更新时间" json:"update_time"`
+}
+
+func (Wallet) TableName() string { return "wallet" }
+
+// ////////////////////
+// 钱包流水表（只追加，不修改、不删除）
+// ////////////////////
+type WalletLedger struct {
+	LedgerID     uint64         `gorm:"primaryKey;autoIncrement;comment:流水ID" json:"ledger_id"`
+	WalletID     uint           `gorm:"not null;index:idx_ledger_wallet;comment:钱包ID" json:"wallet_id"`
+	Wallet       *Wallet        `gorm:"constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;foreignKey:WalletID;references:WalletID" json:"-"`
+	Direction    string         `gorm:"type:enum('credit','debit');not null;comment:方向（credit-入账，debit-出账）" json:"direction"`
+	Amount       float64        `gorm:"type:decimal(10,2);not null;comment:金额" json:"amount"`
+	BalanceAfter float64        `gorm:"type:decimal(10,2);not null;comment:变动后余额" json:"balance_after"`
+	BizType      string         `gorm:"size:20;not null;comment:业务类型（topup/payment/refund）" json:"biz_type"`
+	BizNo        string         `gorm:"size:64;not null;uniqueIndex:uk_ledger_biz_no;comment:业务单号（同一业务只记一次账）" json:"biz_no"`
+	PaymentID    *uint64        `gorm:"index:idx_ledger_payment;comment:关联支付流水号" json:"payment_id"`
+	Payment      *PaymentRecord `gorm:"constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;foreignKey:PaymentID;references:PaymentID" json:"-"`
+	Remark       string         `gorm:"size:255;comment:备注" json:"remark"`
+	CreateTime   time.Time      `gorm:"autoCreateTime;comment:创建时间" json:"create_time"`
+}
+
+func (WalletLedger) TableName() string { return "wallet_ledger" }
+
+// ////////////////////
+// 退款策略表（按停车场配置取消预订的退款比例档位）
+// ////////////////////
+type RefundPolicyTier struct {
+	TierID         uint       `gorm:"primaryKey;autoIncrement;comment:档位ID" json:"tier_id"`
+	LotID          uint       `gorm:"not null;index:idx_refund_policy_lot;comment:所属停车场ID" json:"lot_id"`
+	Lot            ParkingLot `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:LotID;references:LotID" json:"-"`
+	MinHoursBefore float64    `gorm:"type:decimal(6,2);not null;comment:距预订开始至少提前的小时数" json:"min_hours_before"`
+	RefundPercent  int        `gorm:"not null;comment:退款比例（0-100）" json:"refund_percent"`
+	CreateTime     time.Time  `gorm:"autoCreateTime;comment:创建时间" json:"create_time"`
+}
+
+func (RefundPolicyTier) TableName() string { return "refund_policy_tier" }
+
+// ////////////////////
+// 退款记录表
+// ////////////////////
+type RefundRecord struct {
+	RefundID         uint64         `gorm:"primaryKey;autoIncrement;comment:退款记录ID" json:"refund_id"`
+	RefundNo         string         `gorm:"size:64;unique;not null;comment:退款单号" json:"refund_no"`
+	PaymentID        uint64         `gorm:"not null;index:idx_refund_payment;comment:原支付流水号" json:"payment_id"`
+	Payment          *PaymentRecord `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:PaymentID;references:PaymentID" json:"-"`
+	Amount           float64        `gorm:"type:decimal(10,2);not null;comment:退款金额" json:"amount"`
+	Reason           string         `gorm:"size:255;comment:退款原因" json:"reason"`
+	Status           int8           `gorm:"default:0;index:idx_refund_status;comment:退款状态（0-处理中，1-成功，2-失败）" json:"status"`
+	ProviderRefundNo string         `gorm:"size:100;comment:支付渠道退款单号" json:"provider_refund_no"`
+	FailReason       string         `gorm:"size:255;comment:失败原因" json:"fail_reason"`
+	OperatorType     string         `gorm:"type:enum('user','admin');not null;comment:发起方" json:"operator_type"`
+	OperatorID       uint           `gorm:"not null;comment:发起人ID（用户ID或管理员ID）" json:"operator_id"`
+	CreateTime       time.Time      `gorm:"autoCreateTime;comment:创建时间" json:"create_time"`
+	FinishTime       *time.Time     `gorm:"comment:完成时间" json:"finish_time"`
+}
+
+func (RefundRecord) TableName() string { return "refund_record" }
+
 // ////////////////////
 // 停车记录表
 // ////////////////////
 type ParkingRecord struct {
-	RecordID        uint         `gorm:"primaryKey;autoIncrement;comment:停车记录唯一标识" json:"record_id"`
-	UserID          uint         `gorm:"index:idx_user_id;not null;comment:用户ID" json:"user_id"`
-	User            Users_list   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:UserID;references:UserID" json:"user"`
-	VehicleID       uint         `gorm:"index:idx_vehicle_id;not null;comment:车辆ID" json:"vehicle_id"`
-	Vehicle         Vehicle      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:VehicleID;references:VehicleID" json:"vehicle"`
-	SpaceID         uint         `gorm:"not null;comment:车位ID" json:"space_id"`
-	Space           ParkingSpace `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:SpaceID;references:SpaceID" json:"space"`
-	LotID           uint         `gorm:"not null;comment:停车场ID" json:"lot_id"`
-	Lot             ParkingLot   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:LotID;references:LotID" json:"lot"`
-	EntryTime       time.Time    `gorm:"not null;comment:入场时间" json:"entry_time"`
-	ExitTime        *time.Time   `gorm:"comment:出场时间" json:"exit_time"`
-	DurationMinutes int          `gorm:"comment:停车时长（分钟）" json:"duration_minute"`
-	FeeCalculated   float64      `gorm:"type:decimal(10,2);default:0.00;comment:计算停车费" json:"fee_calculated"`
-	FeePaid         float64      `gorm:"type:decimal(10,2);default:0.00;comment:实际支付停车费" json:"fee_paid"`
-	PaymentStatus   int8         `gorm:"default:0;comment:支付状态（0-未支付，1-已支付）" json:"payment_status"`
-	IsViolation     int8         `gorm:"default:0;index:idx_violation;comment:是否违规" json:"is_violation"`
-	ViolationReason string       `gorm:"size:255;comment:违规原因" json:"violation_reason"`
-	RecordStatus    int8         `gorm:"default:1;index:idx_record_status;comment:记录状态（1-在场，2-已出场）" json:"record_status"`
-	CreateTime      time.Time    `gorm:"autoCreateTime;comment:记录创建时间" json:"create_time"`
+	RecordID        uint              `gorm:"primaryKey;autoIncrement;comment:停车记录唯一标识" json:"record_id"`
+	UserID          *uint             `gorm:"index:idx_user_id;comment:用户ID（访客停车为空）" json:"user_id"`
+	User            Users_list        `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:UserID;references:UserID" json:"user"`
+	VehicleID       *uint             `gorm:"index:idx_vehicle_id;comment:车辆ID（访客停车为空）" json:"vehicle_id"`
+	Vehicle         Vehicle           `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:VehicleID;references:VehicleID" json:"vehicle"`
+	LicensePlate    string            `gorm:"size:20;not null;index:idx_record_plate;comment:车牌号" json:"license_plate"`
+	TicketCode      string            `gorm:"size:40;not null;uniqueIndex:uk_ticket_code;comment:停车凭证号" json:"ticket_code"`
+	ActivePlate     *string           `gorm:"size:20;uniqueIndex:uk_record_active_plate;comment:在场车牌（在场时等于车牌号，出场后置空，保证同一车牌只有一条在场记录）" json:"-"`
+	SpaceID         uint              `gorm:"not null;comment:车位ID" json:"space_id"`
+	Space           ParkingSpace      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:SpaceID;references:SpaceID" json:"space"`
+	LotID           uint              `gorm:"not null;comment:停车场ID" json:"lot_id"`
+	Lot             ParkingLot        `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:LotID;references:LotID" json:"lot"`
+	ReservationID   *uint             `gorm:"uniqueIndex:uk_record_reservation;comment:关联的预订订单ID（凭预订入场时写入）" json:"reservation_id"`
+	Reservation     *ReservationOrder `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;foreignKey:ReservationID;references:OrderID" json:"reservation,omitempty"`
+	EntryTime       time.Time         `gorm:"not null;comment:入场时间" json:"entry_time"`
+	ExitTime        *time.Time        `gorm:"comment:出场时间" json:"exit_time"`
+	DurationMinutes int               `gorm:"comment:停车时长（分钟）" json:"duration_minute"`
+	FeeCalculated   float64           `gorm:"type:decimal(10,2);default:0.00;comment:应付停车费（已抵扣预订预付金额）" json:"fee_calculated"`
+	FeePaid         float64           `gorm:"type:decimal(10,2);default:0.00;comment:实际支付停车费" json:"fee_paid"`
+	FeeBreakdown    string            `gorm:"type:text;comment:计费明细（JSON）" json:"fee_breakdown"`
+	PaymentStatus   int8              `gorm:"default:0;comment:支付状态（0-未支付，1-已支付）" json:"payment_status"`
+	IsViolation     int8              `gorm:"default:0;index:idx_violation;comment:是否违规" json:"is_violation"`
+	ViolationReason string            `gorm:"size:255;comment:违规原因" json:"violation_reason"`
+	RecordStatus    int8              `gorm:"default:1;index:idx_record_status;comment:记录状态（1-在场，2-已出场）" json:"record_status"`
+	CreateTime      time.Time         `gorm:"autoCreateTime;comment:记录创建时间" json:"create_time"`
 
 	Violations []ViolationRecord `gorm:"foreignKey:RecordID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
 }
@@ -269,20 +409,260 @@ func (ParkingRecord) TableName() string { return "parking_record" }
 // 违规记录表
 // ////////////////////
 type ViolationRecord struct {
-	ViolationID   uint          `gorm:"primaryKey;autoIncrement;comment:违规记录唯一标识" json:"violation_id"`
-	RecordID      uint          `gorm:"index:idx_record_id;not null;comment:关联停车记录ID" json:"record_id"`
-	Record        ParkingRecord `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:RecordID;references:RecordID" json:"record"`
-	UserID        uint          `gorm:"index:idx_user_id;not null;comment:用户ID" json:"user_id"`
-	User          Users_list    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:UserID;references:UserID" json:"user"`
-	VehicleID     uint          `gorm:"not null;comment:车辆ID" json:"vehicle_id"`
-	Vehicle       Vehicle       `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:VehicleID;references:VehicleID" json:"vehicle"`
-	ViolationType string        `gorm:"size:50;not null;comment:违规类型" json:"violation_type"`
-	ViolationTime time.Time     `gorm:"not null;index:idx_violation_time;comment:违规发生时间" json:"violation_time"`
-	Description   string        `gorm:"type:text;comment:违规描述" json:"description"`
-	FineAmount    float64       `gorm:"type:decimal(10,2);default:0.00;comment:罚款金额" json:"fine_amount"`
-	Status        int8          `gorm:"default:0;index:idx_status;comment:处理状态（0-未处理，1-已处理）" json:"status"`
-	CreateTime    time.Time     `gorm:"autoCreateTime;comment:记录创建时间" json:"create_time"`
-	ProcessTime   *time.Time    `gorm:"comment:处理时间" json:"process_time"`
+	ViolationID   uint              `gorm:"primaryKey;autoIncrement;comment:违规记录唯一标识" json:"violation_id"`
+	RecordID      *uint             `gorm:"index:idx_record_id;comment:关联停车记录ID（无停车记录时为空）" json:"record_id"`
+	Record        *ParkingRecord    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:RecordID;references:RecordID" json:"record"`
+	LotID         *uint             `gorm:"index:idx_violation_lot;comment:违规所属停车场ID" json:"lot_id"`
+	Lot           *ParkingLot       `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;foreignKey:LotID;references:LotID" json:"lot,omitempty"`
+	ReservationID *uint             `gorm:"index:idx_violation_reservation;comment:关联预订订单ID" json:"reservation_id"`
+	Reservation   *ReservationOrder `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;foreignKey:ReservationID;references:OrderID" json:"reservation,omitempty"`
+	UserID        uint              `gorm:"index:idx_user_id;not null;comment:用户ID" json:"user_id"`
+	User          Users_list        `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:UserID;references:UserID" json:"user"`
+	VehicleID     uint              `gorm:"not null;comment:车辆ID" json:"vehicle_id"`
+	Vehicle       Vehicle           `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:VehicleID;references:VehicleID" json:"vehicle"`
+	ViolationType string            `gorm:"size:50;not null;comment:违规类型" json:"violation_type"`
+	ViolationTime time.Time         `gorm:"not null;index:idx_violation_time;comment:违规发生时间" json:"violation_time"`
+	Description   string            `gorm:"type:text;comment:违规描述" json:"description"`
+	FineAmount    float64           `gorm:"type:decimal(10,2);default:0.00;comment:罚款金额" json:"fine_amount"`
+	Status        int8              `gorm:"default:0;index:idx_status;comment:处理状态（0-未处理，1-已处理，2-申诉中，3-已免除）" json:"status"`
+	CreateTime    time.Time         `gorm:"autoCreateTime;comment:记录创建时间" json:"create_time"`
+	ProcessTime   *time.Time        `gorm:"comment:处理时间" json:"process_time"`
 }
 
 func (ViolationRecord) TableName() string { return "violation_record" }
+
+// ////////////////////
+// 违规规则命中表（记录每条规则已对哪些源记录开具过违规，保证同一规则对同一记录只开具一次）
+// ////////////////////
+type ViolationRuleHit struct {
+	HitID       uint64           `gorm:"primaryKey;autoIncrement;comment:命中记录ID" json:"hit_id"`
+	RuleCode    string           `gorm:"size:50;not null;uniqueIndex:uk_rule_hit,priority:1;comment:规则编码" json:"rule_code"`
+	SourceType  string           `gorm:"size:20;not null;uniqueIndex:uk_rule_hit,priority:2;comment:源记录类型（reservation/parking/violation）" json:"source_type"`
+	SourceID    uint             `gorm:"not null;uniqueIndex:uk_rule_hit,priority:3;comment:源记录ID" json:"source_id"`
+	ViolationID *uint            `gorm:"index:idx_rule_hit_violation;comment:生成的违规记录ID" json:"violation_id"`
+	Violation   *ViolationRecord `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;foreignKey:ViolationID;references:ViolationID" json:"-"`
+	CreateTime  time.Time        `gorm:"autoCreateTime;comment:命中时间" json:"create_time"`
+}
+
+func (ViolationRuleHit) TableName() string { return "violation_rule_hit" }
+
+// ////////////////////
+// 违规申诉表
+// ////////////////////
+type ViolationAppeal struct {
+	AppealID     uint             `gorm:"primaryKey;autoIncrement;comment:申诉ID" json:"appeal_id"`
+	ViolationID  uint             `gorm:"not null;index:idx_appeal_violation;comment:违规记录ID" json:"violation_id"`
+	Violation    *ViolationRecord `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:ViolationID;references:ViolationID" json:"violation,omitempty"`
+	UserID       uint             `gorm:"not null;index:idx_appeal_user;comment:申诉用户ID" json:"user_id"`
+	User         Users_list       `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:UserID;references:UserID" json:"-"`
+	Reason       string           `gorm:"type:text;not null;comment:申诉理由" json:"reason"`
+	Status       int8             `gorm:"default:0;index:idx_appeal_status;comment:申诉状态（0-待审核，1-维持原判，2-减免罚款，3-免除罚款）" json:"status"`
+	OriginalFine float64          `gorm:"type:decimal(10,2);not null;comment:申诉时的罚款金额" json:"original_fine"`
+	FinalFine    *float64         `gorm:"type:decimal(10,2);comment:审核后的罚款金额" json:"final_fine"`
+	ReviewerID   *uint            `gorm:"comment:审核管理员ID" json:"reviewer_id"`
+	Reviewer     *Admins          `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;foreignKey:ReviewerID;references:AdminID" json:"-"`
+	ReviewRemark string           `gorm:"size:255;comment:审核意见" json:"review_remark"`
+	CreateTime   time.Time        `gorm:"autoCreateTime;comment:提交时间" json:"create_time"`
+	ReviewTime   *time.Time       `gorm:"comment:审核时间" json:"review_time"`
+}
+
+func (ViolationAppeal) TableName() string { return "violation_appeal" }
+
+// ////////////////////
+// 违规变更历史表（状态与罚款金额的每次变更都留痕）
+// ////////////////////
+type ViolationHistory struct {
+	HistoryID    uint64           `gorm:"primaryKey;autoIncrement;comment:历史记录ID" json:"history_id"`
+	ViolationID  uint             `gorm:"not null;index:idx_history_violation;comment:违规记录ID" json:"violation_id"`
+	Violation    *ViolationRecord `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:ViolationID;references:ViolationID" json:"-"`
+	AppealID     *uint            `gorm:"index:idx_history_appeal;comment:关联申诉ID" json:"appeal_id"`
+	Appeal       *ViolationAppeal `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;foreignKey:AppealID;references:AppealID" json:"-"`
+	Action       string           `gorm:"size:30;not null;comment:变更动作" json:"action"`
+	OldStatus    int8             `gorm:"not null;comment:变更前状态" json:"old_status"`
+	NewStatus    int8             `gorm:"not null;comment:变更后状态" json:"new_status"`
+	OldFine      float64          `gorm:"type:decimal(10,2);not null;comment:变更前罚款金额" json:"old_fine"`
+	NewFine      float64          `gorm:"type:decimal(10,2);not null;comment:变更后罚款金额" json:"new_fine"`
+	OperatorType string           `gorm:"type:enum('user','admin');not null;comment:操作方" json:"operator_type"`
+	OperatorID   uint             `gorm:"not null;comment:操作人ID（用户ID或管理员ID）" json:"operator_id"`
+	Remark       string           `gorm:"size:255;comment:备注" json:"remark"`
+	CreateTime   time.Time        `gorm:"autoCreateTime;comment:变更时间" json:"create_time"`
+}
+
+func (ViolationHistory) TableName() string { return "violation_history" }
+
+// ////////////////////
+// 计费规则表
+// ////////////////////
+type TariffRule struct {
+	RuleID          uint       `gorm:"primaryKey;autoIncrement;comment:计费规则ID" json:"rule_id"`
+	LotID           uint       `gorm:"not null;index:idx_tariff_lot;comment:所属停车场ID" json:"lot_id"`
+	Lot             ParkingLot `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:LotID;references:LotID" json:"-"`
+	Name            string     `gorm:"size:100;comment:规则名称" json:"name"`
+	SpaceType       string     `gorm:"size:20;default:'';comment:适用车位类型（空表示全部类型）" json:"space_type"`
+	DayType         string     `gorm:"type:enum('all','weekday','weekend','holiday');default:'all';comment:适用日期类型" json:"day_type"`
+	GraceMinutes    int        `gorm:"default:0;comment:免费时长（分钟）" json:"grace_minutes"`
+	FirstHourRate   float64    `gorm:"type:decimal(8,2);default:0.00;comment:首小时费率" json:"first_hour_rate"`
+	HourlyRate      float64    `gorm:"type:decimal(8,2);default:0.00;comment:后续每小时费率（日间）" json:"hourly_rate"`
+	NightHourlyRate float64    `gorm:"type:decimal(8,2);default:0.00;comment:夜间每小时费率（0表示同日间）" json:"night_hourly_rate"`
+	NightStart      string     `gorm:"size:5;default:'22:00';comment:夜间开始时间（HH:MM）" json:"night_start"`
+	NightEnd        string     `gorm:"size:5;default:'07:00';comment:夜间结束时间（HH:MM）" json:"night_end"`
+	DailyCap        float64    `gorm:"type:decimal(8,2);default:0.00;comment:每日封顶金额（0表示不封顶）" json:"daily_cap"`
+	Status          int8       `gorm:"default:1;comment:状态（0-停用，1-启用）" json:"status"`
+	CreateTime      time.Time  `gorm:"autoCreateTime;comment:创建时间" json:"create_time"`
+	UpdateTime      time.Time  `gorm:"autoUpdateTime;comment:更新时间" json:"update_time"`
+}
+
+func (TariffRule) TableName() string { return "tariff_rule" }
+
+// ////////////////////
+// 节假日表（计费用）
+// ////////////////////
+type TariffHoliday struct {
+	HolidayID uint      `gorm:"primaryKey;autoIncrement;comment:节假日ID" json:"holiday_id"`
+	Date      time.Time `gorm:"type:date;unique;not null;comment:节假日日期" json:"date"`
+	Name      string    `gorm:"size:50;comment:节假日名称" json:"name"`
+}
+
+func (TariffHoliday) TableName() string { return "tariff_holiday" }
+
+// ////////////////////
+// 定时任务执行记录表
+// ////////////////////
+type JobRun struct {
+	RunID      uint64     `gorm:"primaryKey;autoIncrement;comment:执行记录ID" json:"run_id"`
+	JobName    string     `gorm:"size:50;not null;index:idx_job_run_name,priority:1;comment:任务名称" json:"job_name"`
+	Instance   string     `gorm:"size:100;not null;comment:执行实例（主机名-进程号）" json:"instance"`
+	Status     string     `gorm:"type:enum('running','success','failed');not null;default:'running';comment:执行状态" json:"status"`
+	Affected   int        `gorm:"default:0;comment:处理记录数" json:"affected"`
+	ErrorMsg   string     `gorm:"type:text;comment:错误信息" json:"error_msg"`
+	StartTime  time.Time  `gorm:"not null;index:idx_job_run_name,priority:2;comment:开始时间" json:"start_time"`
+	FinishTime *time.Time `gorm:"comment:结束时间" json:"finish_time"`
+	DurationMs int64      `gorm:"default:0;comment:耗时（毫秒）" json:"duration_ms"`
+}
+
+func (JobRun) TableName() string { return "job_run" }
+
+// ////////////////////
+// 通知发件箱表（事务内写入，由后台任务投递，失败按退避策略重试）
+// ////////////////////
+type NotificationOutbox struct {
+	OutboxID      uint64      `gorm:"primaryKey;autoIncrement;comment:发件记录ID" json:"outbox_id"`
+	Event         string      `gorm:"size:50;not null;comment:通知事件" json:"event"`
+	Channel       string      `gorm:"type:enum('sms','email','webhook','inbox');not null;comment:投递渠道" json:"channel"`
+	DedupeKey     *string     `gorm:"size:150;uniqueIndex:uk_outbox_dedupe;comment:去重键（同一事件同一渠道只投递一次）" json:"dedupe_key"`
+	UserID        *uint       `gorm:"index:idx_outbox_user;comment:接收用户ID" json:"user_id"`
+	User          *Users_list `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;foreignKey:UserID;references:UserID" json:"-"`
+	Recipient     string      `gorm:"size:255;not null;comment:接收地址（手机号/邮箱/回调地址/用户ID）" json:"recipient"`
+	Title         string      `gorm:"size:100;comment:标题" json:"title"`
+	Content       string      `gorm:"type:text;not null;comment:内容" json:"content"`
+	Payload       string      `gorm:"type:text;comment:模板参数（JSON）" json:"payload"`
+	Status        string      `gorm:"type:enum('pending','sent','failed');not null;default:'pending';index:idx_outbox_due,priority:1;comment:投递状态" json:"status"`
+	Attempts      int         `gorm:"default:0;comment:已尝试次数" json:"attempts"`
+	MaxAttempts   int         `gorm:"default:5;comment:最大尝试次数" json:"max_attempts"`
+	NextAttemptAt time.Time   `gorm:"not null;index:idx_outbox_due,priority:2;comment:下次投递时间" json:"next_attempt_at"`
+	LastError     string      `gorm:"size:500;comment:最近一次失败原因" json:"last_error"`
+	CreateTime    time.Time   `gorm:"autoCreateTime;comment:创建时间" json:"create_time"`
+	SentTime      *time.Time  `gorm:"comment:投递成功时间" json:"sent_time"`
+}
+
+func (NotificationOutbox) TableName() string { return "notification_outbox" }
+
+// ////////////////////
+// 站内信表
+// ////////////////////
+type UserNotification struct {
+	NotificationID uint64     `gorm:"primaryKey;autoIncrement;comment:站内信ID" json:"notification_id"`
+	UserID         uint       `gorm:"not null;index:idx_notification_user,priority:1;comment:用户ID" json:"user_id"`
+	User           Users_list `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:UserID;references:UserID" json:"-"`
+	OutboxID       *uint64    `gorm:"uniqueIndex:uk_notification_outbox;comment:来源发件记录ID" json:"-"`
+	Event          string     `gorm:"size:50;not null;comment:通知事件" json:"event"`
+	Title          string     `gorm:"size:100;comment:标题" json:"title"`
+	Content        string     `gorm:"type:text;not null;comment:内容" json:"content"`
+	IsRead         int8       `gorm:"default:0;index:idx_notification_user,priority:2;comment:是否已读（0-未读，1-已读）" json:"is_read"`
+	CreateTime     time.Time  `gorm:"autoCreateTime;comment:创建时间" json:"create_time"`
+	ReadTime       *time.Time `gorm:"comment:阅读时间" json:"read_time"`
+}
+
+func (UserNotification) TableName() string { return "user_notification" }
+
+// ////////////////////
+// 闸机设备表（按停车场登记，设备凭 API Key 或 HMAC 签名调用入场/出场接口）
+// ////////////////////
+type GateDevice struct {
+	GateID        uint        `gorm:"primaryKey;autoIncrement;comment:闸机ID" json:"gate_id"`
+	GateCode      string      `gorm:"size:50;not null;uniqueIndex:uk_gate_code;comment:闸机编码（设备请求头 X-Gate-Code）" json:"gate_code"`
+	Name          string      `gorm:"size:100;not null;comment:闸机名称" json:"name"`
+	LotID         uint        `gorm:"not null;index:idx_gate_lot;comment:所属停车场ID" json:"lot_id"`
+	Lot           *ParkingLot `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:LotID;references:LotID" json:"lot,omitempty"`
+	Direction     string      `gorm:"type:enum('in','out','both');not null;default:'both';comment:通行方向" json:"direction"`
+	AuthType      string      `gorm:"type:enum('api_key','hmac');not null;default:'hmac';comment:认证方式" json:"auth_type"`
+	SecretHash    string      `gorm:"size:64;comment:API Key 的 SHA-256 摘要（auth_type=api_key）" json:"-"`
+	HMACSecret    string      `gorm:"column:hmac_secret;size:128;comment:HMAC 签名密钥（auth_type=hmac）" json:"-"`
+	Status        int8        `gorm:"default:1;comment:状态（0-停用，1-启用）" json:"status"`
+	Online        int8        `gorm:"default:0;comment:是否在线（0-离线，1-在线）" json:"online"`
+	LastHeartbeat *time.Time  `gorm:"comment:最近心跳时间" json:"last_heartbeat"`
+	LastIP        string      `gorm:"size:45;comment:最近心跳来源IP" json:"last_ip"`
+	Firmware      string      `gorm:"size:50;comment:设备固件版本" json:"firmware"`
+	CreateTime    time.Time   `gorm:"autoCreateTime;comment:登记时间" json:"create_time"`
+	UpdateTime    time.Time   `gorm:"autoUpdateTime;comment:更新时间" json:"update_time"`
+}
+
+func (GateDevice) TableName() string { return "gate_device" }
+
+// ////////////////////
+// 闸机事件表（闸机离线期间缓存的入场/出场事件，恢复后批量补传，按闸机 + 事件ID去重）
+// ////////////////////
+type GateEvent struct {
+	EventID       uint64         `gorm:"primaryKey;autoIncrement;comment:事件记录ID" json:"event_id"`
+	GateID        uint           `gorm:"not null;uniqueIndex:uk_gate_event,priority:1;comment:上报闸机ID" json:"gate_id"`
+	Gate          *GateDevice    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;foreignKey:GateID;references:GateID" json:"gate,omitempty"`
+	EventUID      string         `gorm:"column:event_uid;size:64;not null;uniqueIndex:uk_gate_event,priority:2;comment:闸机生成的事件ID" json:"event_uid"`
+	LotID         uint           `gorm:"not null;index:idx_gate_event_lot,priority:1;comment:停车场ID" json:"lot_id"`
+	Direction     string         `gorm:"type:enum('in','out');not null;comment:通行方向" json:"direction"`
+	LicensePlate  string         `gorm:"size:20;index:idx_gate_event_plate;comment:车牌号" json:"license_plate"`
+	TicketCode    string         `gorm:"size:40;comment:停车凭证号（出场）" json:"ticket_code"`
+	SpaceType     string         `gorm:"size:20;comment:车位类型（入场）" json:"space_type"`
+	OccurredAt    time.Time      `gorm:"not null;comment:事件发生时间（闸机本地时间）" json:"occurred_at"`
+	ReceivedAt    time.Time      `gorm:"not null;comment:服务端接收时间" json:"received_at"`
+	Status        string         `gorm:"type:enum('pending','applied','conflict','failed','resolved','dismissed');not null;default:'pending';index:idx_gate_event_lot,priority:2;comment:处理状态" json:"status"`
+	ConflictType  string         `gorm:"size:30;comment:冲突类型" json:"conflict_type,omitempty"`
+	Message       string         `gorm:"size:255;comment:冲突或失败原因" json:"message,omitempty"`
+	RecordID      *uint          `gorm:"comment:生效后对应的停车记录ID" json:"record_id"`
+	Record        *ParkingRecord `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;foreignKey:RecordID;references:RecordID" json:"-"`
+	ProcessedAt   *time.Time     `gorm:"comment:最近处理时间" json:"processed_at"`
+	ResolveAction string         `gorm:"size:20;comment:人工处理方式" json:"resolve_action,omitempty"`
+	ResolverID    *uint          `gorm:"comment:处理人（管理员ID）" json:"resolver_id,omitempty"`
+	Resolver      *Admins        `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL;foreignKey:ResolverID;references:AdminID" json:"-"`
+	ResolveRemark string         `gorm:"size:255;comment:处理备注" json:"resolve_remark,omitempty"`
+	ResolveTime   *time.Time     `gorm:"comment:人工处理时间" json:"resolve_time,omitempty"`
+}
+
+func (GateEvent) TableName() string { return "gate_event" }
+
+// AccessEvent 通行日志：每次入场/出场尝试（含被拒绝、失败与人工处理）追加一条，只增不改
+// lot_id / gate_id 不设外键，停车场或闸机删除后日志仍保留
+type AccessEvent struct {
+	AccessID      uint64    `gorm:"primaryKey;autoIncrement;comment:日志ID" json:"access_id"`
+	Direction     string    `gorm:"type:enum('in','out');not null;comment:通行方向" json:"direction"`
+	Source        string    `gorm:"type:enum('api','gate','sync','manual');not null;comment:来源" json:"source"`
+	LotID         *uint     `gorm:"index:idx_access_lot_time,priority:1;comment:停车场ID" json:"lot_id"`
+	GateID        *uint     `gorm:"index:idx_access_gate;comment:闸机ID" json:"gate_id"`
+	GateCode      string    `gorm:"size:50;comment:闸机编码" json:"gate_code,omitempty"`
+	GateEventID   *uint64   `gorm:"comment:关联的闸机补传事件ID" json:"gate_event_id,omitempty"`
+	LicensePlate  string    `gorm:"size:20;index:idx_access_plate;comment:车牌号" json:"license_plate"`
+	TicketCode    string    `gorm:"size:40;comment:停车凭证号" json:"ticket_code,omitempty"`
+	Decision      string    `gorm:"type:enum('allowed','denied','error');not null;comment:处理结果" json:"decision"`
+	Reason        string    `gorm:"size:30;not null;index:idx_access_reason;comment:原因编码" json:"reason"`
+	Message       string    `gorm:"size:255;comment:原因说明" json:"message,omitempty"`
+	HTTPStatus    int       `gorm:"column:http_status;comment:处理结果对应的HTTP状态码（补传重放为等价状态码）" json:"http_status"`
+	ReservationID *uint     `gorm:"comment:匹配的预约ID" json:"reservation_id"`
+	SpaceID       *uint     `gorm:"comment:分配/释放的车位ID" json:"space_id"`
+	SpaceNumber   string    `gorm:"size:20;comment:车位编号" json:"space_number,omitempty"`
+	RecordID      *uint     `gorm:"comment:停车记录ID" json:"record_id"`
+	Fee           float64   `gorm:"type:decimal(10,2);default:0;comment:应付金额（出场）" json:"fee"`
+	LatencyMS     int64     `gorm:"column:latency_ms;comment:处理耗时（毫秒）" json:"latency_ms"`
+	OccurredAt    time.Time `gorm:"not null;index:idx_access_lot_time,priority:2;comment:通行时间（补传事件为事件发生时间）" json:"occurred_at"`
+	CreateTime    time.Time `gorm:"autoCreateTime;comment:记录时间" json:"create_time"`
+}
+
+func (AccessEvent) TableName() string { return "access_event" }
@@ -0,0 +1,135 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ChannelConfig 单个渠道的驱动配置
+type ChannelConfig struct {
+	Driver string `yaml:"driver"` // console / file / redis / smtp / webhook / inbox，为空或 none 表示不投递
+	Path   string `yaml:"path"`   // file 驱动的输出文件
+
+	// redis 驱动（仅 sms，本地调试用的假短信：按手机号写入 Redis 列表）
+	KeyPrefix string `yaml:"key_prefix"` // 键前缀，默认 notify:sms:
+	TTL       string `yaml:"ttl"`        // 保留时长，默认 10m
+
+	// smtp 驱动
+	SMTPHost string `yaml:"smtp_host"`
+	SMTPPort int    `yaml:"smtp_port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+
+	// webhook 驱动
+	URL     string `yaml:"url"`
+	Secret  string `yaml:"secret"`  // 非空时对请求体做 HMAC-SHA256 签名
+	Timeout string `yaml:"timeout"` // 请求超时，默认 5s
+}
+
+// TemplateConfig 单个事件的通知模板
+type TemplateConfig struct {
+	Channels  []string `yaml:"channels"`
+	Title     string   `yaml:"title"`
+	Content   string   `yaml:"content"`
+	Sensitive bool     `yaml:"sensitive"` // 内容含验证码等敏感信息：投递结束后清除发件箱中的内容与参数
+}
+
+// Config 映射 config/notify.yaml
+type Config struct {
+	Notify struct {
+		Enabled      bool                     `yaml:"enabled"`
+		MaxAttempts  int                      `yaml:"max_attempts"`
+		RetryBase    string                   `yaml:"retry_base"`
+		RetryMax     string                   `yaml:"retry_max"`
+		BatchSize    int                      `yaml:"batch_size"`
+		ReminderLead string                   `yaml:"reminder_lead"`
+		Channels     map[string]ChannelConfig `yaml:"channels"`
+	} `yaml:"notify"`
+	Templates map[string]TemplateConfig `yaml:"templates"`
+
+	retryBase    time.Duration
+	retryMax     time.Duration
+	reminderLead time.Duration
+	titles       map[string]*template.Template
+	contents     map[string]*template.Template
+}
+
+// LoadConfig 从 YAML 文件加载通知配置并预编译模板
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.init(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (c *Config) init() error {
+	n := &c.Notify
+	if n.MaxAttempts <= 0 {
+		n.MaxAttempts = 5
+	}
+	if n.BatchSize <= 0 {
+		n.BatchSize = 100
+	}
+	var err error
+	if c.retryBase, err = parseDuration(n.RetryBase, 30*time.Second); err != nil {
+		return fmt.Errorf("retry_base 无效: %w", err)
+	}
+	if c.retryMax, err = parseDuration(n.RetryMax, 30*time.Minute); err != nil {
+		return fmt.Errorf("retry_max 无效: %w", err)
+	}
+	if c.reminderLead, err = parseDuration(n.ReminderLead, 30*time.Minute); err != nil {
+		return fmt.Errorf("reminder_lead 无效: %w", err)
+	}
+
+	c.titles = make(map[string]*template.Template, len(c.Templates))
+	c.contents = make(map[string]*template.Template, len(c.Templates))
+	for event, t := range c.Templates {
+		if t.Content == "" {
+			return fmt.Errorf("模板 %s 缺少 content", event)
+		}
+		for _, ch := range t.Channels {
+			if !validChannel(ch) {
+				return fmt.Errorf("模板 %s 的渠道 %s 无效", event, ch)
+			}
+		}
+		if c.titles[event], err = template.New(event).Option("missingkey=error").Parse(t.Title); err != nil {
+			return fmt.Errorf("模板 %s 的 title 解析失败: %w", event, err)
+		}
+		if c.contents[event], err = template.New(event).Option("missingkey=error").Parse(t.Content); err != nil {
+			return fmt.Errorf("模板 %s 的 content 解析失败: %w", event, err)
+		}
+	}
+	return nil
+}
+
+// ReminderLead 预订开始前发送提醒的提前量
+func (c *Config) ReminderLead() time.Duration {
+	return c.reminderLead
+}
+
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("必须大于 0")
+	}
+	return d, nil
+}
@@ -0,0 +1,279 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// 投递渠道
+const (
+	ChannelSMS     = "sms"
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelInbox   = "inbox"
+)
+
+func validChannel(ch string) bool {
+	switch ch {
+	case ChannelSMS, ChannelEmail, ChannelWebhook, ChannelInbox:
+		return true
+	}
+	return false
+}
+
+// Message 一条待投递的通知
+type Message struct {
+	OutboxID  uint64          `json:"outbox_id"`
+	Event     string          `json:"event"`
+	Channel   string          `json:"channel"`
+	UserID    *uint           `json:"user_id,omitempty"`
+	Recipient string          `json:"recipient"`
+	Title     string          `json:"title"`
+	Content   string          `json:"content"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// Driver 渠道驱动：返回 nil 表示投递成功，返回错误时按退避策略重试
+type Driver interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// SMSSender 短信服务商接口：接入真实短信平台时实现该接口，并通过 Service.RegisterSMSSender 注册为 sms 渠道
+type SMSSender interface {
+	SendSMS(ctx context.Context, phone, content string) error
+}
+
+// smsDriver 将 SMSSender 适配为 sms 渠道驱动
+type smsDriver struct {
+	sender SMSSender
+}
+
+func (d smsDriver) Send(ctx context.Context, m *Message) error {
+	return d.sender.SendSMS(ctx, m.Recipient, m.Content)
+}
+
+// fakeDriver 本地调试驱动，不会真正发出短信/邮件
+func fakeDriver(driver string) bool {
+	return driver == "console" || driver == "file" || driver == "redis"
+}
+
+// newDriver 按渠道配置创建驱动，driver 为空或 none 时返回 nil（渠道未启用）
+func newDriver(channel string, cfg ChannelConfig) (Driver, error) {
+	switch cfg.Driver {
+	case "", "none":
+		return nil, nil
+	case "console":
+		return consoleDriver{}, nil
+	case "file":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("渠道 %s 的 file 驱动缺少 path", channel)
+		}
+		return &fileDriver{path: cfg.Path}, nil
+	case "redis":
+		if channel != ChannelSMS {
+			return nil, fmt.Errorf("redis 驱动只能用于 sms 渠道")
+		}
+		ttl, err := parseDuration(cfg.TTL, 10*time.Minute)
+		if err != nil {
+			return nil, fmt.Errorf("sms 渠道的 ttl 无效: %w", err)
+		}
+		prefix := cfg.KeyPrefix
+		if prefix == "" {
+			prefix = "notify:sms:"
+		}
+		return smsDriver{sender: &redisSMSSender{prefix: prefix, ttl: ttl}}, nil
+	case "smtp":
+		if channel != ChannelEmail {
+			return nil, fmt.Errorf("smtp 驱动只能用于 email 渠道")
+		}
+		if cfg.SMTPHost == "" || cfg.From == "" {
+			return nil, fmt.Errorf("email 渠道的 smtp 驱动缺少 smtp_host 或 from")
+		}
+		return &smtpDriver{cfg: cfg}, nil
+	case "webhook":
+		if channel != ChannelWebhook {
+			return nil, fmt.Errorf("webhook 驱动只能用于 webhook 渠道")
+		}
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("webhook 渠道缺少 url")
+		}
+		timeout, err := parseDuration(cfg.Timeout, 5*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("webhook 渠道的 timeout 无效: %w", err)
+		}
+		return &webhookDriver{url: cfg.URL, secret: cfg.Secret, client: &http.Client{Timeout: timeout}}, nil
+	case "inbox":
+		if channel != ChannelInbox {
+			return nil, fmt.Errorf("inbox 驱动只能用于 inbox 渠道")
+		}
+		return inboxDriver{}, nil
+	}
+	return nil, fmt.Errorf("渠道 %s 的驱动 %s 不支持", channel, cfg.Driver)
+}
+
+// ==================== console / file（本地调试） ====================
+
+// consoleDriver 将通知打印到日志
+type consoleDriver struct{}
+
+func (consoleDriver) Send(_ context.Context, m *Message) error {
+	log.Printf("📨 [%s] %s → %s: %s %s", m.Channel, m.Event, m.Recipient, m.Title, m.Content)
+	return nil
+}
+
+// fileDriver 将通知以 JSON 行追加写入文件
+type fileDriver struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (d *fileDriver) Send(_ context.Context, m *Message) error {
+	line, err := json.Marshal(struct {
+		*Message
+		Time string `json:"time"`
+	}{m, time.Now().Format(time.RFC3339)})
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(d.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(d.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// redisSMSSender 假短信：按手机号写入 Redis 列表 {prefix}{phone}（最新在前，保留最近 20 条），供本地调试与自动化测试读取
+type redisSMSSender struct {
+	prefix string
+	ttl    time.Duration
+}
+
+func (s *redisSMSSender) SendSMS(ctx context.Context, phone, content string) error {
+	if inits.RedisClient == nil {
+		return errors.New("Redis 未初始化")
+	}
+	item, err := json.Marshal(map[string]string{"content": content, "time": time.Now().Format(time.RFC3339)})
+	if err != nil {
+		return err
+	}
+	key := s.prefix + phone
+	pipe := inits.RedisClient.TxPipeline()
+	pipe.LPush(ctx, key, item)
+	pipe.LTrim(ctx, key, 0, 19)
+	pipe.Expire(ctx, key, s.ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// ==================== smtp（email） ====================
+
+type smtpDriver struct {
+	cfg ChannelConfig
+}
+
+func (d *smtpDriver) Send(_ context.Context, m *Message) error {
+	port := d.cfg.SMTPPort
+	if port == 0 {
+		port = 25
+	}
+	addr := d.cfg.SMTPHost + ":" + strconv.Itoa(port)
+	var auth smtp.Auth
+	if d.cfg.Username != "" {
+		auth = smtp.PlainAuth("", d.cfg.Username, d.cfg.Password, d.cfg.SMTPHost)
+	}
+
+	var body strings.Builder
+	body.WriteString("From: " + d.cfg.From + "\r\n")
+	body.WriteString("To: " + m.Recipient + "\r\n")
+	body.WriteString("Subject: " + m.Title + "\r\n")
+	body.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	body.WriteString(m.Content)
+
+	from := d.cfg.From
+	if i := strings.LastIndex(from, "<"); i >= 0 {
+		from = strings.TrimSuffix(from[i+1:], ">")
+	}
+	return smtp.SendMail(addr, auth, from, []string{m.Recipient}, []byte(body.String()))
+}
+
+// ==================== webhook ====================
+
+// webhookDriver 以 JSON POST 投递到配置的地址，2xx 视为成功
+type webhookDriver struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func (d *webhookDriver) Send(ctx context.Context, m *Message) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Notify-Event", m.Event)
+	if d.secret != "" {
+		mac := hmac.New(sha256.New, []byte(d.secret))
+		mac.Write(body)
+		req.Header.Set("X-Notify-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook 返回 HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ==================== inbox（站内信） ====================
+
+// inboxDriver 写入站内信表，同一发件记录只写入一次（重试时唯一索引去重）
+type inboxDriver struct{}
+
+func (inboxDriver) Send(_ context.Context, m *Message) error {
+	if m.UserID == nil {
+		return errors.New("站内信缺少接收用户")
+	}
+	return inits.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.UserNotification{
+		UserID:   *m.UserID,
+		OutboxID: &m.OutboxID,
+		Event:    m.Event,
+		Title:    m.Title,
+		Content:  m.Content,
+	}).Error
+}
@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"net/http"
+	"smart_parking_backend/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler 通知 HTTP 处理
+type Handler struct {
+	svc *Service
+}
+
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// isSystemAdmin 发件箱为全局数据，仅系统管理员可查看
+func isSystemAdmin(c *gin.Context) bool {
+	role, _ := c.Get("role")
+	r, _ := role.(string)
+	return r == "system"
+}
+
+// ==================== 用户端（站内信） ====================
+
+// ListInbox 查询当前用户的站内信
+// GET /api/notifications?unread=1&page=1&page_size=20
+func (h *Handler) ListInbox(c *gin.Context) {
+	page := utils.ParseInt(c.DefaultQuery("page", "1"), 1)
+	pageSize := utils.ParseInt(c.DefaultQuery("page_size", "20"), 20)
+
+	list, total, unread, err := h.svc.ListInbox(c.GetUint("user_id"), c.Query("unread") == "1", page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "查询站内信失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    gin.H{"total": total, "unread": unread, "page": page, "page_size": pageSize, "records": list},
+	})
+}
+
+// MarkRead 标记一条站内信已读
+// POST /api/notifications/:id/read
+func (h *Handler) MarkRead(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的站内信ID"})
+		return
+	}
+	if _, err := h.svc.MarkRead(c.GetUint("user_id"), id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "更新站内信失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success"})
+}
+
+// MarkAllRead 标记全部站内信已读
+// POST /api/notifications/read-all
+func (h *Handler) MarkAllRead(c *gin.Context) {
+	n, err := h.svc.MarkRead(c.GetUint("user_id"), 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "更新站内信失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": gin.H{"updated": n}})
+}
+
+// ==================== 管理端（发件箱） ====================
+
+// ListOutbox 分页查询发件记录
+// GET /admin/notify/outbox?status=failed&event=violation_issued&page=1&page_size=20
+func (h *Handler) ListOutbox(c *gin.Context) {
+	if !isSystemAdmin(c) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "仅系统管理员可查看通知发件箱"})
+		return
+	}
+	page := utils.ParseInt(c.DefaultQuery("page", "1"), 1)
+	pageSize := utils.ParseInt(c.DefaultQuery("page_size", "20"), 20)
+
+	list, total, err := h.svc.ListOutbox(c.Query("status"), c.Query("event"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "查询发件记录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    gin.H{"total": total, "page": page, "page_size": pageSize, "records": list},
+	})
+}
+
+// RetryOutbox 将投递失败的记录重新加入投递队列
+// POST /admin/notify/outbox/:id/retry
+func (h *Handler) RetryOutbox(c *gin.Context) {
+	if !isSystemAdmin(c) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "仅系统管理员可重试通知"})
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的发件记录ID"})
+		return
+	}
+	ok, err := h.svc.RetryFailed(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "重试失败"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"code": 409, "message": "发件记录不存在或不是失败状态"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "已重新加入投递队列"})
+}
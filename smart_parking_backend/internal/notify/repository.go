@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Repository 通知数据访问层
+type Repository struct{}
+
+// NewRepository 创建 Repository 实例
+func NewRepository() *Repository {
+	return &Repository{}
+}
+
+// ==================== 发件箱（NotificationOutbox）操作 ====================
+
+// CreateOutboxWithTx 逐条写入发件记录，去重键冲突的记录忽略（同一事件同一渠道只投递一次），被忽略记录的 OutboxID 为 0
+func (r *Repository) CreateOutboxWithTx(tx *gorm.DB, rows []model.NotificationOutbox) error {
+	for i := range rows {
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows[i])
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			rows[i].OutboxID = 0
+		}
+	}
+	return nil
+}
+
+// FindUserWithTx 查询接收用户的联系方式
+func (r *Repository) FindUserWithTx(tx *gorm.DB, userID uint) (*model.Users_list, error) {
+	var u model.Users_list
+	err := tx.Select("user_id", "phone", "email").First(&u, userID).Error
+	return &u, err
+}
+
+// FindDue 查询到期待投递的发件记录
+func (r *Repository) FindDue(now time.Time, limit int) ([]model.NotificationOutbox, error) {
+	var list []model.NotificationOutbox
+	err := inits.DB.Where("status = ? AND next_attempt_at <= ?", OutboxPending, now).
+		Order("next_attempt_at ASC, outbox_id ASC").Limit(limit).Find(&list).Error
+	return list, err
+}
+
+// FindByIDs 按ID查询发件记录
+func (r *Repository) FindByIDs(ids []uint64) ([]model.NotificationOutbox, error) {
+	var list []model.NotificationOutbox
+	err := inits.DB.Where("outbox_id IN ?", ids).Order("outbox_id ASC").Find(&list).Error
+	return list, err
+}
+
+// Claim 领取一条到期的发件记录：把下次投递时间推迟到 until，返回 false 表示已被其它投递方领取
+// 投递方崩溃时记录在 until 之后重新到期，保证至少投递一次
+func (r *Repository) Claim(outboxID uint64, now, until time.Time) (bool, error) {
+	res := inits.DB.Model(&model.NotificationOutbox{}).
+		Where("outbox_id = ? AND status = ? AND next_attempt_at <= ?", outboxID, OutboxPending, now).
+		Update("next_attempt_at", until)
+	return res.RowsAffected > 0, res.Error
+}
+
+// maskedContent 敏感通知投递结束后保留在发件箱中的内容
+const maskedContent = "[已清除]"
+
+// MarkSent 标记投递成功，mask 为 true 时清除内容与参数
+func (r *Repository) MarkSent(outboxID uint64, attempts int, now time.Time, mask bool) error {
+	updates := map[string]interface{}{"status": OutboxSent, "attempts": attempts, "sent_time": now, "last_error": ""}
+	if mask {
+		updates["content"], updates["payload"] = maskedContent, ""
+	}
+	return inits.DB.Model(&model.NotificationOutbox{}).Where("outbox_id = ?", outboxID).Updates(updates).Error
+}
+
+// MarkRetry 记录失败并设置下次投递时间
+func (r *Repository) MarkRetry(outboxID uint64, attempts int, next time.Time, errMsg string) error {
+	return inits.DB.Model(&model.NotificationOutbox{}).Where("outbox_id = ?", outboxID).
+		Updates(map[string]interface{}{"attempts": attempts, "next_attempt_at": next, "last_error": errMsg}).Error
+}
+
+// MarkFailed 超过最大尝试次数，标记为投递失败，mask 为 true 时清除内容与参数
+func (r *Repository) MarkFailed(outboxID uint64, attempts int, errMsg string, mask bool) error {
+	updates := map[string]interface{}{"status": OutboxFailed, "attempts": attempts, "last_error": errMsg}
+	if mask {
+		updates["content"], updates["payload"] = maskedContent, ""
+	}
+	return inits.DB.Model(&model.NotificationOutbox{}).Where("outbox_id = ?", outboxID).Updates(updates).Error
+}
+
+// ResetFailed 将投递失败的记录重新置为待投递，返回是否重置成功
+func (r *Repository) ResetFailed(outboxID uint64, now time.Time) (bool, error) {
+	res := inits.DB.Model(&model.NotificationOutbox{}).
+		Where("outbox_id = ? AND status = ? AND content <> ?", outboxID, OutboxFailed, maskedContent). // 已清除内容的敏感通知不能重试
+		Updates(map[string]interface{}{"status": OutboxPending, "attempts": 0, "next_attempt_at": now})
+	return res.RowsAffected > 0, res.Error
+}
+
+// FindOutbox 分页查询发件记录（status / event 为空时不过滤）
+func (r *Repository) FindOutbox(status, event string, offset, limit int) ([]model.NotificationOutbox, int64, error) {
+	var list []model.NotificationOutbox
+	var total int64
+	query := inits.DB.Model(&model.NotificationOutbox{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if event != "" {
+		query = query.Where("event = ?", event)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("outbox_id DESC").Offset(offset).Limit(limit).Find(&list).Error
+	return list, total, err
+}
+
+// ==================== 站内信（UserNotification）操作 ====================
+
+// FindInbox 分页查询用户站内信，同时返回未读数
+func (r *Repository) FindInbox(userID uint, unreadOnly bool, offset, limit int) ([]model.UserNotification, int64, int64, error) {
+	var list []model.UserNotification
+	var total, unread int64
+	if err := inits.DB.Model(&model.UserNotification{}).
+		Where("user_id = ? AND is_read = 0", userID).Count(&unread).Error; err != nil {
+		return nil, 0, 0, err
+	}
+	query := inits.DB.Model(&model.UserNotification{}).Where("user_id = ?", userID)
+	if unreadOnly {
+		query = query.Where("is_read = 0")
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, 0, err
+	}
+	err := query.Order("notification_id DESC").Offset(offset).Limit(limit).Find(&list).Error
+	return list, total, unread, err
+}
+
+// MarkRead 标记站内信已读（notificationID 为 0 时标记该用户全部未读），返回更新条数
+func (r *Repository) MarkRead(userID uint, notificationID uint64, now time.Time) (int64, error) {
+	query := inits.DB.Model(&model.UserNotification{}).Where("user_id = ? AND is_read = 0", userID)
+	if notificationID > 0 {
+		query = query.Where("notification_id = ?", notificationID)
+	}
+	res := query.Updates(map[string]interface{}{"is_read": 1, "read_time": now})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,27 @@
+package notify
+
+import (
+	"smart_parking_backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NotifyRoutes 注册站内信与通知发件箱管理路由
+func NotifyRoutes(r *gin.Engine, svc *Service) {
+	handler := NewHandler(svc)
+
+	inbox := r.Group("/api/notifications")
+	inbox.Use(middleware.UserAuthMiddleware())
+	{
+		inbox.GET("", handler.ListInbox)             // 查询站内信
+		inbox.POST("/read-all", handler.MarkAllRead) // 全部标记已读
+		inbox.POST("/:id/read", handler.MarkRead)    // 标记已读
+	}
+
+	admin := r.Group("/admin/notify")
+	admin.Use(middleware.AdminAuthMiddleware())
+	{
+		admin.GET("/outbox", handler.ListOutbox)             // 查询发件记录
+		admin.POST("/outbox/:id/retry", handler.RetryOutbox) // 重试投递失败的通知
+	}
+}
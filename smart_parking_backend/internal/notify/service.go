@@ -0,0 +1,352 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 通知事件（与 config/notify.yaml 中的模板名一致）
+const (
+	EventViolationIssued     = "violation_issued"
+	EventReservationReminder = "reservation_reminder"
+	EventPaymentReceipt      = "payment_receipt"
+	EventLoginCode           = "login_code"
+)
+
+// 发件记录状态
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// claimLease 投递方领取发件记录后的占用时长，超时未回写结果时记录重新到期
+const claimLease = 5 * time.Minute
+
+// Recipient 通知接收方：UserID 不为 0 时按用户资料补全手机号与邮箱；未注册用户（如验证码登录）只填手机号
+type Recipient struct {
+	UserID uint
+	Phone  string
+	Email  string
+}
+
+// Service 通知服务：业务代码按事件写入发件箱，由 Dispatch 按渠道驱动投递并重试
+type Service struct {
+	cfg     *Config
+	repo    *Repository
+	drivers map[string]Driver
+}
+
+// NewService 按配置创建各渠道驱动
+func NewService(cfg *Config, repo *Repository) (*Service, error) {
+	s := &Service{cfg: cfg, repo: repo, drivers: make(map[string]Driver)}
+	for ch, cc := range cfg.Notify.Channels {
+		if !validChannel(ch) {
+			return nil, fmt.Errorf("未知的通知渠道: %s", ch)
+		}
+		d, err := newDriver(ch, cc)
+		if err != nil {
+			return nil, err
+		}
+		if d != nil {
+			s.drivers[ch] = d
+		}
+		if ch == ChannelSMS && fakeDriver(cc.Driver) {
+			log.Printf("⚠️  短信渠道使用本地调试驱动 %s，短信不会真正发出，生产环境请注册短信服务商", cc.Driver)
+		}
+	}
+	return s, nil
+}
+
+// RegisterSMSSender 注册短信服务商，替换配置文件中的 sms 渠道驱动
+func (s *Service) RegisterSMSSender(sender SMSSender) {
+	s.drivers[ChannelSMS] = smsDriver{sender: sender}
+}
+
+// ChannelEnabled 渠道是否已启用（配置了驱动）
+func (s *Service) ChannelEnabled(channel string) bool {
+	return s.cfg.Notify.Enabled && s.drivers[channel] != nil
+}
+
+// Notify 写入发件箱后立即尝试投递（适用于不在业务事务中的通知，如登录验证码），投递失败由后台任务重试
+func (s *Service) Notify(event string, to Recipient, dedupeKey string, data map[string]interface{}) error {
+	rows, err := s.build(inits.DB, event, to, dedupeKey, data)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+	if err := s.repo.CreateOutboxWithTx(inits.DB, rows); err != nil {
+		return fmt.Errorf("写入通知发件箱失败: %w", err)
+	}
+	ids := make([]uint64, 0, len(rows))
+	for _, r := range rows {
+		if r.OutboxID > 0 {
+			ids = append(ids, r.OutboxID)
+		}
+	}
+	go s.deliverNow(ids)
+	return nil
+}
+
+// NotifyWithTx 在业务事务中写入发件箱，事务提交后由后台任务投递；事务回滚时通知一并撤销
+// 只有写库失败时返回错误；模板渲染失败只记录日志，不影响业务事务
+func (s *Service) NotifyWithTx(tx *gorm.DB, event string, to Recipient, dedupeKey string, data map[string]interface{}) error {
+	rows, err := s.build(tx, event, to, dedupeKey, data)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+	if err := s.repo.CreateOutboxWithTx(tx, rows); err != nil {
+		return fmt.Errorf("写入通知发件箱失败: %w", err)
+	}
+	return nil
+}
+
+// build 按事件模板为每个启用的渠道生成一条发件记录
+func (s *Service) build(tx *gorm.DB, event string, to Recipient, dedupeKey string, data map[string]interface{}) ([]model.NotificationOutbox, error) {
+	if !s.cfg.Notify.Enabled {
+		return nil, nil
+	}
+	tpl, ok := s.cfg.Templates[event]
+	if !ok {
+		return nil, nil // 未配置模板的事件不发送
+	}
+
+	title, content, err := s.render(event, data)
+	if err != nil {
+		log.Printf("⚠️  通知 %s 模板渲染失败，已跳过: %v", event, err)
+		return nil, nil
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("⚠️  通知 %s 参数序列化失败，已跳过: %v", event, err)
+		return nil, nil
+	}
+
+	if to.UserID > 0 && (to.Phone == "" || to.Email == "") {
+		u, err := s.repo.FindUserWithTx(tx, to.UserID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			if to.Phone == "" {
+				to.Phone = u.Phone
+			}
+			if to.Email == "" {
+				to.Email = u.Email
+			}
+		}
+	}
+
+	now := time.Now()
+	rows := make([]model.NotificationOutbox, 0, len(tpl.Channels))
+	for _, ch := range tpl.Channels {
+		if s.drivers[ch] == nil {
+			continue
+		}
+		recipient := s.recipientFor(ch, to)
+		if recipient == "" {
+			continue // 接收方没有该渠道的联系方式
+		}
+		row := model.NotificationOutbox{
+			Event:         event,
+			Channel:       ch,
+			Recipient:     recipient,
+			Title:         title,
+			Content:       content,
+			Payload:       string(payload),
+			Status:        OutboxPending,
+			MaxAttempts:   s.cfg.Notify.MaxAttempts,
+			NextAttemptAt: now,
+		}
+		if to.UserID > 0 {
+			uid := to.UserID
+			row.UserID = &uid
+		}
+		if dedupeKey != "" {
+			key := dedupeKey + ":" + ch
+			row.DedupeKey = &key
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// recipientFor 渠道对应的接收地址
+func (s *Service) recipientFor(channel string, to Recipient) string {
+	switch channel {
+	case ChannelSMS:
+		return to.Phone
+	case ChannelEmail:
+		return to.Email
+	case ChannelWebhook:
+		return s.cfg.Notify.Channels[ChannelWebhook].URL
+	case ChannelInbox:
+		if to.UserID > 0 {
+			return strconv.FormatUint(uint64(to.UserID), 10)
+		}
+	}
+	return ""
+}
+
+func (s *Service) render(event string, data map[string]interface{}) (string, string, error) {
+	var title, content bytes.Buffer
+	if err := s.cfg.titles[event].Execute(&title, data); err != nil {
+		return "", "", err
+	}
+	if err := s.cfg.contents[event].Execute(&content, data); err != nil {
+		return "", "", err
+	}
+	return title.String(), content.String(), nil
+}
+
+// ==================== 投递 ====================
+
+// Dispatch 投递到期的发件记录，返回本次投递成功的条数（由定时任务 notify_dispatch 调用）
+func (s *Service) Dispatch(ctx context.Context) (int, error) {
+	if !s.cfg.Notify.Enabled {
+		return 0, nil
+	}
+	rows, err := s.repo.FindDue(time.Now(), s.cfg.Notify.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for i := range rows {
+		if ctx.Err() != nil {
+			break
+		}
+		if s.deliver(ctx, &rows[i]) {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// deliverNow 写入后立即投递指定的发件记录
+func (s *Service) deliverNow(ids []uint64) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("❌ 通知即时投递 panic: %v", r)
+		}
+	}()
+	if len(ids) == 0 {
+		return
+	}
+	rows, err := s.repo.FindByIDs(ids)
+	if err != nil {
+		log.Printf("⚠️  通知即时投递查询失败，等待后台重试: %v", err)
+		return
+	}
+	for i := range rows {
+		s.deliver(context.Background(), &rows[i])
+	}
+}
+
+// deliver 领取并投递一条发件记录，失败时按指数退避安排重试，超过最大次数标记为失败
+func (s *Service) deliver(ctx context.Context, row *model.NotificationOutbox) bool {
+	now := time.Now()
+	claimed, err := s.repo.Claim(row.OutboxID, now, now.Add(claimLease))
+	if err != nil || !claimed {
+		return false
+	}
+
+	attempts := row.Attempts + 1
+	driver := s.drivers[row.Channel]
+	if driver == nil {
+		s.logResult(row, s.repo.MarkFailed(row.OutboxID, attempts, "渠道未启用", s.sensitive(row.Event)))
+		return false
+	}
+
+	sendErr := driver.Send(ctx, &Message{
+		OutboxID:  row.OutboxID,
+		Event:     row.Event,
+		Channel:   row.Channel,
+		UserID:    row.UserID,
+		Recipient: row.Recipient,
+		Title:     row.Title,
+		Content:   row.Content,
+		Payload:   json.RawMessage(row.Payload),
+	})
+	if sendErr == nil {
+		s.logResult(row, s.repo.MarkSent(row.OutboxID, attempts, time.Now(), s.sensitive(row.Event)))
+		return true
+	}
+
+	errMsg := sendErr.Error()
+	if len(errMsg) > 500 {
+		errMsg = errMsg[:500]
+	}
+	if attempts >= row.MaxAttempts {
+		log.Printf("❌ 通知 %d（%s/%s）投递失败 %d 次，已停止重试: %v", row.OutboxID, row.Event, row.Channel, attempts, sendErr)
+		s.logResult(row, s.repo.MarkFailed(row.OutboxID, attempts, errMsg, s.sensitive(row.Event)))
+		return false
+	}
+	s.logResult(row, s.repo.MarkRetry(row.OutboxID, attempts, time.Now().Add(s.backoff(attempts)), errMsg))
+	return false
+}
+
+// sensitive 事件内容是否敏感（投递结束后需清除）
+func (s *Service) sensitive(event string) bool {
+	return s.cfg.Templates[event].Sensitive
+}
+
+// backoff 第 n 次失败后的等待时间：retry_base × 2^(n-1)，不超过 retry_max
+func (s *Service) backoff(attempts int) time.Duration {
+	d := s.cfg.retryBase
+	for i := 1; i < attempts && d < s.cfg.retryMax; i++ {
+		d *= 2
+	}
+	if d > s.cfg.retryMax {
+		d = s.cfg.retryMax
+	}
+	return d
+}
+
+func (s *Service) logResult(row *model.NotificationOutbox, err error) {
+	if err != nil {
+		log.Printf("⚠️  更新通知 %d 投递结果失败: %v", row.OutboxID, err)
+	}
+}
+
+// ==================== 查询与管理 ====================
+
+// ListOutbox 分页查询发件记录
+func (s *Service) ListOutbox(status, event string, page, pageSize int) ([]model.NotificationOutbox, int64, error) {
+	page, pageSize = normalizePage(page, pageSize)
+	return s.repo.FindOutbox(status, event, (page-1)*pageSize, pageSize)
+}
+
+// RetryFailed 将投递失败的记录重新加入投递队列
+func (s *Service) RetryFailed(outboxID uint64) (bool, error) {
+	return s.repo.ResetFailed(outboxID, time.Now())
+}
+
+// ListInbox 分页查询用户站内信
+func (s *Service) ListInbox(userID uint, unreadOnly bool, page, pageSize int) ([]model.UserNotification, int64, int64, error) {
+	page, pageSize = normalizePage(page, pageSize)
+	return s.repo.FindInbox(userID, unreadOnly, (page-1)*pageSize, pageSize)
+}
+
+// MarkRead 标记站内信已读（notificationID 为 0 时全部标记已读）
+func (s *Service) MarkRead(userID uint, notificationID uint64) (int64, error) {
+	return s.repo.MarkRead(userID, notificationID, time.Now())
+}
+
+func normalizePage(page, pageSize int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	return page, pageSize
+}
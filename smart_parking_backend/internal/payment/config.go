@@ -2,22 +2,42 @@ package payment
 
 import (
 	"os"
+	"smart_parking_backend/internal/inits"
 
 	"gopkg.in/yaml.v3"
 )
 
-// Config 映射 YAML 配置（保留原有 alipay 字段，便于未来扩展）
+// ProviderConfig 单个支付渠道的配置
+type ProviderConfig struct {
+	AppID           string `yaml:"app_id"`
+	PrivateKeyPath  string `yaml:"private_key_path"`
+	PublicKeyPath   string `yaml:"public_key_path"` // RSA2 验签使用的平台公钥
+	NotifyURL       string `yaml:"notify_url"`
+	ReturnURL       string `yaml:"return_url"`
+	GatewayURL      string `yaml:"gateway_url"`
+	Charset         string `yaml:"charset"`
+	SignType        string `yaml:"sign_type"`         // "RSA2" | "HMAC-SHA256"
+	NotifySecretEnv string `yaml:"notify_secret_env"` // 从环境变量（支持 .env 文件）读取 HMAC-SHA256 验签密钥，优先于 notify_secret
+	NotifySecret    string `yaml:"notify_secret"`     // HMAC-SHA256 验签密钥，仅建议本地调试使用
+}
+
+// Config 映射 YAML 配置（payment_gateway 下按渠道配置，便于未来扩展）
 type Config struct {
-	Alipay struct {
-		AppID          string `yaml:"app_id"`
-		PrivateKeyPath string `yaml:"private_key_path"`
-		PublicKeyPath  string `yaml:"public_key_path"`
-		NotifyURL      string `yaml:"notify_url"`
-		ReturnURL      string `yaml:"return_url"`
-		GatewayURL     string `yaml:"gateway_url"`
-		Charset        string `yaml:"charset"`
-		SignType       string `yaml:"sign_type"`
-	} `yaml:"alipay"`
+	PaymentGateway struct {
+		Alipay ProviderConfig `yaml:"alipay"`
+		Wechat ProviderConfig `yaml:"wechat"`
+	} `yaml:"payment_gateway"`
+
+	// 回调校验配置
+	Notify struct {
+		MaxSkewSeconds int `yaml:"max_skew_seconds"` // 回调时间戳允许的最大偏差（秒），默认 300
+	} `yaml:"notify"`
+
+	// 沙箱模式：开启后提供 /api/payment/notify/sandbox 供模拟支付页面直接确认支付，生产环境必须关闭
+	// 需同时设置环境变量 PAYMENT_SANDBOX=true 才生效，见 SandboxEnabled
+	Sandbox struct {
+		Enabled bool `yaml:"enabled"`
+	} `yaml:"sandbox"`
 
 	// 可选：模拟支付页面的基础地址，前端 QT 可在该地址启动页面（若 YAML 未配置则使用默认）
 	SimulateHost string `yaml:"simulate_host"`
@@ -33,13 +53,46 @@ func LoadSandboxConfig(path string) (*Config, error) {
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+	cfg.PaymentGateway.Alipay.loadSecret()
+	cfg.PaymentGateway.Wechat.loadSecret()
 	return &cfg, nil
 }
 
+// loadSecret 配置了 notify_secret_env 时从环境变量读取验签密钥
+func (p *ProviderConfig) loadSecret() {
+	if p.NotifySecretEnv == "" {
+		return
+	}
+	if v := inits.GetEnv(p.NotifySecretEnv); v != "" {
+		p.NotifySecret = v
+	}
+}
+
+// sandboxEnvKey 开发环境显式开启沙箱模式的环境变量
+const sandboxEnvKey = "PAYMENT_SANDBOX"
+
+// SandboxEnabled 沙箱模式是否生效：配置文件开启且环境变量 PAYMENT_SANDBOX=true（仅开发环境设置），
+// 避免误发布的配置在生产环境暴露不验签的回调
+func (c *Config) SandboxEnabled() bool {
+	return c != nil && c.Sandbox.Enabled && os.Getenv(sandboxEnvKey) == "true"
+}
+
+// Provider 按渠道名称获取配置
+func (c *Config) Provider(name string) (*ProviderConfig, bool) {
+	switch name {
+	case "alipay":
+		return &c.PaymentGateway.Alipay, true
+	case "wechat":
+		return &c.PaymentGateway.Wechat, true
+	default:
+		return nil, false
+	}
+}
+
 // Helper 方法：暴露返回与通知 URL
 func (c *Config) AlipayNotifyURL() string {
-	return c.Alipay.NotifyURL
+	return c.PaymentGateway.Alipay.NotifyURL
 }
 func (c *Config) AlipayReturnURL() string {
-	return c.Alipay.ReturnURL
+	return c.PaymentGateway.Alipay.ReturnURL
 }
@@ -1,7 +1,15 @@
 package payment
 
 import (
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"smart_parking_backend/internal/booking"
+	"smart_parking_backend/internal/middleware"
+	"smart_parking_backend/internal/model"
+	"smart_parking_backend/internal/wallet"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,11 +25,12 @@ func NewHandler(svc *Service) *Handler {
 
 // CreatePaymentReq 请求体
 type CreatePaymentReq struct {
-	OrderID uint     `json:"order_id" binding:"required"` // 对应三类记录的 ID（reservation->OrderID, parking->RecordID, violation->ViolationID）
-	Type    string   `json:"type" binding:"required"`     // "reservation" | "parking" | "violation"
-	Method  string   `json:"method" binding:"required"`   // "alipay" | "wechat"
-	Amount  *float64 `json:"amount,omitempty"`            // 可选：前端可传金额（如停车场/罚单），对于 reservation 若传入覆盖订单金额
-	// 备注：如果 amount 不传，则根据后端查出的应付金额自动使用
+	OrderID      uint   `json:"order_id"`                  // 对应三类记录的 ID（reservation->OrderID, parking->RecordID, violation->ViolationID）
+	Type         string `json:"type" binding:"required"`   // "reservation" | "parking" | "violation"
+	Method       string `json:"method" binding:"required"` // "alipay" | "wechat"
+	TicketCode   string `json:"ticket_code,omitempty"`     // 可选：parking 类型可凭停车凭证号支付（访客停车）
+	LicensePlate string `json:"license_plate,omitempty"`   // 可选：parking 类型可凭车牌号支付（仅本人的停车记录）
+	// 备注：支付金额一律由后端按单据应付金额确定，客户端传入的 amount 会被忽略
 }
 
 type CreatePaymentResp struct {
@@ -36,7 +45,85 @@ func (h *Handler) CreatePaymentRedirectHandler(c *gin.Context) {
 		return
 	}
 
-	url, paymentID, err := h.svc.CreatePayment(req.OrderID, req.Type, req.Method, req.Amount)
+	// 停车支付按停车凭证号或车牌号定位停车记录；携带凭证号时必须与 order_id 指向同一条记录
+	byTicket, err := h.resolveParkingTarget(&req)
+	if err == nil {
+		// 只能为本人的单据创建支付（访客停车凭停车凭证号）
+		err = h.svc.CheckPayableOwner(req.Type, req.OrderID, c.GetUint("user_id"), byTicket)
+	}
+	if err != nil {
+		respondPayableError(c, err)
+		return
+	}
+	h.respondCreatePayment(c, req.OrderID, req.Type, req.Method)
+}
+
+// GuestParkingPaymentReq 访客停车支付请求体（无需登录，凭停车凭证号支付）
+type GuestParkingPaymentReq struct {
+	TicketCode string `json:"ticket_code" binding:"required"` // 停车凭证号
+	Method     string `json:"method" binding:"required"`      // "alipay" | "wechat"
+}
+
+// GuestParkingPaymentHandler 访客（未注册车辆）凭停车凭证号创建停车费支付，持票即视为车主
+func (h *Handler) GuestParkingPaymentHandler(c *gin.Context) {
+	var req GuestParkingPaymentReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+	recordID, err := h.svc.ResolveParkingRecordID(req.TicketCode, "")
+	if err == nil {
+		err = h.svc.CheckPayableOwner(model.PayableParking, recordID, 0, true)
+	}
+	if err != nil {
+		respondPayableError(c, err)
+		return
+	}
+	h.respondCreatePayment(c, recordID, model.PayableParking, req.Method)
+}
+
+// resolveParkingTarget 停车支付定位停车记录：凭证号每次都解析，与 order_id 不一致时拒绝；未提供 order_id 时再按车牌号查找
+// 返回是否凭停车凭证号定位（凭票支付）
+func (h *Handler) resolveParkingTarget(req *CreatePaymentReq) (bool, error) {
+	if req.Type != model.PayableParking {
+		if req.OrderID == 0 {
+			return false, errors.New("参数错误: order_id 不能为空")
+		}
+		return false, nil
+	}
+	if req.TicketCode != "" {
+		recordID, err := h.svc.ResolveParkingRecordID(req.TicketCode, "")
+		if err != nil {
+			return false, err
+		}
+		if req.OrderID != 0 && req.OrderID != recordID {
+			return false, ErrNotPayableOwner
+		}
+		req.OrderID = recordID
+		return true, nil
+	}
+	if req.OrderID == 0 {
+		recordID, err := h.svc.ResolveParkingRecordID("", req.LicensePlate)
+		if err != nil {
+			return false, err
+		}
+		req.OrderID = recordID
+	}
+	return false, nil
+}
+
+// respondPayableError 单据定位或归属校验失败：无权支付返回 403，其它返回 400
+func respondPayableError(c *gin.Context, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, ErrNotPayableOwner) {
+		status = http.StatusForbidden
+	}
+	c.JSON(status, gin.H{"code": status, "message": err.Error()})
+}
+
+// respondCreatePayment 创建支付并返回模拟支付跳转链接
+func (h *Handler) respondCreatePayment(c *gin.Context, id uint, typ, method string) {
+	url, paymentID, err := h.svc.CreatePayment(id, typ, method)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
 		return
@@ -50,28 +137,317 @@ func (h *Handler) CreatePaymentRedirectHandler(c *gin.Context) {
 	})
 }
 
-// NotifyReq 模拟回调请求体（由模拟支付页面调用）
-type NotifyReq struct {
+// NotifyHandler 接收支付渠道回调：验签 → 时间戳/nonce 防重放 → 金额核对 → 更新 payment_record 与关联订单
+// 支持 JSON 与表单两种提交方式，签名规则见 CanonicalString
+func (h *Handler) NotifyHandler(c *gin.Context) {
+	var req NotifyParams
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+
+	if err := h.svc.VerifyNotify(&req); err != nil {
+		log.Printf("支付回调校验失败: payment_id=%s provider=%s err=%v", req.PaymentID, req.Provider, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "message": err.Error()})
+		return
+	}
+
+	paymentID, err := strconv.ParseUint(req.PaymentID, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: payment_id 无效"})
+		return
+	}
+	amount, err := strconv.ParseFloat(req.Amount, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: amount 无效"})
+		return
+	}
+
+	raw, _ := json.Marshal(req)
+	payment, err := h.svc.HandleNotify(paymentID, amount, req.Provider, req.TransactionNo, string(raw))
+	if err != nil {
+		// 结算未成功，释放 nonce，渠道重试同一回调时可以重新结算
+		h.svc.ReleaseNotifyNonce(&req)
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrPaymentQuarantined) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"code": status, "message": err.Error()})
+		return
+	}
+
+	// 返回 success，模拟支付宝回调习惯
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "payment_id": payment.PaymentID})
+}
+
+// SandboxNotifyReq 沙箱模式下模拟支付页面提交的确认请求
+type SandboxNotifyReq struct {
 	PaymentID     uint64  `json:"payment_id" binding:"required"`
 	Amount        float64 `json:"amount" binding:"required"`
 	TransactionNo string  `json:"transaction_no" binding:"required"`
 	Provider      string  `json:"provider" binding:"required"` // "alipay" | "wechat"
 }
 
-// NotifyHandler 统一接收模拟支付回调并处理（更新 payment_record 与关联订单）
-func (h *Handler) NotifyHandler(c *gin.Context) {
-	var req NotifyReq
+// SandboxNotifyHandler 沙箱模式下由模拟支付页面直接确认支付（不验签，但同样核对金额并写入隔离表）
+// 仅当 payment_sandbox.yaml 中 sandbox.enabled 为 true 时注册该路由
+func (h *Handler) SandboxNotifyHandler(c *gin.Context) {
+	var req SandboxNotifyReq
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
 		return
 	}
 
-	payment, err := h.svc.HandleNotify(req.PaymentID, req.Amount, req.Provider, req.TransactionNo)
+	raw, _ := json.Marshal(req)
+	payment, err := h.svc.HandleNotify(req.PaymentID, req.Amount, req.Provider, req.TransactionNo, string(raw))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrPaymentQuarantined) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"code": status, "message": err.Error()})
 		return
 	}
 
-	// 返回 success，模拟支付宝回调习惯
 	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "payment_id": payment.PaymentID})
 }
+
+// WalletPayReq 钱包支付请求体
+type WalletPayReq struct {
+	OrderID      uint   `json:"order_id"`                // 对应三类记录的 ID（reservation->OrderID, parking->RecordID, violation->ViolationID）
+	Type         string `json:"type" binding:"required"` // "reservation" | "parking" | "violation"
+	TicketCode   string `json:"ticket_code,omitempty"`   // 可选：parking 类型可凭停车凭证号定位停车记录
+	LicensePlate string `json:"license_plate,omitempty"` // 可选：parking 类型可凭车牌号定位停车记录
+}
+
+// WalletPayHandler 使用钱包余额支付，即时结算，不返回跳转链接
+// POST /api/payment/wallet/pay
+func (h *Handler) WalletPayHandler(c *gin.Context) {
+	var req WalletPayReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+
+	if req.OrderID == 0 {
+		if req.Type != "parking" {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: order_id 不能为空"})
+			return
+		}
+		recordID, err := h.svc.ResolveParkingRecordID(req.TicketCode, req.LicensePlate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+			return
+		}
+		req.OrderID = recordID
+	}
+
+	userID := c.GetUint("user_id")
+	payment, err := h.svc.PayWithWallet(req.OrderID, req.Type, userID)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, wallet.ErrInsufficientBalance):
+			status = http.StatusPaymentRequired
+		case errors.Is(err, wallet.ErrWalletFrozen):
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"code": status, "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "支付成功", "payment_id": payment.PaymentID, "data": payment})
+}
+
+// ==================== 退款 ====================
+
+// refundErrorStatus 退款业务错误对应的 HTTP 状态码
+func refundErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, booking.ErrBookingNotCancellable), errors.Is(err, ErrRefundNotAllowed):
+		return http.StatusConflict
+	case errors.Is(err, ErrRefundProviderUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// QuoteBookingRefundHandler 取消预订退款试算
+// GET /api/payment/refund/booking/:id/quote
+func (h *Handler) QuoteBookingRefundHandler(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的订单ID"})
+		return
+	}
+	userID := c.GetUint("user_id")
+
+	quote, err := h.svc.QuoteBookingRefund(uint(orderID), userID)
+	if err != nil {
+		status := refundErrorStatus(err)
+		c.JSON(status, gin.H{"code": status, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": quote})
+}
+
+// CancelBookingRefundReq 用户取消预订请求体
+type CancelBookingRefundReq struct {
+	Reason string `json:"reason"` // 可选：取消原因
+}
+
+// CancelBookingWithRefundHandler 用户取消已支付的预订并按停车场退款策略退款
+// POST /api/payment/refund/booking/:id
+func (h *Handler) CancelBookingWithRefundHandler(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的订单ID"})
+		return
+	}
+	var req CancelBookingRefundReq
+	_ = c.ShouldBindJSON(&req) // 请求体可选
+	userID := c.GetUint("user_id")
+
+	quote, refund, err := h.svc.CancelBookingWithRefund(uint(orderID), userID, req.Reason)
+	if err != nil {
+		if quote != nil {
+			// 订单已取消但渠道退款失败
+			c.JSON(http.StatusBadGateway, gin.H{"code": 502, "message": err.Error(), "data": gin.H{"quote": quote, "refund": refund}})
+			return
+		}
+		status := refundErrorStatus(err)
+		c.JSON(status, gin.H{"code": status, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "订单已取消", "data": gin.H{"quote": quote, "refund": refund}})
+}
+
+// AdminRefundReq 管理员退款请求体
+type AdminRefundReq struct {
+	PaymentID uint64   `json:"payment_id" binding:"required"`
+	Amount    *float64 `json:"amount,omitempty"`          // 可选：不传则退还全部剩余金额
+	Reason    string   `json:"reason" binding:"required"` // 退款原因
+}
+
+// AdminRefundHandler 管理员对支付记录发起全额或部分退款（不受取消策略限制，不改变订单状态）
+// POST /admin/payment/refund
+func (h *Handler) AdminRefundHandler(c *gin.Context) {
+	var req AdminRefundReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+
+	p, err := h.svc.GetPayment(req.PaymentID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": "支付记录不存在"})
+		return
+	}
+	lotID, err := h.svc.PayableLotID(p)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "查询支付对象失败"})
+		return
+	}
+	if !middleware.CanManageLot(c, lotID) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "无权操作该停车场的支付记录"})
+		return
+	}
+
+	refund, err := h.svc.Refund(req.PaymentID, req.Amount, req.Reason, RefundByAdmin, c.GetUint("admin_id"))
+	if err != nil {
+		status := refundErrorStatus(err)
+		if refund != nil {
+			status = http.StatusBadGateway
+		}
+		c.JSON(status, gin.H{"code": status, "message": err.Error(), "data": refund})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "退款成功", "data": refund})
+}
+
+// ListRefundsHandler 查询支付记录的退款记录
+// GET /admin/payment/refunds?payment_id=1
+func (h *Handler) ListRefundsHandler(c *gin.Context) {
+	paymentID, err := strconv.ParseUint(c.Query("payment_id"), 10, 64)
+	if err != nil || paymentID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的支付记录ID"})
+		return
+	}
+	p, err := h.svc.GetPayment(paymentID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"code": 404, "message": "支付记录不存在"})
+		return
+	}
+	lotID, err := h.svc.PayableLotID(p)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "查询支付对象失败"})
+		return
+	}
+	if !middleware.CanManageLot(c, lotID) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "无权查看该停车场的支付记录"})
+		return
+	}
+
+	list, err := h.svc.ListRefunds(paymentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "查询退款记录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": gin.H{"payment": p, "refunds": list}})
+}
+
+// GetRefundPolicyHandler 查询停车场退款策略
+// GET /admin/payment/refund-policy?lot_id=1
+func (h *Handler) GetRefundPolicyHandler(c *gin.Context) {
+	lotID, err := strconv.Atoi(c.Query("lot_id"))
+	if err != nil || lotID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的停车场ID"})
+		return
+	}
+	if !middleware.CanManageLot(c, uint(lotID)) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "无权查看该停车场的退款策略"})
+		return
+	}
+
+	tiers, custom, err := h.svc.GetRefundPolicy(uint(lotID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "查询退款策略失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": gin.H{"lot_id": lotID, "custom": custom, "tiers": tiers}})
+}
+
+// SetRefundPolicyReq 设置退款策略请求体
+type SetRefundPolicyReq struct {
+	LotID uint `json:"lot_id" binding:"required"`
+	Tiers []struct {
+		MinHoursBefore float64 `json:"min_hours_before"`
+		RefundPercent  int     `json:"refund_percent"`
+	} `json:"tiers"` // 为空时恢复默认策略
+}
+
+// SetRefundPolicyHandler 整体替换停车场退款策略
+// PUT /admin/payment/refund-policy
+func (h *Handler) SetRefundPolicyHandler(c *gin.Context) {
+	var req SetRefundPolicyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+	if !middleware.CanManageLot(c, req.LotID) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "无权修改该停车场的退款策略"})
+		return
+	}
+
+	tiers := make([]model.RefundPolicyTier, 0, len(req.Tiers))
+	for _, t := range req.Tiers {
+		tiers = append(tiers, model.RefundPolicyTier{MinHoursBefore: t.MinHoursBefore, RefundPercent: t.RefundPercent})
+	}
+	if err := h.svc.SetRefundPolicy(req.LotID, tiers); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+
+	saved, custom, _ := h.svc.GetRefundPolicy(req.LotID)
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "退款策略已更新", "data": gin.H{"lot_id": req.LotID, "custom": custom, "tiers": saved}})
+}
@@ -0,0 +1,339 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"smart_parking_backend/internal/booking"
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 退款相关错误
+var (
+	ErrRefundNotAllowed          = errors.New("该支付记录当前状态不可退款")
+	ErrRefundExceedsPaid         = errors.New("退款金额超过可退金额")
+	ErrRefundProviderUnavailable = errors.New("未接入该支付渠道的退款接口")
+	ErrBookingNotPaid            = errors.New("订单未支付，请直接取消订单")
+)
+
+// 退款发起方
+const (
+	RefundByUser  = "user"
+	RefundByAdmin = "admin"
+)
+
+// DefaultRefundTiers 停车场未配置退款策略时使用的默认档位：
+// 距开始 2 小时以上全额退款，2 小时内退 50%，预订开始后不退款
+var DefaultRefundTiers = []model.RefundPolicyTier{
+	{MinHoursBefore: 2, RefundPercent: 100},
+	{MinHoursBefore: 0, RefundPercent: 50},
+}
+
+// RefundProvider 支付渠道退款接口，真实渠道接入后通过 RegisterRefundProvider 注册
+type RefundProvider interface {
+	// Refund 向渠道发起退款，成功返回渠道退款单号
+	Refund(p *model.PaymentRecord, refundNo string, amount float64) (string, error)
+}
+
+// sandboxRefundProvider 沙箱模式下的退款渠道，直接视为退款成功
+type sandboxRefundProvider struct{}
+
+func (sandboxRefundProvider) Refund(p *model.PaymentRecord, refundNo string, amount float64) (string, error) {
+	return "SANDBOX_" + refundNo, nil
+}
+
+// RegisterRefundProvider 注册支付方式对应的退款渠道
+func (s *Service) RegisterRefundProvider(method string, provider RefundProvider) {
+	s.refunders[method] = provider
+}
+
+// round2 金额保留两位小数
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// ==================== 退款策略 ====================
+
+// GetRefundPolicy 获取停车场的退款策略档位（按提前小时数降序），未配置时返回默认档位，custom 为 false
+func (s *Service) GetRefundPolicy(lotID uint) (tiers []model.RefundPolicyTier, custom bool, err error) {
+	if err := inits.DB.Where("lot_id = ?", lotID).Order("min_hours_before DESC").Find(&tiers).Error; err != nil {
+		return nil, false, err
+	}
+	if len(tiers) == 0 {
+		return DefaultRefundTiers, false, nil
+	}
+	return tiers, true, nil
+}
+
+// SetRefundPolicy 整体替换停车场的退款策略档位；tiers 为空时恢复默认策略
+func (s *Service) SetRefundPolicy(lotID uint, tiers []model.RefundPolicyTier) error {
+	seen := make(map[int64]bool, len(tiers))
+	for i := range tiers {
+		if tiers[i].MinHoursBefore < 0 {
+			return errors.New("提前小时数不能为负数")
+		}
+		if tiers[i].RefundPercent < 0 || tiers[i].RefundPercent > 100 {
+			return errors.New("退款比例必须在 0-100 之间")
+		}
+		key := int64(math.Round(tiers[i].MinHoursBefore * 100))
+		if seen[key] {
+			return errors.New("提前小时数不能重复")
+		}
+		seen[key] = true
+		tiers[i].TierID = 0
+		tiers[i].LotID = lotID
+	}
+
+	return inits.DB.Transaction(func(tx *gorm.DB) error {
+		var lot model.ParkingLot
+		if err := tx.First(&lot, lotID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("停车场不存在")
+			}
+			return err
+		}
+		if err := tx.Where("lot_id = ?", lotID).Delete(&model.RefundPolicyTier{}).Error; err != nil {
+			return err
+		}
+		if len(tiers) == 0 {
+			return nil
+		}
+		return tx.Create(&tiers).Error
+	})
+}
+
+// refundPercent 按距预订开始的小时数匹配档位：取满足 hoursBefore >= MinHoursBefore 的最高档，预订开始后一律不退款
+func refundPercent(tiers []model.RefundPolicyTier, hoursBefore float64) int {
+	if hoursBefore < 0 {
+		return 0
+	}
+	sorted := append([]model.RefundPolicyTier(nil), tiers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MinHoursBefore > sorted[j].MinHoursBefore })
+	for _, t := range sorted {
+		if hoursBefore >= t.MinHoursBefore {
+			return t.RefundPercent
+		}
+	}
+	return 0
+}
+
+// ==================== 取消预订退款 ====================
+
+// RefundQuote 取消预订的退款试算结果
+type RefundQuote struct {
+	OrderID       uint    `json:"order_id"`
+	PaymentID     uint64  `json:"payment_id"`
+	PaidAmount    float64 `json:"paid_amount"`    // 可退的已付金额（已扣除历史退款）
+	HoursBefore   float64 `json:"hours_before"`   // 距预订开始的小时数，负数表示已开始
+	RefundPercent int     `json:"refund_percent"` // 适用的退款比例
+	RefundAmount  float64 `json:"refund_amount"`  // 本次取消可退金额
+}
+
+// QuoteBookingRefund 按停车场退款策略试算取消预订可退金额（仅订单所属用户可操作）
+func (s *Service) QuoteBookingRefund(orderID, userID uint) (*RefundQuote, error) {
+	order, err := s.bookingSvc.GetBookingDetail(orderID)
+	if err != nil {
+		return nil, errors.New("订单不存在")
+	}
+	if order.UserID != userID {
+		return nil, errors.New("无权操作该订单")
+	}
+	if order.Status != 1 {
+		return nil, booking.ErrBookingNotCancellable
+	}
+	if order.PaymentStatus != 1 && order.PaymentStatus != 3 {
+		return nil, ErrBookingNotPaid
+	}
+
+	var p model.PaymentRecord
+	if err := inits.DB.Where("payable_type = ? AND order_id = ? AND payment_status IN ?", model.PayableReservation, orderID, []int8{1, 4}).
+		Order("pay_time DESC").First(&p).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("未找到订单的支付记录")
+		}
+		return nil, errors.New("查询支付记录失败")
+	}
+
+	tiers, _, err := s.GetRefundPolicy(order.LotID)
+	if err != nil {
+		return nil, errors.New("查询退款策略失败")
+	}
+	hoursBefore := time.Until(order.StartTime).Hours()
+	percent := refundPercent(tiers, hoursBefore)
+	paid := round2(p.Amount - p.RefundedAmount)
+	return &RefundQuote{
+		OrderID:       order.OrderID,
+		PaymentID:     p.PaymentID,
+		PaidAmount:    paid,
+		HoursBefore:   math.Round(hoursBefore*100) / 100,
+		RefundPercent: percent,
+		RefundAmount:  round2(paid * float64(percent) / 100),
+	}, nil
+}
+
+// CancelBookingWithRefund 用户取消已支付的预订：先取消订单释放车位，再按策略发起退款
+// 订单取消后渠道退款失败时返回退款记录与错误，管理员可通过后台对该支付记录重新发起退款
+func (s *Service) CancelBookingWithRefund(orderID, userID uint, reason string) (*RefundQuote, *model.RefundRecord, error) {
+	quote, err := s.QuoteBookingRefund(orderID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.bookingSvc.CancelPaidBooking(orderID); err != nil {
+		return nil, nil, err
+	}
+	if quote.RefundAmount <= 0 {
+		return quote, nil, nil
+	}
+	if reason == "" {
+		reason = fmt.Sprintf("用户取消预订，按策略退款 %d%%", quote.RefundPercent)
+	}
+	refund, err := s.Refund(quote.PaymentID, &quote.RefundAmount, reason, RefundByUser, userID)
+	if err != nil {
+		return quote, refund, fmt.Errorf("订单已取消，但退款失败，请联系管理员: %w", err)
+	}
+	return quote, refund, nil
+}
+
+// ==================== 退款执行 ====================
+
+// Refund 对支付记录发起全额或部分退款，amountPtr 为空时退还全部剩余金额
+// 流程：锁定支付记录并预占可退金额 → 调用渠道退款 → 成功则更新支付记录与业务单据，失败则释放预占金额
+func (s *Service) Refund(paymentID uint64, amountPtr *float64, reason, operatorType string, operatorID uint) (*model.RefundRecord, error) {
+	var p model.PaymentRecord
+	var record *model.RefundRecord
+	var provider RefundProvider
+	err := inits.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&p, paymentID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("支付记录不存在")
+			}
+			return err
+		}
+		if p.PaymentStatus != 1 && p.PaymentStatus != 4 {
+			return ErrRefundNotAllowed
+		}
+		if p.PayableType == model.PayableWalletTopup {
+			return errors.New("钱包充值不支持退款")
+		}
+		var ok bool
+		if provider, ok = s.refunders[p.Method]; !ok {
+			return ErrRefundProviderUnavailable
+		}
+
+		remaining := round2(p.Amount - p.RefundedAmount)
+		amount := remaining
+		if amountPtr != nil {
+			amount = round2(*amountPtr)
+		}
+		if amount <= 0 {
+			return errors.New("退款金额必须大于0")
+		}
+		if amount > remaining {
+			return ErrRefundExceedsPaid
+		}
+
+		if err := tx.Model(&model.PaymentRecord{}).Where("payment_id = ?", p.PaymentID).
+			Update("refunded_amount", gorm.Expr("refunded_amount + ?", amount)).Error; err != nil {
+			return err
+		}
+		record = &model.RefundRecord{
+			RefundNo:     fmt.Sprintf("RF%d%d", p.PaymentID, time.Now().UnixNano()),
+			PaymentID:    p.PaymentID,
+			Amount:       amount,
+			Reason:       reason,
+			Status:       0, // 处理中
+			OperatorType: operatorType,
+			OperatorID:   operatorID,
+		}
+		return tx.Create(record).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	providerRefundNo, refundErr := provider.Refund(&p, record.RefundNo, record.Amount)
+	now := time.Now()
+	record.FinishTime = &now
+
+	if refundErr != nil {
+		// 渠道退款失败：释放预占的可退金额
+		record.Status = 2
+		record.FailReason = refundErr.Error()
+		if err := inits.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(record).Updates(map[string]interface{}{
+				"status": record.Status, "fail_reason": record.FailReason, "finish_time": now,
+			}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&model.PaymentRecord{}).Where("payment_id = ?", p.PaymentID).
+				Update("refunded_amount", gorm.Expr("refunded_amount - ?", record.Amount)).Error
+		}); err != nil {
+			return record, fmt.Errorf("渠道退款失败且回滚退款金额失败: %w", err)
+		}
+		return record, fmt.Errorf("渠道退款失败: %w", refundErr)
+	}
+
+	record.Status = 1
+	record.ProviderRefundNo = providerRefundNo
+	if err := inits.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(record).Updates(map[string]interface{}{
+			"status": record.Status, "provider_refund_no": providerRefundNo, "finish_time": now,
+		}).Error; err != nil {
+			return err
+		}
+		// 3-已全额退款，4-部分退款
+		return tx.Model(&model.PaymentRecord{}).Where("payment_id = ?", p.PaymentID).
+			Updates(map[string]interface{}{
+				"payment_status": gorm.Expr("CASE WHEN refunded_amount >= amount THEN 3 ELSE 4 END"),
+				"refund_time":    now,
+			}).Error
+	}); err != nil {
+		return record, fmt.Errorf("渠道退款成功，但更新退款状态失败: %w", err)
+	}
+
+	if p.PayableType == model.PayableReservation && p.OrderID != nil {
+		if err := s.bookingSvc.ApplyRefund(*p.OrderID, record.Amount); err != nil {
+			return record, fmt.Errorf("退款成功，但订单更新失败: %w", err)
+		}
+	}
+	return record, nil
+}
+
+// PayableLotID 获取支付对象所属停车场，用于停车场管理员的权限校验
+func (s *Service) PayableLotID(p *model.PaymentRecord) (uint, error) {
+	var lotID uint
+	var err error
+	switch {
+	case p.PayableType == model.PayableReservation && p.OrderID != nil:
+		err = inits.DB.Model(&model.ReservationOrder{}).Where("order_id = ?", *p.OrderID).Select("lot_id").Scan(&lotID).Error
+	case p.PayableType == model.PayableParking && p.ParkingRecordID != nil:
+		err = inits.DB.Model(&model.ParkingRecord{}).Where("record_id = ?", *p.ParkingRecordID).Select("lot_id").Scan(&lotID).Error
+	case p.PayableType == model.PayableViolation && p.ViolationID != nil:
+		err = inits.DB.Model(&model.ViolationRecord{}).Where("violation_id = ? AND lot_id IS NOT NULL", *p.ViolationID).Select("lot_id").Scan(&lotID).Error
+	default:
+		return 0, errors.New("支付记录缺少关联对象")
+	}
+	return lotID, err
+}
+
+// ListRefunds 查询支付记录的退款记录
+func (s *Service) ListRefunds(paymentID uint64) ([]model.RefundRecord, error) {
+	var list []model.RefundRecord
+	err := inits.DB.Where("payment_id = ?", paymentID).Order("create_time DESC").Find(&list).Error
+	return list, err
+}
+
+// GetPayment 查询支付记录
+func (s *Service) GetPayment(paymentID uint64) (*model.PaymentRecord, error) {
+	var p model.PaymentRecord
+	if err := inits.DB.First(&p, paymentID).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
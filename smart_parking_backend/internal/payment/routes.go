@@ -1,19 +1,44 @@
 package payment
 
 import (
-	"smart_parking_backend/internal/booking"
+	"smart_parking_backend/internal/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
 // PaymentRoutes 注册路由
-func PaymentRoutes(r *gin.Engine, bookingSvc *booking.Service, cfg *Config) {
-	svc := NewService(bookingSvc, cfg)
+func PaymentRoutes(r *gin.Engine, svc *Service) {
 	handler := NewHandler(svc)
 
 	g := r.Group("/api/payment")
 	{
-		g.POST("/create", handler.CreatePaymentRedirectHandler) // 统一创建支付（reservation/parking/violation）
-		g.POST("/notify", handler.NotifyHandler)                // 模拟支付回调（前端模拟页面会 POST 到这里）
+		g.POST("/create", middleware.UserAuthMiddleware(), handler.CreatePaymentRedirectHandler) // 统一创建支付（reservation/parking/violation，仅本人单据）
+		g.POST("/guest/parking", handler.GuestParkingPaymentHandler)                             // 访客凭停车凭证号支付停车费（无需登录）
+		g.POST("/notify", handler.NotifyHandler)                                                 // 支付渠道回调（需验签）
+		if svc.Config().SandboxEnabled() {
+			g.POST("/notify/sandbox", handler.SandboxNotifyHandler) // 沙箱模式：模拟支付页面确认支付
+		}
+	}
+
+	walletPay := r.Group("/api/payment/wallet")
+	walletPay.Use(middleware.UserAuthMiddleware())
+	{
+		walletPay.POST("/pay", handler.WalletPayHandler) // 钱包余额支付（即时结算）
+	}
+
+	refund := r.Group("/api/payment/refund")
+	refund.Use(middleware.UserAuthMiddleware())
+	{
+		refund.GET("/booking/:id/quote", handler.QuoteBookingRefundHandler) // 取消预订退款试算
+		refund.POST("/booking/:id", handler.CancelBookingWithRefundHandler) // 取消已支付预订并按策略退款
+	}
+
+	admin := r.Group("/admin/payment")
+	admin.Use(middleware.AdminAuthMiddleware())
+	{
+		admin.POST("/refund", handler.AdminRefundHandler)           // 管理员发起全额/部分退款
+		admin.GET("/refunds", handler.ListRefundsHandler)           // 查询支付记录的退款记录
+		admin.GET("/refund-policy", handler.GetRefundPolicyHandler) // 查询停车场退款策略
+		admin.PUT("/refund-policy", handler.SetRefundPolicyHandler) // 设置停车场退款策略
 	}
 }
@@ -3,10 +3,13 @@ package payment
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net/url"
 	"smart_parking_backend/internal/booking"
 	"smart_parking_backend/internal/inits"
 	"smart_parking_backend/internal/model"
+	"smart_parking_backend/internal/notify"
+	"smart_parking_backend/internal/wallet"
 	"time"
 
 	"gorm.io/gorm"
@@ -14,21 +17,36 @@ import (
 
 type Service struct {
 	bookingSvc *booking.Service
+	walletSvc  *wallet.Service
+	notifier   *notify.Service
 	cfg        *Config
 	// 模拟支付页面基础地址（如果在 Config 中未配置，使用默认）
 	simulateBase string
+	// 按支付方式注册的退款渠道
+	refunders map[string]RefundProvider
 }
 
-func NewService(bookingSvc *booking.Service, cfg *Config) *Service {
+func NewService(bookingSvc *booking.Service, walletSvc *wallet.Service, notifier *notify.Service, cfg *Config) *Service {
 	simHost := "http://127.0.0.1:8081/simulate_payment" // 默认模拟支付页面地址（QT 可监听此地址或替换）
 	if cfg != nil && cfg.SimulateHost != "" {
 		simHost = cfg.SimulateHost
 	}
-	return &Service{
+	s := &Service{
 		bookingSvc:   bookingSvc,
+		walletSvc:    walletSvc,
+		notifier:     notifier,
 		cfg:          cfg,
 		simulateBase: simHost,
+		refunders:    make(map[string]RefundProvider),
 	}
+	// 钱包支付原路退回钱包余额
+	s.RegisterRefundProvider("wallet", walletRefundProvider{svc: walletSvc})
+	// 沙箱模式下退款直接成功；生产环境需注册真实渠道的退款实现
+	if cfg.SandboxEnabled() {
+		s.RegisterRefundProvider("alipay", sandboxRefundProvider{})
+		s.RegisterRefundProvider("wechat", sandboxRefundProvider{})
+	}
+	return s
 }
 
 func (s *Service) Config() *Config {
@@ -38,27 +56,67 @@ func (s *Service) Config() *Config {
 // CreatePayment 统一入口：创建 pending 支付记录并返回模拟支付跳转 URL
 // typ: "reservation" | "parking" | "violation"
 // method: "alipay" | "wechat"
-// amountPtr: 可选，若提供则使用该金额；否则从 DB 查出应付金额
+// 金额一律按 DB 中的应付金额：预订为 total_fee，停车为 fee_calculated（不含罚款），罚款为 fine_amount
 // 返回 redirectURL, paymentID, error
-func (s *Service) CreatePayment(orderID uint, typ, method string, amountPtr *float64) (string, uint64, error) {
+func (s *Service) CreatePayment(orderID uint, typ, method string) (string, uint64, error) {
+	if method == "wallet" {
+		return "", 0, errors.New("钱包支付无需跳转，请使用钱包支付接口 /api/payment/wallet/pay")
+	}
 	if method != "alipay" && method != "wechat" {
 		return "", 0, errors.New("不支持的支付方式")
 	}
 
 	switch typ {
 	case "reservation":
-		return s.createReservationPayment(orderID, method, amountPtr)
+		return s.createReservationPayment(orderID, method)
 	case "parking":
-		return s.createParkingPayment(orderID, method, amountPtr)
+		return s.createParkingPayment(orderID, method)
 	case "violation":
-		return s.createViolationPayment(orderID, method, amountPtr)
+		return s.createViolationPayment(orderID, method)
 	default:
 		return "", 0, errors.New("未知的订单类型")
 	}
 }
 
+// ErrNotPayableOwner 当前用户无权为该单据创建支付
+var ErrNotPayableOwner = errors.New("无权支付该单据")
+
+// CheckPayableOwner 校验单据归属：预订、罚款只能由本人支付；停车记录凭停车凭证号定位（byTicket，持票即视为车主）
+// 或由会员本人支付，访客停车记录（无用户）只能凭票支付
+func (s *Service) CheckPayableOwner(typ string, id, userID uint, byTicket bool) error {
+	switch typ {
+	case model.PayableReservation:
+		var order model.ReservationOrder
+		if err := inits.DB.Select("order_id", "user_id").First(&order, id).Error; err != nil {
+			return errors.New("订单不存在")
+		}
+		if order.UserID != userID {
+			return ErrNotPayableOwner
+		}
+	case model.PayableParking:
+		var record model.ParkingRecord
+		if err := inits.DB.Select("record_id", "user_id").First(&record, id).Error; err != nil {
+			return errors.New("停车记录不存在")
+		}
+		if !byTicket && (record.UserID == nil || *record.UserID != userID) {
+			return ErrNotPayableOwner
+		}
+	case model.PayableViolation:
+		var vio model.ViolationRecord
+		if err := inits.DB.Select("violation_id", "user_id").First(&vio, id).Error; err != nil {
+			return errors.New("违规记录不存在")
+		}
+		if vio.UserID != userID {
+			return ErrNotPayableOwner
+		}
+	default:
+		return errors.New("未知的订单类型")
+	}
+	return nil
+}
+
 // ----- reservation -----
-func (s *Service) createReservationPayment(orderID uint, method string, amountPtr *float64) (string, uint64, error) {
+func (s *Service) createReservationPayment(orderID uint, method string) (string, uint64, error) {
 	// 使用 bookingSvc 获取订单
 	order, err := s.bookingSvc.GetBookingDetail(orderID)
 	if err != nil {
@@ -71,16 +129,15 @@ func (s *Service) createReservationPayment(orderID uint, method string, amountPt
 		return "", 0, errors.New("订单已取消")
 	}
 
+	// 金额以订单应付金额为准，不接受客户端传入（回调按此金额核对）
 	amount := order.TotalFee
-	if amountPtr != nil {
-		amount = *amountPtr
-	}
 	if amount <= 0 {
 		return "", 0, errors.New("订单金额为0，请确认金额")
 	}
 
 	// 创建 pending 支付（通过 bookingSvc 的方法以确保行为一致）
-	payment, err := s.bookingSvc.CreatePendingPayment(order.OrderID, order.UserID, amount, method, "")
+	pendingNo := fmt.Sprintf("PENDING_RES_%d_%d", order.OrderID, time.Now().UnixNano()) // 临时唯一交易号，回调时替换
+	payment, err := s.bookingSvc.CreatePendingPayment(order.OrderID, order.UserID, amount, method, pendingNo)
 	if err != nil {
 		return "", 0, err
 	}
@@ -92,15 +149,29 @@ func (s *Service) createReservationPayment(orderID uint, method string, amountPt
 }
 
 // ----- parking -----
-func (s *Service) createParkingPayment(recordID uint, method string, amountPtr *float64) (string, uint64, error) {
-	// 先检查是否已有pending支付记录
-	var existingPayment model.PaymentRecord
-	if err := inits.DB.Where("order_id = ? AND payment_status = 0", recordID).First(&existingPayment).Error; err == nil {
-		// 如果已有pending支付记录，直接返回
-		u := fmt.Sprintf("%s?provider=%s&payment_id=%d", s.simulateBase, url.QueryEscape(method), existingPayment.PaymentID)
-		return u, existingPayment.PaymentID, nil
-	}
 
+// ResolveParkingRecordID 根据停车凭证号或车牌号定位待支付的停车记录（访客停车没有用户账号，只能凭票号/车牌支付）
+// 票号优先；仅提供车牌时取该车牌最近一条未支付的停车记录
+func (s *Service) ResolveParkingRecordID(ticketCode, licensePlate string) (uint, error) {
+	var record model.ParkingRecord
+	query := inits.DB.Where("payment_status = ?", 0) // 0-未支付
+	switch {
+	case ticketCode != "":
+		query = query.Where("ticket_code = ?", ticketCode)
+	case licensePlate != "":
+		query = query.Where("license_plate = ?", licensePlate)
+	default:
+		return 0, errors.New("请提供停车记录ID、停车凭证号或车牌号")
+	}
+	if err := query.Order("entry_time DESC").First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, errors.New("未找到待支付的停车记录")
+		}
+		return 0, errors.New("查询停车记录失败")
+	}
+	return record.RecordID, nil
+}
+func (s *Service) createParkingPayment(recordID uint, method string) (string, uint64, error) {
 	// 查找 ParkingRecord
 	var record model.ParkingRecord
 	if err := inits.DB.First(&record, recordID).Error; err != nil {
@@ -109,76 +180,44 @@ func (s *Service) createParkingPayment(recordID uint, method string, amountPtr *
 		}
 		return "", 0, errors.New("查询停车记录失败")
 	}
+	if record.PaymentStatus == 1 {
+		return "", 0, errors.New("停车费已支付")
+	}
 
+	// 费用在出场时计算（已抵扣预付金额，不含违规罚款），未出场或应付为0时不能创建支付
 	amount := record.FeeCalculated
-	if amountPtr != nil && *amountPtr > 0 {
-		amount = *amountPtr
-	}
-	// 如果费用未计算且未传入金额，使用默认金额
 	if amount <= 0 {
-		amount = 10.0 // 默认10元，实际应该根据停车时长计算
-	}
-
-	// 创建 pending payment record 直接写入 payment_record 表
-	// 注意：由于PaymentRecord的Order字段有外键约束指向ReservationOrder，但这里OrderID是ParkingRecord的ID
-	// 所以需要禁用外键检查或使用原生SQL，或者不加载Order关联
-	// TransactionNo字段有unique约束，待支付时生成临时唯一值
-	now := time.Now()
-	p := &model.PaymentRecord{
-		OrderID:       record.RecordID, // 在表结构里 OrderID 字段复用为关联 ID（reservation/parking/violation）
-		UserID:        record.UserID,
-		Amount:        amount,
-		Method:        method,
-		TransactionNo: fmt.Sprintf("PENDING_%d_%d", record.RecordID, now.Unix()), // 待支付时使用临时唯一值
-		PaymentStatus: 0,                                                         // 待支付
-		CreateTime:    now,
-	}
-	// 使用原生SQL插入，临时禁用外键检查，避免外键约束问题
-	// 注意：OrderID字段有外键约束指向ReservationOrder，但这里OrderID是ParkingRecord的ID
-	// 所以需要临时禁用外键检查
-	sqlDB, err := inits.DB.DB()
-	if err != nil {
-		return "", 0, fmt.Errorf("获取数据库连接失败: %w", err)
+		return "", 0, errors.New("停车费用为0，请确认金额")
 	}
 
-	// 临时禁用外键检查
-	_, err = sqlDB.Exec("SET FOREIGN_KEY_CHECKS = 0")
-	if err != nil {
-		return "", 0, fmt.Errorf("禁用外键检查失败: %w", err)
+	// 同一渠道、同一金额的待支付记录直接复用；换渠道时新建，避免回调因渠道不符被隔离
+	if p, ok := findPendingPayment("parking_record_id", model.PayableParking, recordID, method, amount); ok {
+		u := fmt.Sprintf("%s?provider=%s&payment_id=%d", s.simulateBase, url.QueryEscape(method), p.PaymentID)
+		return u, p.PaymentID, nil
 	}
-	defer func() {
-		sqlDB.Exec("SET FOREIGN_KEY_CHECKS = 1")
-	}()
 
-	// 插入支付记录
-	result, err := sqlDB.Exec(
-		"INSERT INTO payment_record (order_id, user_id, amount, method, transaction_no, payment_status, create_time) VALUES (?, ?, ?, ?, ?, ?, ?)",
-		p.OrderID, p.UserID, p.Amount, p.Method, p.TransactionNo, p.PaymentStatus, p.CreateTime,
-	)
-	if err != nil {
+	// TransactionNo字段有unique约束，待支付时生成临时唯一值，回调时替换为渠道交易号
+	now := time.Now()
+	p := &model.PaymentRecord{
+		PayableType:     model.PayableParking,
+		ParkingRecordID: &record.RecordID,
+		UserID:          record.UserID,
+		Amount:          amount,
+		Method:          method,
+		TransactionNo:   fmt.Sprintf("PENDING_%d_%d", record.RecordID, now.UnixNano()),
+		PaymentStatus:   0, // 待支付
+		CreateTime:      now,
+	}
+	if err := inits.DB.Create(p).Error; err != nil {
 		return "", 0, fmt.Errorf("创建支付记录失败: %w", err)
 	}
 
-	// 获取插入的PaymentID
-	paymentID, err := result.LastInsertId()
-	if err != nil {
-		return "", 0, fmt.Errorf("获取支付ID失败: %w", err)
-	}
-	p.PaymentID = uint64(paymentID)
 	u := fmt.Sprintf("%s?provider=%s&payment_id=%d", s.simulateBase, url.QueryEscape(method), p.PaymentID)
 	return u, p.PaymentID, nil
 }
 
 // ----- violation -----
-func (s *Service) createViolationPayment(violationID uint, method string, amountPtr *float64) (string, uint64, error) {
-	// 先检查是否已有pending支付记录
-	var existingPayment model.PaymentRecord
-	if err := inits.DB.Where("order_id = ? AND payment_status = 0", violationID).First(&existingPayment).Error; err == nil {
-		// 如果已有pending支付记录，直接返回
-		u := fmt.Sprintf("%s?provider=%s&payment_id=%d", s.simulateBase, url.QueryEscape(method), existingPayment.PaymentID)
-		return u, existingPayment.PaymentID, nil
-	}
-
+func (s *Service) createViolationPayment(violationID uint, method string) (string, uint64, error) {
 	var vio model.ViolationRecord
 	if err := inits.DB.First(&vio, violationID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -187,79 +226,128 @@ func (s *Service) createViolationPayment(violationID uint, method string, amount
 		return "", 0, errors.New("查询违规记录失败")
 	}
 
-	if vio.Status == 1 {
-		return "", 0, errors.New("罚款已处理")
+	if err := checkViolationPayable(&vio); err != nil {
+		return "", 0, err
 	}
 
+	// 金额以罚款金额为准，不接受客户端传入
 	amount := vio.FineAmount
-	if amountPtr != nil {
-		amount = *amountPtr
-	}
 	if amount <= 0 {
 		return "", 0, errors.New("罚款金额为0，请确认金额")
 	}
 
+	// 同一渠道、同一金额的待支付记录直接复用
+	if p, ok := findPendingPayment("violation_id", model.PayableViolation, violationID, method, amount); ok {
+		u := fmt.Sprintf("%s?provider=%s&payment_id=%d", s.simulateBase, url.QueryEscape(method), p.PaymentID)
+		return u, p.PaymentID, nil
+	}
+
 	now := time.Now()
 	p := &model.PaymentRecord{
-		OrderID:       vio.ViolationID, // reuse OrderID field
-		UserID:        vio.UserID,
+		PayableType:   model.PayableViolation,
+		ViolationID:   &vio.ViolationID,
+		UserID:        &vio.UserID,
 		Amount:        amount,
 		Method:        method,
-		TransactionNo: fmt.Sprintf("PENDING_VIO_%d_%d", vio.ViolationID, now.Unix()),
+		TransactionNo: fmt.Sprintf("PENDING_VIO_%d_%d", vio.ViolationID, now.UnixNano()),
 		PaymentStatus: 0,
 		CreateTime:    now,
 	}
-
-	sqlDB, err := inits.DB.DB()
-	if err != nil {
-		return "", 0, fmt.Errorf("获取数据库连接失败: %w", err)
-	}
-
-	_, err = sqlDB.Exec("SET FOREIGN_KEY_CHECKS = 0")
-	if err != nil {
-		return "", 0, fmt.Errorf("禁用外键检查失败: %w", err)
-	}
-	defer func() {
-		sqlDB.Exec("SET FOREIGN_KEY_CHECKS = 1")
-	}()
-
-	result, err := sqlDB.Exec(
-		"INSERT INTO payment_record (order_id, user_id, amount, method, transaction_no, payment_status, create_time) VALUES (?, ?, ?, ?, ?, ?, ?)",
-		p.OrderID, p.UserID, p.Amount, p.Method, p.TransactionNo, p.PaymentStatus, p.CreateTime,
-	)
-	if err != nil {
+	if err := inits.DB.Create(p).Error; err != nil {
 		return "", 0, fmt.Errorf("创建支付记录失败: %w", err)
 	}
 
-	paymentID, err := result.LastInsertId()
-	if err != nil {
-		return "", 0, fmt.Errorf("获取支付ID失败: %w", err)
-	}
-	p.PaymentID = uint64(paymentID)
-
 	u := fmt.Sprintf("%s?provider=%s&payment_id=%d", s.simulateBase, url.QueryEscape(method), p.PaymentID)
 	return u, p.PaymentID, nil
 }
 
+// findPendingPayment 查找单据在指定渠道下金额一致的待支付记录（column 为单据外键列名）
+func findPendingPayment(column, payableType string, id uint, method string, amount float64) (*model.PaymentRecord, bool) {
+	var p model.PaymentRecord
+	err := inits.DB.Where("payable_type = ? AND "+column+" = ? AND method = ? AND amount = ? AND payment_status = 0", payableType, id, method, amount).
+		Order("payment_id DESC").First(&p).Error
+	return &p, err == nil
+}
+
+// checkViolationPayable 只有未处理的违规罚款可以支付；申诉中的罚款暂停缴纳，已免除的罚款无需缴纳
+func checkViolationPayable(vio *model.ViolationRecord) error {
+	switch vio.Status {
+	case 1:
+		return errors.New("罚款已处理")
+	case 2:
+		return errors.New("罚款申诉中，暂不能支付")
+	case 3:
+		return errors.New("罚款已免除")
+	}
+	return nil
+}
+
 // ----- 回调处理 -----
-// HandleNotify 处理模拟支付回调：根据 payment_id 更新 payment_record 并更新对应业务表（reservation/parking/violation）
-func (s *Service) HandleNotify(paymentID uint64, amount float64, provider, transactionNo string) (*model.PaymentRecord, error) {
+
+// ErrPaymentQuarantined 回调与支付记录不符，已转入隔离表等待人工审核
+var ErrPaymentQuarantined = errors.New("支付回调与支付记录不符，已转入人工审核")
+
+// 隔离原因
+const (
+	QuarantineAmountMismatch   = "amount_mismatch"   // 回调金额与待支付金额不符
+	QuarantineProviderMismatch = "provider_mismatch" // 回调渠道与下单渠道不符
+	QuarantineUnknownPayment   = "unknown_payment"   // 支付记录不存在
+	QuarantineDuplicatePayment = "duplicate_payment" // 已支付记录收到不同交易号的回调
+	QuarantineInvalidStatus    = "invalid_status"    // 支付记录不是待支付状态
+)
+
+// quarantine 写入隔离记录，不结算订单
+func (s *Service) quarantine(paymentID *uint64, provider, transactionNo string, expected, received float64, reason, raw string) error {
+	q := &model.PaymentQuarantine{
+		PaymentID:      paymentID,
+		Provider:       provider,
+		TransactionNo:  transactionNo,
+		ExpectedAmount: expected,
+		ReceivedAmount: received,
+		Reason:         reason,
+		RawPayload:     raw,
+		Status:         0,
+	}
+	if err := inits.DB.Create(q).Error; err != nil {
+		return fmt.Errorf("写入支付隔离记录失败: %w", err)
+	}
+	return ErrPaymentQuarantined
+}
+
+// sameAmount 按分比较金额，避免浮点误差
+func sameAmount(a, b float64) bool {
+	return math.Round(a*100) == math.Round(b*100)
+}
+
+// HandleNotify 处理已通过验签的支付回调：根据 payment_id 更新 payment_record 并更新对应业务表（reservation/parking/violation）
+// 回调金额必须与待支付记录一致，支付金额以 DB 中的记录为准，不会被回调覆盖；不一致的回调写入隔离表
+// raw 为回调原始参数，仅用于隔离记录留档
+func (s *Service) HandleNotify(paymentID uint64, amount float64, provider, transactionNo, raw string) (*model.PaymentRecord, error) {
 	// 查找 payment_record
 	var p model.PaymentRecord
 	if err := inits.DB.First(&p, paymentID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("支付记录不存在")
+			return nil, s.quarantine(nil, provider, transactionNo, 0, amount, QuarantineUnknownPayment, raw)
 		}
 		return nil, errors.New("查询支付记录失败")
 	}
 
-	// 如果已支付，直接返回
+	// 如果已支付：同一交易号的重复通知直接返回（幂等），不同交易号视为重复支付
 	if p.PaymentStatus == 1 {
-		return &p, nil
+		if p.TransactionNo == transactionNo {
+			return &p, nil
+		}
+		return nil, s.quarantine(&p.PaymentID, provider, transactionNo, p.Amount, amount, QuarantineDuplicatePayment, raw)
+	}
+	if p.PaymentStatus != 0 {
+		return nil, s.quarantine(&p.PaymentID, provider, transactionNo, p.Amount, amount, QuarantineInvalidStatus, raw)
+	}
+	if p.Method != provider {
+		return nil, s.quarantine(&p.PaymentID, provider, transactionNo, p.Amount, amount, QuarantineProviderMismatch, raw)
+	}
+	if !sameAmount(p.Amount, amount) {
+		return nil, s.quarantine(&p.PaymentID, provider, transactionNo, p.Amount, amount, QuarantineAmountMismatch, raw)
 	}
-
-	// 保存原始的TransactionNo用于判断支付类型（在更新之前）
-	originalTransactionNo := p.TransactionNo
 
 	// 更新 payment_record
 	// 检查TransactionNo是否已存在（避免唯一约束冲突）
@@ -274,70 +362,79 @@ func (s *Service) HandleNotify(paymentID uint64, amount float64, provider, trans
 	now := time.Now()
 	p.PaymentStatus = 1
 	p.TransactionNo = transactionNo
-	p.Method = provider // ensure provider saved
-	p.Amount = amount
 	p.PayTime = &now
 
-	if err := inits.DB.Save(&p).Error; err != nil {
+	// 仅当记录仍为待支付时更新：读取后记录可能已被作废（如罚款进入申诉），此时回调转入隔离表
+	// 业务单据结算、支付回执与支付记录在同一事务中完成：结算失败时支付记录保持待支付，渠道重试回调可重新结算
+	updated := false
+	err := inits.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&model.PaymentRecord{}).
+			Where("payment_id = ? AND payment_status = 0", p.PaymentID).
+			Updates(map[string]interface{}{"payment_status": 1, "transaction_no": transactionNo, "pay_time": now})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		updated = true
+		if err := s.settleWithTx(tx, &p); err != nil {
+			return fmt.Errorf("业务单据更新失败: %w", err)
+		}
+		return s.receiptWithTx(tx, &p)
+	})
+	if err != nil {
 		return nil, fmt.Errorf("更新支付记录失败: %w", err)
 	}
+	if !updated {
+		return nil, s.quarantine(&p.PaymentID, provider, transactionNo, p.Amount, amount, QuarantineInvalidStatus, raw)
+	}
+	return &p, nil
+}
 
-	// 根据原始TransactionNo前缀判断支付类型（在更新之前保存的）
-	// - PENDING_VIO_ 开头：违规支付
-	// - PENDING_ 开头：停车支付或预订支付（需要进一步判断）
-	
-	// 先检查是否是违规支付（通过原始TransactionNo前缀判断）
-	// 注意：TransactionNo格式为 "PENDING_VIO_{violation_id}_{timestamp}"，前缀是 "PENDING_VIO_"（12个字符）
-	if len(originalTransactionNo) >= 12 && originalTransactionNo[:12] == "PENDING_VIO_" {
-		var vio model.ViolationRecord
-		if err := inits.DB.First(&vio, p.OrderID).Error; err == nil {
-			// 更新违规记录状态为已处理/已支付
-			vio.Status = 1
-			if err := inits.DB.Save(&vio).Error; err != nil {
-				return &p, errors.New("更新违规记录失败")
-			}
-			return &p, nil
+// receiptWithTx 写入支付回执通知（访客停车没有用户账号，不发送）
+func (s *Service) receiptWithTx(tx *gorm.DB, p *model.PaymentRecord) error {
+	if p.UserID == nil || p.PayTime == nil {
+		return nil
+	}
+	return s.notifier.NotifyWithTx(tx, notify.EventPaymentReceipt, notify.Recipient{UserID: *p.UserID},
+		fmt.Sprintf("payment_receipt:%d", p.PaymentID), map[string]interface{}{
+			"payment_id":   p.PaymentID,
+			"payable_type": p.PayableType,
+			"payable_name": payableName(p.PayableType),
+			"method":       methodName(p.Method),
+			"amount":       fmt.Sprintf("%.2f", p.Amount),
+			"pay_time":     p.PayTime.Format("2006-01-02 15:04:05"),
+		})
+}
+
+// settleWithTx 按支付对象类型结算对应的业务单据，与支付记录的更新在同一事务中执行
+func (s *Service) settleWithTx(tx *gorm.DB, p *model.PaymentRecord) error {
+	switch p.PayableType {
+	case model.PayableReservation:
+		if p.OrderID == nil {
+			return errors.New("支付记录缺少预订订单ID")
 		}
-		// 如果找不到违规记录，继续尝试其他类型
-	}
-	
-	// 尝试查找 reservation 表（预订支付）
-	var reservation model.ReservationOrder
-	if err := inits.DB.First(&reservation, p.OrderID).Error; err == nil {
-		// 有 reservation 记录 -> 使用 bookingSvc.PayBooking 以保持一致行为
-		_, err := s.bookingSvc.PayBooking(reservation.OrderID, p.UserID, amount, provider, transactionNo)
-		if err != nil {
-			// 记录已更新为支付，但 bookingSvc 更新失败
-			return &p, fmt.Errorf("支付记录已更新，但订单更新失败: %w", err)
+		return s.bookingSvc.MarkBookingPaidWithTx(tx, *p.OrderID, p.Amount)
+	case model.PayableParking:
+		if p.ParkingRecordID == nil {
+			return errors.New("支付记录缺少停车记录ID")
 		}
-		return &p, nil
-	}
-
-	// 再尝试 parking_record（停车支付）
-	var park model.ParkingRecord
-	if err := inits.DB.First(&park, p.OrderID).Error; err == nil {
-		// 更新停车记录的支付相关字段
-		park.PaymentStatus = 1
-		park.FeePaid = amount
-		if err := inits.DB.Save(&park).Error; err != nil {
-			return &p, errors.New("更新停车记录失败")
+		return tx.Model(&model.ParkingRecord{}).
+			Where("record_id = ?", *p.ParkingRecordID).
+			Updates(map[string]interface{}{"payment_status": 1, "fee_paid": p.Amount}).Error
+	case model.PayableViolation:
+		if p.ViolationID == nil {
+			return errors.New("支付记录缺少违规记录ID")
 		}
-		return &p, nil
-	}
-
-	// 最后再尝试 violation_record（作为兜底，防止TransactionNo格式异常的情况）
-	var vio model.ViolationRecord
-	if err := inits.DB.First(&vio, p.OrderID).Error; err == nil {
-		// 更新违规记录状态为已处理/已支付
-		vio.Status = 1
-		if err := inits.DB.Save(&vio).Error; err != nil {
-			return &p, errors.New("更新违规记录失败")
+		now := time.Now()
+		return tx.Model(&model.ViolationRecord{}).
+			Where("violation_id = ?", *p.ViolationID).
+			Updates(map[string]interface{}{"status": 1, "process_time": &now}).Error
+	case model.PayableWalletTopup:
+		if p.WalletID == nil {
+			return errors.New("支付记录缺少钱包ID")
 		}
-		return &p, nil
+		return s.walletSvc.CreditWithTx(tx, *p.WalletID, p.Amount, wallet.LedgerTopup,
+			fmt.Sprintf("TOPUP-%d", p.PaymentID), &p.PaymentID, "钱包充值")
+	default:
+		return fmt.Errorf("未知的支付对象类型: %s", p.PayableType)
 	}
-
-	// 如果没找到任何关联表，支付记录已经更新为已支付，返回成功
-	// 这种情况可能是数据不一致，但支付已经完成，不应该阻止支付流程
-	// 记录警告日志，但返回成功
-	return &p, nil
 }
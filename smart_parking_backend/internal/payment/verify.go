@@ -0,0 +1,197 @@
+package payment
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"smart_parking_backend/internal/inits"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 验签失败类错误，处理函数据此返回 401
+var (
+	ErrInvalidSignature = errors.New("回调签名校验失败")
+	ErrNotifyExpired    = errors.New("回调时间戳已过期")
+	ErrNotifyReplayed   = errors.New("重复的回调请求")
+)
+
+// defaultMaxSkew 回调时间戳默认允许的最大偏差
+const defaultMaxSkew = 5 * time.Minute
+
+// NotifyParams 支付渠道回调参数（全部按字符串参与签名，避免数字格式化导致签名不一致）
+type NotifyParams struct {
+	PaymentID     string `json:"payment_id" form:"payment_id" binding:"required"`
+	Amount        string `json:"amount" form:"amount" binding:"required"`
+	TransactionNo string `json:"transaction_no" form:"transaction_no" binding:"required"`
+	Provider      string `json:"provider" form:"provider" binding:"required"`   // "alipay" | "wechat"
+	Timestamp     string `json:"timestamp" form:"timestamp" binding:"required"` // Unix 秒
+	Nonce         string `json:"nonce" form:"nonce" binding:"required"`
+	SignType      string `json:"sign_type" form:"sign_type"`
+	Sign          string `json:"sign" form:"sign" binding:"required"`
+}
+
+// signFields 参与签名的字段（不含 sign 与 sign_type）
+func (n *NotifyParams) signFields() map[string]string {
+	return map[string]string{
+		"payment_id":     n.PaymentID,
+		"amount":         n.Amount,
+		"transaction_no": n.TransactionNo,
+		"provider":       n.Provider,
+		"timestamp":      n.Timestamp,
+		"nonce":          n.Nonce,
+	}
+}
+
+// CanonicalString 按参数名升序拼接 key=value&key=value，空值不参与签名
+func CanonicalString(fields map[string]string) string {
+	keys := make([]string, 0, len(fields))
+	for k, v := range fields {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+fields[k])
+	}
+	return strings.Join(parts, "&")
+}
+
+// SignHMAC 使用 HMAC-SHA256 计算签名（十六进制小写）
+func SignHMAC(secret, content string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(content))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyHMAC 校验 HMAC-SHA256 签名
+func verifyHMAC(secret, content, sign string) error {
+	if secret == "" {
+		return errors.New("未配置回调验签密钥")
+	}
+	expected := SignHMAC(secret, content)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(sign))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// verifyRSA2 使用平台公钥校验 SHA256WithRSA 签名（签名为 Base64）
+func verifyRSA2(publicKeyPath, content, sign string) error {
+	pub, err := loadRSAPublicKey(publicKeyPath)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	digest := sha256.Sum256([]byte(content))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// loadRSAPublicKey 读取 PEM 格式公钥（支持 PKIX 与 PKCS1）
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	if path == "" {
+		return nil, errors.New("未配置回调验签公钥")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取验签公钥失败: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("验签公钥格式错误")
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		if pub, ok := key.(*rsa.PublicKey); ok {
+			return pub, nil
+		}
+		return nil, errors.New("验签公钥不是 RSA 公钥")
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+// VerifyNotify 校验回调：签名 → 时间戳 → nonce 防重放
+func (s *Service) VerifyNotify(n *NotifyParams) error {
+	if s.cfg == nil {
+		return errors.New("支付配置未加载")
+	}
+	provider, ok := s.cfg.Provider(n.Provider)
+	if !ok {
+		return errors.New("不支持的支付渠道")
+	}
+
+	// 1. 签名校验（签名类型以配置为准，不信任回调中的 sign_type）
+	content := CanonicalString(n.signFields())
+	var err error
+	switch strings.ToUpper(provider.SignType) {
+	case "RSA2":
+		err = verifyRSA2(provider.PublicKeyPath, content, n.Sign)
+	case "HMAC-SHA256":
+		err = verifyHMAC(provider.NotifySecret, content, n.Sign)
+	default:
+		err = errors.New("未配置回调签名类型")
+	}
+	if err != nil {
+		return err
+	}
+
+	// 2. 时间戳校验
+	maxSkew := defaultMaxSkew
+	if s.cfg.Notify.MaxSkewSeconds > 0 {
+		maxSkew = time.Duration(s.cfg.Notify.MaxSkewSeconds) * time.Second
+	}
+	ts, err := strconv.ParseInt(n.Timestamp, 10, 64)
+	if err != nil {
+		return ErrNotifyExpired
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > maxSkew || skew < -maxSkew {
+		return ErrNotifyExpired
+	}
+
+	// 3. nonce 防重放：时间窗口内同一渠道的 nonce 只能使用一次
+	if inits.RedisClient == nil {
+		return errors.New("Redis 未初始化，无法校验回调")
+	}
+	fresh, err := inits.RedisClient.SetNX(context.Background(), notifyNonceKey(n), n.PaymentID, 2*maxSkew).Result()
+	if err != nil {
+		return fmt.Errorf("校验回调 nonce 失败: %w", err)
+	}
+	if !fresh {
+		return ErrNotifyReplayed
+	}
+	return nil
+}
+
+// ReleaseNotifyNonce 回调结算失败时释放 nonce，渠道用同一 nonce 重试时可以再次结算
+func (s *Service) ReleaseNotifyNonce(n *NotifyParams) {
+	if inits.RedisClient == nil {
+		return
+	}
+	if err := inits.RedisClient.Del(context.Background(), notifyNonceKey(n)).Err(); err != nil {
+		log.Printf("释放回调 nonce 失败: payment_id=%s, err=%v", n.PaymentID, err)
+	}
+}
+
+func notifyNonceKey(n *NotifyParams) string {
+	return fmt.Sprintf("payment:notify:nonce:%s:%s", n.Provider, n.Nonce)
+}
@@ -0,0 +1,241 @@
+package payment
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"smart_parking_backend/internal/inits"
+)
+
+func TestCanonicalString(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]string
+		want   string
+	}{
+		{"按参数名升序", map[string]string{"b": "2", "a": "1", "c": "3"}, "a=1&b=2&c=3"},
+		{"空值不参与签名", map[string]string{"a": "1", "b": "", "c": "3"}, "a=1&c=3"},
+		{"全部为空", map[string]string{"a": ""}, ""},
+		{
+			"回调字段",
+			(&NotifyParams{PaymentID: "12", Amount: "5.00", TransactionNo: "T1", Provider: "alipay", Timestamp: "1700000000", Nonce: "n1", Sign: "ignored"}).signFields(),
+			"amount=5.00&nonce=n1&payment_id=12&provider=alipay&timestamp=1700000000&transaction_no=T1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanonicalString(tt.fields); got != tt.want {
+				t.Errorf("CanonicalString() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	const content = "amount=5.00&payment_id=12"
+	sign := SignHMAC("secret", content)
+	tests := []struct {
+		name    string
+		secret  string
+		sign    string
+		wantErr error
+	}{
+		{"签名正确", "secret", sign, nil},
+		{"签名大小写不敏感", "secret", strings.ToUpper(sign), nil},
+		{"密钥不一致", "other", sign, ErrInvalidSignature},
+		{"签名被篡改", "secret", SignHMAC("secret", content+"0"), ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyHMAC(tt.secret, content, tt.sign); !errors.Is(err, tt.wantErr) {
+				t.Errorf("verifyHMAC() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if err := verifyHMAC("", content, sign); err == nil {
+		t.Error("未配置密钥时应返回错误")
+	}
+}
+
+// writeRSAPublicKey 生成 RSA 密钥对，按指定 PEM 类型写入公钥文件
+func writeRSAPublicKey(t *testing.T, pkix bool) (*rsa.PrivateKey, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("生成 RSA 密钥失败: %v", err)
+	}
+	block := &pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)}
+	if pkix {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			t.Fatalf("编码公钥失败: %v", err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	}
+	path := filepath.Join(t.TempDir(), "public.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("写入公钥失败: %v", err)
+	}
+	return key, path
+}
+
+func signRSA2(t *testing.T, key *rsa.PrivateKey, content string) string {
+	t.Helper()
+	digest := sha256.Sum256([]byte(content))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("RSA 签名失败: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestVerifyRSA2(t *testing.T) {
+	const content = "amount=5.00&payment_id=12"
+	pkixKey, pkixPath := writeRSAPublicKey(t, true)
+	pkcs1Key, pkcs1Path := writeRSAPublicKey(t, false)
+
+	tests := []struct {
+		name    string
+		path    string
+		sign    string
+		wantErr error
+	}{
+		{"PKIX 公钥验签通过", pkixPath, signRSA2(t, pkixKey, content), nil},
+		{"PKCS1 公钥验签通过", pkcs1Path, signRSA2(t, pkcs1Key, content), nil},
+		{"其他私钥签名", pkixPath, signRSA2(t, pkcs1Key, content), ErrInvalidSignature},
+		{"内容被篡改", pkixPath, signRSA2(t, pkixKey, content+"0"), ErrInvalidSignature},
+		{"签名不是 Base64", pkixPath, "not-base64!", ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyRSA2(tt.path, content, tt.sign); !errors.Is(err, tt.wantErr) {
+				t.Errorf("verifyRSA2() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if err := verifyRSA2("", content, "x"); err == nil {
+		t.Error("未配置公钥时应返回错误")
+	}
+}
+
+// TestVerifyNotify 验签与时间戳校验；nonce 防重放依赖 Redis，通过签名和时间戳校验的回调在未连接 Redis 时返回初始化错误
+func TestVerifyNotify(t *testing.T) {
+	prev := inits.RedisClient
+	inits.RedisClient = nil
+	t.Cleanup(func() { inits.RedisClient = prev })
+
+	rsaKey, rsaPath := writeRSAPublicKey(t, true)
+	cfg := &Config{}
+	cfg.PaymentGateway.Alipay = ProviderConfig{SignType: "RSA2", PublicKeyPath: rsaPath}
+	cfg.PaymentGateway.Wechat = ProviderConfig{SignType: "HMAC-SHA256", NotifySecret: "wechat-secret"}
+	cfg.Notify.MaxSkewSeconds = 60
+	svc := &Service{cfg: cfg}
+
+	now := time.Now().Unix()
+	params := func(provider string, ts int64) *NotifyParams {
+		n := &NotifyParams{PaymentID: "12", Amount: "5.00", TransactionNo: "T1", Provider: provider, Timestamp: strconv.FormatInt(ts, 10), Nonce: "n1"}
+		content := CanonicalString(n.signFields())
+		if provider == "alipay" {
+			n.Sign = signRSA2(t, rsaKey, content)
+		} else {
+			n.Sign = SignHMAC("wechat-secret", content)
+		}
+		return n
+	}
+	errRedis := errors.New("Redis 未初始化，无法校验回调")
+
+	tests := []struct {
+		name    string
+		params  *NotifyParams
+		mutate  func(n *NotifyParams)
+		wantErr error
+	}{
+		{name: "RSA2 签名与时间戳通过", params: params("alipay", now), wantErr: errRedis},
+		{name: "HMAC 签名与时间戳通过", params: params("wechat", now), wantErr: errRedis},
+		{name: "sign_type 以配置为准", params: params("wechat", now), mutate: func(n *NotifyParams) { n.SignType = "NONE" }, wantErr: errRedis},
+		{name: "金额被篡改", params: params("wechat", now), mutate: func(n *NotifyParams) { n.Amount = "0.01" }, wantErr: ErrInvalidSignature},
+		{name: "RSA2 金额被篡改", params: params("alipay", now), mutate: func(n *NotifyParams) { n.Amount = "0.01" }, wantErr: ErrInvalidSignature},
+		{name: "时间戳过早", params: params("wechat", now-120), wantErr: ErrNotifyExpired},
+		{name: "时间戳超前", params: params("wechat", now+120), wantErr: ErrNotifyExpired},
+		{name: "不支持的渠道", params: params("wechat", now), mutate: func(n *NotifyParams) { n.Provider = "paypal" }, wantErr: errors.New("不支持的支付渠道")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.mutate != nil {
+				tt.mutate(tt.params)
+			}
+			err := svc.VerifyNotify(tt.params)
+			if err == nil || err.Error() != tt.wantErr.Error() {
+				t.Errorf("VerifyNotify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSandboxEnabled(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		env     string
+		want    bool
+	}{
+		{"配置与环境变量均开启", true, "true", true},
+		{"仅配置开启", true, "", false},
+		{"仅环境变量开启", false, "true", false},
+		{"环境变量不是 true", true, "1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(sandboxEnvKey, tt.env)
+			cfg := &Config{}
+			cfg.Sandbox.Enabled = tt.enabled
+			if got := cfg.SandboxEnabled(); got != tt.want {
+				t.Errorf("SandboxEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	var nilCfg *Config
+	if nilCfg.SandboxEnabled() {
+		t.Error("未加载配置时沙箱模式不应生效")
+	}
+}
+
+func TestLoadSandboxConfigNotifySecretFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "payment.yaml")
+	yml := "payment_gateway:\n  wechat:\n    sign_type: \"HMAC-SHA256\"\n    notify_secret_env: \"TEST_WECHAT_NOTIFY_SECRET\"\n    notify_secret: \"from-yaml\"\n"
+	if err := os.WriteFile(path, []byte(yml), 0o600); err != nil {
+		t.Fatalf("写入配置失败: %v", err)
+	}
+	tests := []struct {
+		name string
+		env  string
+		want string
+	}{
+		{"环境变量优先", "from-env", "from-env"},
+		{"未设置环境变量时使用配置文件", "", "from-yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_WECHAT_NOTIFY_SECRET", tt.env)
+			cfg, err := LoadSandboxConfig(path)
+			if err != nil {
+				t.Fatalf("LoadSandboxConfig() error = %v", err)
+			}
+			if got := cfg.PaymentGateway.Wechat.NotifySecret; got != tt.want {
+				t.Errorf("NotifySecret = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"smart_parking_backend/internal/wallet"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 钱包充值单笔金额上限
+const maxTopupAmount = 5000.0
+
+// ----- 钱包充值 -----
+
+// CreateTopupPayment 创建钱包充值支付单（通过支付宝/微信支付），回调成功后按 payable_type=wallet_topup 入账
+func (s *Service) CreateTopupPayment(userID uint, amount float64, method string) (string, uint64, error) {
+	if method != "alipay" && method != "wechat" {
+		return "", 0, errors.New("充值仅支持支付宝或微信支付")
+	}
+	amount = round2(amount)
+	if amount <= 0 || amount > maxTopupAmount {
+		return "", 0, fmt.Errorf("充值金额需在 0.01-%.0f 元之间", maxTopupAmount)
+	}
+
+	w, err := s.walletSvc.GetWallet(userID)
+	if err != nil {
+		return "", 0, errors.New("开通钱包失败")
+	}
+	if w.Status != 1 {
+		return "", 0, wallet.ErrWalletFrozen
+	}
+
+	now := time.Now()
+	p := &model.PaymentRecord{
+		PayableType:   model.PayableWalletTopup,
+		WalletID:      &w.WalletID,
+		UserID:        &userID,
+		Amount:        amount,
+		Method:        method,
+		TransactionNo: fmt.Sprintf("PENDING_TOPUP_%d_%d", w.WalletID, now.UnixNano()),
+		PaymentStatus: 0,
+		CreateTime:    now,
+	}
+	if err := inits.DB.Create(p).Error; err != nil {
+		return "", 0, fmt.Errorf("创建支付记录失败: %w", err)
+	}
+
+	u := fmt.Sprintf("%s?provider=%s&payment_id=%d", s.simulateBase, url.QueryEscape(method), p.PaymentID)
+	return u, p.PaymentID, nil
+}
+
+// ----- 钱包支付 -----
+
+// PayWithWallet 使用钱包余额支付预订/停车/违规，扣款、支付记录与业务单据结算在同一事务中完成，无需跳转
+// 只能支付本人的单据；访客停车没有钱包，需使用支付宝/微信
+func (s *Service) PayWithWallet(orderID uint, typ string, userID uint) (*model.PaymentRecord, error) {
+	now := time.Now()
+	p := &model.PaymentRecord{
+		PayableType:   typ,
+		UserID:        &userID,
+		Method:        "wallet",
+		PaymentStatus: 1,
+		PayTime:       &now,
+		CreateTime:    now,
+	}
+
+	err := inits.DB.Transaction(func(tx *gorm.DB) error {
+		amount, column, err := lockPayableForWallet(tx, p, orderID, userID)
+		if err != nil {
+			return err
+		}
+		p.Amount = amount
+		p.TransactionNo = fmt.Sprintf("WALLET_%s_%d_%d", typ, orderID, now.UnixNano())
+
+		// 作废同一单据上未完成的第三方待支付记录，之后到达的回调会进入隔离表而不会重复结算
+		if err := tx.Model(&model.PaymentRecord{}).
+			Where("payable_type = ? AND "+column+" = ? AND payment_status = 0", typ, orderID).
+			Update("payment_status", 2).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(p).Error; err != nil {
+			return fmt.Errorf("创建支付记录失败: %w", err)
+		}
+		if _, err := s.walletSvc.DebitWithTx(tx, userID, amount, wallet.LedgerPayment,
+			fmt.Sprintf("PAY-%d", p.PaymentID), &p.PaymentID, fmt.Sprintf("%s支付", payableName(typ))); err != nil {
+			return err
+		}
+		if err := s.settleWithTx(tx, p); err != nil {
+			return fmt.Errorf("业务单据更新失败: %w", err)
+		}
+		return s.receiptWithTx(tx, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// lockPayableForWallet 锁定待支付单据并校验归属与状态，返回应付金额与支付记录中对应的外键列名
+func lockPayableForWallet(tx *gorm.DB, p *model.PaymentRecord, id, userID uint) (float64, string, error) {
+	lock := tx.Clauses(clause.Locking{Strength: "UPDATE"})
+	switch p.PayableType {
+	case model.PayableReservation:
+		var order model.ReservationOrder
+		if err := lock.First(&order, id).Error; err != nil {
+			return 0, "", errors.New("订单不存在")
+		}
+		if order.UserID != userID {
+			return 0, "", errors.New("无权支付该订单")
+		}
+		if order.PaymentStatus != 0 {
+			return 0, "", errors.New("订单已支付")
+		}
+		if order.Status == 0 {
+			return 0, "", errors.New("订单已取消")
+		}
+		if order.TotalFee <= 0 {
+			return 0, "", errors.New("订单金额为0，请确认金额")
+		}
+		p.OrderID = &order.OrderID
+		return order.TotalFee, "order_id", nil
+	case model.PayableParking:
+		var record model.ParkingRecord
+		if err := lock.First(&record, id).Error; err != nil {
+			return 0, "", errors.New("停车记录不存在")
+		}
+		if record.UserID == nil {
+			return 0, "", errors.New("访客停车不支持钱包支付，请使用支付宝或微信")
+		}
+		if *record.UserID != userID {
+			return 0, "", errors.New("无权支付该停车记录")
+		}
+		if record.PaymentStatus == 1 {
+			return 0, "", errors.New("停车费已支付")
+		}
+		if record.FeeCalculated <= 0 {
+			return 0, "", errors.New("停车费用为0，请确认金额")
+		}
+		p.ParkingRecordID = &record.RecordID
+		return record.FeeCalculated, "parking_record_id", nil
+	case model.PayableViolation:
+		var vio model.ViolationRecord
+		if err := lock.First(&vio, id).Error; err != nil {
+			return 0, "", errors.New("违规记录不存在")
+		}
+		if vio.UserID != userID {
+			return 0, "", errors.New("无权支付该违规记录")
+		}
+		if err := checkViolationPayable(&vio); err != nil {
+			return 0, "", err
+		}
+		if vio.FineAmount <= 0 {
+			return 0, "", errors.New("罚款金额为0，请确认金额")
+		}
+		p.ViolationID = &vio.ViolationID
+		return vio.FineAmount, "violation_id", nil
+	default:
+		return 0, "", errors.New("未知的订单类型")
+	}
+}
+
+// payableName 支付对象类型的中文名称（用于流水备注）
+func payableName(typ string) string {
+	switch typ {
+	case model.PayableReservation:
+		return "预订"
+	case model.PayableParking:
+		return "停车费"
+	case model.PayableViolation:
+		return "罚款"
+	case model.PayableWalletTopup:
+		return "钱包充值"
+	default:
+		return typ
+	}
+}
+
+// methodName 支付方式的中文名称（用于通知内容）
+func methodName(method string) string {
+	switch method {
+	case "alipay":
+		return "支付宝"
+	case "wechat":
+		return "微信"
+	case "wallet":
+		return "钱包"
+	default:
+		return method
+	}
+}
+
+// ----- 钱包退款 -----
+
+// walletRefundProvider 钱包支付的退款渠道：原路退回钱包余额
+type walletRefundProvider struct {
+	svc *wallet.Service
+}
+
+func (w walletRefundProvider) Refund(p *model.PaymentRecord, refundNo string, amount float64) (string, error) {
+	if p.UserID == nil {
+		return "", errors.New("支付记录缺少用户")
+	}
+	wal, err := w.svc.GetWallet(*p.UserID)
+	if err != nil {
+		return "", err
+	}
+	if err := w.svc.Credit(wal.WalletID, amount, wallet.LedgerRefund, "REFUND-"+refundNo, &p.PaymentID,
+		fmt.Sprintf("%s退款", payableName(p.PayableType))); err != nil {
+		return "", err
+	}
+	return "WALLET_" + refundNo, nil
+}
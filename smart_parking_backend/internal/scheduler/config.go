@@ -0,0 +1,52 @@
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// JobConfig 单个任务的配置
+type JobConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Interval string `yaml:"interval"` // 执行间隔，Go duration 格式，如 "1m"、"30s"、"24h"
+}
+
+// Config 映射 config.yaml 中的 scheduler 配置段
+type Config struct {
+	Scheduler struct {
+		Enabled bool                 `yaml:"enabled"`
+		Jobs    map[string]JobConfig `yaml:"jobs"`
+	} `yaml:"scheduler"`
+}
+
+// LoadConfig 从 YAML 文件加载定时任务配置（未配置 scheduler 段时返回禁用状态）
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// jobInterval 解析任务配置：返回是否启用与执行间隔
+func (c *Config) jobInterval(name string) (bool, time.Duration, error) {
+	jc, ok := c.Scheduler.Jobs[name]
+	if !ok || !jc.Enabled {
+		return false, 0, nil
+	}
+	interval, err := time.ParseDuration(jc.Interval)
+	if err != nil {
+		return false, 0, fmt.Errorf("任务 %s 的执行间隔无效: %w", name, err)
+	}
+	if interval < time.Second {
+		return false, 0, fmt.Errorf("任务 %s 的执行间隔不能小于 1s", name)
+	}
+	return true, interval, nil
+}
@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"net/http"
+	"smart_parking_backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler 定时任务管理接口
+type Handler struct {
+	sched *Scheduler
+}
+
+func NewHandler(sched *Scheduler) *Handler {
+	return &Handler{sched: sched}
+}
+
+// isSystemAdmin 定时任务为全局任务，仅系统管理员可查看
+func isSystemAdmin(c *gin.Context) bool {
+	role, _ := c.Get("role")
+	r, _ := role.(string)
+	return r == "system"
+}
+
+// ListJobs 查询已启用的任务及最近一次执行情况
+// GET /admin/scheduler/jobs
+func (h *Handler) ListJobs(c *gin.Context) {
+	if !isSystemAdmin(c) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "仅系统管理员可查看定时任务"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    gin.H{"enabled": h.sched.cfg.Scheduler.Enabled, "instance": h.sched.instance, "jobs": h.sched.Jobs()},
+	})
+}
+
+// ListRuns 分页查询任务执行记录
+// GET /admin/scheduler/runs?job=booking_expiry&page=1&page_size=20
+func (h *Handler) ListRuns(c *gin.Context) {
+	if !isSystemAdmin(c) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "仅系统管理员可查看定时任务"})
+		return
+	}
+	page := utils.ParseInt(c.DefaultQuery("page", "1"), 1)
+	pageSize := utils.ParseInt(c.DefaultQuery("page_size", "20"), 20)
+
+	list, total, err := h.sched.ListRuns(c.Query("job"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "查询执行记录失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    gin.H{"total": total, "page": page, "page_size": pageSize, "records": list},
+	})
+}
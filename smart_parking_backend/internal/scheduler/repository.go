@@ -0,0 +1,58 @@
+package scheduler
+
+import (
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"time"
+)
+
+// Repository 定时任务执行记录数据访问层
+type Repository struct{}
+
+// NewRepository 创建 Repository 实例
+func NewRepository() *Repository {
+	return &Repository{}
+}
+
+// CreateRun 写入一条执行中的记录
+func (r *Repository) CreateRun(run *model.JobRun) error {
+	return inits.DB.Create(run).Error
+}
+
+// FinishRun 更新执行结果
+func (r *Repository) FinishRun(runID uint64, status string, affected int, errMsg string, finish time.Time, durationMs int64) error {
+	return inits.DB.Model(&model.JobRun{}).Where("run_id = ?", runID).Updates(map[string]interface{}{
+		"status":      status,
+		"affected":    affected,
+		"error_msg":   errMsg,
+		"finish_time": finish,
+		"duration_ms": durationMs,
+	}).Error
+}
+
+// FindRuns 分页查询执行记录（按开始时间倒序），jobName 为空时查询全部任务
+func (r *Repository) FindRuns(jobName string, offset, limit int) ([]model.JobRun, int64, error) {
+	var list []model.JobRun
+	var total int64
+	query := inits.DB.Model(&model.JobRun{})
+	if jobName != "" {
+		query = query.Where("job_name = ?", jobName)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("run_id DESC").Offset(offset).Limit(limit).Find(&list).Error
+	return list, total, err
+}
+
+// FindLastRun 查询任务最近一次执行记录，没有时返回 nil
+func (r *Repository) FindLastRun(jobName string) (*model.JobRun, error) {
+	var list []model.JobRun
+	if err := inits.DB.Where("job_name = ?", jobName).Order("run_id DESC").Limit(1).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return &list[0], nil
+}
@@ -0,0 +1,19 @@
+package scheduler
+
+import (
+	"smart_parking_backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SchedulerRoutes 注册定时任务管理路由
+func SchedulerRoutes(r *gin.Engine, sched *Scheduler) {
+	handler := NewHandler(sched)
+
+	admin := r.Group("/admin/scheduler")
+	admin.Use(middleware.AdminAuthMiddleware())
+	{
+		admin.GET("/jobs", handler.ListJobs) // 查询已启用任务及最近执行情况
+		admin.GET("/runs", handler.ListRuns) // 查询任务执行记录
+	}
+}
@@ -0,0 +1,254 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// JobFunc 任务执行函数，返回本次处理的记录数
+type JobFunc func(ctx context.Context) (int, error)
+
+// 执行状态
+const (
+	RunRunning = "running"
+	RunSuccess = "success"
+	RunFailed  = "failed"
+)
+
+// 分布式锁的键前缀：scheduler:lock:{job_name}
+const lockKeyPrefix = "scheduler:lock:"
+
+// 仅当锁仍归属当前实例时才续期/释放
+var (
+	renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// job 已注册的任务
+type job struct {
+	name     string
+	desc     string
+	interval time.Duration
+	fn       JobFunc
+}
+
+// JobInfo 任务信息（供管理接口展示）
+type JobInfo struct {
+	Name     string        `json:"name"`
+	Desc     string        `json:"desc"`
+	Interval string        `json:"interval"`
+	LastRun  *model.JobRun `json:"last_run"`
+}
+
+// Scheduler 进程内定时任务调度器
+// 多实例部署时，每个任务在每个周期内通过 Redis 锁保证只有一个实例执行
+type Scheduler struct {
+	cfg      *Config
+	repo     *Repository
+	instance string
+	jobs     []*job
+
+	mu      sync.Mutex
+	started bool
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// New 创建调度器
+func New(cfg *Config, repo *Repository) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		cfg:      cfg,
+		repo:     repo,
+		instance: fmt.Sprintf("%s-%d", host, os.Getpid()),
+	}
+}
+
+// Register 注册任务，是否启用与执行间隔读取配置中同名的任务；未启用的任务不会注册
+func (s *Scheduler) Register(name, desc string, fn JobFunc) error {
+	enabled, interval, err := s.cfg.jobInterval(name)
+	if err != nil || !enabled {
+		return err
+	}
+	s.jobs = append(s.jobs, &job{name: name, desc: desc, interval: interval, fn: fn})
+	return nil
+}
+
+// Start 启动所有已注册任务（配置中 scheduler.enabled=false 时不启动）
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started || !s.cfg.Scheduler.Enabled {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.started = true
+
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, j)
+		log.Printf("⏱️  定时任务 %s 已启动，间隔 %s", j.name, j.interval)
+	}
+}
+
+// Stop 停止调度并等待正在执行的任务结束，超过 ctx 截止时间则直接返回
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.started {
+		s.mu.Unlock()
+		return nil
+	}
+	s.cancel()
+	s.started = false
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.New("等待定时任务结束超时")
+	}
+}
+
+// Jobs 返回已注册任务及其最近一次执行记录
+func (s *Scheduler) Jobs() []JobInfo {
+	list := make([]JobInfo, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		last, err := s.repo.FindLastRun(j.name)
+		if err != nil {
+			log.Printf("查询任务 %s 执行记录失败: %v", j.name, err)
+		}
+		list = append(list, JobInfo{Name: j.name, Desc: j.desc, Interval: j.interval.String(), LastRun: last})
+	}
+	return list
+}
+
+// loop 按间隔触发任务，启动后立即执行一次
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		s.runOnce(ctx, j)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOnce 获取锁后执行一次任务并写入执行记录；未获取到锁说明本周期已由其它实例执行
+func (s *Scheduler) runOnce(ctx context.Context, j *job) {
+	if ctx.Err() != nil {
+		return
+	}
+	// 锁的有效期略短于执行间隔，保证下个周期能重新获取
+	lease := j.interval * 9 / 10
+	if lease < time.Second {
+		lease = time.Second
+	}
+	key := lockKeyPrefix + j.name
+	token := fmt.Sprintf("%s-%d", s.instance, time.Now().UnixNano())
+
+	if inits.RedisClient == nil {
+		log.Printf("⚠️  定时任务 %s 跳过：Redis 未初始化，无法获取任务锁", j.name)
+		return
+	}
+	ok, err := inits.RedisClient.SetNX(ctx, key, token, lease).Result()
+	if err != nil {
+		log.Printf("⚠️  定时任务 %s 跳过：获取任务锁失败: %v", j.name, err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	// 执行时间超过锁有效期时自动续期，避免其它实例并发执行
+	stopRenew := make(chan struct{})
+	go func() {
+		t := time.NewTicker(lease / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-stopRenew:
+				return
+			case <-t.C:
+				if err := renewScript.Run(context.Background(), inits.RedisClient, []string{key}, token, lease.Milliseconds()).Err(); err != nil {
+					log.Printf("⚠️  定时任务 %s 续期任务锁失败: %v", j.name, err)
+				}
+			}
+		}
+	}()
+
+	start := time.Now()
+	run := &model.JobRun{JobName: j.name, Instance: s.instance, Status: RunRunning, StartTime: start}
+	if err := s.repo.CreateRun(run); err != nil {
+		log.Printf("写入任务 %s 执行记录失败: %v", j.name, err)
+	}
+
+	affected, runErr := s.safeRun(ctx, j)
+	close(stopRenew)
+
+	finish := time.Now()
+	status, errMsg := RunSuccess, ""
+	if runErr != nil {
+		status, errMsg = RunFailed, runErr.Error()
+		log.Printf("❌ 定时任务 %s 执行失败: %v", j.name, runErr)
+	}
+	if run.RunID > 0 {
+		if err := s.repo.FinishRun(run.RunID, status, affected, errMsg, finish, finish.Sub(start).Milliseconds()); err != nil {
+			log.Printf("更新任务 %s 执行记录失败: %v", j.name, err)
+		}
+	}
+
+	// 执行时间超过锁有效期（锁已被续期）时主动释放，否则保留到本周期结束
+	if finish.Sub(start) >= lease {
+		_ = releaseScript.Run(context.Background(), inits.RedisClient, []string{key}, token).Err()
+	}
+}
+
+// safeRun 执行任务并捕获 panic，避免单个任务异常导致进程退出
+func (s *Scheduler) safeRun(ctx context.Context, j *job) (affected int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return j.fn(ctx)
+}
+
+// ListRuns 分页查询执行记录
+func (s *Scheduler) ListRuns(jobName string, page, pageSize int) ([]model.JobRun, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	return s.repo.FindRuns(jobName, (page-1)*pageSize, pageSize)
+}
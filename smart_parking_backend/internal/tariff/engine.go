@@ -0,0 +1,252 @@
+package tariff
+
+import (
+	"math"
+	"smart_parking_backend/internal/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 计费明细项类型
+const (
+	ItemGrace     = "grace"      // 免费时长内
+	ItemFirstHour = "first_hour" // 首小时
+	ItemDay       = "day"        // 日间后续小时
+	ItemNight     = "night"      // 夜间小时
+	ItemDailyCap  = "daily_cap"  // 每日封顶减免
+)
+
+// LineItem 计费明细中的一项
+type LineItem struct {
+	Date   string  `json:"date"`    // 计费日期（YYYY-MM-DD）
+	Kind   string  `json:"kind"`    // 明细类型
+	RuleID uint    `json:"rule_id"` // 命中的规则ID（0表示停车场默认费率）
+	Hours  int     `json:"hours"`   // 计费小时数
+	Rate   float64 `json:"rate"`    // 单价
+	Amount float64 `json:"amount"`  // 小计（封顶减免为负数）
+}
+
+// Breakdown 计费结果及明细
+type Breakdown struct {
+	LotID           uint       `json:"lot_id"`
+	SpaceType       string     `json:"space_type"`
+	StartTime       string     `json:"start_time"`
+	EndTime         string     `json:"end_time"`
+	DurationMinutes int        `json:"duration_minutes"`
+	ChargedHours    int        `json:"charged_hours"`
+	Items           []LineItem `json:"items"`
+	Total           float64    `json:"total"`
+	// 凭预订入场时抵扣的预订已付金额，AmountDue 为抵扣后的应付金额
+	Prepaid   float64 `json:"prepaid,omitempty"`
+	AmountDue float64 `json:"amount_due"`
+}
+
+// ApplyPrepaid 从计费总额中抵扣已预付金额（不超过总额），返回抵扣后的应付金额
+func (b *Breakdown) ApplyPrepaid(prepaid float64) float64 {
+	if prepaid < 0 {
+		prepaid = 0
+	}
+	b.Prepaid = round2(math.Min(prepaid, b.Total))
+	b.AmountDue = round2(b.Total - b.Prepaid)
+	return b.AmountDue
+}
+
+// RuleSet 某停车场用于计费的规则集合
+type RuleSet struct {
+	Rules    []model.TariffRule
+	Holidays map[string]bool // 节假日日期集合（YYYY-MM-DD）
+	// DefaultRate 未配置任何规则时使用的小时费率（停车场 HourlyRate）
+	DefaultRate float64
+}
+
+// ruleFor 选取某一时刻适用的规则
+// 优先级：车位类型精确匹配优先于通用规则；日期类型 holiday > weekend/weekday > all
+func (rs *RuleSet) ruleFor(spaceType string, t time.Time) model.TariffRule {
+	date := t.Format("2006-01-02")
+	isHoliday := rs.Holidays[date]
+	isWeekend := t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+
+	best := -1
+	var picked model.TariffRule
+	for _, r := range rs.Rules {
+		if r.Status != 1 {
+			continue
+		}
+		score := 0
+		switch r.SpaceType {
+		case spaceType:
+			score += 10
+		case "":
+		default:
+			continue
+		}
+		switch r.DayType {
+		case "holiday":
+			if !isHoliday {
+				continue
+			}
+			score += 3
+		case "weekend":
+			if !isWeekend {
+				continue
+			}
+			score += 2
+		case "weekday":
+			if isWeekend {
+				continue
+			}
+			score += 2
+		case "all", "":
+		default:
+			continue
+		}
+		if score > best {
+			best = score
+			picked = r
+		}
+	}
+
+	if best < 0 {
+		// 未配置规则，兼容旧逻辑：按停车场小时费率计费
+		return model.TariffRule{
+			FirstHourRate: rs.DefaultRate,
+			HourlyRate:    rs.DefaultRate,
+		}
+	}
+	return picked
+}
+
+// Calculate 根据规则计算 [start, end) 时间段的费用
+// 计费以小时为单位向上取整（不足1小时按1小时），逐小时按当时适用的规则定价，
+// 再按自然日应用每日封顶；停车时长不超过免费时长时不收费
+func Calculate(rs *RuleSet, lotID uint, spaceType string, start, end time.Time) *Breakdown {
+	b := &Breakdown{
+		LotID:     lotID,
+		SpaceType: spaceType,
+		StartTime: start.Format("2006-01-02 15:04:05"),
+		EndTime:   end.Format("2006-01-02 15:04:05"),
+		Items:     []LineItem{},
+	}
+
+	duration := end.Sub(start)
+	if duration < 0 {
+		duration = 0
+	}
+	b.DurationMinutes = int(math.Ceil(duration.Minutes()))
+
+	startRule := rs.ruleFor(spaceType, start)
+	if startRule.GraceMinutes > 0 && b.DurationMinutes <= startRule.GraceMinutes {
+		b.Items = append(b.Items, LineItem{
+			Date:   start.Format("2006-01-02"),
+			Kind:   ItemGrace,
+			RuleID: startRule.RuleID,
+		})
+		return b
+	}
+
+	hours := int(math.Ceil(duration.Hours()))
+	if hours < 1 {
+		hours = 1 // 不足1小时按1小时计费
+	}
+	b.ChargedHours = hours
+
+	type itemKey struct {
+		date   string
+		kind   string
+		ruleID uint
+		rate   float64
+	}
+	var order []itemKey
+	grouped := make(map[itemKey]*LineItem)
+	dayTotals := make(map[string]float64)
+	dayRules := make(map[string]model.TariffRule)
+	var days []string
+
+	for i := 0; i < hours; i++ {
+		t := start.Add(time.Duration(i) * time.Hour)
+		date := t.Format("2006-01-02")
+		rule := rs.ruleFor(spaceType, t)
+		if _, ok := dayRules[date]; !ok {
+			dayRules[date] = rule
+			days = append(days, date)
+		}
+
+		kind, rate := ItemDay, rule.HourlyRate
+		if i == 0 {
+			kind, rate = ItemFirstHour, rule.FirstHourRate
+			if rate <= 0 {
+				rate = rule.HourlyRate
+			}
+		} else if rule.NightHourlyRate > 0 && inNight(rule, t) {
+			kind, rate = ItemNight, rule.NightHourlyRate
+		}
+
+		key := itemKey{date: date, kind: kind, ruleID: rule.RuleID, rate: rate}
+		item, ok := grouped[key]
+		if !ok {
+			item = &LineItem{Date: date, Kind: kind, RuleID: rule.RuleID, Rate: rate}
+			grouped[key] = item
+			order = append(order, key)
+		}
+		item.Hours++
+		item.Amount = round2(item.Amount + rate)
+		dayTotals[date] += rate
+	}
+
+	total := 0.0
+	for _, date := range days {
+		for _, key := range order {
+			if key.date == date {
+				b.Items = append(b.Items, *grouped[key])
+			}
+		}
+		dayTotal := round2(dayTotals[date])
+		if rule := dayRules[date]; rule.DailyCap > 0 && dayTotal > rule.DailyCap {
+			b.Items = append(b.Items, LineItem{
+				Date:   date,
+				Kind:   ItemDailyCap,
+				RuleID: rule.RuleID,
+				Amount: round2(rule.DailyCap - dayTotal),
+			})
+			dayTotal = rule.DailyCap
+		}
+		total += dayTotal
+	}
+	b.Total = round2(total)
+	b.AmountDue = b.Total
+	return b
+}
+
+// inNight 判断时刻是否处于规则的夜间时段（支持跨零点，如 22:00-07:00）
+func inNight(rule model.TariffRule, t time.Time) bool {
+	from, ok1 := parseClock(rule.NightStart)
+	to, ok2 := parseClock(rule.NightEnd)
+	if !ok1 || !ok2 || from == to {
+		return false
+	}
+	m := t.Hour()*60 + t.Minute()
+	if from < to {
+		return m >= from && m < to
+	}
+	return m >= from || m < to
+}
+
+// parseClock 解析 HH:MM 为当日分钟数
+func parseClock(s string) (int, bool) {
+	parts := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	if len(parts) != 2 {
+		return 0, false
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, false
+	}
+	return h*60 + m, true
+}
+
+// round2 金额保留两位小数
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
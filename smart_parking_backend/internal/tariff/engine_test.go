@@ -0,0 +1,247 @@
+package tariff
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"smart_parking_backend/internal/model"
+)
+
+var cst = time.FixedZone("CST", 8*3600)
+
+// at 构造北京时间的时刻，2026-10-14 为周三，2026-10-17 为周六
+func at(day, hour, min int) time.Time {
+	return time.Date(2026, time.October, day, hour, min, 0, 0, cst)
+}
+
+func TestCalculate(t *testing.T) {
+	base := model.TariffRule{RuleID: 1, Status: 1, DayType: "all", FirstHourRate: 6, HourlyRate: 4}
+	night := base
+	night.NightHourlyRate, night.NightStart, night.NightEnd = 2, "22:00", "07:00"
+	grace := base
+	grace.GraceMinutes = 15
+	capped := model.TariffRule{RuleID: 1, Status: 1, DayType: "all", FirstHourRate: 10, HourlyRate: 10, DailyCap: 50}
+	weekend := model.TariffRule{RuleID: 2, Status: 1, DayType: "weekend", FirstHourRate: 8, HourlyRate: 8}
+	charging := model.TariffRule{RuleID: 3, Status: 1, SpaceType: "充电", DayType: "all", FirstHourRate: 12, HourlyRate: 12}
+	holiday := model.TariffRule{RuleID: 4, Status: 1, DayType: "holiday", FirstHourRate: 20, HourlyRate: 20}
+	disabled := base
+	disabled.Status = 0
+
+	tests := []struct {
+		name       string
+		rules      []model.TariffRule
+		holidays   map[string]bool
+		spaceType  string
+		start, end time.Time
+		wantHours  int
+		wantTotal  float64
+		wantItems  []LineItem
+	}{
+		{
+			name:      "未配置规则按停车场小时费率，不足1小时向上取整",
+			start:     at(14, 10, 0),
+			end:       at(14, 12, 30),
+			wantHours: 3,
+			wantTotal: 15,
+			wantItems: []LineItem{
+				{Date: "2026-10-14", Kind: ItemFirstHour, Hours: 1, Rate: 5, Amount: 5},
+				{Date: "2026-10-14", Kind: ItemDay, Hours: 2, Rate: 5, Amount: 10},
+			},
+		},
+		{
+			name:      "免费时长内不收费",
+			rules:     []model.TariffRule{grace},
+			start:     at(14, 10, 0),
+			end:       at(14, 10, 15),
+			wantTotal: 0,
+			wantItems: []LineItem{{Date: "2026-10-14", Kind: ItemGrace, RuleID: 1}},
+		},
+		{
+			name:      "超出免费时长按首小时计费",
+			rules:     []model.TariffRule{grace},
+			start:     at(14, 10, 0),
+			end:       at(14, 10, 16),
+			wantHours: 1,
+			wantTotal: 6,
+			wantItems: []LineItem{{Date: "2026-10-14", Kind: ItemFirstHour, RuleID: 1, Hours: 1, Rate: 6, Amount: 6}},
+		},
+		{
+			name:      "夜间费率跨零点按自然日拆分",
+			rules:     []model.TariffRule{night},
+			start:     at(14, 21, 0),
+			end:       at(15, 1, 0),
+			wantHours: 4,
+			wantTotal: 12,
+			wantItems: []LineItem{
+				{Date: "2026-10-14", Kind: ItemFirstHour, RuleID: 1, Hours: 1, Rate: 6, Amount: 6},
+				{Date: "2026-10-14", Kind: ItemNight, RuleID: 1, Hours: 2, Rate: 2, Amount: 4},
+				{Date: "2026-10-15", Kind: ItemNight, RuleID: 1, Hours: 1, Rate: 2, Amount: 2},
+			},
+		},
+		{
+			name:      "每日封顶",
+			rules:     []model.TariffRule{capped},
+			start:     at(14, 8, 0),
+			end:       at(14, 18, 0),
+			wantHours: 10,
+			wantTotal: 50,
+			wantItems: []LineItem{
+				{Date: "2026-10-14", Kind: ItemFirstHour, RuleID: 1, Hours: 1, Rate: 10, Amount: 10},
+				{Date: "2026-10-14", Kind: ItemDay, RuleID: 1, Hours: 9, Rate: 10, Amount: 90},
+				{Date: "2026-10-14", Kind: ItemDailyCap, RuleID: 1, Amount: -50},
+			},
+		},
+		{
+			name:      "周末规则优先于通用规则",
+			rules:     []model.TariffRule{base, weekend},
+			start:     at(17, 10, 0),
+			end:       at(17, 11, 0),
+			wantHours: 1,
+			wantTotal: 8,
+			wantItems: []LineItem{{Date: "2026-10-17", Kind: ItemFirstHour, RuleID: 2, Hours: 1, Rate: 8, Amount: 8}},
+		},
+		{
+			name:      "工作日不命中周末规则",
+			rules:     []model.TariffRule{base, weekend},
+			start:     at(14, 10, 0),
+			end:       at(14, 11, 0),
+			wantHours: 1,
+			wantTotal: 6,
+			wantItems: []LineItem{{Date: "2026-10-14", Kind: ItemFirstHour, RuleID: 1, Hours: 1, Rate: 6, Amount: 6}},
+		},
+		{
+			name:      "车位类型精确匹配优先于日期类型",
+			rules:     []model.TariffRule{weekend, charging},
+			spaceType: "充电",
+			start:     at(17, 10, 0),
+			end:       at(17, 11, 0),
+			wantHours: 1,
+			wantTotal: 12,
+			wantItems: []LineItem{{Date: "2026-10-17", Kind: ItemFirstHour, RuleID: 3, Hours: 1, Rate: 12, Amount: 12}},
+		},
+		{
+			name:      "节假日规则",
+			rules:     []model.TariffRule{base, weekend, holiday},
+			holidays:  map[string]bool{"2026-10-14": true},
+			start:     at(14, 10, 0),
+			end:       at(14, 11, 0),
+			wantHours: 1,
+			wantTotal: 20,
+			wantItems: []LineItem{{Date: "2026-10-14", Kind: ItemFirstHour, RuleID: 4, Hours: 1, Rate: 20, Amount: 20}},
+		},
+		{
+			name:      "停用的规则不参与计费",
+			rules:     []model.TariffRule{disabled},
+			start:     at(14, 10, 0),
+			end:       at(14, 11, 0),
+			wantHours: 1,
+			wantTotal: 5,
+			wantItems: []LineItem{{Date: "2026-10-14", Kind: ItemFirstHour, Hours: 1, Rate: 5, Amount: 5}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spaceType := tt.spaceType
+			if spaceType == "" {
+				spaceType = "普通"
+			}
+			rs := &RuleSet{Rules: tt.rules, Holidays: tt.holidays, DefaultRate: 5}
+			b := Calculate(rs, 1, spaceType, tt.start, tt.end)
+			if b.ChargedHours != tt.wantHours {
+				t.Errorf("ChargedHours = %d, want %d", b.ChargedHours, tt.wantHours)
+			}
+			if b.Total != tt.wantTotal || b.AmountDue != tt.wantTotal {
+				t.Errorf("Total = %v, AmountDue = %v, want %v", b.Total, b.AmountDue, tt.wantTotal)
+			}
+			if !reflect.DeepEqual(b.Items, tt.wantItems) {
+				t.Errorf("Items = %+v\nwant %+v", b.Items, tt.wantItems)
+			}
+		})
+	}
+}
+
+func TestApplyPrepaid(t *testing.T) {
+	tests := []struct {
+		name        string
+		total       float64
+		prepaid     float64
+		wantPrepaid float64
+		wantDue     float64
+	}{
+		{"部分抵扣", 30, 20, 20, 10},
+		{"预付超过总额只抵扣总额", 30, 40, 30, 0},
+		{"预付为负数按0处理", 30, -5, 0, 30},
+		{"未预付", 30, 0, 0, 30},
+		{"金额保留两位小数", 10.1, 3.333, 3.33, 6.77},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Breakdown{Total: tt.total, AmountDue: tt.total}
+			due := b.ApplyPrepaid(tt.prepaid)
+			if due != tt.wantDue || b.AmountDue != tt.wantDue {
+				t.Errorf("ApplyPrepaid(%v) = %v, AmountDue = %v, want %v", tt.prepaid, due, b.AmountDue, tt.wantDue)
+			}
+			if b.Prepaid != tt.wantPrepaid {
+				t.Errorf("Prepaid = %v, want %v", b.Prepaid, tt.wantPrepaid)
+			}
+		})
+	}
+}
+
+func TestQuoteRejectsReversedRange(t *testing.T) {
+	svc := NewService(NewRepository())
+	if _, err := svc.Quote(1, "普通", at(14, 12, 0), at(14, 10, 0)); err == nil {
+		t.Fatal("结束时间早于开始时间时应返回错误")
+	}
+}
+
+func TestNormalizeSpaceType(t *testing.T) {
+	tests := map[string]string{
+		"":     "普通",
+		"  ":   "普通",
+		"充电桩":  "充电",
+		" 充电 ": "充电",
+		"普通":   "普通",
+		"VIP":  "VIP",
+	}
+	for in, want := range tests {
+		if got := NormalizeSpaceType(in); got != want {
+			t.Errorf("NormalizeSpaceType(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestValidateRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    model.TariffRule
+		wantErr bool
+		check   func(r model.TariffRule) bool
+	}{
+		{
+			name: "补全默认日期类型与夜间时段",
+			rule: model.TariffRule{LotID: 1, SpaceType: "充电桩"},
+			check: func(r model.TariffRule) bool {
+				return r.DayType == "all" && r.NightStart == "22:00" && r.NightEnd == "07:00" && r.SpaceType == "充电"
+			},
+		},
+		{name: "缺少停车场", rule: model.TariffRule{}, wantErr: true},
+		{name: "非法日期类型", rule: model.TariffRule{LotID: 1, DayType: "monday"}, wantErr: true},
+		{name: "负数费率", rule: model.TariffRule{LotID: 1, HourlyRate: -1}, wantErr: true},
+		{name: "夜间时间格式错误", rule: model.TariffRule{LotID: 1, NightStart: "25:00"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := tt.rule
+			err := validateRule(&rule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil && !tt.check(rule) {
+				t.Errorf("validateRule() 补全后的规则不符合预期: %+v", rule)
+			}
+		})
+	}
+}
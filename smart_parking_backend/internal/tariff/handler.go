@@ -0,0 +1,219 @@
+package tariff
+
+import (
+	"net/http"
+	"smart_parking_backend/internal/middleware"
+	"smart_parking_backend/internal/model"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler 计费模块 HTTP 处理
+type Handler struct {
+	svc *Service
+}
+
+func NewHandler(svc *Service) *Handler {
+	return &Handler{svc: svc}
+}
+
+// parseQueryTime 解析查询参数中的时间（支持 "2006-01-02 15:04:05" 与 RFC3339）
+func parseQueryTime(v string) (time.Time, error) {
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		loc = time.Local
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", v, loc); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.In(loc), nil
+}
+
+// Quote 费用试算
+// GET /api/tariff/quote?lot_id=1&space_type=普通&start_time=...&end_time=...
+func (h *Handler) Quote(c *gin.Context) {
+	lotID, err := strconv.Atoi(c.Query("lot_id"))
+	if err != nil || lotID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的停车场ID"})
+		return
+	}
+	start, err := parseQueryTime(c.Query("start_time"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "开始时间格式错误"})
+		return
+	}
+	end, err := parseQueryTime(c.Query("end_time"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "结束时间格式错误"})
+		return
+	}
+
+	breakdown, err := h.svc.Quote(uint(lotID), c.Query("space_type"), start, end)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": breakdown})
+}
+
+// ListRules 查询停车场计费规则
+// GET /admin/tariff/rules?lot_id=1
+func (h *Handler) ListRules(c *gin.Context) {
+	lotID, err := strconv.Atoi(c.Query("lot_id"))
+	if err != nil || lotID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的停车场ID"})
+		return
+	}
+	if !middleware.CanManageLot(c, uint(lotID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限管理该停车场"})
+		return
+	}
+	rules, err := h.svc.ListRules(uint(lotID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询计费规则失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "查询成功", "data": rules})
+}
+
+// CreateRule 新增计费规则
+// POST /admin/tariff/rules
+func (h *Handler) CreateRule(c *gin.Context) {
+	var rule model.TariffRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数无效"})
+		return
+	}
+	if !middleware.CanManageLot(c, rule.LotID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限管理该停车场"})
+		return
+	}
+	rule.RuleID = 0
+	if err := h.svc.SaveRule(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "计费规则添加成功", "data": rule})
+}
+
+// UpdateRule 修改计费规则
+// PUT /admin/tariff/rules/:id
+func (h *Handler) UpdateRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的规则ID"})
+		return
+	}
+	existing, err := h.svc.GetRule(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "计费规则不存在"})
+		return
+	}
+	if !middleware.CanManageLot(c, existing.LotID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限管理该停车场"})
+		return
+	}
+
+	var rule model.TariffRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数无效"})
+		return
+	}
+	// 规则所属停车场与创建时间不可修改
+	rule.RuleID = existing.RuleID
+	rule.LotID = existing.LotID
+	rule.CreateTime = existing.CreateTime
+	if err := h.svc.SaveRule(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "计费规则更新成功", "data": rule})
+}
+
+// DeleteRule 删除计费规则
+// DELETE /admin/tariff/rules/:id
+func (h *Handler) DeleteRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的规则ID"})
+		return
+	}
+	existing, err := h.svc.GetRule(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "计费规则不存在"})
+		return
+	}
+	if !middleware.CanManageLot(c, existing.LotID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限管理该停车场"})
+		return
+	}
+	if err := h.svc.DeleteRule(existing.RuleID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "计费规则删除成功"})
+}
+
+// ListHolidays 查询节假日
+// GET /admin/tariff/holidays
+func (h *Handler) ListHolidays(c *gin.Context) {
+	list, err := h.svc.ListHolidays()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询节假日失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "查询成功", "data": list})
+}
+
+// AddHoliday 新增节假日（仅系统管理员，节假日对全部停车场生效）
+// POST /admin/tariff/holidays
+func (h *Handler) AddHoliday(c *gin.Context) {
+	if role, _ := c.Get("role"); role != "system" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "仅系统管理员可维护节假日"})
+		return
+	}
+	var req struct {
+		Date string `json:"date" binding:"required"` // YYYY-MM-DD
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数无效"})
+		return
+	}
+	date, err := time.ParseInLocation("2006-01-02", req.Date, time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "日期格式应为 YYYY-MM-DD"})
+		return
+	}
+	holiday := model.TariffHoliday{Date: date, Name: req.Name}
+	if err := h.svc.AddHoliday(&holiday); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "添加节假日失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "节假日添加成功", "data": holiday})
+}
+
+// DeleteHoliday 删除节假日（仅系统管理员）
+// DELETE /admin/tariff/holidays/:id
+func (h *Handler) DeleteHoliday(c *gin.Context) {
+	if role, _ := c.Get("role"); role != "system" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "仅系统管理员可维护节假日"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的节假日ID"})
+		return
+	}
+	if err := h.svc.DeleteHoliday(uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "节假日删除成功"})
+}
@@ -0,0 +1,81 @@
+package tariff
+
+import (
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"time"
+)
+
+// Repository 计费规则数据访问层
+type Repository struct{}
+
+// NewRepository 创建 Repository 实例
+func NewRepository() *Repository {
+	return &Repository{}
+}
+
+// ==================== 计费规则（TariffRule）操作 ====================
+
+func (r *Repository) FindRulesByLot(lotID uint) ([]model.TariffRule, error) {
+	var list []model.TariffRule
+	err := inits.DB.Where("lot_id = ?", lotID).Order("rule_id ASC").Find(&list).Error
+	return list, err
+}
+
+func (r *Repository) GetRuleByID(ruleID uint) (*model.TariffRule, error) {
+	var rule model.TariffRule
+	err := inits.DB.First(&rule, ruleID).Error
+	return &rule, err
+}
+
+func (r *Repository) CreateRule(rule *model.TariffRule) error {
+	return inits.DB.Create(rule).Error
+}
+
+func (r *Repository) UpdateRule(rule *model.TariffRule) error {
+	return inits.DB.Save(rule).Error
+}
+
+func (r *Repository) DeleteRule(ruleID uint) error {
+	return inits.DB.Delete(&model.TariffRule{}, ruleID).Error
+}
+
+// ==================== 节假日（TariffHoliday）操作 ====================
+
+// FindHolidaysBetween 查询日期区间内的节假日，返回 YYYY-MM-DD 集合
+func (r *Repository) FindHolidaysBetween(start, end time.Time) (map[string]bool, error) {
+	var list []model.TariffHoliday
+	err := inits.DB.
+		Where("date BETWEEN ? AND ?", start.Format("2006-01-02"), end.Format("2006-01-02")).
+		Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	holidays := make(map[string]bool, len(list))
+	for _, h := range list {
+		holidays[h.Date.Format("2006-01-02")] = true
+	}
+	return holidays, nil
+}
+
+func (r *Repository) FindAllHolidays() ([]model.TariffHoliday, error) {
+	var list []model.TariffHoliday
+	err := inits.DB.Order("date ASC").Find(&list).Error
+	return list, err
+}
+
+func (r *Repository) CreateHoliday(h *model.TariffHoliday) error {
+	return inits.DB.Create(h).Error
+}
+
+func (r *Repository) DeleteHoliday(holidayID uint) error {
+	return inits.DB.Delete(&model.TariffHoliday{}, holidayID).Error
+}
+
+// ==================== 停车场（ParkingLot）操作 ====================
+
+func (r *Repository) GetLotByID(lotID uint) (*model.ParkingLot, error) {
+	var lot model.ParkingLot
+	err := inits.DB.First(&lot, lotID).Error
+	return &lot, err
+}
@@ -0,0 +1,29 @@
+package tariff
+
+import (
+	"smart_parking_backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TariffRoutes 注册计费模块相关路由
+func TariffRoutes(r *gin.Engine, svc *Service) {
+	handler := NewHandler(svc)
+
+	api := r.Group("/api/tariff")
+	{
+		api.GET("/quote", handler.Quote) // 费用试算
+	}
+
+	admin := r.Group("/admin/tariff")
+	admin.Use(middleware.AdminAuthMiddleware())
+	{
+		admin.GET("/rules", handler.ListRules)               // 查询停车场计费规则
+		admin.POST("/rules", handler.CreateRule)             // 新增计费规则
+		admin.PUT("/rules/:id", handler.UpdateRule)          // 修改计费规则
+		admin.DELETE("/rules/:id", handler.DeleteRule)       // 删除计费规则
+		admin.GET("/holidays", handler.ListHolidays)         // 查询节假日
+		admin.POST("/holidays", handler.AddHoliday)          // 新增节假日
+		admin.DELETE("/holidays/:id", handler.DeleteHoliday) // 删除节假日
+	}
+}
@@ -0,0 +1,139 @@
+package tariff
+
+import (
+	"encoding/json"
+	"errors"
+	"smart_parking_backend/internal/model"
+	"strings"
+	"time"
+)
+
+// Service 计费服务：预订报价与出场计费统一入口
+type Service struct {
+	repo *Repository
+}
+
+// NewService 创建 Service 实例
+func NewService(repo *Repository) *Service {
+	return &Service{repo: repo}
+}
+
+// NormalizeSpaceType 统一车位类型写法（兼容前端"充电桩"与数据库"充电"不一致的问题）
+func NormalizeSpaceType(spaceType string) string {
+	spaceType = strings.TrimSpace(spaceType)
+	if spaceType == "" {
+		return "普通"
+	}
+	if spaceType == "充电桩" {
+		return "充电"
+	}
+	return spaceType
+}
+
+// Quote 计算指定停车场、车位类型在 [start, end) 时间段内的费用
+func (s *Service) Quote(lotID uint, spaceType string, start, end time.Time) (*Breakdown, error) {
+	if end.Before(start) {
+		return nil, errors.New("结束时间不能早于开始时间")
+	}
+	lot, err := s.repo.GetLotByID(lotID)
+	if err != nil {
+		return nil, errors.New("停车场不存在")
+	}
+	rules, err := s.repo.FindRulesByLot(lotID)
+	if err != nil {
+		return nil, err
+	}
+	holidays, err := s.repo.FindHolidaysBetween(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &RuleSet{
+		Rules:       rules,
+		Holidays:    holidays,
+		DefaultRate: lot.HourlyRate,
+	}
+	return Calculate(rs, lotID, NormalizeSpaceType(spaceType), start, end), nil
+}
+
+// Encode 将计费明细序列化为 JSON 字符串，用于存入订单/停车记录
+func (b *Breakdown) Encode() string {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// ==================== 规则管理 ====================
+
+func (s *Service) ListRules(lotID uint) ([]model.TariffRule, error) {
+	return s.repo.FindRulesByLot(lotID)
+}
+
+func (s *Service) GetRule(ruleID uint) (*model.TariffRule, error) {
+	return s.repo.GetRuleByID(ruleID)
+}
+
+func (s *Service) SaveRule(rule *model.TariffRule) error {
+	if err := validateRule(rule); err != nil {
+		return err
+	}
+	if _, err := s.repo.GetLotByID(rule.LotID); err != nil {
+		return errors.New("停车场不存在")
+	}
+	if rule.RuleID == 0 {
+		return s.repo.CreateRule(rule)
+	}
+	return s.repo.UpdateRule(rule)
+}
+
+func (s *Service) DeleteRule(ruleID uint) error {
+	return s.repo.DeleteRule(ruleID)
+}
+
+func (s *Service) ListHolidays() ([]model.TariffHoliday, error) {
+	return s.repo.FindAllHolidays()
+}
+
+func (s *Service) AddHoliday(h *model.TariffHoliday) error {
+	return s.repo.CreateHoliday(h)
+}
+
+func (s *Service) DeleteHoliday(holidayID uint) error {
+	return s.repo.DeleteHoliday(holidayID)
+}
+
+// validateRule 校验规则字段合法性并补全默认值
+func validateRule(rule *model.TariffRule) error {
+	if rule.LotID == 0 {
+		return errors.New("停车场ID不能为空")
+	}
+	if rule.SpaceType != "" {
+		rule.SpaceType = NormalizeSpaceType(rule.SpaceType)
+	}
+	switch rule.DayType {
+	case "":
+		rule.DayType = "all"
+	case "all", "weekday", "weekend", "holiday":
+	default:
+		return errors.New("日期类型必须为 all、weekday、weekend 或 holiday")
+	}
+	if rule.GraceMinutes < 0 || rule.FirstHourRate < 0 || rule.HourlyRate < 0 ||
+		rule.NightHourlyRate < 0 || rule.DailyCap < 0 {
+		return errors.New("费率、免费时长与封顶金额不能为负数")
+	}
+	if rule.NightStart == "" {
+		rule.NightStart = "22:00"
+	}
+	if rule.NightEnd == "" {
+		rule.NightEnd = "07:00"
+	}
+	if _, ok := parseClock(rule.NightStart); !ok {
+		return errors.New("夜间开始时间格式应为 HH:MM")
+	}
+	if _, ok := parseClock(rule.NightEnd); !ok {
+		return errors.New("夜间结束时间格式应为 HH:MM")
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package violation
+
+import (
+	"errors"
+	"math"
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 违规记录状态
+const (
+	StatusUnprocessed int8 = 0 // 未处理（待缴纳）
+	StatusProcessed   int8 = 1 // 已处理（已缴纳）
+	StatusAppealing   int8 = 2 // 申诉中（暂停缴纳）
+	StatusWaived      int8 = 3 // 已免除
+)
+
+// 申诉状态
+const (
+	AppealPending int8 = 0 // 待审核
+	AppealUpheld  int8 = 1 // 维持原判
+	AppealReduced int8 = 2 // 减免罚款
+	AppealWaived  int8 = 3 // 免除罚款
+)
+
+// 审核结论
+const (
+	DecisionUphold = "uphold"
+	DecisionReduce = "reduce"
+	DecisionWaive  = "waive"
+)
+
+// 变更历史动作
+const (
+	HistoryAppealSubmitted = "appeal_submitted"
+	HistoryAppealUpheld    = "appeal_upheld"
+	HistoryAppealReduced   = "appeal_reduced"
+	HistoryAppealWaived    = "appeal_waived"
+)
+
+// 申诉相关错误
+var (
+	ErrViolationNotFound  = errors.New("违规记录不存在")
+	ErrAppealNotFound     = errors.New("申诉不存在")
+	ErrNotViolationOwner  = errors.New("无权申诉该违规记录")
+	ErrNotAppealable      = errors.New("只有未处理的违规记录可以申诉")
+	ErrAlreadyAppealed    = errors.New("该违规记录已申诉过，不能重复申诉")
+	ErrFineAlreadyPaid    = errors.New("罚款已支付，不能申诉")
+	ErrAppealReviewed     = errors.New("申诉已审核")
+	ErrInvalidDecision    = errors.New("审核结论无效，可选 uphold / reduce / waive")
+	ErrInvalidReducedFine = errors.New("减免后的罚款金额必须大于0且小于原罚款金额")
+	ErrLotForbidden       = errors.New("无权审核其他停车场的申诉")
+)
+
+// AppealService 违规申诉服务：用户提交申诉 → 管理员审核（维持 / 减免 / 免除），每次变更写入历史
+type AppealService struct {
+	repo *Repository
+}
+
+// NewAppealService 创建 AppealService 实例
+func NewAppealService(repo *Repository) *AppealService {
+	return &AppealService{repo: repo}
+}
+
+// SubmitAppeal 用户提交申诉：违规记录转为申诉中，暂停缴纳，并作废未完成的待支付记录
+func (s *AppealService) SubmitAppeal(userID, violationID uint, reason string) (*model.ViolationAppeal, error) {
+	var appeal *model.ViolationAppeal
+	err := inits.DB.Transaction(func(tx *gorm.DB) error {
+		v, err := s.repo.LockViolationWithTx(tx, violationID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrViolationNotFound
+			}
+			return err
+		}
+		if v.UserID != userID {
+			return ErrNotViolationOwner
+		}
+		if v.Status != StatusUnprocessed {
+			return ErrNotAppealable
+		}
+		count, err := s.repo.CountAppealsWithTx(tx, violationID)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAlreadyAppealed
+		}
+		paid, err := s.repo.HoldPendingPaymentsWithTx(tx, violationID)
+		if err != nil {
+			return err
+		}
+		if paid {
+			return ErrFineAlreadyPaid
+		}
+
+		appeal = &model.ViolationAppeal{
+			ViolationID:  violationID,
+			UserID:       userID,
+			Reason:       reason,
+			Status:       AppealPending,
+			OriginalFine: v.FineAmount,
+		}
+		if err := tx.Create(appeal).Error; err != nil {
+			return err
+		}
+		if err := s.repo.UpdateViolationWithTx(tx, violationID, StatusAppealing, v.FineAmount, nil); err != nil {
+			return err
+		}
+		return s.repo.AppendHistoryWithTx(tx, &model.ViolationHistory{
+			ViolationID:  violationID,
+			AppealID:     &appeal.AppealID,
+			Action:       HistoryAppealSubmitted,
+			OldStatus:    v.Status,
+			NewStatus:    StatusAppealing,
+			OldFine:      v.FineAmount,
+			NewFine:      v.FineAmount,
+			OperatorType: "user",
+			OperatorID:   userID,
+			Remark:       reason,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return appeal, nil
+}
+
+// ReviewAppeal 管理员审核申诉
+// uphold：维持原判，恢复为未处理；reduce：按 fineAmount 减免后恢复为未处理；waive：免除罚款，金额置0
+// canManage 用于校验管理员是否有权管理违规所属停车场
+func (s *AppealService) ReviewAppeal(appealID, adminID uint, decision string, fineAmount float64, remark string, canManage func(lotID *uint) bool) (*model.ViolationAppeal, error) {
+	var appeal *model.ViolationAppeal
+	err := inits.DB.Transaction(func(tx *gorm.DB) error {
+		a, err := s.repo.LockAppealWithTx(tx, appealID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrAppealNotFound
+			}
+			return err
+		}
+		if a.Status != AppealPending {
+			return ErrAppealReviewed
+		}
+		v, err := s.repo.LockViolationWithTx(tx, a.ViolationID)
+		if err != nil {
+			return err
+		}
+		if !canManage(v.LotID) {
+			return ErrLotForbidden
+		}
+
+		var newStatus int8
+		var newFine float64
+		var action string
+		switch decision {
+		case DecisionUphold:
+			a.Status, newStatus, newFine, action = AppealUpheld, StatusUnprocessed, v.FineAmount, HistoryAppealUpheld
+		case DecisionReduce:
+			fineAmount = math.Round(fineAmount*100) / 100
+			if fineAmount <= 0 || fineAmount >= v.FineAmount {
+				return ErrInvalidReducedFine
+			}
+			a.Status, newStatus, newFine, action = AppealReduced, StatusUnprocessed, fineAmount, HistoryAppealReduced
+		case DecisionWaive:
+			a.Status, newStatus, newFine, action = AppealWaived, StatusWaived, 0, HistoryAppealWaived
+		default:
+			return ErrInvalidDecision
+		}
+
+		now := time.Now()
+		var processTime *time.Time
+		if newStatus == StatusWaived {
+			processTime = &now
+		}
+		if err := s.repo.UpdateViolationWithTx(tx, v.ViolationID, newStatus, newFine, processTime); err != nil {
+			return err
+		}
+
+		a.FinalFine = &newFine
+		a.ReviewerID = &adminID
+		a.ReviewRemark = remark
+		a.ReviewTime = &now
+		if err := tx.Model(a).Updates(map[string]interface{}{
+			"status":        a.Status,
+			"final_fine":    newFine,
+			"reviewer_id":   adminID,
+			"review_remark": remark,
+			"review_time":   now,
+		}).Error; err != nil {
+			return err
+		}
+		appeal = a
+
+		return s.repo.AppendHistoryWithTx(tx, &model.ViolationHistory{
+			ViolationID:  v.ViolationID,
+			AppealID:     &a.AppealID,
+			Action:       action,
+			OldStatus:    v.Status,
+			NewStatus:    newStatus,
+			OldFine:      v.FineAmount,
+			NewFine:      newFine,
+			OperatorType: "admin",
+			OperatorID:   adminID,
+			Remark:       remark,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return appeal, nil
+}
+
+// ListAppeals 分页查询申诉
+// lotIDs 为 nil 时不按停车场过滤
+func (s *AppealService) ListAppeals(userID uint, lotIDs []uint, status *int8, page, pageSize int) ([]model.ViolationAppeal, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	return s.repo.FindAppeals(userID, lotIDs, status, (page-1)*pageSize, pageSize)
+}
+
+// GetAppeal 查询申诉详情
+func (s *AppealService) GetAppeal(appealID uint) (*model.ViolationAppeal, error) {
+	a, err := s.repo.GetAppeal(appealID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAppealNotFound
+	}
+	return a, err
+}
+
+// GetHistory 查询违规记录及其变更历史
+func (s *AppealService) GetHistory(violationID uint) (*model.ViolationRecord, []model.ViolationHistory, error) {
+	v, err := s.repo.GetViolation(violationID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrViolationNotFound
+		}
+		return nil, nil, err
+	}
+	list, err := s.repo.FindHistory(violationID)
+	return v, list, err
+}
@@ -0,0 +1,197 @@
+package violation
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"smart_parking_backend/internal/booking"
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"smart_parking_backend/internal/notify"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errSkip 源记录在扫描后已被处理（已命中或预订状态已变化），跳过且不视为错误
+var errSkip = errors.New("skip")
+
+// Item 一条已开具（或试运行时将开具）的违规
+type Item struct {
+	RuleCode      string  `json:"rule_code"`
+	ViolationType string  `json:"violation_type"`
+	SourceType    string  `json:"source_type"`
+	SourceID      uint    `json:"source_id"`
+	UserID        uint    `json:"user_id"`
+	VehicleID     uint    `json:"vehicle_id"`
+	FineAmount    float64 `json:"fine_amount"`
+	Description   string  `json:"description"`
+	ViolationID   uint    `json:"violation_id,omitempty"`
+}
+
+// Result 一次规则执行的结果
+type Result struct {
+	DryRun  bool   `json:"dry_run"`
+	Created int    `json:"violation_count"`
+	Items   []Item `json:"items"`
+}
+
+// Engine 违规规则引擎：按配置扫描源记录并开具违规，同一规则对同一源记录只开具一次
+type Engine struct {
+	rules    *RuleSet
+	repo     *Repository
+	notifier *notify.Service
+}
+
+// NewEngine 创建规则引擎
+func NewEngine(rules *RuleSet, repo *Repository, notifier *notify.Service) *Engine {
+	return &Engine{rules: rules, repo: repo, notifier: notifier}
+}
+
+// Rules 返回全部规则配置
+func (e *Engine) Rules() []Rule {
+	return e.rules.Rules
+}
+
+// Run 执行启用的规则：source 不为空时只执行该源记录的规则，ruleCode 不为空时只执行该规则
+// dryRun 为 true 时只返回将要开具的违规，不写库、不取消预订
+func (e *Engine) Run(source, ruleCode string, dryRun bool) (*Result, error) {
+	now := time.Now()
+	result := &Result{DryRun: dryRun, Items: []Item{}}
+	matched := false
+
+	for i := range e.rules.Rules {
+		rule := &e.rules.Rules[i]
+		if (source != "" && rule.Source != source) || (ruleCode != "" && rule.Code != ruleCode) {
+			continue
+		}
+		matched = true
+		if !rule.Enabled {
+			continue
+		}
+
+		candidates, err := e.repo.FindCandidates(rule, now)
+		if err != nil {
+			return result, fmt.Errorf("规则 %s 扫描失败: %w", rule.Code, err)
+		}
+		for j := range candidates {
+			c := &candidates[j]
+			item := Item{
+				RuleCode:      rule.Code,
+				ViolationType: rule.Name,
+				SourceType:    sourceTypes[rule.Source],
+				SourceID:      c.sourceID,
+				UserID:        c.userID,
+				VehicleID:     c.vehicleID,
+				FineAmount:    rule.fineFor(c),
+				Description:   rule.Description + "。" + c.ref,
+			}
+			if item.FineAmount <= 0 {
+				continue // 罚款金额为0（如停车费尚未计算）时暂不开具，后续执行再判断
+			}
+			if dryRun {
+				result.Items = append(result.Items, item)
+				continue
+			}
+
+			if err := e.apply(rule, c, &item, now); err != nil {
+				if !errors.Is(err, errSkip) {
+					log.Printf("规则 %s 对源记录 %d 开具违规失败: %v", rule.Code, c.sourceID, err)
+				}
+				continue
+			}
+			result.Items = append(result.Items, item)
+			result.Created++
+		}
+	}
+
+	if !matched {
+		return result, errors.New("没有匹配的违规规则")
+	}
+	if dryRun {
+		result.Created = len(result.Items)
+	}
+	return result, nil
+}
+
+// apply 在一个事务中写入命中记录、执行预订取消并创建违规记录
+func (e *Engine) apply(rule *Rule, c *candidate, item *Item, now time.Time) error {
+	return inits.DB.Transaction(func(tx *gorm.DB) error {
+		hit := &model.ViolationRuleHit{RuleCode: rule.Code, SourceType: item.SourceType, SourceID: c.sourceID}
+		ok, err := e.repo.ClaimHitWithTx(tx, hit)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errSkip
+		}
+
+		// 预订类违规关联凭该预订入场的在场停车记录：车辆仍在场时只开具违规、不取消预订，罚款随出场一并提示
+		recordID := c.recordID
+		inside := false
+		if c.reservationID != nil {
+			active, err := e.repo.ActiveParkingRecordWithTx(tx, *c.reservationID)
+			if err != nil {
+				return err
+			}
+			if active != nil {
+				recordID, inside = active, true
+			}
+		}
+
+		if rule.CancelReservation && c.spaceID > 0 && !inside {
+			// 与入场流程保持一致的加锁顺序：先锁车位，再改预订
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Select("space_id").First(&model.ParkingSpace{}, c.spaceID).Error; err != nil {
+				return err
+			}
+			cancelled, err := e.repo.CancelReservationWithTx(tx, c.sourceID, c.status, now)
+			if err != nil {
+				return err
+			}
+			if !cancelled {
+				return errSkip // 扫描后车辆已入场/离场或订单已取消，本次不开具
+			}
+			// 释放车位（同一车位可能还有其它时间段的预订，按当前时间重新计算）
+			if err := booking.RefreshReservedFlagsWithTx(tx, c.spaceID); err != nil {
+				return err
+			}
+		}
+
+		violation := model.ViolationRecord{
+			RecordID:      recordID,
+			LotID:         c.lotID,
+			ReservationID: c.reservationID,
+			UserID:        c.userID,
+			VehicleID:     c.vehicleID,
+			ViolationType: rule.Name,
+			ViolationTime: now,
+			Description:   item.Description,
+			FineAmount:    item.FineAmount,
+			Status:        0, // 0-未处理
+		}
+		if err := tx.Create(&violation).Error; err != nil {
+			return err
+		}
+		item.ViolationID = violation.ViolationID
+		if err := e.repo.BindHitViolationWithTx(tx, hit.HitID, violation.ViolationID); err != nil {
+			return err
+		}
+
+		// 违规通知与违规记录同一事务写入发件箱，由通知任务投递
+		return e.notifier.NotifyWithTx(tx, notify.EventViolationIssued, notify.Recipient{UserID: c.userID},
+			"violation_issued:"+utoa(violation.ViolationID), map[string]interface{}{
+				"violation_id":   violation.ViolationID,
+				"violation_type": violation.ViolationType,
+				"violation_time": now.Format("2006-01-02 15:04:05"),
+				"fine_amount":    fmt.Sprintf("%.2f", violation.FineAmount),
+				"description":    violation.Description,
+			})
+	})
+}
+
+func utoa(v uint) string {
+	return strconv.FormatUint(uint64(v), 10)
+}
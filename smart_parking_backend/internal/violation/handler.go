@@ -0,0 +1,229 @@
+package violation
+
+import (
+	"errors"
+	"net/http"
+	"smart_parking_backend/internal/middleware"
+	"smart_parking_backend/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler 违规申诉 HTTP 处理
+type Handler struct {
+	appeals *AppealService
+}
+
+func NewHandler(appeals *AppealService) *Handler {
+	return &Handler{appeals: appeals}
+}
+
+// canManageLot 系统管理员可管理全部停车场，停车场管理员只能管理自己的停车场
+// 未关联停车场的违规记录只有系统管理员可管理
+func canManageLot(c *gin.Context, lotID *uint) bool {
+	if lotID == nil {
+		return c.GetString("role") == middleware.RoleSystem
+	}
+	return middleware.CanManageLot(c, *lotID)
+}
+
+// adminLotFilter 停车场管理员只能查看所管理停车场的申诉，系统管理员不过滤（返回 nil）
+func adminLotFilter(c *gin.Context) []uint {
+	if c.GetString("role") == middleware.RoleSystem {
+		return nil
+	}
+	return middleware.ManagedLots(c)
+}
+
+// appealErrorStatus 申诉业务错误对应的 HTTP 状态码
+func appealErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrViolationNotFound), errors.Is(err, ErrAppealNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrNotViolationOwner), errors.Is(err, ErrLotForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrNotAppealable), errors.Is(err, ErrAlreadyAppealed), errors.Is(err, ErrFineAlreadyPaid),
+		errors.Is(err, ErrAppealReviewed):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidDecision), errors.Is(err, ErrInvalidReducedFine):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// parseStatus 解析可选的 status 查询参数
+func parseStatus(c *gin.Context) (*int8, bool) {
+	raw := c.Query("status")
+	if raw == "" {
+		return nil, true
+	}
+	v, err := strconv.ParseInt(raw, 10, 8)
+	if err != nil {
+		return nil, false
+	}
+	st := int8(v)
+	return &st, true
+}
+
+// ==================== 用户端 ====================
+
+// SubmitAppealReq 提交申诉请求体
+type SubmitAppealReq struct {
+	Reason string `json:"reason" binding:"required,max=1000"`
+}
+
+// SubmitAppeal 用户对自己的违规记录提交申诉
+// POST /api/violations/:violation_id/appeal
+func (h *Handler) SubmitAppeal(c *gin.Context) {
+	violationID, err := strconv.ParseUint(c.Param("violation_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的违规记录ID"})
+		return
+	}
+	var req SubmitAppealReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+
+	appeal, err := h.appeals.SubmitAppeal(c.GetUint("user_id"), uint(violationID), req.Reason)
+	if err != nil {
+		status := appealErrorStatus(err)
+		c.JSON(status, gin.H{"code": status, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "申诉已提交，审核期间暂停缴纳罚款", "data": appeal})
+}
+
+// ListMyAppeals 查询当前用户的申诉
+// GET /api/violations/appeals?status=0&page=1&page_size=20
+func (h *Handler) ListMyAppeals(c *gin.Context) {
+	status, ok := parseStatus(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的申诉状态"})
+		return
+	}
+	page := utils.ParseInt(c.DefaultQuery("page", "1"), 1)
+	pageSize := utils.ParseInt(c.DefaultQuery("page_size", "20"), 20)
+
+	list, total, err := h.appeals.ListAppeals(c.GetUint("user_id"), nil, status, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "查询申诉失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    gin.H{"total": total, "page": page, "page_size": pageSize, "records": list},
+	})
+}
+
+// GetViolationHistory 查询自己违规记录的变更历史
+// GET /api/violations/:violation_id/history
+func (h *Handler) GetViolationHistory(c *gin.Context) {
+	violationID, err := strconv.ParseUint(c.Param("violation_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的违规记录ID"})
+		return
+	}
+	v, history, err := h.appeals.GetHistory(uint(violationID))
+	if err != nil {
+		status := appealErrorStatus(err)
+		c.JSON(status, gin.H{"code": status, "message": err.Error()})
+		return
+	}
+	if v.UserID != c.GetUint("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "无权查看该违规记录"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": gin.H{"violation": v, "history": history}})
+}
+
+// ==================== 管理端 ====================
+
+// ListAppeals 查询申诉（停车场管理员只能看到本停车场的申诉）
+// GET /admin/appeals?status=0&page=1&page_size=20
+func (h *Handler) ListAppeals(c *gin.Context) {
+	status, ok := parseStatus(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的申诉状态"})
+		return
+	}
+	lotIDs := adminLotFilter(c)
+	if lotIDs != nil && len(lotIDs) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": "管理员未绑定停车场"})
+		return
+	}
+	page := utils.ParseInt(c.DefaultQuery("page", "1"), 1)
+	pageSize := utils.ParseInt(c.DefaultQuery("page_size", "20"), 20)
+
+	list, total, err := h.appeals.ListAppeals(0, lotIDs, status, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "查询申诉失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    gin.H{"total": total, "page": page, "page_size": pageSize, "records": list},
+	})
+}
+
+// GetAppeal 查询申诉详情及违规变更历史
+// GET /admin/appeals/:id
+func (h *Handler) GetAppeal(c *gin.Context) {
+	appealID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的申诉ID"})
+		return
+	}
+	appeal, err := h.appeals.GetAppeal(uint(appealID))
+	if err != nil {
+		status := appealErrorStatus(err)
+		c.JSON(status, gin.H{"code": status, "message": err.Error()})
+		return
+	}
+	if appeal.Violation == nil || !canManageLot(c, appeal.Violation.LotID) {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "message": ErrLotForbidden.Error()})
+		return
+	}
+	_, history, err := h.appeals.GetHistory(appeal.ViolationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "查询变更历史失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": gin.H{"appeal": appeal, "history": history}})
+}
+
+// ReviewAppealReq 审核申诉请求体
+type ReviewAppealReq struct {
+	Decision   string  `json:"decision" binding:"required"` // "uphold" | "reduce" | "waive"
+	FineAmount float64 `json:"fine_amount"`                 // decision=reduce 时必填：减免后的罚款金额
+	Remark     string  `json:"remark" binding:"max=255"`
+}
+
+// ReviewAppeal 审核申诉：维持原判 / 减免罚款 / 免除罚款
+// POST /admin/appeals/:id/review
+func (h *Handler) ReviewAppeal(c *gin.Context) {
+	appealID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "无效的申诉ID"})
+		return
+	}
+	var req ReviewAppealReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+
+	appeal, err := h.appeals.ReviewAppeal(uint(appealID), c.GetUint("admin_id"), req.Decision, req.FineAmount, req.Remark,
+		func(lotID *uint) bool { return canManageLot(c, lotID) })
+	if err != nil {
+		status := appealErrorStatus(err)
+		c.JSON(status, gin.H{"code": status, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "审核完成", "data": appeal})
+}
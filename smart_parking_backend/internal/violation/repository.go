@@ -0,0 +1,277 @@
+package violation
+
+import (
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// candidate 规则扫描出的待开具违规的源记录
+type candidate struct {
+	sourceID      uint
+	userID        uint
+	vehicleID     uint
+	recordID      *uint // 违规关联的停车记录
+	lotID         *uint // 违规所属停车场
+	reservationID *uint // 违规关联的预订订单
+	spaceID       uint  // 预订类源记录的车位ID
+	status        int8  // 预订类源记录扫描时的状态，取消预订时用于乐观校验
+	hourlyRate    float64
+	unpaidFee     float64
+	fineAmount    float64
+	ref           string // 写入违规描述的源记录说明
+}
+
+// Repository 违规规则数据访问层
+type Repository struct{}
+
+// NewRepository 创建 Repository 实例
+func NewRepository() *Repository {
+	return &Repository{}
+}
+
+// notHit 排除该规则已命中过的源记录
+func notHit(db *gorm.DB, rule *Rule, idColumn string) *gorm.DB {
+	return db.Where("NOT EXISTS (?)", inits.DB.Table("violation_rule_hit h").Select("1").
+		Where("h.rule_code = ? AND h.source_type = ? AND h.source_id = "+idColumn, rule.Code, sourceTypes[rule.Source]))
+}
+
+// ==================== 源记录扫描 ====================
+
+// FindCandidates 按规则的 source 扫描达到阈值且未命中过的源记录
+func (r *Repository) FindCandidates(rule *Rule, now time.Time) ([]candidate, error) {
+	threshold := now.Add(-rule.after)
+	switch rule.Source {
+	case SourceReservationNotEntered:
+		return r.findReservations(rule, 1, "start_time <= ?", threshold)
+	case SourceReservationOverstay:
+		return r.findReservations(rule, 2, "end_time <= ?", threshold)
+	case SourceParkingUnpaid:
+		return r.findUnpaidParking(rule, threshold)
+	case SourceViolationUnpaid:
+		return r.findUnpaidViolations(rule, threshold)
+	}
+	return nil, nil
+}
+
+func (r *Repository) findReservations(rule *Rule, status int8, cond string, threshold time.Time) ([]candidate, error) {
+	var list []model.ReservationOrder
+	query := inits.DB.
+		Where("status = ?", status).
+		Where(cond, threshold).
+		Where("actual_end_time IS NULL"). // 未实际结束
+		Preload("Lot")
+	if err := notHit(query, rule, "reservation_order.order_id").Find(&list).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]candidate, 0, len(list))
+	for _, o := range list {
+		result = append(result, candidate{
+			sourceID:      o.OrderID,
+			userID:        o.UserID,
+			vehicleID:     o.VehicleID,
+			lotID:         &o.LotID,
+			reservationID: &o.OrderID,
+			spaceID:       o.SpaceID,
+			status:        o.Status,
+			hourlyRate:    o.Lot.HourlyRate,
+			ref:           "预订订单ID: " + utoa(o.OrderID),
+		})
+	}
+	return result, nil
+}
+
+func (r *Repository) findUnpaidParking(rule *Rule, threshold time.Time) ([]candidate, error) {
+	var list []model.ParkingRecord
+	query := inits.DB.
+		Where("payment_status = ?", 0). // 0-未支付
+		Where("entry_time <= ?", threshold).
+		Where("user_id IS NOT NULL AND vehicle_id IS NOT NULL"). // 访客停车无用户账号，无法开具违规记录
+		Preload("Lot")
+	if err := notHit(query, rule, "parking_record.record_id").Find(&list).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]candidate, 0, len(list))
+	for _, p := range list {
+		result = append(result, candidate{
+			sourceID:   p.RecordID,
+			userID:     *p.UserID,
+			vehicleID:  *p.VehicleID,
+			recordID:   &p.RecordID,
+			lotID:      &p.LotID,
+			hourlyRate: p.Lot.HourlyRate,
+			unpaidFee:  p.FeeCalculated - p.FeePaid,
+			ref:        "停车记录ID: " + utoa(p.RecordID),
+		})
+	}
+	return result, nil
+}
+
+func (r *Repository) findUnpaidViolations(rule *Rule, threshold time.Time) ([]candidate, error) {
+	var list []model.ViolationRecord
+	query := inits.DB.
+		Where("status = ?", 0). // 0-未处理
+		Where("violation_time <= ?", threshold).
+		// 由升级类规则生成的违规不再升级，避免罚款无限叠加
+		Where("NOT EXISTS (?)", inits.DB.Table("violation_rule_hit g").Select("1").
+			Where("g.violation_id = violation_record.violation_id AND g.source_type = ?", "violation"))
+	if len(rule.ExcludeTypes) > 0 {
+		query = query.Where("violation_type NOT IN ?", rule.ExcludeTypes)
+	}
+	if err := notHit(query, rule, "violation_record.violation_id").Find(&list).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]candidate, 0, len(list))
+	for _, v := range list {
+		ref := "原违规记录ID: " + utoa(v.ViolationID)
+		if v.RecordID != nil {
+			ref += "，关联停车记录ID: " + utoa(*v.RecordID)
+		}
+		result = append(result, candidate{
+			sourceID:  v.ViolationID,
+			userID:    v.UserID,
+			vehicleID: v.VehicleID,
+			// 升级后的违规沿用原违规的停车场、预订与停车记录
+			recordID:      v.RecordID,
+			lotID:         v.LotID,
+			reservationID: v.ReservationID,
+			fineAmount:    v.FineAmount,
+			ref:           ref,
+		})
+	}
+	return result, nil
+}
+
+// ==================== 命中记录（ViolationRuleHit）操作 ====================
+
+// ClaimHitWithTx 写入命中记录，返回 false 表示该规则已对该源记录开具过违规（并发执行时由唯一索引保证只有一方成功）
+func (r *Repository) ClaimHitWithTx(tx *gorm.DB, hit *model.ViolationRuleHit) (bool, error) {
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(hit)
+	return res.RowsAffected > 0, res.Error
+}
+
+// BindHitViolationWithTx 回填命中记录生成的违规记录ID
+func (r *Repository) BindHitViolationWithTx(tx *gorm.DB, hitID uint64, violationID uint) error {
+	return tx.Model(&model.ViolationRuleHit{}).Where("hit_id = ?", hitID).Update("violation_id", violationID).Error
+}
+
+// CancelReservationWithTx 取消预订（仅当状态仍为扫描时的状态），返回是否取消成功
+func (r *Repository) CancelReservationWithTx(tx *gorm.DB, orderID uint, fromStatus int8, now time.Time) (bool, error) {
+	res := tx.Model(&model.ReservationOrder{}).
+		Where("order_id = ? AND status = ? AND actual_end_time IS NULL", orderID, fromStatus).
+		Updates(map[string]interface{}{"status": 0, "actual_end_time": now})
+	return res.RowsAffected > 0, res.Error
+}
+
+// ActiveParkingRecordWithTx 凭预订入场且仍在场的停车记录ID，车辆不在场时返回 nil
+func (r *Repository) ActiveParkingRecordWithTx(tx *gorm.DB, reservationID uint) (*uint, error) {
+	var ids []uint
+	err := tx.Model(&model.ParkingRecord{}).
+		Where("reservation_id = ? AND record_status = ?", reservationID, 1). // 1-在场
+		Limit(1).Pluck("record_id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	return &ids[0], nil
+}
+
+// ==================== 申诉（ViolationAppeal）操作 ====================
+
+// LockViolationWithTx 对违规记录加行锁
+func (r *Repository) LockViolationWithTx(tx *gorm.DB, violationID uint) (*model.ViolationRecord, error) {
+	var v model.ViolationRecord
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&v, violationID).Error
+	return &v, err
+}
+
+// LockAppealWithTx 对申诉记录加行锁
+func (r *Repository) LockAppealWithTx(tx *gorm.DB, appealID uint) (*model.ViolationAppeal, error) {
+	var a model.ViolationAppeal
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&a, appealID).Error
+	return &a, err
+}
+
+// CountAppealsWithTx 统计违规记录的申诉次数
+func (r *Repository) CountAppealsWithTx(tx *gorm.DB, violationID uint) (int64, error) {
+	var count int64
+	err := tx.Model(&model.ViolationAppeal{}).Where("violation_id = ?", violationID).Count(&count).Error
+	return count, err
+}
+
+// HoldPendingPaymentsWithTx 作废违规记录上未完成的待支付记录，之后到达的回调会进入隔离表
+// 返回是否已存在支付成功的记录（已支付的罚款不能再申诉）
+func (r *Repository) HoldPendingPaymentsWithTx(tx *gorm.DB, violationID uint) (bool, error) {
+	if err := tx.Model(&model.PaymentRecord{}).
+		Where("payable_type = ? AND violation_id = ? AND payment_status = 0", model.PayableViolation, violationID).
+		Update("payment_status", 2).Error; err != nil {
+		return false, err
+	}
+	var paid int64
+	err := tx.Model(&model.PaymentRecord{}).
+		Where("payable_type = ? AND violation_id = ? AND payment_status = 1", model.PayableViolation, violationID).
+		Count(&paid).Error
+	return paid > 0, err
+}
+
+// UpdateViolationWithTx 更新违规记录的状态与罚款金额
+func (r *Repository) UpdateViolationWithTx(tx *gorm.DB, violationID uint, status int8, fine float64, processTime *time.Time) error {
+	updates := map[string]interface{}{"status": status, "fine_amount": fine}
+	if processTime != nil {
+		updates["process_time"] = processTime
+	}
+	return tx.Model(&model.ViolationRecord{}).Where("violation_id = ?", violationID).Updates(updates).Error
+}
+
+// AppendHistoryWithTx 追加一条违规变更历史
+func (r *Repository) AppendHistoryWithTx(tx *gorm.DB, h *model.ViolationHistory) error {
+	return tx.Create(h).Error
+}
+
+// FindAppeals 分页查询申诉（userID 为 0、lotIDs 为 nil 时不过滤，status 为 nil 时查询全部状态）
+func (r *Repository) FindAppeals(userID uint, lotIDs []uint, status *int8, offset, limit int) ([]model.ViolationAppeal, int64, error) {
+	var list []model.ViolationAppeal
+	var total int64
+	query := inits.DB.Model(&model.ViolationAppeal{})
+	if userID > 0 {
+		query = query.Where("violation_appeal.user_id = ?", userID)
+	}
+	if lotIDs != nil {
+		query = query.Joins("JOIN violation_record ON violation_record.violation_id = violation_appeal.violation_id").
+			Where("violation_record.lot_id IN ?", lotIDs)
+	}
+	if status != nil {
+		query = query.Where("violation_appeal.status = ?", *status)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Preload("Violation").Order("violation_appeal.appeal_id DESC").Offset(offset).Limit(limit).Find(&list).Error
+	return list, total, err
+}
+
+// GetAppeal 查询申诉详情（含违规记录）
+func (r *Repository) GetAppeal(appealID uint) (*model.ViolationAppeal, error) {
+	var a model.ViolationAppeal
+	err := inits.DB.Preload("Violation").First(&a, appealID).Error
+	return &a, err
+}
+
+// FindHistory 查询违规记录的变更历史（按时间正序）
+func (r *Repository) FindHistory(violationID uint) ([]model.ViolationHistory, error) {
+	var list []model.ViolationHistory
+	err := inits.DB.Where("violation_id = ?", violationID).Order("history_id ASC").Find(&list).Error
+	return list, err
+}
+
+// GetViolation 查询违规记录
+func (r *Repository) GetViolation(violationID uint) (*model.ViolationRecord, error) {
+	var v model.ViolationRecord
+	err := inits.DB.First(&v, violationID).Error
+	return &v, err
+}
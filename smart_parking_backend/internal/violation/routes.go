@@ -0,0 +1,28 @@
+package violation
+
+import (
+	"smart_parking_backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AppealRoutes 注册违规申诉相关路由
+func AppealRoutes(r *gin.Engine, appeals *AppealService) {
+	handler := NewHandler(appeals)
+
+	api := r.Group("/api/violations")
+	api.Use(middleware.UserAuthMiddleware())
+	{
+		api.GET("/appeals", handler.ListMyAppeals)                     // 查询我的申诉
+		api.POST("/:violation_id/appeal", handler.SubmitAppeal)        // 提交申诉
+		api.GET("/:violation_id/history", handler.GetViolationHistory) // 查询违规变更历史
+	}
+
+	admin := r.Group("/admin/appeals")
+	admin.Use(middleware.AdminAuthMiddleware())
+	{
+		admin.GET("", handler.ListAppeals)              // 查询申诉列表
+		admin.GET("/:id", handler.GetAppeal)            // 查询申诉详情及变更历史
+		admin.POST("/:id/review", handler.ReviewAppeal) // 审核申诉
+	}
+}
@@ -0,0 +1,128 @@
+package violation
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 规则扫描的源记录
+const (
+	SourceReservationNotEntered = "reservation_not_entered" // 预订未使用
+	SourceReservationOverstay   = "reservation_overstay"    // 超时停车
+	SourceParkingUnpaid         = "parking_unpaid"          // 未支付停车费
+	SourceViolationUnpaid       = "violation_unpaid"        // 未支付罚款
+)
+
+// 罚款基数
+const (
+	FineBaseFixed         = "fixed"
+	FineBaseLotHourlyRate = "lot_hourly_rate"
+	FineBaseUnpaidFee     = "unpaid_fee"
+	FineBaseFineAmount    = "fine_amount"
+)
+
+// sourceTypes 源记录对应的命中记录类型
+var sourceTypes = map[string]string{
+	SourceReservationNotEntered: "reservation",
+	SourceReservationOverstay:   "reservation",
+	SourceParkingUnpaid:         "parking",
+	SourceViolationUnpaid:       "violation",
+}
+
+// FineFormula 罚款公式：金额 = 基数 × Multiplier + Amount
+type FineFormula struct {
+	Base       string  `yaml:"base" json:"base"`
+	Multiplier float64 `yaml:"multiplier" json:"multiplier"`
+	Amount     float64 `yaml:"amount" json:"amount"`
+}
+
+// Rule 一条违规规则
+type Rule struct {
+	Code              string      `yaml:"code" json:"code"`
+	Name              string      `yaml:"name" json:"name"` // 写入违规记录的 violation_type
+	Source            string      `yaml:"source" json:"source"`
+	Enabled           bool        `yaml:"enabled" json:"enabled"`
+	After             string      `yaml:"after" json:"after"`
+	Description       string      `yaml:"description" json:"description"`
+	Fine              FineFormula `yaml:"fine" json:"fine"`
+	CancelReservation bool        `yaml:"cancel_reservation" json:"cancel_reservation"`
+	ExcludeTypes      []string    `yaml:"exclude_types" json:"exclude_types,omitempty"`
+
+	after time.Duration
+}
+
+// RuleSet 违规规则集合
+type RuleSet struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// LoadRules 从 YAML 文件加载并校验违规规则
+func LoadRules(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rs RuleSet
+	if err := yaml.Unmarshal(data, &rs); err != nil {
+		return nil, err
+	}
+	if err := rs.validate(); err != nil {
+		return nil, err
+	}
+	return &rs, nil
+}
+
+// validate 校验规则配置，启动时发现错误直接失败，避免运行期按错误规则开具罚款
+func (rs *RuleSet) validate() error {
+	seen := make(map[string]bool)
+	for i := range rs.Rules {
+		r := &rs.Rules[i]
+		if r.Code == "" || r.Name == "" {
+			return fmt.Errorf("第 %d 条违规规则缺少 code 或 name", i+1)
+		}
+		if seen[r.Code] {
+			return fmt.Errorf("违规规则编码重复: %s", r.Code)
+		}
+		seen[r.Code] = true
+
+		if _, ok := sourceTypes[r.Source]; !ok {
+			return fmt.Errorf("违规规则 %s 的 source 无效: %s", r.Code, r.Source)
+		}
+		d, err := time.ParseDuration(r.After)
+		if err != nil || d < 0 {
+			return fmt.Errorf("违规规则 %s 的 after 无效: %s", r.Code, r.After)
+		}
+		r.after = d
+
+		switch r.Fine.Base {
+		case FineBaseFixed, FineBaseLotHourlyRate, FineBaseUnpaidFee, FineBaseFineAmount:
+		default:
+			return fmt.Errorf("违规规则 %s 的 fine.base 无效: %s", r.Code, r.Fine.Base)
+		}
+		if r.Fine.Multiplier < 0 || r.Fine.Amount < 0 {
+			return fmt.Errorf("违规规则 %s 的罚款公式不能为负数", r.Code)
+		}
+		if r.Fine.Base == FineBaseFixed && r.Fine.Amount <= 0 {
+			return fmt.Errorf("违规规则 %s 使用固定罚款时 fine.amount 必须大于0", r.Code)
+		}
+	}
+	return nil
+}
+
+// fineFor 按公式计算罚款金额
+func (r *Rule) fineFor(c *candidate) float64 {
+	var base float64
+	switch r.Fine.Base {
+	case FineBaseLotHourlyRate:
+		base = c.hourlyRate
+	case FineBaseUnpaidFee:
+		base = c.unpaidFee
+	case FineBaseFineAmount:
+		base = c.fineAmount
+	}
+	return math.Round((base*r.Fine.Multiplier+r.Fine.Amount)*100) / 100
+}
@@ -0,0 +1,110 @@
+package violation
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoadRulesShippedConfig 随服务发布的规则配置可以加载，阈值、罚款公式与是否取消预订符合配置说明
+// 超时停车时车辆仍在场，不能取消使用中的预订
+func TestLoadRulesShippedConfig(t *testing.T) {
+	rs, err := LoadRules("../../config/violation_rules.yaml")
+	if err != nil {
+		t.Fatalf("LoadRules() error = %v", err)
+	}
+	want := map[string]struct {
+		source string
+		after  time.Duration
+		base   string
+		cancel bool
+	}{
+		"reservation_not_entered": {SourceReservationNotEntered, 30 * time.Minute, FineBaseLotHourlyRate, true},
+		"reservation_overstay":    {SourceReservationOverstay, 30 * time.Minute, FineBaseLotHourlyRate, false},
+		"parking_fee_unpaid":      {SourceParkingUnpaid, 720 * time.Hour, FineBaseUnpaidFee, false},
+		"fine_unpaid":             {SourceViolationUnpaid, 336 * time.Hour, FineBaseFineAmount, false},
+	}
+	if len(rs.Rules) != len(want) {
+		t.Fatalf("规则数量 = %d, want %d", len(rs.Rules), len(want))
+	}
+	for _, r := range rs.Rules {
+		w, ok := want[r.Code]
+		if !ok {
+			t.Errorf("未预期的规则 %s", r.Code)
+			continue
+		}
+		if r.Source != w.source || r.after != w.after || r.Fine.Base != w.base || r.CancelReservation != w.cancel {
+			t.Errorf("规则 %s = {source: %s, after: %v, base: %s, cancel: %v}, want %+v", r.Code, r.Source, r.after, r.Fine.Base, r.CancelReservation, w)
+		}
+	}
+}
+
+func TestRuleSetValidate(t *testing.T) {
+	valid := func() Rule {
+		return Rule{Code: "r1", Name: "超时停车", Source: SourceReservationOverstay, After: "30m", Fine: FineFormula{Base: FineBaseLotHourlyRate, Multiplier: 1}}
+	}
+	tests := []struct {
+		name    string
+		mutate  func(rs *RuleSet)
+		wantErr string
+	}{
+		{name: "合法规则", mutate: func(rs *RuleSet) {}},
+		{name: "阈值为0", mutate: func(rs *RuleSet) { rs.Rules[0].After = "0s" }},
+		{name: "缺少编码", mutate: func(rs *RuleSet) { rs.Rules[0].Code = "" }, wantErr: "缺少 code 或 name"},
+		{name: "编码重复", mutate: func(rs *RuleSet) { rs.Rules = append(rs.Rules, rs.Rules[0]) }, wantErr: "编码重复"},
+		{name: "未知源记录", mutate: func(rs *RuleSet) { rs.Rules[0].Source = "unknown" }, wantErr: "source 无效"},
+		{name: "阈值格式错误", mutate: func(rs *RuleSet) { rs.Rules[0].After = "30" }, wantErr: "after 无效"},
+		{name: "阈值为负数", mutate: func(rs *RuleSet) { rs.Rules[0].After = "-1h" }, wantErr: "after 无效"},
+		{name: "未知罚款基数", mutate: func(rs *RuleSet) { rs.Rules[0].Fine.Base = "double" }, wantErr: "fine.base 无效"},
+		{name: "罚款公式为负数", mutate: func(rs *RuleSet) { rs.Rules[0].Fine.Multiplier = -1 }, wantErr: "不能为负数"},
+		{name: "固定罚款金额为0", mutate: func(rs *RuleSet) { rs.Rules[0].Fine = FineFormula{Base: FineBaseFixed} }, wantErr: "fine.amount 必须大于0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := &RuleSet{Rules: []Rule{valid()}}
+			tt.mutate(rs)
+			err := rs.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validate() error = %v, want 包含 %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateParsesThreshold(t *testing.T) {
+	rs := &RuleSet{Rules: []Rule{{Code: "r1", Name: "未支付停车费", Source: SourceParkingUnpaid, After: "1h30m", Fine: FineFormula{Base: FineBaseUnpaidFee, Multiplier: 2}}}}
+	if err := rs.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+	if got := rs.Rules[0].after; got != 90*time.Minute {
+		t.Errorf("after = %v, want %v", got, 90*time.Minute)
+	}
+}
+
+func TestFineFor(t *testing.T) {
+	c := &candidate{hourlyRate: 5, unpaidFee: 12.345, fineAmount: 40}
+	tests := []struct {
+		name string
+		fine FineFormula
+		want float64
+	}{
+		{"固定金额", FineFormula{Base: FineBaseFixed, Multiplier: 3, Amount: 50}, 50},
+		{"停车场小时费率", FineFormula{Base: FineBaseLotHourlyRate, Multiplier: 1}, 5},
+		{"未付停车费加倍并保留两位小数", FineFormula{Base: FineBaseUnpaidFee, Multiplier: 2}, 24.69},
+		{"原罚款金额加倍再加固定金额", FineFormula{Base: FineBaseFineAmount, Multiplier: 2, Amount: 10}, 90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Rule{Fine: tt.fine}
+			if got := r.fineFor(c); got != tt.want {
+				t.Errorf("fineFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
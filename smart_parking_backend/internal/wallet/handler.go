@@ -0,0 +1,80 @@
+package wallet
+
+import (
+	"net/http"
+	"smart_parking_backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TopupCreator 创建充值支付单（由支付模块实现，充值通过支付宝/微信完成）
+type TopupCreator interface {
+	CreateTopupPayment(userID uint, amount float64, method string) (string, uint64, error)
+}
+
+// Handler 钱包模块 HTTP 处理
+type Handler struct {
+	svc   *Service
+	topup TopupCreator
+}
+
+func NewHandler(svc *Service, topup TopupCreator) *Handler {
+	return &Handler{svc: svc, topup: topup}
+}
+
+// GetWallet 查询当前用户钱包余额
+// GET /api/wallet
+func (h *Handler) GetWallet(c *gin.Context) {
+	w, err := h.svc.GetWallet(c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "查询钱包失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "message": "success", "data": w})
+}
+
+// ListLedger 分页查询当前用户钱包流水
+// GET /api/wallet/ledger?page=1&page_size=20
+func (h *Handler) ListLedger(c *gin.Context) {
+	page := utils.ParseInt(c.DefaultQuery("page", "1"), 1)
+	pageSize := utils.ParseInt(c.DefaultQuery("page_size", "20"), 20)
+
+	list, total, err := h.svc.ListLedger(c.GetUint("user_id"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "message": "查询钱包流水失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "success",
+		"data":    gin.H{"total": total, "page": page, "page_size": pageSize, "records": list},
+	})
+}
+
+// TopupReq 充值请求体
+type TopupReq struct {
+	Amount float64 `json:"amount" binding:"required,gt=0"`
+	Method string  `json:"method" binding:"required"` // "alipay" | "wechat"
+}
+
+// Topup 钱包充值：创建充值支付单并返回模拟支付链接，支付回调成功后入账
+// POST /api/wallet/topup
+func (h *Handler) Topup(c *gin.Context) {
+	var req TopupReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": "参数错误: " + err.Error()})
+		return
+	}
+
+	url, paymentID, err := h.topup.CreateTopupPayment(c.GetUint("user_id"), req.Amount, req.Method)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":       0,
+		"message":    "ok",
+		"data":       gin.H{"redirect_url": url},
+		"payment_id": paymentID,
+	})
+}
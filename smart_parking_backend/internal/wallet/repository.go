@@ -0,0 +1,80 @@
+package wallet
+
+import (
+	"errors"
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Repository 钱包数据访问层
+type Repository struct{}
+
+// NewRepository 创建 Repository 实例
+func NewRepository() *Repository {
+	return &Repository{}
+}
+
+// ==================== 钱包（Wallet）操作 ====================
+
+// FindByUser 查询用户钱包，不存在时返回 nil
+func (r *Repository) FindByUser(userID uint) (*model.Wallet, error) {
+	var w model.Wallet
+	err := inits.DB.Where("user_id = ?", userID).First(&w).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &w, err
+}
+
+// EnsureWithTx 查询用户钱包，不存在时创建（唯一索引保证每个用户只有一个钱包）
+func (r *Repository) EnsureWithTx(tx *gorm.DB, userID uint) (*model.Wallet, error) {
+	w := model.Wallet{UserID: userID, Status: 1}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&w).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("user_id = ?", userID).First(&w).Error; err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// LockWithTx 对钱包加行锁（SELECT ... FOR UPDATE），余额变动前必须先加锁
+func (r *Repository) LockWithTx(tx *gorm.DB, walletID uint) (*model.Wallet, error) {
+	var w model.Wallet
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&w, walletID).Error
+	return &w, err
+}
+
+// UpdateBalanceWithTx 写入新余额
+func (r *Repository) UpdateBalanceWithTx(tx *gorm.DB, walletID uint, balance float64) error {
+	return tx.Model(&model.Wallet{}).Where("wallet_id = ?", walletID).Update("balance", balance).Error
+}
+
+// ==================== 钱包流水（WalletLedger）操作 ====================
+
+// LedgerExistsWithTx 按业务单号判断是否已记账（用于幂等）
+func (r *Repository) LedgerExistsWithTx(tx *gorm.DB, bizNo string) (bool, error) {
+	var count int64
+	err := tx.Model(&model.WalletLedger{}).Where("biz_no = ?", bizNo).Count(&count).Error
+	return count > 0, err
+}
+
+// AppendLedgerWithTx 追加一条流水（流水表只追加，不提供修改与删除）
+func (r *Repository) AppendLedgerWithTx(tx *gorm.DB, entry *model.WalletLedger) error {
+	return tx.Create(entry).Error
+}
+
+// FindLedger 分页查询钱包流水（按时间倒序）
+func (r *Repository) FindLedger(walletID uint, offset, limit int) ([]model.WalletLedger, int64, error) {
+	var list []model.WalletLedger
+	var total int64
+	query := inits.DB.Model(&model.WalletLedger{}).Where("wallet_id = ?", walletID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("ledger_id DESC").Offset(offset).Limit(limit).Find(&list).Error
+	return list, total, err
+}
@@ -0,0 +1,20 @@
+package wallet
+
+import (
+	"smart_parking_backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WalletRoutes 注册钱包模块相关路由
+func WalletRoutes(r *gin.Engine, svc *Service, topup TopupCreator) {
+	handler := NewHandler(svc, topup)
+
+	api := r.Group("/api/wallet")
+	api.Use(middleware.UserAuthMiddleware())
+	{
+		api.GET("", handler.GetWallet)         // 查询钱包余额
+		api.GET("/ledger", handler.ListLedger) // 查询钱包流水
+		api.POST("/topup", handler.Topup)      // 钱包充值
+	}
+}
@@ -0,0 +1,145 @@
+package wallet
+
+import (
+	"errors"
+	"math"
+	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// 钱包相关错误
+var (
+	ErrInsufficientBalance = errors.New("钱包余额不足")
+	ErrWalletFrozen        = errors.New("钱包已冻结")
+)
+
+// 流水业务类型
+const (
+	LedgerTopup   = "topup"   // 充值入账
+	LedgerPayment = "payment" // 支付扣款
+	LedgerRefund  = "refund"  // 退款入账
+)
+
+// Service 钱包服务：余额变动统一通过流水记账
+type Service struct {
+	repo *Repository
+}
+
+// NewService 创建 Service 实例
+func NewService(repo *Repository) *Service {
+	return &Service{repo: repo}
+}
+
+// round2 金额保留两位小数
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// GetWallet 获取用户钱包，不存在时自动开通
+func (s *Service) GetWallet(userID uint) (*model.Wallet, error) {
+	w, err := s.repo.FindByUser(userID)
+	if err != nil || w != nil {
+		return w, err
+	}
+	return s.EnsureWalletWithTx(inits.DB, userID)
+}
+
+// EnsureWalletWithTx 在事务中获取用户钱包，不存在时自动开通
+func (s *Service) EnsureWalletWithTx(tx *gorm.DB, userID uint) (*model.Wallet, error) {
+	return s.repo.EnsureWithTx(tx, userID)
+}
+
+// CreditWithTx 入账：锁定钱包 → 追加流水 → 更新余额；同一业务单号只入账一次
+func (s *Service) CreditWithTx(tx *gorm.DB, walletID uint, amount float64, bizType, bizNo string, paymentID *uint64, remark string) error {
+	if amount <= 0 {
+		return errors.New("入账金额必须大于0")
+	}
+	w, err := s.repo.LockWithTx(tx, walletID)
+	if err != nil {
+		return errors.New("钱包不存在")
+	}
+	exists, err := s.repo.LedgerExistsWithTx(tx, bizNo)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	balance := round2(w.Balance + amount)
+	if err := s.repo.AppendLedgerWithTx(tx, &model.WalletLedger{
+		WalletID:     w.WalletID,
+		Direction:    "credit",
+		Amount:       round2(amount),
+		BalanceAfter: balance,
+		BizType:      bizType,
+		BizNo:        bizNo,
+		PaymentID:    paymentID,
+		Remark:       remark,
+	}); err != nil {
+		return err
+	}
+	return s.repo.UpdateBalanceWithTx(tx, w.WalletID, balance)
+}
+
+// Credit 入账（独立事务）
+func (s *Service) Credit(walletID uint, amount float64, bizType, bizNo string, paymentID *uint64, remark string) error {
+	return inits.DB.Transaction(func(tx *gorm.DB) error {
+		return s.CreditWithTx(tx, walletID, amount, bizType, bizNo, paymentID, remark)
+	})
+}
+
+// DebitWithTx 扣款：锁定钱包 → 校验余额 → 追加流水 → 更新余额，需与业务单据在同一事务中调用
+func (s *Service) DebitWithTx(tx *gorm.DB, userID uint, amount float64, bizType, bizNo string, paymentID *uint64, remark string) (*model.Wallet, error) {
+	if amount <= 0 {
+		return nil, errors.New("扣款金额必须大于0")
+	}
+	w, err := s.repo.EnsureWithTx(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if w, err = s.repo.LockWithTx(tx, w.WalletID); err != nil {
+		return nil, err
+	}
+	if w.Status != 1 {
+		return nil, ErrWalletFrozen
+	}
+	if round2(w.Balance) < round2(amount) {
+		return nil, ErrInsufficientBalance
+	}
+
+	w.Balance = round2(w.Balance - amount)
+	if err := s.repo.AppendLedgerWithTx(tx, &model.WalletLedger{
+		WalletID:     w.WalletID,
+		Direction:    "debit",
+		Amount:       round2(amount),
+		BalanceAfter: w.Balance,
+		BizType:      bizType,
+		BizNo:        bizNo,
+		PaymentID:    paymentID,
+		Remark:       remark,
+	}); err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdateBalanceWithTx(tx, w.WalletID, w.Balance); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// ListLedger 分页查询用户钱包流水
+func (s *Service) ListLedger(userID uint, page, pageSize int) ([]model.WalletLedger, int64, error) {
+	w, err := s.GetWallet(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	return s.repo.FindLedger(w.WalletID, (page-1)*pageSize, pageSize)
+}
@@ -8,10 +8,20 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"smart_parking_backend/internal/access"
+	"smart_parking_backend/internal/admin"
+	"smart_parking_backend/internal/auth"
 	"smart_parking_backend/internal/booking"
 	"smart_parking_backend/internal/controller"
+	"smart_parking_backend/internal/gate"
 	"smart_parking_backend/internal/inits"
+	"smart_parking_backend/internal/middleware"
+	"smart_parking_backend/internal/notify"
 	"smart_parking_backend/internal/payment"
+	"smart_parking_backend/internal/scheduler"
+	"smart_parking_backend/internal/tariff"
+	"smart_parking_backend/internal/violation"
+	"smart_parking_backend/internal/wallet"
 	"smart_parking_backend/pkg/logger"
 	router "smart_parking_backend/routers"
 	"syscall"
@@ -36,22 +46,81 @@ func main() {
 		}
 	}()
 
+	// 初始化令牌服务（密钥、有效期见 config.yaml 的 auth 段，密钥从环境变量读取）
+	authCfg, err := auth.LoadConfig("config/config.yaml")
+	if err != nil {
+		log.Fatalf("加载鉴权配置失败: %v", err)
+	}
+	authSvc := auth.NewService(authCfg, auth.NewRepository())
+	middleware.InitAuthService(authSvc)
+	controller.InitAuthService(authSvc)
+
+	// 初始化通知服务（渠道驱动与模板见 config/notify.yaml）
+	notifyCfg, err := notify.LoadConfig("config/notify.yaml")
+	if err != nil {
+		log.Fatalf("加载通知配置失败: %v", err)
+	}
+	notifySvc, err := notify.NewService(notifyCfg, notify.NewRepository())
+	if err != nil {
+		log.Fatalf("初始化通知服务失败: %v", err)
+	}
+	controller.InitNotifyService(notifySvc)
+
 	// 初始化模块服务
+	tariffRepo := tariff.NewRepository()
+	tariffSvc := tariff.NewService(tariffRepo)
+
 	repo := booking.NewRepository()
-	bookingSvc := booking.NewService(repo)
+	bookingSvc := booking.NewService(repo, tariffSvc, notifySvc)
 
 	cfg, err := payment.LoadSandboxConfig("config/payment_sandbox.yaml")
 	if err != nil {
 		log.Fatalf("加载支付配置失败: %v", err)
 	}
 
-	paymentSvc := payment.NewService(bookingSvc, cfg)
+	walletSvc := wallet.NewService(wallet.NewRepository())
+	paymentSvc := payment.NewService(bookingSvc, walletSvc, notifySvc, cfg)
 
 	// 初始化控制器的支付服务
 	controller.InitPaymentService(paymentSvc)
+	// 初始化控制器的计费服务
+	controller.InitTariffService(tariffSvc)
+
+	// 初始化违规规则引擎（规则、阈值与罚款公式见 config/violation_rules.yaml）
+	rules, err := violation.LoadRules("config/violation_rules.yaml")
+	if err != nil {
+		log.Fatalf("加载违规规则失败: %v", err)
+	}
+	violationRepo := violation.NewRepository()
+	controller.InitViolationEngine(violation.NewEngine(rules, violationRepo, notifySvc))
+	appealSvc := violation.NewAppealService(violationRepo)
+
+	// 初始化定时任务（任务开关与执行间隔见 config.yaml 的 scheduler 段）
+	schedCfg, err := scheduler.LoadConfig("config/config.yaml")
+	if err != nil {
+		log.Fatalf("加载定时任务配置失败: %v", err)
+	}
+	sched := scheduler.New(schedCfg, scheduler.NewRepository())
+
+	// 初始化闸机服务（心跳超时、签名时间偏差与离线补传参数见 config.yaml 的 gate 段）
+	gateCfg, err := gate.LoadConfig("config/config.yaml")
+	if err != nil {
+		log.Fatalf("加载闸机配置失败: %v", err)
+	}
+	gateSvc := gate.NewService(gateCfg, gate.NewRepository(), controller.GateReplayer{})
+
+	// 初始化通行日志（入场/出场的每次尝试均写入 access_event）
+	accessSvc := access.NewService(access.NewRepository())
+	controller.InitAccessJournal(accessSvc)
+
+	if err := registerJobs(sched, bookingSvc, notifySvc, notifyCfg, gateSvc); err != nil {
+		log.Fatalf("注册定时任务失败: %v", err)
+	}
+
+	adminSvc := admin.NewService(admin.NewRepository(), authSvc)
 
 	// 初始化路由
-	r := router.InitRouter(bookingSvc, paymentSvc)
+	r := router.InitRouter(bookingSvc, paymentSvc, tariffSvc, walletSvc, sched, appealSvc, notifySvc, adminSvc, authSvc, gateSvc, accessSvc)
 
 	port := ":8080"
 
@@ -76,6 +145,9 @@ func main() {
 	}
 	fmt.Printf("✅ Smart Parking 后端服务已在端口 %s 成功启动并正在运行\n", port)
 
+	// 服务启动成功后再启动定时任务
+	sched.Start()
+
 	// ================== 修复静态检查错误的关键修改 ==================
 	// 使用更简单的通道操作替代只有一个 case 的 select
 	quit := make(chan os.Signal, 1)
@@ -91,9 +163,52 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("❌ 强制关闭服务器: %v", err)
 	}
+	// 停止定时任务，等待正在执行的任务结束
+	if err := sched.Stop(ctx); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
 	log.Println("✅ 服务器已关闭")
 }
 
+// registerJobs 注册后台定时任务，原先需要客户端调用接口触发的过期/违规扫描改为后端定期执行
+func registerJobs(sched *scheduler.Scheduler, bookingSvc *booking.Service, notifySvc *notify.Service, notifyCfg *notify.Config, gateSvc *gate.Service) error {
+	jobs := []struct {
+		name string
+		desc string
+		fn   scheduler.JobFunc
+	}{
+		{"booking_expiry", "超时预订自动取消", func(ctx context.Context) (int, error) {
+			return bookingSvc.CheckAndUpdateExpiredBookings()
+		}},
+		{"reserved_flag_sync", "同步车位预订标记", func(ctx context.Context) (int, error) {
+			return 0, bookingSvc.SyncReservedFlags()
+		}},
+		{"violation_unused_reservation", "违规检查：预订未使用", func(ctx context.Context) (int, error) {
+			return controller.RunViolationCheck(1)
+		}},
+		{"violation_overtime_parking", "违规检查：超时停车", func(ctx context.Context) (int, error) {
+			return controller.RunViolationCheck(2)
+		}},
+		{"violation_unpaid_parking_fee", "违规检查：未支付停车费", func(ctx context.Context) (int, error) {
+			return controller.RunViolationCheck(3)
+		}},
+		{"violation_unpaid_fine", "违规检查：未支付罚款", func(ctx context.Context) (int, error) {
+			return controller.RunViolationCheck(4)
+		}},
+		{"reservation_reminder", "预订开始前提醒", func(ctx context.Context) (int, error) {
+			return bookingSvc.RemindUpcomingBookings(notifyCfg.ReminderLead())
+		}},
+		{"notify_dispatch", "投递通知发件箱", notifySvc.Dispatch},
+		{"gate_offline_scan", "闸机离线检测", gateSvc.ScanOffline},
+	}
+	for _, j := range jobs {
+		if err := sched.Register(j.name, j.desc, j.fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // isServerReady 尝试连接指定端口，确认服务器是否已就绪
 func isServerReady(port string, maxAttempts int, interval time.Duration) bool {
 	for i := 0; i < maxAttempts; i++ {
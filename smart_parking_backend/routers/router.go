@@ -1,16 +1,25 @@
 package router
 
 import (
+	"smart_parking_backend/internal/access"
+	"smart_parking_backend/internal/admin"
+	"smart_parking_backend/internal/auth"
 	"smart_parking_backend/internal/booking"
 	"smart_parking_backend/internal/controller"
+	"smart_parking_backend/internal/gate"
 	"smart_parking_backend/internal/inits"
 	"smart_parking_backend/internal/middleware"
+	"smart_parking_backend/internal/notify"
 	"smart_parking_backend/internal/payment"
+	"smart_parking_backend/internal/scheduler"
+	"smart_parking_backend/internal/tariff"
+	"smart_parking_backend/internal/violation"
+	"smart_parking_backend/internal/wallet"
 
 	"github.com/gin-gonic/gin"
 )
 
-func InitRouter(bookingSvc *booking.Service, paymentCfg *payment.Service) *gin.Engine {
+func InitRouter(bookingSvc *booking.Service, paymentCfg *payment.Service, tariffSvc *tariff.Service, walletSvc *wallet.Service, sched *scheduler.Scheduler, appealSvc *violation.AppealService, notifySvc *notify.Service, adminSvc *admin.Service, authSvc *auth.Service, gateSvc *gate.Service, accessSvc *access.Service) *gin.Engine {
 	r := gin.Default()
 
 	// 全局中间件
@@ -32,13 +41,17 @@ func InitRouter(bookingSvc *booking.Service, paymentCfg *payment.Service) *gin.E
 			protectedUserGroup.GET("/vehicles", controller.GetUserVehicles(inits.DB))
 			protectedUserGroup.POST("/vehicles", controller.AddUserVehicle(inits.DB))
 			protectedUserGroup.DELETE("/vehicles/:id", controller.DeleteUserVehicle(inits.DB))
+			// 认领访客停车记录
+			protectedUserGroup.POST("/parking/claim", controller.ClaimGuestParkingRecord)
 		}
 	}
 
+	// -------------------- 令牌刷新 / 注销 --------------------
+	auth.AuthRoutes(r, authSvc)
+
 	// -------------------- 管理员模块 --------------------
 	adminGroup := r.Group("/admin")
 	{
-		adminGroup.POST("/register", controller.AdminRegisterController)
 		adminGroup.POST("/login", controller.AdminLoginController)
 
 		// 需要认证的管理员路由
@@ -50,56 +63,116 @@ func InitRouter(bookingSvc *booking.Service, paymentCfg *payment.Service) *gin.E
 		}
 	}
 
+	// 权限策略：
+	// user      —— 用户 token，只能访问自己的资源（用户ID取自 token，不信任路径/请求体参数）
+	// lot_admin —— 管理员 token，只能管理分配给自己的停车场（admin_lot）
+	// system    —— 管理员 token，可管理全部停车场，停车场的新增/删除仅系统管理员可操作
+	adminAuth := middleware.AdminAuthMiddleware()
+	lotAdmin := middleware.RequireRoles(middleware.RoleSystem, middleware.RoleLotAdmin)
+	systemOnly := middleware.RequireRoles(middleware.RoleSystem)
+
+	// -------------------- 管理员账号管理（系统管理员） --------------------
+	admin.AdminRoutes(r, adminSvc)
+	r.PUT("/admin/users/:id/status", adminAuth, systemOnly, controller.SetUserStatus) // 启用/禁用用户账号
+
+	// -------------------- 全网分析（系统管理员为全部停车场，停车场管理员为自己管理的停车场） --------------------
+	networkGroup := r.Group("/admin/network")
+	networkGroup.Use(adminAuth, lotAdmin)
+	{
+		networkGroup.GET("/overview", controller.NetworkOverview)                                                     // 汇总、对比与排名
+		networkGroup.GET("/lots/:lot_id", middleware.LotScope("lot_id"), controller.NetworkLotDetail)                 // 下钻到停车场
+		networkGroup.GET("/lots/:lot_id/levels/:level", middleware.LotScope("lot_id"), controller.NetworkLevelDetail) // 下钻到楼层
+	}
+
 	// -------------------- 停车场模块 --------------------
 	api_1 := r.Group("/api/v2")
 	{
-		api_1.POST("/addparkinglot", controller.AddParkingLot)
 		api_1.GET("/getparkinglots", controller.GetAllParkingLots)
 		api_1.GET("/getparkinglot/:id", controller.GetParkingLotByID)
-		api_1.DELETE("/deleteparkinglot/:id", controller.DeleteParkingLot)
-		api_1.POST("/addparkingspace", controller.AddParkingSpace)
-		api_1.PATCH("/updatespacestatus/:id", controller.UpdateSpaceStatus)
-		api_1.GET("/getspacesbylotid/:lot_id", controller.GetSpacesByLotID)
+
+		api_1.POST("/addparkinglot", adminAuth, systemOnly, controller.AddParkingLot)
+		api_1.DELETE("/deleteparkinglot/:id", adminAuth, systemOnly, controller.DeleteParkingLot)
+		api_1.POST("/addparkingspace", adminAuth, lotAdmin, controller.AddParkingSpace)          // 处理函数内按 lot_id 校验管理范围
+		api_1.PATCH("/updatespacestatus/:id", adminAuth, lotAdmin, controller.UpdateSpaceStatus) // 处理函数内按车位所属停车场校验
+		api_1.GET("/getspacesbylotid/:lot_id", adminAuth, middleware.LotScope("lot_id"), controller.GetSpacesByLotID)
 	}
 
 	// -------------------- 车位模块 --------------------
 	api_2 := r.Group("/api/v3")
+	api_2.Use(adminAuth, lotAdmin)
 	{
 		api_2.POST("/addparkingspace", controller.AddParkingSpace)
 		api_2.PATCH("/updatespacestatus/:id", controller.UpdateSpaceStatus)
-		api_2.GET("/getspacesbylotid/:lot_id", controller.GetSpacesByLotID)
+		api_2.GET("/getspacesbylotid/:lot_id", middleware.LotScope("lot_id"), controller.GetSpacesByLotID)
 	}
 
 	// -------------------- 预订模块 --------------------
 	booking.BookingRoutes(r, bookingSvc)
 
+	// -------------------- 计费模块 --------------------
+	tariff.TariffRoutes(r, tariffSvc)
+
 	// -------------------- 停车模块 --------------------
+	// 人工办理入场/出场（岗亭）：需管理员登录，停车场管理员只能办理管理范围内的停车场；闸机使用 /api/gate/*
+	parkingStaffGroup := r.Group("/api/parking")
+	parkingStaffGroup.Use(middleware.AdminAuthMiddleware(), middleware.RequireRoles(middleware.RoleSystem, middleware.RoleLotAdmin))
+	{
+		parkingStaffGroup.POST("/entry", controller.VehicleEntry) // 车辆入场
+		parkingStaffGroup.POST("/exit", controller.VehicleExit)   // 车辆出场
+	}
 	parkingGroup := r.Group("/api/parking")
 	{
 		// 注意：具体路由要放在参数路由之前，避免路由冲突
-		parkingGroup.POST("/entry", controller.VehicleEntry)                                   // 车辆入场
-		parkingGroup.POST("/exit", controller.VehicleExit)                                     // 车辆出场
-		parkingGroup.POST("/check-reservation", controller.CheckValidReservation)              // 检查有效预订（进场前确认）
-		parkingGroup.GET("/space-types", controller.GetParkingSpaceTypes)                      // 获取车位类型
-		parkingGroup.GET("/getlicense/:license_plate", controller.GetVehicleByLicensePlate)    // 根据车牌号获取车辆信息
-		parkingGroup.GET("/getparkinglotoccupancy/:lot_id", controller.GetParkingLotOccupancy) // 实时获取停车场车位信息
-		parkingGroup.GET("/lots/:lot_id/spaces", controller.GetParkingLotSpaces)               // 获取停车场车位信息
-		parkingGroup.GET("/:user_id/active-parking", controller.GetUserActiveParkingRecords)   // 获取用户在场停车记录（放在最后，避免冲突）
+		parkingGroup.POST("/check-reservation", adminAuth, lotAdmin, controller.CheckValidReservation)           // 检查有效预订（进场前确认，处理函数内按 lot_id 校验管理范围）
+		parkingGroup.GET("/space-types", controller.GetParkingSpaceTypes)                                        // 获取车位类型
+		parkingGroup.GET("/getlicense/:license_plate", adminAuth, lotAdmin, controller.GetVehicleByLicensePlate) // 根据车牌号获取车辆信息（停车场管理员仅限管理范围内停车或预订过的车辆）
+		// 车位占用与车位列表：用户可查看全部停车场（预订时选择），管理员仅限管理范围内的停车场
+		parkingGroup.GET("/getparkinglotoccupancy/:lot_id", middleware.LotViewer("lot_id"), controller.GetParkingLotOccupancy) // 实时获取停车场车位信息
+		parkingGroup.GET("/lots/:lot_id/spaces", middleware.LotViewer("lot_id"), controller.GetParkingLotSpaces)               // 获取停车场车位信息
+		// 获取用户在场停车记录（放在最后，避免冲突），路径中的 user_id 须与 token 一致
+		parkingGroup.GET("/:user_id/active-parking", middleware.UserAuthMiddleware(), middleware.SelfOnly("user_id"), controller.GetUserActiveParkingRecords)
 	}
 
+	// -------------------- 闸机设备 --------------------
+	// 闸机凭设备密钥或 HMAC 签名认证，入场时停车场取闸机所属停车场，出场时拒绝其他停车场的在场记录
+	// /api/parking/entry、/exit 仅供管理员人工办理，闸机必须使用以下接口
+	gate.GateRoutes(r, gateSvc)
+	gateGroup := r.Group("/api/gate")
+	{
+		gateGroup.POST("/entry", gateSvc.DeviceAuth(gate.DirectionIn), controller.VehicleEntry) // 闸机上报车辆入场
+		gateGroup.POST("/exit", gateSvc.DeviceAuth(gate.DirectionOut), controller.VehicleExit)  // 闸机上报车辆出场
+	}
+
+	// -------------------- 通行日志 --------------------
+	// 入场/出场的每次尝试（含被拒绝、失败、补传重放与人工处理）均写入通行日志，管理员按停车场范围查询与统计
+	access.AccessRoutes(r, accessSvc)
+
 	//违规管理路由
 	violationGroup := r.Group("/api/violations")
 	{
-		violationGroup.POST("/check", controller.CheckViolations)                       // 检查违规行为
-		violationGroup.GET("/checkmyself/:user_id", controller.GetUserViolationHistory) // 检查用户违规记录
+		violationGroup.POST("/check", adminAuth, systemOnly, controller.CheckViolations) // 检查违规行为（全局扫描，仅系统管理员）
+		violationGroup.GET("/checkmyself/:user_id", middleware.UserAuthMiddleware(), middleware.SelfOnly("user_id"),
+			controller.GetUserViolationHistory) // 检查用户违规记录，路径中的 user_id 须与 token 一致
 	}
 
 	// -------------------- 支付模块 --------------------
-	payment.PaymentRoutes(r, bookingSvc, paymentCfg.Config())
+	payment.PaymentRoutes(r, paymentCfg)
+
+	// -------------------- 钱包模块 --------------------
+	wallet.WalletRoutes(r, walletSvc, paymentCfg)
+
+	// -------------------- 定时任务 --------------------
+	scheduler.SchedulerRoutes(r, sched)
+
+	// -------------------- 违规申诉 --------------------
+	violation.AppealRoutes(r, appealSvc)
+
+	// -------------------- 通知（站内信 / 发件箱） --------------------
+	notify.NotifyRoutes(r, notifySvc)
 
 	violationPaymentGroup := r.Group("/api/violations")
 	{
-		violationPaymentGroup.POST("/:violation_id/pay", controller.PayViolationFine) // 支付罚款
+		violationPaymentGroup.POST("/:violation_id/pay", middleware.UserAuthMiddleware(), controller.PayViolationFine) // 支付罚款（仅本人）
 	}
 
 	return r
@@ -779,12 +779,8 @@ Page {
     }
 
     function loadBookings() {
-        // 先检查并更新超时的预订记录，然后再获取最新列表
-        apiClient.checkAndUpdateExpiredBookings()
-        // 延迟一下再获取预订列表，确保超时检查已完成
-        Qt.callLater(function() {
-            apiClient.getUserBookings(userId)
-        })
+        // 超时预订由后端定时任务处理，直接获取最新列表
+        apiClient.getUserBookings(userId)
     }
 
     function loadPaymentRecords() {